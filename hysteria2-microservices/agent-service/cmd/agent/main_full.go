@@ -8,10 +8,11 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/credentials"
 	"hysteria2-microservices/agent-service/internal/config"
 	"hysteria2-microservices/agent-service/internal/handlers"
 	"hysteria2-microservices/agent-service/internal/services"
@@ -31,19 +32,30 @@ func main() {
 	// Initialize services
 	localServices := setupLocalServices(cfg, logger)
 
-	// Setup gRPC client to master server
-	masterClient, err := setupMasterClient(cfg, logger)
+	// Setup gRPC client to master server, enrolling first if needed
+	masterConn, err := setupMasterClient(cfg, localServices.NodeState, logger)
 	if err != nil {
 		logger.Fatalf("Failed to connect to master server: %v", err)
 	}
-	defer func() {
-		if masterClient != nil {
-			masterClient.Close()
-		}
-	}()
+	var masterClient pb.MasterServiceClient
+	if masterConn != nil {
+		defer masterConn.Close()
+		masterClient = pb.NewMasterServiceClient(masterConn)
+		// The master assigns the node ID at enrollment
+		cfg.Node.ID = localServices.NodeState.NodeID()
+	}
 
-	// Setup gRPC server for master commands
-	grpcServer := setupGRPCServer(localServices, masterClient, logger)
+	// Setup gRPC server for master commands. It only accepts the master's
+	// client certificate, so it runs once the node is enrolled.
+	var grpcServer *grpc.Server
+	if localServices.NodeState.Enrolled() {
+		grpcServer, err = setupGRPCServer(localServices, masterClient, logger)
+		if err != nil {
+			logger.Fatalf("Failed to setup gRPC server: %v", err)
+		}
+	} else {
+		logger.Warn("Node is not enrolled, not serving master commands")
+	}
 
 	// Start agent
 	agent := handlers.NewAgent(localServices, masterClient, cfg, logger)
@@ -55,7 +67,9 @@ func main() {
 	go agent.Start(ctx)
 
 	// Start gRPC server
-	go startGRPCServer(grpcServer, cfg, logger)
+	if grpcServer != nil {
+		go startGRPCServer(grpcServer, cfg, logger)
+	}
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
@@ -64,7 +78,9 @@ func main() {
 
 	logger.Info("Shutting down agent...")
 	cancel()
-	grpcServer.GracefulStop()
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
 	logger.Info("Agent stopped")
 }
 
@@ -89,39 +105,81 @@ func setupLogger(cfg config.LoggingConfig) *logrus.Logger {
 }
 
 func setupLocalServices(cfg *config.Config, logger *logrus.Logger) *services.LocalServices {
+	nodeState := services.NewNodeState(logger, cfg)
+
 	return &services.LocalServices{
 		ConfigManager:    services.NewConfigManager(logger),
 		MetricsCollector: services.NewMetricsCollector(cfg, logger),
 		SystemManager:    services.NewSystemManager(logger),
 		NetworkManager:   services.NewNetworkManager(logger),
-		HysteriaManager:  services.NewHysteriaManager(logger, cfg),
+		HysteriaManager:  services.NewHysteriaManager(logger, cfg, nodeState),
+		UserStore:        services.NewUserStore(logger, cfg),
+		NodeState:        nodeState,
 	}
 }
 
-func setupMasterClient(cfg *config.Config, logger *logrus.Logger) (pb.MasterServiceClient, error) {
+func setupMasterClient(cfg *config.Config, nodeState services.NodeState, logger *logrus.Logger) (*grpc.ClientConn, error) {
 	if cfg.MasterServer == "" {
 		logger.Warn("No master server configured, running in standalone mode")
 		return nil, nil
 	}
 
-	conn, err := grpc.Dial(cfg.MasterServer, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if !nodeState.Enrolled() {
+		if err := enrollNode(cfg, nodeState, logger); err != nil {
+			return nil, fmt.Errorf("failed to enroll node: %w", err)
+		}
+	}
+
+	tlsConfig, err := nodeState.ClientTLSConfig(cfg.MasterCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.Dial(cfg.MasterServer, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to master server: %w", err)
 	}
 
-	client := pb.NewMasterServiceClient(conn)
 	logger.Infof("Connected to master server: %s", cfg.MasterServer)
 
-	return client, nil
+	return conn, nil
 }
 
-func setupGRPCServer(localServices *services.LocalServices, masterClient pb.MasterServiceClient, logger *logrus.Logger) *grpc.Server {
-	s := grpc.NewServer()
+// enrollNode registers with the master over a TLS connection without a
+// client certificate and stores the certificate it issues
+func enrollNode(cfg *config.Config, nodeState services.NodeState, logger *logrus.Logger) error {
+	tlsConfig, err := nodeState.BootstrapTLSConfig(cfg.MasterCAFile, cfg.MasterCAFingerprint)
+	if err != nil {
+		return err
+	}
+
+	conn, err := grpc.Dial(cfg.MasterServer, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to master server: %w", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return handlers.Enroll(ctx, pb.NewMasterServiceClient(conn), nodeState, cfg, logger)
+}
+
+// setupGRPCServer creates the NodeManager server. Its calls change the
+// users the node accepts and rewrite and restart Hysteria2, so it requires
+// mTLS with the master's client certificate.
+func setupGRPCServer(localServices *services.LocalServices, masterClient pb.MasterServiceClient, logger *logrus.Logger) (*grpc.Server, error) {
+	tlsConfig, err := localServices.NodeState.ServerTLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TLS credentials: %w", err)
+	}
+
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
 
 	// Register node manager service
 	pb.RegisterNodeManagerServer(s, handlers.NewNodeManagerHandler(localServices, logger))
 
-	return s
+	return s, nil
 }
 
 func startGRPCServer(s *grpc.Server, cfg *config.Config, logger *logrus.Logger) {
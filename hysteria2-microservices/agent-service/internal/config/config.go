@@ -9,12 +9,14 @@ import (
 )
 
 type Config struct {
-	MasterServer string          `mapstructure:"master_server"`
-	Node         NodeConfig      `mapstructure:"node"`
-	Metrics      MetricsConfig   `mapstructure:"metrics"`
-	Logging      LoggingConfig   `mapstructure:"logging"`
-	Network      NetworkConfig   `mapstructure:"network"`
-	Hysteria2    Hysteria2Config `mapstructure:"hysteria2"`
+	MasterServer        string          `mapstructure:"master_server"`
+	MasterCAFile        string          `mapstructure:"master_ca_file"`        // CA that signed the master's gRPC server cert
+	MasterCAFingerprint string          `mapstructure:"master_ca_fingerprint"` // sha256 of the master CA, pinned during enrollment
+	Node                NodeConfig      `mapstructure:"node"`
+	Metrics             MetricsConfig   `mapstructure:"metrics"`
+	Logging             LoggingConfig   `mapstructure:"logging"`
+	Network             NetworkConfig   `mapstructure:"network"`
+	Hysteria2           Hysteria2Config `mapstructure:"hysteria2"`
 }
 
 type NodeConfig struct {
@@ -27,6 +29,8 @@ type NodeConfig struct {
 	GRPCPort     int               `mapstructure:"grpc_port"`
 	Capabilities map[string]string `mapstructure:"capabilities"`
 	Metadata     map[string]string `mapstructure:"metadata"`
+	JoinToken    string            `mapstructure:"join_token"` // single-use, only needed until the node is enrolled
+	StateFile    string            `mapstructure:"state_file"` // node ID, credentials and last applied config version
 }
 
 type MetricsConfig struct {
@@ -57,10 +61,16 @@ type Hysteria2Config struct {
 	CustomConfig       string `mapstructure:"custom_config"` // JSON string for custom settings
 	ListenPorts        []int  `mapstructure:"listen_ports"`
 	DefaultListenPort  int    `mapstructure:"default_listen_port"`
-	AuthType           string `mapstructure:"auth_type"` // "password", "userpass", etc.
+	AuthType           string `mapstructure:"auth_type"` // "http", "local", "password", "userpass", etc. See the README for what http and local enforce
 	AuthPassword       string `mapstructure:"auth_password"`
-	UpMbps             int    `mapstructure:"up_mbps"`
+	AuthURL            string `mapstructure:"auth_url"` // api-service auth backend, used when auth_type is "http"
+	AuthInsecure       bool   `mapstructure:"auth_insecure"`
+	UpMbps             int    `mapstructure:"up_mbps"` // server bandwidth, per-user caps come from the auth backend
 	DownMbps           int    `mapstructure:"down_mbps"`
+	TrafficStatsListen string `mapstructure:"traffic_stats_listen"`
+	TrafficStatsSecret string `mapstructure:"traffic_stats_secret"`
+	UsersFile          string `mapstructure:"users_file"`        // local user table fed by the orchestrator
+	LocalAuthListen    string `mapstructure:"local_auth_listen"` // agent auth hook, used when auth_type is "local"
 }
 
 func LoadConfig() (*Config, error) {
@@ -94,6 +104,7 @@ func LoadConfig() (*Config, error) {
 func setDefaults() {
 	viper.SetDefault("master_server", "")
 	viper.SetDefault("node.grpc_port", 50051)
+	viper.SetDefault("node.state_file", "/var/lib/hysteria-agent/state.json")
 	viper.SetDefault("metrics.collect_interval", 30)
 	viper.SetDefault("metrics.report_interval", 60)
 	viper.SetDefault("logging.level", "info")
@@ -109,13 +120,20 @@ func setDefaults() {
 	viper.SetDefault("hysteria2.salamander_enabled", false)
 	viper.SetDefault("hysteria2.obfs_type", "")
 	viper.SetDefault("hysteria2.default_listen_port", 8080)
-	viper.SetDefault("hysteria2.auth_type", "password")
+	viper.SetDefault("hysteria2.auth_type", "http")
+	viper.SetDefault("hysteria2.auth_url", "http://api-service:8080/api/v1/hysteria/auth")
+	viper.SetDefault("hysteria2.auth_insecure", false)
 	viper.SetDefault("hysteria2.up_mbps", 100)
 	viper.SetDefault("hysteria2.down_mbps", 100)
+	viper.SetDefault("hysteria2.traffic_stats_listen", "127.0.0.1:9999")
+	viper.SetDefault("hysteria2.users_file", "/var/lib/hysteria-agent/users.json")
+	viper.SetDefault("hysteria2.local_auth_listen", "127.0.0.1:9998")
 }
 
 func bindEnvVars() {
 	viper.BindEnv("master_server", "MASTER_SERVER")
+	viper.BindEnv("master_ca_file", "MASTER_CA_FILE")
+	viper.BindEnv("master_ca_fingerprint", "MASTER_CA_FINGERPRINT")
 	viper.BindEnv("node.id", "NODE_ID")
 	viper.BindEnv("node.name", "NODE_NAME")
 	viper.BindEnv("node.hostname", "NODE_HOSTNAME")
@@ -123,6 +141,14 @@ func bindEnvVars() {
 	viper.BindEnv("node.location", "NODE_LOCATION")
 	viper.BindEnv("node.country", "NODE_COUNTRY")
 	viper.BindEnv("node.grpc_port", "NODE_GRPC_PORT")
+	viper.BindEnv("node.join_token", "NODE_JOIN_TOKEN")
+	viper.BindEnv("node.state_file", "NODE_STATE_FILE")
+	viper.BindEnv("hysteria2.auth_type", "HYSTERIA_AUTH_TYPE")
+	viper.BindEnv("hysteria2.auth_url", "HYSTERIA_AUTH_URL")
+	viper.BindEnv("hysteria2.traffic_stats_listen", "HYSTERIA_TRAFFIC_STATS_LISTEN")
+	viper.BindEnv("hysteria2.traffic_stats_secret", "HYSTERIA_TRAFFIC_STATS_SECRET")
+	viper.BindEnv("hysteria2.users_file", "HYSTERIA_USERS_FILE")
+	viper.BindEnv("hysteria2.local_auth_listen", "HYSTERIA_LOCAL_AUTH_LISTEN")
 	viper.BindEnv("logging.level", "LOG_LEVEL")
 	viper.BindEnv("logging.format", "LOG_FORMAT")
 }
@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"hysteria2-microservices/agent-service/internal/config"
 	"hysteria2-microservices/agent-service/internal/services"
 	pb "hysteria2-microservices/proto"
@@ -16,15 +19,42 @@ type Agent struct {
 	masterClient  pb.MasterServiceClient
 	config        *config.Config
 	logger        *logrus.Logger
+
+	// Commands run one at a time on commandLoop so a slow command never
+	// delays heartbeats. pendingResults holds their results until a heartbeat
+	// delivers them; a command redelivered while it is queued, running or
+	// awaiting acknowledgement is not run again.
+	mu             sync.Mutex
+	commands       chan *pb.NodeCommand
+	queuedCommands map[string]bool
+	pendingResults map[string]*pb.CommandResult
+	// resultReady wakes heartbeatLoop to report a finished command early
+	resultReady chan struct{}
+
+	// connections collects client authentications from the local auth hook
+	// for the next heartbeat
+	connections *connectionLog
 }
 
+// defaultHeartbeatInterval is used until the master sends its own interval
+const defaultHeartbeatInterval = 30 * time.Second
+
+// commandQueueSize bounds commands waiting for the worker; the master
+// redelivers commands that did not fit
+const commandQueueSize = 32
+
 // NewAgent creates a new Agent
 func NewAgent(localServices *services.LocalServices, masterClient pb.MasterServiceClient, cfg *config.Config, logger *logrus.Logger) *Agent {
 	return &Agent{
-		localServices: localServices,
-		masterClient:  masterClient,
-		config:        cfg,
-		logger:        logger,
+		localServices:  localServices,
+		masterClient:   masterClient,
+		config:         cfg,
+		logger:         logger,
+		commands:       make(chan *pb.NodeCommand, commandQueueSize),
+		queuedCommands: make(map[string]bool),
+		pendingResults: make(map[string]*pb.CommandResult),
+		resultReady:    make(chan struct{}, 1),
+		connections:    newConnectionLog(),
 	}
 }
 
@@ -32,16 +62,29 @@ func NewAgent(localServices *services.LocalServices, masterClient pb.MasterServi
 func (a *Agent) Start(ctx context.Context) {
 	a.logger.Info("Starting agent...")
 
-	// Register with master if client available
+	// Refresh the node's registration from the persisted state. Enrollment
+	// happened before the master client was created.
 	if a.masterClient != nil {
 		if err := a.registerWithMaster(ctx); err != nil {
 			a.logger.Errorf("Failed to register with master: %v", err)
 		}
 	}
 
-	// Start heartbeat if master client available
+	// Start heartbeat and traffic reporting if master client available
 	if a.masterClient != nil {
 		go a.heartbeatLoop(ctx)
+		go a.commandLoop(ctx)
+		go a.trafficLoop(ctx)
+	}
+
+	// Serve the local auth hook when Hysteria authenticates against the user table
+	if a.config.Hysteria2.AuthType == "local" {
+		hook := NewAuthHook(a.localServices.UserStore, a.config.Hysteria2.LocalAuthListen, a.connections, a.logger)
+		go func() {
+			if err := hook.Run(ctx); err != nil {
+				a.logger.Errorf("Local auth hook stopped: %v", err)
+			}
+		}()
 	}
 
 	// Enable masquerading if configured
@@ -75,47 +118,76 @@ func (a *Agent) Start(ctx context.Context) {
 	a.logger.Info("Agent started")
 }
 
+// registerWithMaster re-registers the enrolled node. The master identifies
+// it by its client certificate and updates the existing record in place.
 func (a *Agent) registerWithMaster(ctx context.Context) error {
-	req := &pb.RegisterNodeRequest{
-		Name:      a.config.Node.Name,
-		Hostname:  a.config.Node.Hostname,
-		IpAddress: a.config.Node.IPAddress,
-		Location:  a.config.Node.Location,
-		Country:   a.config.Node.Country,
-		GrpcPort:  int32(a.config.Node.GRPCPort),
-		Version:   "1.0.0",
-		Capabilities: map[string]string{
-			"masquerading": "true",
-			"network":      "true",
-		},
-		AuthToken: "dummy-token", // TODO: implement proper auth
-		Metadata:  a.config.Node.Metadata,
-	}
-
-	resp, err := a.masterClient.RegisterNode(ctx, req)
+	resp, err := a.masterClient.RegisterNode(ctx, newRegisterNodeRequest(a.config, a.localServices.NodeState))
 	if err != nil {
 		return err
 	}
 
-	if resp.Success {
-		a.logger.Infof("Registered with master server, node ID: %s", resp.NodeId)
-		// Store node ID if needed
-	} else {
-		a.logger.Errorf("Registration failed: %s", resp.Message)
+	if !resp.Success {
+		return fmt.Errorf("registration rejected: %s", resp.Message)
 	}
 
+	a.logger.Infof("Registered with master server, node ID: %s", resp.NodeId)
 	return nil
 }
 
+// heartbeatLoop sends heartbeats at the interval the master asks for in its
+// responses, and early once a command finished. Commands and their results
+// travel with the heartbeats, so they reach nodes the master cannot dial.
 func (a *Agent) heartbeatLoop(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second) // TODO: configurable
-	defer ticker.Stop()
+	interval := defaultHeartbeatInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	beat := func() {
+		next, err := a.sendHeartbeat(ctx)
+		if err != nil {
+			a.logger.Errorf("Failed to send heartbeat: %v", err)
+		} else if next > 0 && next != interval {
+			a.logger.Infof("Heartbeat interval changed to %s", next)
+			interval = next
+		}
+		timer.Reset(interval)
+	}
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := a.sendHeartbeat(ctx); err != nil {
-				a.logger.Errorf("Failed to send heartbeat: %v", err)
+		case <-timer.C:
+			beat()
+		case <-a.resultReady:
+			// Report command results without waiting for the next beat
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			beat()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// commandLoop runs queued commands one at a time and keeps their results for
+// the next heartbeat
+func (a *Agent) commandLoop(ctx context.Context) {
+	for {
+		select {
+		case cmd := <-a.commands:
+			result := a.executeCommand(ctx, cmd)
+
+			a.mu.Lock()
+			delete(a.queuedCommands, cmd.Id)
+			a.pendingResults[cmd.Id] = result
+			a.mu.Unlock()
+
+			select {
+			case a.resultReady <- struct{}{}:
+			default:
 			}
 		case <-ctx.Done():
 			return
@@ -123,7 +195,31 @@ func (a *Agent) heartbeatLoop(ctx context.Context) {
 	}
 }
 
-func (a *Agent) sendHeartbeat(ctx context.Context) error {
+// queueCommands hands the commands the master returned to commandLoop,
+// skipping those already queued, running or awaiting acknowledgement
+func (a *Agent) queueCommands(commands []*pb.NodeCommand) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, cmd := range commands {
+		if _, done := a.pendingResults[cmd.Id]; done || a.queuedCommands[cmd.Id] {
+			continue
+		}
+
+		select {
+		case a.commands <- cmd:
+			a.queuedCommands[cmd.Id] = true
+		default:
+			a.logger.Warnf("Command queue full, dropping %s command %s until it is redelivered", cmd.Type, cmd.Id)
+		}
+	}
+}
+
+// sendHeartbeat reports the node's status with the results of executed
+// commands and recent client connections, then queues the commands the
+// master returned. It returns the heartbeat interval requested by the
+// master, zero if none was sent.
+func (a *Agent) sendHeartbeat(ctx context.Context) (time.Duration, error) {
 	// Collect metrics
 	metrics, err := a.localServices.MetricsCollector.Collect()
 	if err != nil {
@@ -133,26 +229,159 @@ func (a *Agent) sendHeartbeat(ctx context.Context) error {
 	// Convert metrics to map[string]float64
 	metricValues := make(map[string]float64)
 	for k, v := range metrics {
-		if f, ok := v.(float64); ok {
-			metricValues[k] = f
+		switch n := v.(type) {
+		case float64:
+			metricValues[k] = n
+		case int:
+			metricValues[k] = float64(n)
+		case int64:
+			metricValues[k] = float64(n)
+		case uint32:
+			metricValues[k] = float64(n)
+		case uint64:
+			metricValues[k] = float64(n)
 		}
 	}
 
 	req := &pb.HeartbeatRequest{
 		NodeId:    a.config.Node.ID,
-		Status:    "online",
+		Status:    a.nodeStatus(),
 		Metrics:   metricValues,
 		Timestamp: nil, // Will be set by protobuf
 	}
+	a.mu.Lock()
+	for _, result := range a.pendingResults {
+		req.CommandResults = append(req.CommandResults, result)
+	}
+	a.mu.Unlock()
+	req.Connections = a.connections.pending()
 
 	resp, err := a.masterClient.Heartbeat(ctx, req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if !resp.Success {
 		a.logger.Warnf("Heartbeat failed: %s", resp.Message)
+		return time.Duration(resp.HeartbeatIntervalSeconds) * time.Second, nil
+	}
+
+	// The master stored the results, redeliveries of these commands are
+	// no longer possible
+	a.mu.Lock()
+	for _, result := range req.CommandResults {
+		delete(a.pendingResults, result.CommandId)
+	}
+	a.mu.Unlock()
+	a.connections.ack(req.Connections)
+
+	a.queueCommands(resp.PendingCommands)
+
+	return time.Duration(resp.HeartbeatIntervalSeconds) * time.Second, nil
+}
+
+// nodeStatus reports "error" while Hysteria2 is not running so the master
+// can take the node out of rotation
+func (a *Agent) nodeStatus() string {
+	status, err := a.localServices.HysteriaManager.GetHysteria2Status()
+	if err != nil {
+		a.logger.Warnf("Failed to get Hysteria2 status: %v", err)
+		return "error"
+	}
+	if running, _ := status["running"].(bool); !running {
+		return "error"
+	}
+	return "online"
+}
+
+// trafficLoop polls per-client traffic from the local Hysteria2 and reports it
+// to the master. The trafficStats counters are cleared on every read, so a
+// report is kept until the master confirmed it recorded it. An unconfirmed
+// report is resent unchanged under the same sequence, which lets the master
+// skip it when only the confirmation was lost; deltas collected meanwhile go
+// into the next report.
+func (a *Agent) trafficLoop(ctx context.Context) {
+	interval := time.Duration(a.config.Metrics.ReportInterval) * time.Second
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	pending := make(map[string]*pb.ClientTraffic)
+	var unconfirmed *pb.TrafficReport
+	// Sequences start at the start time so they do not repeat across restarts
+	sequence := uint64(time.Now().UnixNano())
+
+	for {
+		select {
+		case <-ticker.C:
+			clients, err := a.localServices.MetricsCollector.CollectTraffic()
+			if err != nil {
+				a.logger.Errorf("Failed to collect traffic: %v", err)
+			}
+
+			for _, c := range clients {
+				entry, ok := pending[c.ClientID]
+				if !ok {
+					entry = &pb.ClientTraffic{ClientId: c.ClientID}
+					pending[c.ClientID] = entry
+				}
+				entry.Upload += c.Upload
+				entry.Download += c.Download
+				entry.Connections = int32(c.Connections)
+			}
+
+			if unconfirmed == nil && len(pending) > 0 {
+				sequence++
+				unconfirmed = &pb.TrafficReport{
+					NodeId:    a.config.Node.ID,
+					Timestamp: timestamppb.Now(),
+					Sequence:  sequence,
+				}
+				for _, entry := range pending {
+					unconfirmed.Clients = append(unconfirmed.Clients, entry)
+				}
+				pending = make(map[string]*pb.ClientTraffic)
+			}
+
+			if unconfirmed == nil {
+				continue
+			}
+
+			if err := a.sendTraffic(ctx, unconfirmed); err != nil {
+				a.logger.Errorf("Failed to report traffic, it will be resent: %v", err)
+				continue
+			}
+
+			a.logger.Debugf("Reported traffic for %d clients", len(unconfirmed.Clients))
+			unconfirmed = nil
+		case <-ctx.Done():
+			return
+		}
 	}
+}
 
+// sendTraffic delivers one report on its own stream. The master answers only
+// after storing it and aborts the stream when it could not, so a nil error
+// means the deltas are safe to drop.
+func (a *Agent) sendTraffic(ctx context.Context, report *pb.TrafficReport) error {
+	stream, err := a.masterClient.ReportTraffic(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open traffic stream: %w", err)
+	}
+
+	if err := stream.Send(report); err != nil {
+		// The real error is returned by CloseAndRecv
+		a.logger.Debugf("Failed to send traffic report: %v", err)
+	}
+
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return err
+	}
+	if !resp.Success || resp.ReportsReceived < 1 {
+		return fmt.Errorf("traffic report rejected: %s", resp.Message)
+	}
 	return nil
 }
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"hysteria2-microservices/agent-service/internal/services"
+)
+
+// AuthHook serves the Hysteria2 HTTP auth backend from the local user table,
+// used when hysteria2.auth_type is "local". Table changes take effect on the
+// next connection attempt without touching the running server. Accepted
+// clients get their user's speed limits along with their ID.
+type AuthHook struct {
+	userStore   services.UserStore
+	listen      string
+	connections *connectionLog
+	logger      *logrus.Logger
+}
+
+type authHookRequest struct {
+	Addr string `json:"addr"`
+	Auth string `json:"auth"`
+	Tx   uint64 `json:"tx"`
+}
+
+// authHookResponse carries the per-client bandwidth in bytes per second from
+// the client's side, as in Hysteria's bandwidth settings. Limits left out
+// fall back to the server's bandwidth section.
+type authHookResponse struct {
+	OK   bool   `json:"ok"`
+	ID   string `json:"id"`
+	Up   uint64 `json:"up,omitempty"`
+	Down uint64 `json:"down,omitempty"`
+}
+
+// NewAuthHook creates a new AuthHook
+func NewAuthHook(userStore services.UserStore, listen string, connections *connectionLog, logger *logrus.Logger) *AuthHook {
+	return &AuthHook{
+		userStore:   userStore,
+		listen:      listen,
+		connections: connections,
+		logger:      logger,
+	}
+}
+
+// Run serves the auth hook until ctx is cancelled
+func (h *AuthHook) Run(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/auth", h.handleAuth)
+
+	server := &http.Server{
+		Addr:              h.listen,
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	h.logger.Infof("Starting local auth hook on %s", h.listen)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (h *AuthHook) handleAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req authHookRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.logger.Warnf("Invalid auth hook request: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	resp := authHookResponse{}
+	user, clientID, err := h.userStore.Authenticate(req.Auth)
+	if err != nil {
+		h.logger.Debugf("Auth rejected for %s: %v", req.Addr, err)
+	} else {
+		h.logger.Debugf("Auth accepted for client %s of user %s from %s", clientID, user.UserID, req.Addr)
+		resp = authHookResponse{
+			OK:   true,
+			ID:   clientID,
+			Up:   user.BandwidthUp,
+			Down: user.BandwidthDown,
+		}
+		h.connections.record(clientID, req.Addr, time.Now())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
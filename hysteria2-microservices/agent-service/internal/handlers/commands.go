@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"hysteria2-microservices/agent-service/internal/services"
+	pb "hysteria2-microservices/proto"
+)
+
+// Command types queued by the orchestrator and delivered with heartbeat responses
+const (
+	commandRestart            = "restart"
+	commandReload             = "reload"
+	commandResyncUsers        = "resync-users"
+	commandCollectDiagnostics = "collect-diagnostics"
+)
+
+// diagnosticsLogLines is how much of the Hysteria2 journal goes into diagnostics
+const diagnosticsLogLines = 200
+
+// executeCommand runs a command from the orchestrator and builds the result
+// reported with the next heartbeat
+func (a *Agent) executeCommand(ctx context.Context, cmd *pb.NodeCommand) *pb.CommandResult {
+	a.logger.Infof("Executing %s command %s", cmd.Type, cmd.Id)
+
+	var output string
+	var err error
+	switch cmd.Type {
+	case commandRestart:
+		err = a.localServices.HysteriaManager.RestartHysteria2(services.DefaultConfigPath)
+		output = "Hysteria2 restarted"
+	case commandReload:
+		err = a.localServices.HysteriaManager.ReloadHysteria2()
+		output = "Hysteria2 reloaded"
+	case commandResyncUsers:
+		output, err = a.resyncUsers(ctx)
+	case commandCollectDiagnostics:
+		output, err = a.collectDiagnostics(ctx)
+	default:
+		err = fmt.Errorf("unknown command type %q", cmd.Type)
+	}
+
+	if err != nil {
+		a.logger.Errorf("Command %s (%s) failed: %v", cmd.Id, cmd.Type, err)
+		return &pb.CommandResult{CommandId: cmd.Id, Success: false, Output: err.Error()}
+	}
+	return &pb.CommandResult{CommandId: cmd.Id, Success: true, Output: output}
+}
+
+// resyncUsers replaces the local user table with the one held by the
+// orchestrator. Users missing from it are removed, clients that lost
+// access are disconnected and those whose speed limits changed reconnect.
+func (a *Agent) resyncUsers(ctx context.Context) (string, error) {
+	resp, err := a.masterClient.SyncUsers(ctx, &pb.SyncUsersRequest{NodeId: a.config.Node.ID})
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch users: %w", err)
+	}
+	if !resp.Success {
+		return "", fmt.Errorf("user sync rejected: %s", resp.Message)
+	}
+
+	store := a.localServices.UserStore
+	keep := make(map[string]bool, len(resp.Users))
+	var kicked []string
+	var failed int
+
+	for _, u := range resp.Users {
+		keep[u.UserId] = true
+
+		user := &services.LocalUser{UserID: u.UserId}
+		if err := user.ApplyUserConfig(u.UserConfig); err != nil {
+			a.logger.Warnf("Skipping user %s with invalid config: %v", u.UserId, err)
+			failed++
+			continue
+		}
+
+		previous, _ := store.Get(u.UserId)
+		if err := store.Put(user); err != nil {
+			return "", fmt.Errorf("failed to store user %s: %w", u.UserId, err)
+		}
+		if previous != nil {
+			kicked = append(kicked, changedClientIDs(previous, user)...)
+		}
+	}
+
+	var removed int
+	for _, user := range store.List() {
+		if keep[user.UserID] {
+			continue
+		}
+		if _, err := store.Remove(user.UserID); err != nil {
+			return "", fmt.Errorf("failed to remove user %s: %w", user.UserID, err)
+		}
+		kicked = append(kicked, user.ClientIDs()...)
+		removed++
+	}
+
+	if len(kicked) > 0 {
+		if err := a.localServices.HysteriaManager.KickClients(kicked); err != nil {
+			a.logger.Errorf("Failed to kick revoked clients after resync: %v", err)
+		}
+	}
+
+	return fmt.Sprintf("synced %d users, removed %d, skipped %d, kicked %d clients",
+		len(resp.Users)-failed, removed, failed, len(kicked)), nil
+}
+
+// collectDiagnostics gathers the node's state into a JSON report
+func (a *Agent) collectDiagnostics(ctx context.Context) (string, error) {
+	report := map[string]interface{}{
+		"node_id":        a.config.Node.ID,
+		"config_version": a.localServices.HysteriaManager.ConfigVersion(),
+		"users":          len(a.localServices.UserStore.List()),
+		"collected_at":   time.Now().UTC(),
+	}
+
+	if info, err := a.localServices.SystemManager.GetSystemInfo(); err != nil {
+		report["system_error"] = err.Error()
+	} else {
+		report["system"] = info
+	}
+
+	if status, err := a.localServices.HysteriaManager.GetHysteria2Status(); err != nil {
+		report["hysteria2_error"] = err.Error()
+	} else {
+		report["hysteria2"] = status
+	}
+
+	if metrics, err := a.localServices.MetricsCollector.Collect(); err != nil {
+		report["metrics_error"] = err.Error()
+	} else {
+		report["metrics"] = metrics
+	}
+
+	if a.config.Hysteria2.EnableSystemd {
+		logs, err := exec.CommandContext(ctx, "journalctl", "-u", "hysteria2", "--no-pager",
+			"-n", fmt.Sprint(diagnosticsLogLines)).CombinedOutput()
+		if err != nil {
+			report["logs_error"] = err.Error()
+		} else {
+			report["logs"] = string(logs)
+		}
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode diagnostics: %w", err)
+	}
+	return string(data), nil
+}
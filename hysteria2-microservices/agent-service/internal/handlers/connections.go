@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+	pb "hysteria2-microservices/proto"
+)
+
+// connectionLog keeps the latest successful authentication per client until
+// a heartbeat reports it to the master
+type connectionLog struct {
+	mu          sync.Mutex
+	connections map[string]*pb.ClientConnection
+}
+
+func newConnectionLog() *connectionLog {
+	return &connectionLog{connections: make(map[string]*pb.ClientConnection)}
+}
+
+// record notes that a client authenticated from addr
+func (l *connectionLog) record(clientID, addr string, at time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.connections[clientID] = &pb.ClientConnection{
+		ClientId:    clientID,
+		Address:     addr,
+		ConnectedAt: timestamppb.New(at),
+	}
+}
+
+// pending returns the connections not yet reported
+func (l *connectionLog) pending() []*pb.ClientConnection {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	connections := make([]*pb.ClientConnection, 0, len(l.connections))
+	for _, conn := range l.connections {
+		connections = append(connections, conn)
+	}
+	return connections
+}
+
+// ack drops the reported connections. Clients that authenticated again in
+// the meantime stay pending.
+func (l *connectionLog) ack(sent []*pb.ClientConnection) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, conn := range sent {
+		if l.connections[conn.ClientId] == conn {
+			delete(l.connections, conn.ClientId)
+		}
+	}
+}
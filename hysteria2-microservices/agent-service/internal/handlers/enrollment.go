@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"hysteria2-microservices/agent-service/internal/config"
+	"hysteria2-microservices/agent-service/internal/services"
+	pb "hysteria2-microservices/proto"
+)
+
+// Enroll registers the node with the master using the configured join token
+// and stores the client certificate it issues. Later MasterService calls
+// authenticate with that certificate instead of the token.
+func Enroll(ctx context.Context, masterClient pb.MasterServiceClient, nodeState services.NodeState, cfg *config.Config, logger *logrus.Logger) error {
+	if cfg.Node.JoinToken == "" {
+		return errors.New("node is not enrolled and no join token is configured")
+	}
+
+	csr, err := nodeState.CreateCSR(cfg.Node.Hostname)
+	if err != nil {
+		return err
+	}
+
+	// A previously known node ID lets the master reuse the node's record
+	req := newRegisterNodeRequest(cfg, nodeState)
+	req.AuthToken = cfg.Node.JoinToken
+	req.Csr = csr
+
+	resp, err := masterClient.RegisterNode(ctx, req)
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("enrollment rejected: %s", resp.Message)
+	}
+
+	if err := nodeState.StoreCredentials(resp.Certificate, resp.CaCertificate); err != nil {
+		return fmt.Errorf("failed to store node credentials: %w", err)
+	}
+
+	logger.Infof("Enrolled with master server, node ID: %s", resp.NodeId)
+	return nil
+}
+
+func newRegisterNodeRequest(cfg *config.Config, nodeState services.NodeState) *pb.RegisterNodeRequest {
+	return &pb.RegisterNodeRequest{
+		Name:      cfg.Node.Name,
+		Hostname:  cfg.Node.Hostname,
+		IpAddress: cfg.Node.IPAddress,
+		Location:  cfg.Node.Location,
+		Country:   cfg.Node.Country,
+		GrpcPort:  int32(cfg.Node.GRPCPort),
+		Version:   "1.0.0",
+		Capabilities: map[string]string{
+			"masquerading": "true",
+			"network":      "true",
+		},
+		Metadata:      cfg.Node.Metadata,
+		NodeId:        nodeState.NodeID(),
+		ConfigVersion: nodeState.ConfigVersion(),
+	}
+}
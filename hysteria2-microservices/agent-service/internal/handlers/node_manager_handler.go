@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"hysteria2-microservices/agent-service/internal/services"
@@ -103,29 +104,278 @@ func (h *NodeManagerHandler) IsMasqueradingEnabled(ctx context.Context, req *pb.
 	}, nil
 }
 
-// Other methods (placeholders for now)
+// UpdateConfig stages a versioned Hysteria2 config pushed by the orchestrator.
+// It takes effect, and becomes the reported config version, on the following
+// successful ReloadConfig.
 func (h *NodeManagerHandler) UpdateConfig(ctx context.Context, req *pb.ConfigUpdateRequest) (*pb.ConfigUpdateResponse, error) {
-	return &pb.ConfigUpdateResponse{Success: false, Message: "Not implemented"}, nil
+	h.logger.Infof("UpdateConfig called: type=%s version=%s", req.ConfigType, req.Version)
+
+	if req.ConfigType != "" && req.ConfigType != "hysteria2" {
+		return &pb.ConfigUpdateResponse{
+			Success: false,
+			Message: fmt.Sprintf("Unsupported config type %q", req.ConfigType),
+		}, nil
+	}
+
+	if err := h.localServices.HysteriaManager.ApplyConfig(req.Version, req.ConfigData); err != nil {
+		h.logger.Errorf("Failed to apply config %s: %v", req.Version, err)
+		return &pb.ConfigUpdateResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to apply config: %v", err),
+		}, nil
+	}
+
+	return &pb.ConfigUpdateResponse{
+		Success:         true,
+		Message:         "Config updated successfully",
+		DeployedVersion: req.Version,
+	}, nil
 }
 
+// ReloadConfig restarts Hysteria2 so it picks up the config staged by
+// UpdateConfig. When the server does not come up the agent restores the last
+// known good config and reports the failure.
 func (h *NodeManagerHandler) ReloadConfig(ctx context.Context, req *pb.ReloadRequest) (*pb.ReloadResponse, error) {
-	return &pb.ReloadResponse{Success: false, Message: "Not implemented"}, nil
+	h.logger.Infof("ReloadConfig called for service %s", req.ServiceName)
+
+	if req.ServiceName != "" && req.ServiceName != "hysteria2" {
+		return &pb.ReloadResponse{
+			Success: false,
+			Message: fmt.Sprintf("Unsupported service %q", req.ServiceName),
+		}, nil
+	}
+
+	if err := h.localServices.HysteriaManager.ReloadHysteria2(); err != nil {
+		h.logger.Errorf("Failed to reload Hysteria2: %v", err)
+		return &pb.ReloadResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to reload Hysteria2: %v", err),
+		}, nil
+	}
+
+	return &pb.ReloadResponse{
+		Success: true,
+		Message: "Hysteria2 reloaded successfully",
+	}, nil
 }
 
 func (h *NodeManagerHandler) GetStatus(ctx context.Context, req *pb.StatusRequest) (*pb.StatusResponse, error) {
 	return &pb.StatusResponse{}, nil
 }
 
+// AddUser provisions a user into the local table, replacing any existing
+// entry. The auth hook reads the table on every connection, so no reload of
+// Hysteria2 is needed and existing sessions are kept, except for clients
+// that lost access or whose speed limits changed and those listed in
+// client_ids.
 func (h *NodeManagerHandler) AddUser(ctx context.Context, req *pb.AddUserRequest) (*pb.AddUserResponse, error) {
-	return &pb.AddUserResponse{Success: false, Message: "Not implemented"}, nil
+	h.logger.Infof("AddUser called for user %s", req.UserId)
+
+	if req.UserId == "" {
+		return &pb.AddUserResponse{Success: false, Message: "user_id is required"}, nil
+	}
+
+	user := &services.LocalUser{UserID: req.UserId}
+	if err := user.ApplyUserConfig(req.UserConfig); err != nil {
+		return &pb.AddUserResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid user config: %v", err),
+		}, nil
+	}
+
+	previous, _ := h.localServices.UserStore.Get(req.UserId)
+
+	if err := h.localServices.UserStore.Put(user); err != nil {
+		h.logger.Errorf("Failed to add user %s: %v", req.UserId, err)
+		return &pb.AddUserResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to add user: %v", err),
+		}, nil
+	}
+
+	var kick []string
+	if previous != nil {
+		kick = changedClientIDs(previous, user)
+	}
+	kick = mergeClientIDs(kick, req.ClientIds)
+	if len(kick) > 0 {
+		if err := h.localServices.HysteriaManager.KickClients(kick); err != nil {
+			h.logger.Errorf("Failed to kick revoked clients of user %s: %v", req.UserId, err)
+		}
+	}
+
+	return &pb.AddUserResponse{
+		Success: true,
+		Message: "User added successfully",
+	}, nil
 }
 
+// RemoveUser drops the user from the local table and disconnects its live
+// Hysteria2 clients, both those listed in the request and those in the table
 func (h *NodeManagerHandler) RemoveUser(ctx context.Context, req *pb.RemoveUserRequest) (*pb.RemoveUserResponse, error) {
-	return &pb.RemoveUserResponse{Success: false, Message: "Not implemented"}, nil
+	h.logger.Infof("RemoveUser called for user %s (%d clients)", req.UserId, len(req.ClientIds))
+
+	removed, err := h.localServices.UserStore.Remove(req.UserId)
+	if err != nil {
+		h.logger.Errorf("Failed to remove user %s: %v", req.UserId, err)
+		return &pb.RemoveUserResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to remove user: %v", err),
+		}, nil
+	}
+
+	clientIDs := req.ClientIds
+	if removed != nil {
+		clientIDs = mergeClientIDs(clientIDs, removed.ClientIDs())
+	}
+
+	if err := h.localServices.HysteriaManager.KickClients(clientIDs); err != nil {
+		h.logger.Errorf("Failed to remove user %s: %v", req.UserId, err)
+		return &pb.RemoveUserResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to remove user: %v", err),
+		}, nil
+	}
+
+	return &pb.RemoveUserResponse{
+		Success: true,
+		Message: "User removed successfully",
+	}, nil
 }
 
+// UpdateUser merges user_config into an existing user. Clients that lose
+// access through the update are disconnected right away, and those whose
+// speed limits changed reconnect to pick them up.
 func (h *NodeManagerHandler) UpdateUser(ctx context.Context, req *pb.UpdateUserRequest) (*pb.UpdateUserResponse, error) {
-	return &pb.UpdateUserResponse{Success: false, Message: "Not implemented"}, nil
+	h.logger.Infof("UpdateUser called for user %s", req.UserId)
+
+	previous, ok := h.localServices.UserStore.Get(req.UserId)
+	if !ok {
+		return &pb.UpdateUserResponse{
+			Success: false,
+			Message: fmt.Sprintf("User %s not found", req.UserId),
+		}, nil
+	}
+
+	user, _ := h.localServices.UserStore.Get(req.UserId)
+	if err := user.ApplyUserConfig(req.UserConfig); err != nil {
+		return &pb.UpdateUserResponse{
+			Success: false,
+			Message: fmt.Sprintf("Invalid user config: %v", err),
+		}, nil
+	}
+
+	if err := h.localServices.UserStore.Put(user); err != nil {
+		h.logger.Errorf("Failed to update user %s: %v", req.UserId, err)
+		return &pb.UpdateUserResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to update user: %v", err),
+		}, nil
+	}
+
+	h.kickChangedClients(previous, user)
+
+	return &pb.UpdateUserResponse{
+		Success: true,
+		Message: "User updated successfully",
+	}, nil
+}
+
+// kickChangedClients disconnects the clients of previous that changedClientIDs
+// reports
+func (h *NodeManagerHandler) kickChangedClients(previous, current *services.LocalUser) {
+	changed := changedClientIDs(previous, current)
+	if len(changed) == 0 {
+		return
+	}
+
+	if err := h.localServices.HysteriaManager.KickClients(changed); err != nil {
+		h.logger.Errorf("Failed to kick changed clients of user %s: %v", current.UserID, err)
+	}
+}
+
+// changedClientIDs returns the clients of previous that must be
+// disconnected once the user is replaced by current: those revoked, and the
+// rest too if the speed limits changed. Hysteria takes a client's limits
+// from the auth hook when it connects, so a reconnect applies new limits
+// without a restart.
+func changedClientIDs(previous, current *services.LocalUser) []string {
+	revoked := revokedClientIDs(previous, current)
+	if previous.BandwidthUp == current.BandwidthUp && previous.BandwidthDown == current.BandwidthDown {
+		return revoked
+	}
+	return mergeClientIDs(revoked, previous.ClientIDs())
+}
+
+// revokedClientIDs returns the clients of previous that may no longer
+// connect as current: removed clients, rotated secrets, or the whole user
+// once it is disabled or expired
+func revokedClientIDs(previous, current *services.LocalUser) []string {
+	if !current.Active(time.Now()) {
+		return previous.ClientIDs()
+	}
+
+	var revoked []string
+	for clientID, secret := range previous.Clients {
+		if current.Clients[clientID] != secret {
+			revoked = append(revoked, clientID)
+		}
+	}
+	return revoked
+}
+
+func mergeClientIDs(a, b []string) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	merged := make([]string, 0, len(a)+len(b))
+	for _, ids := range [][]string{a, b} {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				merged = append(merged, id)
+			}
+		}
+	}
+	return merged
+}
+
+// KickClients disconnects clients through the trafficStats API. Their
+// credentials stay valid, so they may reconnect.
+func (h *NodeManagerHandler) KickClients(ctx context.Context, req *pb.KickClientsRequest) (*pb.KickClientsResponse, error) {
+	h.logger.Infof("KickClients called for %d clients", len(req.ClientIds))
+
+	if err := h.localServices.HysteriaManager.KickClients(req.ClientIds); err != nil {
+		h.logger.Errorf("Failed to kick clients: %v", err)
+		return &pb.KickClientsResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to kick clients: %v", err),
+		}, nil
+	}
+
+	return &pb.KickClientsResponse{
+		Success: true,
+		Message: "Clients kicked successfully",
+	}, nil
+}
+
+// GetOnlineClients lists the clients connected to the local Hysteria2
+func (h *NodeManagerHandler) GetOnlineClients(ctx context.Context, req *pb.GetOnlineClientsRequest) (*pb.GetOnlineClientsResponse, error) {
+	online, err := h.localServices.HysteriaManager.OnlineClients()
+	if err != nil {
+		h.logger.Errorf("Failed to get online clients: %v", err)
+		return &pb.GetOnlineClientsResponse{
+			Success: false,
+			Message: fmt.Sprintf("Failed to get online clients: %v", err),
+		}, nil
+	}
+
+	resp := &pb.GetOnlineClientsResponse{Success: true}
+	for clientID, connections := range online {
+		resp.Clients = append(resp.Clients, &pb.OnlineClient{
+			ClientId:    clientID,
+			Connections: int32(connections),
+		})
+	}
+	return resp, nil
 }
 
 func (h *NodeManagerHandler) GetMetrics(ctx context.Context, req *pb.MetricsRequest) (*pb.MetricsResponse, error) {
@@ -179,7 +429,7 @@ func (h *NodeManagerHandler) ConfigureHysteria2(ctx context.Context, req *pb.Con
 	}
 
 	// Save config to file
-	configPath := "/etc/hysteria/config.json"
+	configPath := services.DefaultConfigPath
 	err = os.WriteFile(configPath, []byte(config), 0644)
 	if err != nil {
 		h.logger.Errorf("Failed to save config: %v", err)
@@ -246,7 +496,7 @@ func (h *NodeManagerHandler) GetHysteria2Status(ctx context.Context, req *pb.Get
 	}
 
 	// Convert to protobuf types
-	var statusMap map[string]string
+	statusMap := make(map[string]string)
 	for k, v := range status {
 		if str, ok := v.(string); ok {
 			statusMap[k] = str
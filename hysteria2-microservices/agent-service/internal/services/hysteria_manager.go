@@ -3,8 +3,12 @@ package services
 import (
 	"encoding/json"
 	"fmt"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"hysteria2-microservices/agent-service/internal/config"
@@ -23,18 +27,45 @@ type HysteriaManager interface {
 	DisablePortHopping() error
 	EnableSalamander(password string) error
 	DisableSalamander() error
+	KickClients(clientIDs []string) error
+	OnlineClients() (map[string]int, error)
+	ApplyConfig(version string, data []byte) error
+	ConfigVersion() string
+	ReloadHysteria2() error
 }
 
+// DefaultConfigPath is where the agent writes the Hysteria2 server config
+const DefaultConfigPath = "/etc/hysteria/config.json"
+
+// LastGoodConfigPath keeps the last config Hysteria2 ran with, restored when
+// a new config fails to come up
+const LastGoodConfigPath = DefaultConfigPath + ".last-good"
+
+const (
+	healthProbeAttempts = 5
+	healthProbeInterval = time.Second
+)
+
 type HysteriaManagerImpl struct {
-	logger *logrus.Logger
-	config *config.Config
+	logger       *logrus.Logger
+	config       *config.Config
+	trafficStats *TrafficStatsClient
+	nodeState    NodeState
+
+	// stagedVersion is the version written by ApplyConfig that has not been
+	// reloaded yet
+	mu            sync.Mutex
+	stagedVersion string
 }
 
-// NewHysteriaManager creates a new HysteriaManager
-func NewHysteriaManager(logger *logrus.Logger, cfg *config.Config) HysteriaManager {
+// NewHysteriaManager creates a new HysteriaManager. The applied config
+// version is kept in nodeState so it survives restarts.
+func NewHysteriaManager(logger *logrus.Logger, cfg *config.Config, nodeState NodeState) HysteriaManager {
 	return &HysteriaManagerImpl{
-		logger: logger,
-		config: cfg,
+		logger:       logger,
+		config:       cfg,
+		trafficStats: NewTrafficStatsClient(cfg.Hysteria2.TrafficStatsListen, cfg.Hysteria2.TrafficStatsSecret),
+		nodeState:    nodeState,
 	}
 }
 
@@ -97,10 +128,7 @@ func (hm *HysteriaManagerImpl) generateDefaultConfig() map[string]interface{} {
 			"cert": "/etc/hysteria/cert.pem",
 			"key":  "/etc/hysteria/key.pem",
 		},
-		"auth": map[string]interface{}{
-			"type":     hm.config.Hysteria2.AuthType,
-			"password": hm.config.Hysteria2.AuthPassword,
-		},
+		"auth": hm.generateAuthConfig(),
 		"bandwidth": map[string]interface{}{
 			"up":   fmt.Sprintf("%d mbps", hm.config.Hysteria2.UpMbps),
 			"down": fmt.Sprintf("%d mbps", hm.config.Hysteria2.DownMbps),
@@ -108,6 +136,36 @@ func (hm *HysteriaManagerImpl) generateDefaultConfig() map[string]interface{} {
 	}
 }
 
+// generateAuthConfig builds the auth section. With "http" auth every client
+// is validated per device by the api-service instead of a shared password.
+// With "local" auth Hysteria asks the agent, which checks its own user table,
+// so user changes apply without restarting the server.
+func (hm *HysteriaManagerImpl) generateAuthConfig() map[string]interface{} {
+	if hm.config.Hysteria2.AuthType == "local" {
+		return map[string]interface{}{
+			"type": "http",
+			"http": map[string]interface{}{
+				"url": fmt.Sprintf("http://%s/auth", hm.config.Hysteria2.LocalAuthListen),
+			},
+		}
+	}
+
+	if hm.config.Hysteria2.AuthType == "http" {
+		return map[string]interface{}{
+			"type": "http",
+			"http": map[string]interface{}{
+				"url":      hm.config.Hysteria2.AuthURL,
+				"insecure": hm.config.Hysteria2.AuthInsecure,
+			},
+		}
+	}
+
+	return map[string]interface{}{
+		"type":     hm.config.Hysteria2.AuthType,
+		"password": hm.config.Hysteria2.AuthPassword,
+	}
+}
+
 func (hm *HysteriaManagerImpl) applyConfigOptions(config map[string]interface{}) {
 	// Apply Salamander obfuscation
 	if hm.config.Hysteria2.SalamanderEnabled {
@@ -117,6 +175,14 @@ func (hm *HysteriaManagerImpl) applyConfigOptions(config map[string]interface{})
 		}
 	}
 
+	// Expose the trafficStats API used by the metrics collector
+	if hm.config.Hysteria2.TrafficStatsListen != "" {
+		config["trafficStats"] = map[string]interface{}{
+			"listen": hm.config.Hysteria2.TrafficStatsListen,
+			"secret": hm.config.Hysteria2.TrafficStatsSecret,
+		}
+	}
+
 	// Apply Port Hopping
 	if hm.config.Hysteria2.PortHopping {
 		config["hopping"] = map[string]interface{}{
@@ -215,26 +281,28 @@ func (hm *HysteriaManagerImpl) RestartHysteria2(configPath string) error {
 // GetHysteria2Status returns Hysteria2 service status
 func (hm *HysteriaManagerImpl) GetHysteria2Status() (map[string]interface{}, error) {
 	status := map[string]interface{}{
-		"installed": hm.IsHysteria2Installed(),
-		"running":   false,
-		"systemd":   hm.config.Hysteria2.EnableSystemd,
+		"installed":      hm.IsHysteria2Installed(),
+		"systemd":        hm.config.Hysteria2.EnableSystemd,
+		"config_version": hm.ConfigVersion(),
 	}
 
+	status["running"] = hm.isRunning()
+
+	return status, nil
+}
+
+// isRunning reports whether the Hysteria2 server process is up
+func (hm *HysteriaManagerImpl) isRunning() bool {
 	if hm.config.Hysteria2.EnableSystemd {
 		// Check systemd status
 		cmd := exec.Command("systemctl", "is-active", "hysteria2")
 		output, err := cmd.Output()
-		if err == nil && strings.TrimSpace(string(output)) == "active" {
-			status["running"] = true
-		}
-	} else {
-		// Check if process is running
-		cmd := exec.Command("pgrep", "-f", "hysteria")
-		err := cmd.Run()
-		status["running"] = err == nil
+		return err == nil && strings.TrimSpace(string(output)) == "active"
 	}
 
-	return status, nil
+	// Check if process is running
+	cmd := exec.Command("pgrep", "-f", "hysteria")
+	return cmd.Run() == nil
 }
 
 // EnablePortHopping enables port hopping
@@ -282,3 +350,181 @@ func (hm *HysteriaManagerImpl) runCommand(name string, args ...string) error {
 	hm.logger.Debugf("Running command: %s %v", name, args)
 	return cmd.Run()
 }
+
+// KickClients disconnects the given clients through the trafficStats API
+func (hm *HysteriaManagerImpl) KickClients(clientIDs []string) error {
+	if hm.config.Hysteria2.TrafficStatsListen == "" {
+		return fmt.Errorf("trafficStats API is disabled")
+	}
+
+	if err := hm.trafficStats.Kick(clientIDs); err != nil {
+		return fmt.Errorf("failed to kick clients: %w", err)
+	}
+
+	hm.logger.Infof("Kicked %d Hysteria2 clients", len(clientIDs))
+	return nil
+}
+
+// OnlineClients returns the number of open connections per connected client
+func (hm *HysteriaManagerImpl) OnlineClients() (map[string]int, error) {
+	if hm.config.Hysteria2.TrafficStatsListen == "" {
+		return nil, fmt.Errorf("trafficStats API is disabled")
+	}
+
+	online, err := hm.trafficStats.GetOnline()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get online clients: %w", err)
+	}
+	return online, nil
+}
+
+// ApplyConfig validates a pushed Hysteria2 config and stages it in
+// DefaultConfigPath. The file is replaced atomically so a failed write never
+// leaves a truncated config behind. The config Hysteria2 currently runs with
+// is kept as the last known good one, and the version is only recorded once
+// ReloadHysteria2 brought the server up with the new config.
+func (hm *HysteriaManagerImpl) ApplyConfig(version string, data []byte) error {
+	if _, err := parseConfig(data); err != nil {
+		return err
+	}
+
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	// A config staged earlier never ran, so it must not replace the backup
+	if hm.stagedVersion == "" {
+		if current, err := os.ReadFile(DefaultConfigPath); err == nil {
+			if err := writeFileAtomic(LastGoodConfigPath, current); err != nil {
+				return fmt.Errorf("failed to keep last known good config: %w", err)
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read current config: %w", err)
+		}
+	}
+
+	if err := writeConfig(data); err != nil {
+		return err
+	}
+	hm.stagedVersion = version
+
+	hm.logger.Infof("Staged Hysteria2 config version %s", version)
+	return nil
+}
+
+// ConfigVersion returns the version of the last config Hysteria2 came up with
+func (hm *HysteriaManagerImpl) ConfigVersion() string {
+	return hm.nodeState.ConfigVersion()
+}
+
+// ReloadHysteria2 restarts Hysteria2 with the config on disk and probes that
+// the server stays up. On success a staged config becomes the node's config
+// version and the last known good one. Otherwise the last known good config
+// is restored and Hysteria2 restarted with it, so a bad push never leaves the
+// node down.
+func (hm *HysteriaManagerImpl) ReloadHysteria2() error {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	data, err := os.ReadFile(DefaultConfigPath)
+	if err != nil {
+		return hm.restoreLastGood(fmt.Errorf("failed to read config: %w", err))
+	}
+	if _, err := parseConfig(data); err != nil {
+		return hm.restoreLastGood(err)
+	}
+
+	if err := hm.restartAndProbe(); err != nil {
+		return hm.restoreLastGood(err)
+	}
+
+	if err := writeFileAtomic(LastGoodConfigPath, data); err != nil {
+		hm.logger.Warnf("Failed to keep last known good config: %v", err)
+	}
+
+	if hm.stagedVersion != "" {
+		if err := hm.nodeState.SetConfigVersion(hm.stagedVersion); err != nil {
+			// Hysteria2 runs the new config and the in-memory version is updated; only persisting failed
+			hm.logger.Errorf("Failed to persist config version %s: %v", hm.stagedVersion, err)
+		}
+		hm.logger.Infof("Hysteria2 is running config version %s", hm.stagedVersion)
+		hm.stagedVersion = ""
+	}
+
+	return nil
+}
+
+// restartAndProbe restarts Hysteria2 and checks that it stays running for a
+// few seconds; a server rejecting its config exits right after the restart
+func (hm *HysteriaManagerImpl) restartAndProbe() error {
+	if err := hm.RestartHysteria2(DefaultConfigPath); err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < healthProbeAttempts; attempt++ {
+		time.Sleep(healthProbeInterval)
+
+		if !hm.isRunning() {
+			return fmt.Errorf("health probe failed: hysteria2 is not running")
+		}
+	}
+
+	return nil
+}
+
+// restoreLastGood puts the last known good config back after cause made a
+// reload fail and restarts Hysteria2 with it. The staged version is dropped,
+// so the node keeps reporting the version it runs.
+func (hm *HysteriaManagerImpl) restoreLastGood(cause error) error {
+	staged := hm.stagedVersion
+	hm.stagedVersion = ""
+
+	data, err := os.ReadFile(LastGoodConfigPath)
+	if err != nil {
+		return fmt.Errorf("%w; no last known good config to restore: %v", cause, err)
+	}
+	if err := writeConfig(data); err != nil {
+		return fmt.Errorf("%w; failed to restore last known good config: %v", cause, err)
+	}
+	if err := hm.restartAndProbe(); err != nil {
+		return fmt.Errorf("%w; restored last known good config but Hysteria2 did not come up: %v", cause, err)
+	}
+
+	hm.logger.Warnf("Restored last known good config after config version %q failed: %v", staged, cause)
+	return fmt.Errorf("%w; restored last known good config", cause)
+}
+
+// parseConfig checks that data is a Hysteria2 server config the agent can run
+func parseConfig(data []byte) (map[string]interface{}, error) {
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	if _, ok := parsed["listen"]; !ok {
+		return nil, fmt.Errorf("invalid config: missing listen address")
+	}
+	if _, ok := parsed["auth"]; !ok {
+		return nil, fmt.Errorf("invalid config: missing auth section")
+	}
+	return parsed, nil
+}
+
+// writeConfig replaces DefaultConfigPath atomically so a failed write never
+// leaves a truncated config behind
+func writeConfig(data []byte) error {
+	return writeFileAtomic(DefaultConfigPath, data)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace config: %w", err)
+	}
+	return nil
+}
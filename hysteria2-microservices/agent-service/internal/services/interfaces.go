@@ -1,5 +1,7 @@
 package services
 
+import "crypto/tls"
+
 // ConfigManager handles configuration management
 type ConfigManager interface {
 	GetConfig() (map[string]interface{}, error)
@@ -9,6 +11,7 @@ type ConfigManager interface {
 // MetricsCollector collects system metrics
 type MetricsCollector interface {
 	Collect() (map[string]interface{}, error)
+	CollectTraffic() ([]ClientTraffic, error)
 	StartCollection() error
 	StopCollection() error
 }
@@ -31,6 +34,29 @@ type NetworkManager interface {
 	GetNetworkInterfaces() ([]string, error)
 }
 
+// UserStore keeps the users provisioned onto this node, persisted on disk
+type UserStore interface {
+	Get(userID string) (*LocalUser, bool)
+	List() []*LocalUser
+	Put(user *LocalUser) error
+	Remove(userID string) (*LocalUser, error)
+	Authenticate(auth string) (*LocalUser, string, error)
+}
+
+// NodeState persists the node's identity, enrollment credentials and last
+// applied config version
+type NodeState interface {
+	Enrolled() bool
+	NodeID() string
+	ConfigVersion() string
+	SetConfigVersion(version string) error
+	CreateCSR(commonName string) ([]byte, error)
+	StoreCredentials(certPEM, caPEM []byte) error
+	ClientTLSConfig(caFile string) (*tls.Config, error)
+	ServerTLSConfig() (*tls.Config, error)
+	BootstrapTLSConfig(caFile, fingerprint string) (*tls.Config, error)
+}
+
 // LocalServices aggregates all local services
 type LocalServices struct {
 	ConfigManager    ConfigManager
@@ -38,4 +64,6 @@ type LocalServices struct {
 	SystemManager    SystemManager
 	NetworkManager   NetworkManager
 	HysteriaManager  HysteriaManager
+	UserStore        UserStore
+	NodeState        NodeState
 }
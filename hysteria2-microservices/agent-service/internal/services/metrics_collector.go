@@ -1,7 +1,9 @@
 package services
 
 import (
+	"fmt"
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -11,15 +13,23 @@ import (
 // MetricsCollectorImpl implements MetricsCollector interface
 type MetricsCollectorImpl struct {
 	logger          *logrus.Logger
+	trafficStats    *TrafficStatsClient
+	trafficEnabled  bool
 	collectInterval time.Duration
 	reportInterval  time.Duration
 	stopChan        chan struct{}
+
+	// CPU usage is measured between two calls of Collect
+	mu      sync.Mutex
+	lastCPU cpuTimes
 }
 
 // NewMetricsCollector creates a new MetricsCollector
 func NewMetricsCollector(cfg *config.Config, logger *logrus.Logger) MetricsCollector {
 	return &MetricsCollectorImpl{
 		logger:          logger,
+		trafficStats:    NewTrafficStatsClient(cfg.Hysteria2.TrafficStatsListen, cfg.Hysteria2.TrafficStatsSecret),
+		trafficEnabled:  cfg.Hysteria2.TrafficStatsListen != "",
 		collectInterval: time.Duration(cfg.Metrics.CollectInterval) * time.Second,
 		reportInterval:  time.Duration(cfg.Metrics.ReportInterval) * time.Second,
 		stopChan:        make(chan struct{}),
@@ -41,9 +51,69 @@ func (mc *MetricsCollectorImpl) Collect() (map[string]interface{}, error) {
 		"timestamp":    time.Now().Unix(),
 	}
 
+	// Host metrics reported to the master with every heartbeat. A metric
+	// that cannot be read is left out rather than reported as zero.
+	if cpu, err := readCPUTimes(); err == nil {
+		mc.mu.Lock()
+		if mc.lastCPU.total > 0 {
+			metrics["cpu_usage"] = cpuUsage(mc.lastCPU, cpu)
+		}
+		mc.lastCPU = cpu
+		mc.mu.Unlock()
+	} else {
+		mc.logger.Debugf("Failed to read CPU times: %v", err)
+	}
+
+	if usage, err := readMemoryUsage(); err == nil {
+		metrics["memory_usage"] = usage
+	} else {
+		mc.logger.Debugf("Failed to read memory usage: %v", err)
+	}
+
+	if mc.trafficEnabled {
+		if online, err := mc.trafficStats.GetOnline(); err == nil {
+			connections := 0
+			for _, n := range online {
+				connections += n
+			}
+			metrics["active_connections"] = connections
+		} else {
+			mc.logger.Debugf("Failed to read online clients: %v", err)
+		}
+	}
+
 	return metrics, nil
 }
 
+// CollectTraffic polls the local Hysteria2 trafficStats API and returns the
+// per-client traffic since the previous call together with open connections
+func (mc *MetricsCollectorImpl) CollectTraffic() ([]ClientTraffic, error) {
+	traffic, err := mc.trafficStats.GetTraffic(true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read traffic stats: %w", err)
+	}
+
+	online, err := mc.trafficStats.GetOnline()
+	if err != nil {
+		// Counters were already cleared, so still report them
+		mc.logger.Warnf("Failed to read online clients: %v", err)
+	}
+
+	for id, connections := range online {
+		entry := traffic[id]
+		entry.ClientID = id
+		entry.Connections = connections
+		traffic[id] = entry
+	}
+
+	clients := make([]ClientTraffic, 0, len(traffic))
+	for _, entry := range traffic {
+		clients = append(clients, entry)
+	}
+
+	return clients, nil
+}
+
 // StartCollection starts periodic metrics collection
 func (mc *MetricsCollectorImpl) StartCollection() error {
 	mc.logger.Info("Starting metrics collection")
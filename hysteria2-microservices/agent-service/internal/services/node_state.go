@@ -0,0 +1,361 @@
+package services
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"hysteria2-microservices/agent-service/internal/config"
+)
+
+// OrchestratorClientName is the common name of the client certificate the
+// orchestrator presents to the NodeManager server. It must match the
+// orchestrator's pki.OrchestratorClientName.
+const OrchestratorClientName = "Hysteria2 Orchestrator Client"
+
+// agentState is the on-disk form of NodeStateImpl
+type agentState struct {
+	NodeID        string     `json:"node_id"`
+	Certificate   string     `json:"certificate"`    // PEM client certificate issued at enrollment
+	PrivateKey    string     `json:"private_key"`    // PEM PKCS#8 key the certificate was issued for
+	CACertificate string     `json:"ca_certificate"` // PEM master CA
+	ConfigVersion string     `json:"config_version,omitempty"`
+	EnrolledAt    *time.Time `json:"enrolled_at,omitempty"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// NodeStateImpl persists the node's identity across restarts: the node ID
+// assigned by the master, the enrollment credentials and the last applied
+// config version. The certificate's common name is the node ID.
+type NodeStateImpl struct {
+	logger *logrus.Logger
+	path   string
+
+	mu         sync.RWMutex
+	state      agentState
+	pendingKey *ecdsa.PrivateKey
+	cert       *tls.Certificate
+}
+
+// NewNodeState creates a NodeState backed by the configured state file and
+// loads the state left by a previous run
+func NewNodeState(logger *logrus.Logger, cfg *config.Config) NodeState {
+	n := &NodeStateImpl{
+		logger: logger,
+		path:   cfg.Node.StateFile,
+	}
+
+	if err := n.load(); err != nil {
+		logger.Errorf("Failed to load node state from %s: %v", n.path, err)
+	} else if n.cert != nil {
+		logger.Infof("Loaded node state for %s from %s", n.state.NodeID, n.path)
+	}
+
+	return n
+}
+
+// Enrolled reports whether the node holds credentials issued by the master
+func (n *NodeStateImpl) Enrolled() bool {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.cert != nil
+}
+
+// NodeID returns the ID assigned by the master, or "" before enrollment
+func (n *NodeStateImpl) NodeID() string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.state.NodeID
+}
+
+// ConfigVersion returns the last Hysteria2 config version the agent applied
+func (n *NodeStateImpl) ConfigVersion() string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return n.state.ConfigVersion
+}
+
+// SetConfigVersion records an applied config version
+func (n *NodeStateImpl) SetConfigVersion(version string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.state.ConfigVersion = version
+	return n.save()
+}
+
+// CreateCSR generates a new private key and returns a PEM encoded CSR for
+// it. The key is kept in memory until StoreCredentials persists the issued
+// certificate.
+func (n *NodeStateImpl) CreateCSR(commonName string) ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate node key: %w", err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CSR: %w", err)
+	}
+
+	n.mu.Lock()
+	n.pendingKey = key
+	n.mu.Unlock()
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}), nil
+}
+
+// StoreCredentials persists the certificate issued for the key from
+// CreateCSR along with the master CA
+func (n *NodeStateImpl) StoreCredentials(certPEM, caPEM []byte) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.pendingKey == nil {
+		return errors.New("no pending key, call CreateCSR first")
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(n.pendingKey)
+	if err != nil {
+		return fmt.Errorf("failed to encode node key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+
+	cert, nodeID, err := parseCredentials(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	n.state.NodeID = nodeID
+	n.state.Certificate = string(certPEM)
+	n.state.PrivateKey = string(keyPEM)
+	n.state.CACertificate = string(caPEM)
+	n.state.EnrolledAt = &now
+	n.cert = cert
+	n.pendingKey = nil
+
+	if err := n.save(); err != nil {
+		return err
+	}
+
+	n.logger.Infof("Stored node state for %s in %s", nodeID, n.path)
+	return nil
+}
+
+// ClientTLSConfig returns the mTLS config for MasterService calls. The
+// master is verified against caFile when set, otherwise the stored CA.
+func (n *NodeStateImpl) ClientTLSConfig(caFile string) (*tls.Config, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if n.cert == nil {
+		return nil, errors.New("node is not enrolled")
+	}
+
+	caPEM := []byte(n.state.CACertificate)
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master CA file: %w", err)
+		}
+		caPEM = data
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no valid master CA certificates")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{*n.cert},
+		RootCAs:      pool,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// ServerTLSConfig returns the mTLS config for the NodeManager server, which
+// serves the node's certificate and only accepts the orchestrator's client
+// certificate. Clients are verified against the CA stored at enrollment,
+// which signs node certificates too, so the common name is checked as well.
+func (n *NodeStateImpl) ServerTLSConfig() (*tls.Config, error) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	if n.cert == nil {
+		return nil, errors.New("node is not enrolled")
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(n.state.CACertificate)) {
+		return nil, errors.New("no valid master CA certificates")
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{*n.cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 || state.PeerCertificates[0].Subject.CommonName != OrchestratorClientName {
+				return errors.New("client certificate is not the orchestrator's")
+			}
+			return nil
+		},
+	}, nil
+}
+
+// BootstrapTLSConfig returns the TLS config used to enroll. Without a
+// client certificate yet, the master is trusted either through caFile or by
+// pinning the SHA-256 fingerprint of the CA it presents in its chain.
+func (n *NodeStateImpl) BootstrapTLSConfig(caFile, fingerprint string) (*tls.Config, error) {
+	if caFile != "" {
+		data, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read master CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no valid master CA certificates")
+		}
+		return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+	}
+
+	pinned, err := hex.DecodeString(strings.ReplaceAll(strings.ToLower(fingerprint), ":", ""))
+	if err != nil || len(pinned) != sha256.Size {
+		return nil, errors.New("master CA file or a valid sha256 CA fingerprint is required to enroll")
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		// Chain verification is done against the pinned CA below
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPinnedChain(rawCerts, pinned)
+		},
+	}, nil
+}
+
+func parseCredentials(certPEM, keyPEM []byte) (*tls.Certificate, string, error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, "", fmt.Errorf("invalid node certificate: %w", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse node certificate: %w", err)
+	}
+	if leaf.Subject.CommonName == "" {
+		return nil, "", errors.New("node certificate has no node ID")
+	}
+
+	cert.Leaf = leaf
+	return &cert, leaf.Subject.CommonName, nil
+}
+
+func (n *NodeStateImpl) load() error {
+	data, err := os.ReadFile(n.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read node state: %w", err)
+	}
+
+	var state agentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("failed to parse node state: %w", err)
+	}
+
+	if state.Certificate != "" {
+		cert, nodeID, err := parseCredentials([]byte(state.Certificate), []byte(state.PrivateKey))
+		if err != nil {
+			return err
+		}
+		if nodeID != state.NodeID {
+			return fmt.Errorf("node certificate is for %s, state is for %s", nodeID, state.NodeID)
+		}
+		n.cert = cert
+	}
+
+	n.state = state
+	return nil
+}
+
+// save must be called with mu held. The file is replaced atomically.
+func (n *NodeStateImpl) save() error {
+	n.state.UpdatedAt = time.Now()
+
+	data, err := json.MarshalIndent(n.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal node state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(n.path), 0700); err != nil {
+		return fmt.Errorf("failed to create node state directory: %w", err)
+	}
+
+	tmp := n.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write node state: %w", err)
+	}
+	if err := os.Rename(tmp, n.path); err != nil {
+		return fmt.Errorf("failed to replace node state: %w", err)
+	}
+
+	return nil
+}
+
+func verifyPinnedChain(rawCerts [][]byte, pinned []byte) error {
+	if len(rawCerts) == 0 {
+		return errors.New("master presented no certificate")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse master certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	roots := x509.NewCertPool()
+	intermediates := x509.NewCertPool()
+	found := false
+	for _, cert := range certs[1:] {
+		sum := sha256.Sum256(cert.Raw)
+		if cert.IsCA && bytes.Equal(sum[:], pinned) {
+			roots.AddCert(cert)
+			found = true
+		} else {
+			intermediates.AddCert(cert)
+		}
+	}
+	if !found {
+		return errors.New("master CA does not match the pinned fingerprint")
+	}
+
+	if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+		return fmt.Errorf("master certificate not signed by pinned CA: %w", err)
+	}
+	return nil
+}
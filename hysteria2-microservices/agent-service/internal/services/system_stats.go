@@ -0,0 +1,94 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// cpuTimes holds the aggregate jiffies from the first line of /proc/stat
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+// readCPUTimes reads the time spent idle and in total by all CPUs since boot
+func readCPUTimes() (cpuTimes, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		return cpuTimes{}, fmt.Errorf("empty /proc/stat")
+	}
+
+	fields := strings.Fields(scanner.Text())
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return cpuTimes{}, fmt.Errorf("unexpected /proc/stat line: %q", scanner.Text())
+	}
+
+	var times cpuTimes
+	for i, field := range fields[1:] {
+		value, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return cpuTimes{}, fmt.Errorf("invalid /proc/stat value %q: %w", field, err)
+		}
+		times.total += value
+		// idle and iowait
+		if i == 3 || i == 4 {
+			times.idle += value
+		}
+	}
+	return times, nil
+}
+
+// cpuUsage returns the percentage of CPU time spent busy between two samples
+func cpuUsage(prev, cur cpuTimes) float64 {
+	total := cur.total - prev.total
+	if cur.total <= prev.total || total == 0 {
+		return 0
+	}
+	idle := cur.idle - prev.idle
+	if cur.idle < prev.idle {
+		idle = 0
+	}
+	return float64(total-idle) / float64(total) * 100
+}
+
+// readMemoryUsage returns the percentage of memory in use, counting
+// reclaimable memory as available
+func readMemoryUsage() (float64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var total, available uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "MemTotal:":
+			total, _ = strconv.ParseUint(fields[1], 10, 64)
+		case "MemAvailable:":
+			available, _ = strconv.ParseUint(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if total == 0 || available > total {
+		return 0, fmt.Errorf("unexpected /proc/meminfo contents")
+	}
+
+	return float64(total-available) / float64(total) * 100, nil
+}
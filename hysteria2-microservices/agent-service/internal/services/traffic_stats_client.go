@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ClientTraffic holds per-client counters read from the Hysteria2 trafficStats API
+type ClientTraffic struct {
+	ClientID    string
+	Upload      int64
+	Download    int64
+	Connections int
+}
+
+// TrafficStatsClient talks to the local Hysteria2 trafficStats API
+type TrafficStatsClient struct {
+	baseURL    string
+	secret     string
+	httpClient *http.Client
+}
+
+// NewTrafficStatsClient creates a client for the trafficStats API listening on listen
+func NewTrafficStatsClient(listen, secret string) *TrafficStatsClient {
+	// Hysteria accepts ":9999" style listen addresses; reach them over loopback
+	host := listen
+	if strings.HasPrefix(host, ":") {
+		host = "127.0.0.1" + host
+	}
+
+	return &TrafficStatsClient{
+		baseURL:    "http://" + host,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GetTraffic returns per-client byte counters. With clear set, Hysteria resets
+// the counters after reading so the result is a delta since the last call.
+func (c *TrafficStatsClient) GetTraffic(clear bool) (map[string]ClientTraffic, error) {
+	path := "/traffic"
+	if clear {
+		path += "?clear=1"
+	}
+
+	// Hysteria reports tx as bytes sent by the client and rx as bytes received
+	var raw map[string]struct {
+		Tx int64 `json:"tx"`
+		Rx int64 `json:"rx"`
+	}
+	if err := c.do(http.MethodGet, path, nil, &raw); err != nil {
+		return nil, err
+	}
+
+	traffic := make(map[string]ClientTraffic, len(raw))
+	for id, stats := range raw {
+		traffic[id] = ClientTraffic{
+			ClientID: id,
+			Upload:   stats.Tx,
+			Download: stats.Rx,
+		}
+	}
+
+	return traffic, nil
+}
+
+// GetOnline returns the number of open connections per online client
+func (c *TrafficStatsClient) GetOnline() (map[string]int, error) {
+	var online map[string]int
+	if err := c.do(http.MethodGet, "/online", nil, &online); err != nil {
+		return nil, err
+	}
+	return online, nil
+}
+
+// Kick disconnects the given clients. They can reconnect unless their
+// credentials are rejected by the auth backend.
+func (c *TrafficStatsClient) Kick(clientIDs []string) error {
+	if len(clientIDs) == 0 {
+		return nil
+	}
+	return c.do(http.MethodPost, "/kick", clientIDs, nil)
+}
+
+func (c *TrafficStatsClient) do(method, path string, body interface{}, dest interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if c.secret != "" {
+		req.Header.Set("Authorization", c.secret)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("trafficStats request %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("trafficStats request %s returned status %d", path, resp.StatusCode)
+	}
+
+	if dest == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
+		return fmt.Errorf("failed to decode trafficStats response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,352 @@
+package services
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"hysteria2-microservices/agent-service/internal/config"
+)
+
+// Keys understood in AddUserRequest/UpdateUserRequest user_config
+const (
+	// UserConfigClientPrefix + client ID maps a Hysteria2 client (device ID) to
+	// its auth secret. An empty value removes the client on update.
+	UserConfigClientPrefix = "client."
+	// UserConfigBandwidthUp and UserConfigBandwidthDown cap the upload and
+	// download speed of each of the user's clients, e.g. "50 mbps". A bare
+	// number is bytes per second, as in Hysteria. Empty leaves the node's.
+	UserConfigBandwidthUp   = "bandwidth_up"
+	UserConfigBandwidthDown = "bandwidth_down"
+	// UserConfigExpiresAt is an RFC 3339 timestamp after which auth fails
+	UserConfigExpiresAt = "expires_at"
+	// UserConfigDisabled set to "true" rejects the user without removing it
+	UserConfigDisabled = "disabled"
+)
+
+// LocalUser is a user provisioned onto this node by the orchestrator
+type LocalUser struct {
+	UserID        string            `json:"user_id"`
+	Clients       map[string]string `json:"clients"`                  // client ID -> auth secret
+	BandwidthUp   uint64            `json:"bandwidth_up,omitempty"`   // bytes per second, 0 = node default
+	BandwidthDown uint64            `json:"bandwidth_down,omitempty"` // bytes per second, 0 = node default
+	ExpiresAt     *time.Time        `json:"expires_at,omitempty"`
+	Disabled      bool              `json:"disabled,omitempty"`
+	UpdatedAt     time.Time         `json:"updated_at"`
+}
+
+// ClientIDs returns the Hysteria2 client IDs belonging to the user
+func (u *LocalUser) ClientIDs() []string {
+	ids := make([]string, 0, len(u.Clients))
+	for id := range u.Clients {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Active reports whether the user may currently connect
+func (u *LocalUser) Active(now time.Time) bool {
+	if u.Disabled {
+		return false
+	}
+	return u.ExpiresAt == nil || now.Before(*u.ExpiresAt)
+}
+
+// ApplyUserConfig merges user_config values into the user
+func (u *LocalUser) ApplyUserConfig(userConfig map[string]string) error {
+	if u.Clients == nil {
+		u.Clients = make(map[string]string)
+	}
+
+	for key, value := range userConfig {
+		switch {
+		case strings.HasPrefix(key, UserConfigClientPrefix):
+			clientID := strings.TrimPrefix(key, UserConfigClientPrefix)
+			if clientID == "" || strings.Contains(clientID, ":") {
+				return fmt.Errorf("invalid client ID %q", clientID)
+			}
+			if value == "" {
+				delete(u.Clients, clientID)
+			} else {
+				u.Clients[clientID] = value
+			}
+		case key == UserConfigBandwidthUp:
+			bps, err := ParseBandwidth(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			u.BandwidthUp = bps
+		case key == UserConfigBandwidthDown:
+			bps, err := ParseBandwidth(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			u.BandwidthDown = bps
+		case key == UserConfigExpiresAt:
+			if value == "" {
+				u.ExpiresAt = nil
+				continue
+			}
+			expiresAt, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			u.ExpiresAt = &expiresAt
+		case key == UserConfigDisabled:
+			disabled, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", key, err)
+			}
+			u.Disabled = disabled
+		default:
+			return fmt.Errorf("unknown user_config key %q", key)
+		}
+	}
+
+	return nil
+}
+
+// ParseBandwidth converts a Hysteria bandwidth string to bytes per second.
+// An empty string means no per-user cap.
+func ParseBandwidth(value string) (uint64, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+
+	units := []struct {
+		suffix string
+		bits   uint64
+	}{
+		{"gbps", 1000 * 1000 * 1000},
+		{"mbps", 1000 * 1000},
+		{"kbps", 1000},
+		{"bps", 1},
+	}
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			n, err := strconv.ParseUint(strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * unit.bits / 8, nil
+		}
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
+
+type userStoreFile struct {
+	Users []*LocalUser `json:"users"`
+}
+
+type UserStoreImpl struct {
+	logger *logrus.Logger
+	path   string
+
+	mu      sync.RWMutex
+	users   map[string]*LocalUser
+	clients map[string]string // client ID -> user ID
+}
+
+// NewUserStore creates a UserStore backed by the configured users file and
+// loads any users persisted by a previous run
+func NewUserStore(logger *logrus.Logger, cfg *config.Config) UserStore {
+	s := &UserStoreImpl{
+		logger:  logger,
+		path:    cfg.Hysteria2.UsersFile,
+		users:   make(map[string]*LocalUser),
+		clients: make(map[string]string),
+	}
+
+	if err := s.load(); err != nil {
+		logger.Errorf("Failed to load user table from %s: %v", s.path, err)
+	} else {
+		logger.Infof("Loaded %d users from %s", len(s.users), s.path)
+	}
+
+	return s
+}
+
+// Get returns a copy of the user
+func (s *UserStoreImpl) Get(userID string) (*LocalUser, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, false
+	}
+	return copyUser(user), true
+}
+
+// List returns copies of all users
+func (s *UserStoreImpl) List() []*LocalUser {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*LocalUser, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, copyUser(user))
+	}
+	return users
+}
+
+// Put inserts or replaces a user and persists the table
+func (s *UserStoreImpl) Put(user *LocalUser) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for clientID := range user.Clients {
+		if owner, ok := s.clients[clientID]; ok && owner != user.UserID {
+			return fmt.Errorf("client %s already belongs to user %s", clientID, owner)
+		}
+	}
+
+	previous := s.users[user.UserID]
+
+	user = copyUser(user)
+	user.UpdatedAt = time.Now()
+	s.setUser(user)
+
+	if err := s.save(); err != nil {
+		// Keep memory consistent with what is on disk
+		s.deleteUser(user.UserID)
+		if previous != nil {
+			s.setUser(previous)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Remove deletes a user, persists the table and returns the removed entry
+func (s *UserStoreImpl) Remove(userID string) (*LocalUser, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[userID]
+	if !ok {
+		return nil, nil
+	}
+
+	s.deleteUser(userID)
+	if err := s.save(); err != nil {
+		s.setUser(user)
+		return nil, err
+	}
+
+	return copyUser(user), nil
+}
+
+// Authenticate validates a "<client_id>:<secret>" credential against the
+// table and returns the owning user and the client ID
+func (s *UserStoreImpl) Authenticate(auth string) (*LocalUser, string, error) {
+	clientID, secret, ok := strings.Cut(auth, ":")
+	if !ok || clientID == "" || secret == "" {
+		return nil, "", fmt.Errorf("malformed credentials")
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	userID, ok := s.clients[clientID]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown client %s", clientID)
+	}
+	user := s.users[userID]
+
+	if subtle.ConstantTimeCompare([]byte(user.Clients[clientID]), []byte(secret)) != 1 {
+		return nil, "", fmt.Errorf("invalid secret for client %s", clientID)
+	}
+	if !user.Active(time.Now()) {
+		return nil, "", fmt.Errorf("user %s is disabled or expired", userID)
+	}
+
+	return copyUser(user), clientID, nil
+}
+
+func (s *UserStoreImpl) setUser(user *LocalUser) {
+	s.deleteUser(user.UserID)
+	s.users[user.UserID] = user
+	for clientID := range user.Clients {
+		s.clients[clientID] = user.UserID
+	}
+}
+
+func (s *UserStoreImpl) deleteUser(userID string) {
+	if user, ok := s.users[userID]; ok {
+		for clientID := range user.Clients {
+			delete(s.clients, clientID)
+		}
+		delete(s.users, userID)
+	}
+}
+
+func (s *UserStoreImpl) load() error {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var file userStoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("invalid user table: %w", err)
+	}
+
+	for _, user := range file.Users {
+		s.setUser(user)
+	}
+	return nil
+}
+
+// save writes the table atomically so a crash never leaves a truncated file
+func (s *UserStoreImpl) save() error {
+	file := userStoreFile{Users: make([]*LocalUser, 0, len(s.users))}
+	for _, user := range s.users {
+		file.Users = append(file.Users, user)
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal user table: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create user table directory: %w", err)
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write user table: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("failed to replace user table: %w", err)
+	}
+
+	return nil
+}
+
+func copyUser(user *LocalUser) *LocalUser {
+	c := *user
+	c.Clients = make(map[string]string, len(user.Clients))
+	for id, secret := range user.Clients {
+		c.Clients[id] = secret
+	}
+	if user.ExpiresAt != nil {
+		expiresAt := *user.ExpiresAt
+		c.ExpiresAt = &expiresAt
+	}
+	return &c
+}
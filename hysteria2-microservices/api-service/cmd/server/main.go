@@ -11,10 +11,15 @@ import (
 	"hysteria2-microservices/api-service/internal/database"
 	"hysteria2-microservices/api-service/internal/handlers"
 	"hysteria2-microservices/api-service/internal/middleware"
+	"hysteria2-microservices/api-service/internal/models"
 	"hysteria2-microservices/api-service/internal/repositories"
 	"hysteria2-microservices/api-service/internal/services"
 	"hysteria2-microservices/api-service/pkg/cache"
 	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/mailer"
+	"hysteria2-microservices/api-service/pkg/orchestrator"
+	"hysteria2-microservices/api-service/pkg/password"
+	"hysteria2-microservices/api-service/pkg/ratelimit"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
@@ -47,22 +52,83 @@ func main() {
 	sessionRepo := repositories.NewSessionRepository(db)
 	trafficRepo := repositories.NewTrafficRepository(db)
 	nodeRepo := repositories.NewNodeRepository(db)
+	hysteriaConfigRepo := repositories.NewHysteriaConfigRepository(db)
+	mfaRepo := repositories.NewMFARepository(db)
+	inviteRepo := repositories.NewInviteCodeRepository(db)
+	planRepo := repositories.NewPlanRepository(db)
+	subscriptionRepo := repositories.NewSubscriptionRepository(db)
+
+	// Initialize orchestrator client
+	orchestratorClient := orchestrator.NewClient(cfg.OrchestratorURL, cfg.InternalAPISecret)
+
+	// WebSocket hub is created first since services push real-time events
+	// through it. Events are relayed to the other replicas over Redis.
+	wsHandler := handlers.NewWebSocketHandler(redisClient, appLogger)
+
+	// Rate limits and login lockouts are kept in Redis and shared by all replicas
+	limiter := ratelimit.NewLimiter(redisClient)
+	loginLockout := ratelimit.Lockout{
+		Threshold: cfg.LoginLockoutThreshold,
+		Base:      time.Second * time.Duration(cfg.LoginLockoutSeconds),
+		Max:       time.Second * time.Duration(cfg.LoginLockoutMaxSeconds),
+	}
+
+	// Outgoing mail, written to files unless an SMTP server is configured
+	var mail mailer.Mailer = mailer.NewFileMailer(cfg.MailDir, cfg.MailFrom)
+	if cfg.Mailer == "smtp" {
+		mail = mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.MailFrom,
+		})
+	}
+
+	// Password hashing shared by sign-in, registration and admin-created users
+	passwords := password.NewHasher(password.DefaultParams)
 
 	// Initialize services
-	authService := services.NewAuthService(userRepo, sessionRepo, redisClient, cfg.JWTSecret, time.Hour*time.Duration(cfg.JWTExpiryHour))
-	userService := services.NewUserService(userRepo, deviceRepo, redisClient)
-	trafficService := services.NewTrafficService(trafficRepo, redisClient, wsHandler)
+	authService := services.NewAuthService(userRepo, deviceRepo, sessionRepo, subscriptionRepo, redisClient, passwords, limiter, loginLockout, cfg.JWTSecret, time.Hour*time.Duration(cfg.JWTExpiryHour))
+	mfaService := services.NewMFAService(userRepo, mfaRepo, authService, redisClient, appLogger, cfg.MFAIssuer)
+	registrationService := services.NewRegistrationService(userRepo, inviteRepo, redisClient, passwords, mail, appLogger, cfg.RegistrationMode, cfg.PublicURL)
+	passwordService := services.NewPasswordService(userRepo, authService, redisClient, passwords, mail, appLogger, cfg.PasswordResetURL, cfg.RevokeSessionsOnPasswordChange)
+	userService := services.NewUserService(userRepo, deviceRepo, authService, orchestratorClient, redisClient, passwords, appLogger)
+	planService := services.NewPlanService(planRepo, subscriptionRepo, userRepo, orchestratorClient, redisClient, appLogger)
+	enforcementService := services.NewEnforcementService(userRepo, authService, planService, orchestratorClient, wsHandler, redisClient, appLogger, time.Second*time.Duration(cfg.EnforcementIntervalSec))
+	trafficService := services.NewTrafficService(trafficRepo, redisClient, wsHandler, enforcementService, appLogger)
 	nodeService := services.NewNodeService(nodeRepo, appLogger)
+	shareLinkService := services.NewShareLinkService(userRepo, deviceRepo, nodeRepo, subscriptionRepo, appLogger)
+	clientConfigService := services.NewClientConfigService(hysteriaConfigRepo, deviceRepo, nodeRepo, subscriptionRepo, orchestratorClient, appLogger)
+	hysteriaService := services.NewHysteriaService(deviceRepo, orchestratorClient, appLogger)
+	deviceService := services.NewDeviceService(deviceRepo, userRepo, orchestratorClient, wsHandler, redisClient, appLogger, cfg.MaxDevicesPerUser)
+
+	// Start background quota enforcement
+	enforcementService.Start()
+	defer enforcementService.Stop()
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(authService, appLogger)
-	userHandler := handlers.NewUserHandler(userService, appLogger)
-	wsHandler := handlers.NewWebSocketHandler(trafficService, appLogger)
+	authHandler := handlers.NewAuthHandler(authService, mfaService, registrationService, appLogger)
+	registrationHandler := handlers.NewRegistrationHandler(registrationService, appLogger)
+	mfaHandler := handlers.NewMFAHandler(mfaService, authHandler, appLogger)
+	passwordHandler := handlers.NewPasswordHandler(passwordService, appLogger)
+	userHandler := handlers.NewUserHandler(userService, enforcementService, appLogger)
+	planHandler := handlers.NewPlanHandler(planService, appLogger)
 	trafficHandler := handlers.NewTrafficHandler(trafficService, appLogger)
 	nodeHandler := handlers.NewNodeHandler(nodeService, appLogger)
+	if cfg.HysteriaAuthSecret == "" {
+		appLogger.Warn("HYSTERIA_AUTH_SECRET is not set, the Hysteria2 auth hook rejects every client")
+	}
+	hysteriaHandler := handlers.NewHysteriaHandler(authService, deviceService, planService, cfg.HysteriaAuthSecret, appLogger)
+	deviceHandler := handlers.NewDeviceHandler(deviceService, appLogger)
+	connectionHandler := handlers.NewConnectionHandler(hysteriaService, appLogger)
+	clientConfigHandler := handlers.NewClientConfigHandler(clientConfigService, appLogger)
+	subscriptionHandler := handlers.NewSubscriptionHandler(shareLinkService, cfg.PublicURL, appLogger)
+	internalHandler := handlers.NewInternalHandler(wsHandler, trafficService, cfg.InternalAPISecret, appLogger)
 
 	// Create Fiber app
 	app := fiber.New(fiber.Config{
+		ProxyHeader: cfg.ProxyHeader,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -90,41 +156,151 @@ func main() {
 	// API routes
 	api := app.Group("/api/v1")
 
+	// Per-route rate limits, counted per client IP on public routes and
+	// per user on protected ones
+	loginLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "login", Limit: cfg.RateLimitLogin, Window: time.Minute}, middleware.ByIP, appLogger)
+	registerLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "register", Limit: cfg.RateLimitRegister, Window: time.Hour}, middleware.ByIP, appLogger)
+	mfaLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "mfa", Limit: cfg.RateLimitLogin, Window: time.Minute}, middleware.ByIP, appLogger)
+	verifyLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "verify_email", Limit: cfg.RateLimitLogin, Window: time.Minute}, middleware.ByIP, appLogger)
+	resendLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "verify_email_resend", Limit: cfg.RateLimitRegister, Window: time.Hour}, middleware.ByIP, appLogger)
+	forgotLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "password_forgot", Limit: cfg.RateLimitRegister, Window: time.Hour}, middleware.ByIP, appLogger)
+	resetLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "password_reset", Limit: cfg.RateLimitLogin, Window: time.Minute}, middleware.ByIP, appLogger)
+	changeLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "password_change", Limit: cfg.RateLimitLogin, Window: time.Minute}, middleware.ByUser, appLogger)
+	refreshLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "refresh", Limit: cfg.RateLimitLogin, Window: time.Minute}, middleware.ByIP, appLogger)
+	subscriptionLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "subscription", Limit: cfg.RateLimitAPI, Window: time.Minute}, middleware.ByIP, appLogger)
+	hysteriaAuthLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "hysteria_auth", Limit: cfg.RateLimitHysteriaAuth, Window: time.Minute}, middleware.ByIP, appLogger)
+	apiLimit := middleware.RateLimit(limiter, ratelimit.Rule{Name: "api", Limit: cfg.RateLimitAPI, Window: time.Minute}, middleware.ByUser, appLogger)
+
 	// Public routes
 	auth := api.Group("/auth")
-	auth.Post("/register", authHandler.Register)
-	auth.Post("/login", authHandler.Login)
-	auth.Post("/refresh", authHandler.RefreshToken)
+	auth.Post("/register", registerLimit, authHandler.Register)
+	auth.Post("/login", loginLimit, authHandler.Login)
+	auth.Post("/refresh", refreshLimit, authHandler.RefreshToken)
+
+	// Self-registration policy and email verification
+	auth.Get("/registration", registrationHandler.GetPolicy)
+	auth.Get("/verify-email", verifyLimit, registrationHandler.VerifyEmail)
+	auth.Post("/verify-email/resend", resendLimit, registrationHandler.ResendVerification)
+
+	// Forgotten passwords, reset through an emailed link
+	auth.Post("/password/forgot", forgotLimit, passwordHandler.ForgotPassword)
+	auth.Post("/password/reset", resetLimit, passwordHandler.ResetPassword)
+
+	// Second login step, authenticated by the challenge token from login
+	auth.Post("/mfa/verify", mfaLimit, mfaHandler.Verify)
+	auth.Post("/mfa/setup", mfaLimit, mfaHandler.SetupChallenge)
+	auth.Post("/mfa/setup/confirm", mfaLimit, mfaHandler.ConfirmSetupChallenge)
+
+	// Hysteria2 HTTP auth backend, called by the nodes themselves
+	api.Post("/hysteria/auth", hysteriaAuthLimit, hysteriaHandler.Authenticate)
+
+	// Subscription feed for VPN clients, authenticated by the token in the URL
+	api.Get("/sub/:token", subscriptionLimit, subscriptionHandler.GetSubscription)
+
+	// Service-to-service routes, authenticated with the shared internal secret
+	internal := api.Group("/internal", internalHandler.RequireSecret())
+	internal.Post("/node-events", internalHandler.NodeEvent)
+	internal.Post("/node-metrics", internalHandler.NodeMetric)
+	internal.Post("/deployments", internalHandler.Deployment)
+	internal.Post("/traffic", internalHandler.Traffic)
 
 	// Protected routes
-	protected := api.Group("", middleware.JWTAuth(authService))
+	protected := api.Group("", middleware.JWTAuth(authService), apiLimit)
+
+	// Session management for the signed-in user
+	session := protected.Group("/auth")
+	session.Post("/logout", authHandler.Logout)
+	session.Get("/sessions", authHandler.ListSessions)
+	session.Delete("/sessions/:id", authHandler.RevokeSession)
+	session.Post("/password/change", changeLimit, passwordHandler.ChangePassword)
+	session.Post("/mfa/enroll", mfaHandler.Enroll)
+	session.Post("/mfa/enroll/confirm", mfaHandler.ConfirmEnroll)
+	session.Post("/mfa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+	session.Post("/mfa/disable", mfaHandler.Disable)
+
+	// Invite codes and the queue of registrations awaiting approval
+	invites := protected.Group("/invites", middleware.RequirePermission(models.PermUsersWrite))
+	invites.Get("", registrationHandler.ListInvites)
+	invites.Post("", registrationHandler.CreateInvite)
+	invites.Delete("/:id", registrationHandler.DeleteInvite)
+
+	registrations := protected.Group("/registrations", middleware.RequirePermission(models.PermUsersWrite))
+	registrations.Get("/pending", registrationHandler.ListPending)
+	registrations.Post("/:id/approve", registrationHandler.Approve)
+	registrations.Post("/:id/reject", registrationHandler.Reject)
+
+	// Security policies
+	security := protected.Group("/security", middleware.RequirePermission(models.PermSecurityManage))
+	security.Get("/mfa-policy", mfaHandler.GetPolicy)
+	security.Put("/mfa-policy", mfaHandler.UpdatePolicy)
+
+	// Plan catalogue, readable by every user
+	managePlans := middleware.RequirePermission(models.PermPlansManage)
+	plans := protected.Group("/plans")
+	plans.Get("", planHandler.ListPlans)
+	plans.Post("", managePlans, planHandler.CreatePlan)
+	plans.Get("/:id", planHandler.GetPlan)
+	plans.Put("/:id", managePlans, planHandler.UpdatePlan)
+	plans.Delete("/:id", managePlans, planHandler.DeletePlan)
 
 	// User routes
 	users := protected.Group("/users")
-	users.Get("", userHandler.GetUsers)
-	users.Post("", userHandler.CreateUser)
-	users.Get("/:id", userHandler.GetUser)
-	users.Put("/:id", userHandler.UpdateUser)
-	users.Delete("/:id", userHandler.DeleteUser)
+	registerUserRoutes(users, userHandler, authHandler)
+
+	// Plan subscriptions. Users may read their own, changes need users:write.
+	writeUsers := middleware.RequirePermission(models.PermUsersWrite)
+	users.Get("/:userId/plan", planHandler.GetSubscription)
+	users.Post("/:userId/plan", writeUsers, planHandler.AssignPlan)
+	users.Post("/:userId/plan/extend", writeUsers, planHandler.ExtendSubscription)
+	users.Post("/:userId/plan/change", writeUsers, planHandler.ChangePlan)
+	users.Get("/:userId/plan/history", planHandler.GetHistory)
 
 	// Device routes
-	users.Group("/:userId/devices").Get("", userHandler.GetUserDevices)
+	devices := users.Group("/:userId/devices")
+	devices.Get("", userHandler.GetUserDevices)
+	devices.Post("", deviceHandler.CreateDevice)
+	devices.Patch("/:deviceId", deviceHandler.RenameDevice)
+	devices.Delete("/:deviceId", deviceHandler.DeleteDevice)
+	devices.Post("/:deviceId/block", deviceHandler.BlockDevice)
+	devices.Post("/:deviceId/unblock", deviceHandler.UnblockDevice)
+
+	// Hysteria2 client configs per device
+	configs := users.Group("/:userId/devices/:deviceId/configs")
+	configs.Get("", clientConfigHandler.ListConfigs)
+	configs.Post("", clientConfigHandler.GenerateConfig)
+	configs.Post("/regenerate-credentials", clientConfigHandler.RegenerateCredentials)
+	configs.Get("/:configId", clientConfigHandler.GetConfig)
+	configs.Put("/:configId", clientConfigHandler.UpdateConfig)
+	configs.Delete("/:configId", clientConfigHandler.DeleteConfig)
+	configs.Post("/:configId/activate", clientConfigHandler.ActivateConfig)
+	configs.Get("/:configId/download", clientConfigHandler.DownloadConfig)
+
+	// Share links and subscription URL
+	users.Get("/:userId/subscription", subscriptionHandler.GetUserSubscription)
+	users.Post("/:userId/subscription/rotate", subscriptionHandler.RotateSubscriptionToken)
 
 	// Node routes
+	readNodes := middleware.RequirePermission(models.PermNodesRead)
+	writeNodes := middleware.RequirePermission(models.PermNodesWrite)
 	nodes := protected.Group("/nodes")
-	nodes.Get("", nodeHandler.GetNodes)
-	nodes.Post("", nodeHandler.CreateNode)
-	nodes.Get("/:id", nodeHandler.GetNode)
-	nodes.Put("/:id", nodeHandler.UpdateNode)
-	nodes.Delete("/:id", nodeHandler.DeleteNode)
-	nodes.Get("/:id/metrics", nodeHandler.GetNodeMetrics)
-	nodes.Post("/:id/restart", nodeHandler.RestartNode)
-	nodes.Get("/:id/logs", nodeHandler.GetNodeLogs)
+	nodes.Get("", readNodes, nodeHandler.GetNodes)
+	nodes.Post("", writeNodes, nodeHandler.CreateNode)
+	nodes.Get("/:id", readNodes, nodeHandler.GetNode)
+	nodes.Put("/:id", writeNodes, nodeHandler.UpdateNode)
+	nodes.Delete("/:id", writeNodes, nodeHandler.DeleteNode)
+	nodes.Get("/:id/metrics", readNodes, nodeHandler.GetNodeMetrics)
+	nodes.Post("/:id/restart", writeNodes, nodeHandler.RestartNode)
+	nodes.Get("/:id/logs", readNodes, nodeHandler.GetNodeLogs)
+
+	// Live connections across the node fleet
+	connections := protected.Group("/connections")
+	connections.Get("", connectionHandler.GetConnections)
+	connections.Delete("/:userId", connectionHandler.DisconnectUser)
 
 	// Traffic routes
 	traffic := protected.Group("/traffic")
 	traffic.Get("/users/:userId", trafficHandler.GetUserTraffic)
-	traffic.Get("/summary", trafficHandler.GetTrafficSummary)
+	traffic.Get("/summary", middleware.RequirePermission(models.PermUsersRead), trafficHandler.GetTrafficSummary)
 
 	// WebSocket routes
 	app.Get("/ws", middleware.JWTAuth(authService), wsHandler.WebSocketUpgrade())
@@ -151,3 +327,16 @@ func main() {
 
 	appLogger.Info("Server exited")
 }
+
+// registerUserRoutes adds the account routes of the /users group. Routes
+// under /users/:id are also open to the user itself, their handlers check
+// ownership.
+func registerUserRoutes(users fiber.Router, userHandler *handlers.UserHandler, authHandler *handlers.AuthHandler) {
+	users.Get("", middleware.RequirePermission(models.PermUsersRead), userHandler.GetUsers)
+	users.Post("", middleware.RequirePermission(models.PermUsersWrite), userHandler.CreateUser)
+	users.Get("/:id", userHandler.GetUser)
+	users.Put("/:id", userHandler.UpdateUser)
+	users.Delete("/:id", middleware.RequirePermission(models.PermUsersWrite), userHandler.DeleteUser)
+	users.Get("/:id/lockout", middleware.RequirePermission(models.PermUsersSuspend), authHandler.GetLoginLockout)
+	users.Post("/:id/unlock", middleware.RequirePermission(models.PermUsersSuspend), authHandler.UnlockAccount)
+}
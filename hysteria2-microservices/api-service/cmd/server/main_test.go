@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hysteria2-microservices/api-service/internal/handlers"
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// fakeUserService answers every call the user routes make. Methods the
+// tests do not need panic through the embedded nil interface.
+type fakeUserService struct {
+	interfaces.UserService
+}
+
+func (s *fakeUserService) CreateUser(ctx context.Context, user *models.User) error {
+	user.ID = uuid.New()
+	return nil
+}
+
+func (s *fakeUserService) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return &models.User{ID: id, Role: models.RoleUser, Status: "active"}, nil
+}
+
+func (s *fakeUserService) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+
+func (s *fakeUserService) ListUsers(ctx context.Context, page, limit int, search, status, role string) ([]*models.User, int64, error) {
+	return nil, 0, nil
+}
+
+// fakeAuthService answers the lockout routes
+type fakeAuthService struct {
+	interfaces.AuthService
+}
+
+func (s *fakeAuthService) GetLoginLockout(ctx context.Context, userID uuid.UUID) (*interfaces.LoginLockout, error) {
+	return &interfaces.LoginLockout{}, nil
+}
+
+func (s *fakeAuthService) UnlockAccount(ctx context.Context, userID uuid.UUID) error {
+	return nil
+}
+
+func TestUserRoutePermissions(t *testing.T) {
+	callerID := uuid.New()
+	self := "/users/" + callerID.String()
+	other := "/users/" + uuid.New().String()
+	newUser := `{"username":"carol","email":"carol@example.com","password":"correct horse"}`
+
+	type route struct {
+		method, path, body string
+	}
+	list := route{fiber.MethodGet, "/users", ""}
+	create := route{fiber.MethodPost, "/users", newUser}
+	read := route{fiber.MethodGet, other, ""}
+	readSelf := route{fiber.MethodGet, self, ""}
+	edit := route{fiber.MethodPut, other, `{"full_name":"Mallory"}`}
+	remove := route{fiber.MethodDelete, other, ""}
+	lockout := route{fiber.MethodGet, other + "/lockout", ""}
+	unlock := route{fiber.MethodPost, other + "/unlock", ""}
+
+	tests := []struct {
+		role    string
+		allowed []route
+		denied  []route
+	}{
+		{models.RoleUser, []route{readSelf}, []route{list, create, read, edit, remove, lockout, unlock}},
+		{models.RoleOperator, []route{list, read}, []route{create, edit, remove, lockout, unlock}},
+		{models.RoleSupport, []route{list, read, lockout, unlock}, []route{create, edit, remove}},
+		{models.RoleAdmin, []route{list, create, read, remove, lockout, unlock}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.role, func(t *testing.T) {
+			log := logger.NewLogger("error")
+			userHandler := handlers.NewUserHandler(&fakeUserService{}, nil, log)
+			authHandler := handlers.NewAuthHandler(&fakeAuthService{}, nil, nil, log)
+
+			app := fiber.New()
+			users := app.Group("/users", func(c *fiber.Ctx) error {
+				// Stands in for the JWT middleware
+				c.Locals("user_id", callerID.String())
+				c.Locals("role", tt.role)
+				return c.Next()
+			})
+			registerUserRoutes(users, userHandler, authHandler)
+
+			check := func(r route, wantAllowed bool) {
+				req := httptest.NewRequest(r.method, r.path, strings.NewReader(r.body))
+				req.Header.Set("Content-Type", "application/json")
+				resp, err := app.Test(req)
+				if err != nil {
+					t.Fatalf("%s %s: %v", r.method, r.path, err)
+				}
+				if allowed := resp.StatusCode != fiber.StatusForbidden; allowed != wantAllowed {
+					t.Errorf("%s %s: status %d, want allowed %v", r.method, r.path, resp.StatusCode, wantAllowed)
+				}
+			}
+			for _, r := range tt.allowed {
+				check(r, true)
+			}
+			for _, r := range tt.denied {
+				check(r, false)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 
@@ -15,6 +16,68 @@ type Config struct {
 	LogLevel      string
 	AllowOrigins  string
 	JWTExpiryHour int
+
+	// Shared secret Hysteria2 nodes pass as ?secret= on the HTTP auth hook.
+	// The hook rejects everything while it is unset.
+	HysteriaAuthSecret string
+
+	// Externally reachable base URL of this service, used in subscription URLs
+	PublicURL string
+
+	// Base URL of the orchestrator REST API used for fleet-wide operations
+	OrchestratorURL string
+
+	// Shared secret the orchestrator sends on /api/v1/internal calls, and
+	// that the orchestrator requires on its own REST API
+	InternalAPISecret string
+
+	// How often users are swept for exhausted quotas and expired plans
+	EnforcementIntervalSec int
+
+	// Devices a user may register unless their own device_limit is set
+	MaxDevicesPerUser int
+
+	// Issuer shown next to the account in authenticator apps
+	MFAIssuer string
+
+	// Header carrying the client IP when running behind a reverse proxy,
+	// e.g. X-Forwarded-For. Only set it if the proxy overwrites the header.
+	ProxyHeader string
+
+	// Requests allowed per client IP: logins and MFA codes per minute,
+	// registrations per hour. API requests are limited per user and minute.
+	RateLimitLogin    int
+	RateLimitRegister int
+	RateLimitAPI      int
+	// Hysteria2 auth calls per node per minute, every client connection
+	// of the node counts
+	RateLimitHysteriaAuth int
+
+	// Consecutive failed logins before an account is locked, and the first
+	// lockout, which doubles with every further failure up to the maximum
+	LoginLockoutThreshold  int
+	LoginLockoutSeconds    int
+	LoginLockoutMaxSeconds int
+
+	// Self-registration mode: open, closed, invite, email or approval
+	RegistrationMode string
+
+	// Outgoing mail. "smtp" delivers through SMTPHost, "file" writes the
+	// messages to MailDir instead.
+	Mailer       string
+	MailFrom     string
+	MailDir      string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	// Page of the web app that takes the token from an emailed reset link
+	// and lets the user choose a new password
+	PasswordResetURL string
+
+	// Whether changing a password signs out the user's other sessions
+	RevokeSessionsOnPasswordChange bool
 }
 
 func Load() (*Config, error) {
@@ -29,6 +92,49 @@ func Load() (*Config, error) {
 		LogLevel:      getEnv("LOG_LEVEL", "info"),
 		AllowOrigins:  getEnv("ALLOW_ORIGINS", "http://localhost:3000"),
 		JWTExpiryHour: getEnvAsInt("JWT_EXPIRY_HOUR", 24),
+
+		HysteriaAuthSecret: getEnv("HYSTERIA_AUTH_SECRET", ""),
+
+		PublicURL:              getEnv("PUBLIC_URL", "http://localhost:8080"),
+		OrchestratorURL:        getEnv("ORCHESTRATOR_URL", "http://localhost:8081"),
+		InternalAPISecret:      getEnv("INTERNAL_API_SECRET", ""),
+		EnforcementIntervalSec: getEnvAsInt("ENFORCEMENT_INTERVAL_SECONDS", 60),
+		MaxDevicesPerUser:      getEnvAsInt("MAX_DEVICES_PER_USER", 5),
+		MFAIssuer:              getEnv("MFA_ISSUER", "Hysteria2 VPN"),
+		ProxyHeader:            getEnv("PROXY_HEADER", ""),
+		RateLimitLogin:         getEnvAsInt("RATE_LIMIT_LOGIN", 10),
+		RateLimitRegister:      getEnvAsInt("RATE_LIMIT_REGISTER", 5),
+		RateLimitAPI:           getEnvAsInt("RATE_LIMIT_API", 300),
+		RateLimitHysteriaAuth:  getEnvAsInt("RATE_LIMIT_HYSTERIA_AUTH", 1200),
+		LoginLockoutThreshold:  getEnvAsInt("LOGIN_LOCKOUT_THRESHOLD", 5),
+		LoginLockoutSeconds:    getEnvAsInt("LOGIN_LOCKOUT_SECONDS", 60),
+		LoginLockoutMaxSeconds: getEnvAsInt("LOGIN_LOCKOUT_MAX_SECONDS", 3600),
+		RegistrationMode:       getEnv("REGISTRATION_MODE", "approval"),
+		Mailer:                 getEnv("MAILER", "file"),
+		MailFrom:               getEnv("MAIL_FROM", "Hysteria2 VPN <noreply@localhost>"),
+		MailDir:                getEnv("MAIL_DIR", "./mail"),
+		SMTPHost:               getEnv("SMTP_HOST", ""),
+		SMTPPort:               getEnvAsInt("SMTP_PORT", 587),
+		SMTPUsername:           getEnv("SMTP_USERNAME", ""),
+		SMTPPassword:           getEnv("SMTP_PASSWORD", ""),
+		PasswordResetURL:       getEnv("PASSWORD_RESET_URL", "http://localhost:3000/reset-password"),
+
+		RevokeSessionsOnPasswordChange: getEnvAsBool("REVOKE_SESSIONS_ON_PASSWORD_CHANGE", true),
+	}
+
+	switch config.RegistrationMode {
+	case "open", "closed", "invite", "email", "approval":
+	default:
+		return nil, fmt.Errorf("invalid REGISTRATION_MODE %q", config.RegistrationMode)
+	}
+	switch config.Mailer {
+	case "file":
+	case "smtp":
+		if config.SMTPHost == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required when MAILER is smtp")
+		}
+	default:
+		return nil, fmt.Errorf("invalid MAILER %q", config.Mailer)
 	}
 
 	return config, nil
@@ -49,3 +155,12 @@ func getEnvAsInt(key string, defaultValue int) int {
 	}
 	return defaultValue
 }
+
+func getEnvAsBool(key string, defaultValue bool) bool {
+	if value := os.Getenv(key); value != "" {
+		if boolValue, err := strconv.ParseBool(value); err == nil {
+			return boolValue
+		}
+	}
+	return defaultValue
+}
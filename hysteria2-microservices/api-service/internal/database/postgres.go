@@ -22,7 +22,14 @@ func NewConnection(databaseURL string) (*gorm.DB, error) {
 		&models.User{},
 		&models.Device{},
 		&models.Session{},
+		&models.MFARecoveryCode{},
+		&models.MFARequiredRole{},
+		&models.InviteCode{},
+		&models.Plan{},
+		&models.Subscription{},
+		&models.SubscriptionHistory{},
 		&models.TrafficStats{},
+		&models.TrafficReport{},
 		&models.HysteriaConfig{},
 	); err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"hysteria2-microservices/api-service/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// can reports whether the caller's role grants the permission
+func can(c *fiber.Ctx, permission models.Permission) bool {
+	role, _ := c.Locals("role").(string)
+	return models.HasPermission(role, permission)
+}
+
+// isSelf reports whether userID is the caller's own account
+func isSelf(c *fiber.Ctx, userID uuid.UUID) bool {
+	currentUserID, _ := c.Locals("user_id").(string)
+	return currentUserID == userID.String()
+}
+
+// currentUserID reads the caller's ID from the token. The error response is
+// already sent when it reports false.
+func currentUserID(c *fiber.Ctx) (uuid.UUID, bool) {
+	value, _ := c.Locals("user_id").(string)
+	userID, err := uuid.Parse(value)
+	if err != nil {
+		c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid user ID in token",
+		})
+		return uuid.Nil, false
+	}
+	return userID, true
+}
+
+// parseUserID parses the named user ID parameter and allows only the user
+// itself or a role granting the permission. The error response is already
+// sent when it reports false.
+func parseUserID(c *fiber.Ctx, param string, permission models.Permission) (uuid.UUID, bool) {
+	userID, err := uuid.Parse(c.Params(param))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+		return uuid.Nil, false
+	}
+
+	if !isSelf(c, userID) && !can(c, permission) {
+		c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient permissions",
+		})
+		return uuid.Nil, false
+	}
+
+	return userID, true
+}
+
+// authorizedUserID parses :userId for the user itself or a role granting
+// the permission
+func authorizedUserID(c *fiber.Ctx, permission models.Permission) (uuid.UUID, bool) {
+	return parseUserID(c, "userId", permission)
+}
+
+// deviceParams parses :userId and :deviceId for the user itself or a role
+// granting the permission
+func deviceParams(c *fiber.Ctx, permission models.Permission) (uuid.UUID, uuid.UUID, bool) {
+	userID, ok := authorizedUserID(c, permission)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	deviceID, err := uuid.Parse(c.Params("deviceId"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid device ID",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return userID, deviceID, true
+}
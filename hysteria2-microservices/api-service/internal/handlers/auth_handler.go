@@ -1,23 +1,32 @@
 package handlers
 
 import (
+	"errors"
+	"strconv"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
 	"hysteria2-microservices/api-service/internal/services/interfaces"
 	"hysteria2-microservices/api-service/pkg/logger"
-	"time"
+	"hysteria2-microservices/api-service/pkg/ratelimit"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 )
 
 type AuthHandler struct {
-	authService interfaces.AuthService
-	logger      *logger.Logger
+	authService         interfaces.AuthService
+	mfaService          interfaces.MFAService
+	registrationService interfaces.RegistrationService
+	logger              *logger.Logger
 }
 
 type RegisterRequest struct {
 	Username string `json:"username" validate:"required,min=3,max=50"`
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=8"`
+	// Required when registration is invite-only
+	InviteCode string `json:"invite_code"`
 }
 
 type LoginRequest struct {
@@ -29,10 +38,23 @@ type RefreshRequest struct {
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
-func NewAuthHandler(authService interfaces.AuthService, logger *logger.Logger) *AuthHandler {
+// SessionResponse describes a signed-in session without its secrets
+type SessionResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	IPAddress  *string    `json:"ip_address"`
+	UserAgent  *string    `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	Current    bool       `json:"current"`
+}
+
+func NewAuthHandler(authService interfaces.AuthService, mfaService interfaces.MFAService, registrationService interfaces.RegistrationService, logger *logger.Logger) *AuthHandler {
 	return &AuthHandler{
-		authService: authService,
-		logger:      logger,
+		authService:         authService,
+		mfaService:          mfaService,
+		registrationService: registrationService,
+		logger:              logger,
 	}
 }
 
@@ -45,35 +67,40 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		})
 	}
 
-	user, err := h.authService.Register(c.Context(), req.Username, req.Email, req.Password)
+	user, err := h.registrationService.Register(c.Context(), &interfaces.RegisterRequest{
+		Username:   req.Username,
+		Email:      req.Email,
+		Password:   req.Password,
+		InviteCode: req.InviteCode,
+	})
 	if err != nil {
-		h.logger.Error("Failed to register user", "error", err, "username", req.Username, "email", req.Email)
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+		h.logger.Warn("Failed to register user", "error", err, "username", req.Username, "email", req.Email)
+		status := fiber.StatusBadRequest
+		if errors.Is(err, interfaces.ErrRegistrationClosed) || errors.Is(err, interfaces.ErrInviteRequired) {
+			status = fiber.StatusForbidden
+		}
+		return c.Status(status).JSON(fiber.Map{
 			"error": err.Error(),
 		})
 	}
 
-	// Generate token pair
-	tokenPair, err := h.authService.GenerateTokenPair(user.ID)
-	if err != nil {
-		h.logger.Error("Failed to generate token pair", "error", err, "user_id", user.ID)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to generate tokens",
+	h.logger.Info("User registered successfully", "user_id", user.ID, "username", user.Username, "status", user.Status)
+
+	// Accounts awaiting verification or approval cannot sign in yet
+	switch user.Status {
+	case models.UserStatusPendingVerification:
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"user":                  userSummary(user),
+			"verification_required": true,
+		})
+	case models.UserStatusPendingApproval:
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+			"user":              userSummary(user),
+			"approval_required": true,
 		})
 	}
 
-	h.logger.Info("User registered successfully", "user_id", user.ID, "username", user.Username)
-
-	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"user": fiber.Map{
-			"id":       user.ID,
-			"username": user.Username,
-			"email":    user.Email,
-			"role":     user.Role,
-			"status":   user.Status,
-		},
-		"token": tokenPair,
-	})
+	return h.signIn(c, user, fiber.StatusCreated)
 }
 
 func (h *AuthHandler) Login(c *fiber.Ctx) error {
@@ -87,14 +114,57 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 
 	user, err := h.authService.Login(c.Context(), req.Email, req.Password)
 	if err != nil {
+		var retry *interfaces.RetryError
+		if errors.As(err, &retry) {
+			h.logger.Warn("Login rejected", "email", req.Email, "ip", c.IP(), "error", err)
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ratelimit.RetryAfterSeconds(retry.RetryAfter)))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many failed logins, try again later",
+			})
+		}
+
+		if errors.Is(err, interfaces.ErrEmailNotVerified) || errors.Is(err, interfaces.ErrAccountPendingApproval) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+
 		h.logger.Warn("Failed login attempt", "email", req.Email, "error", err)
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid credentials",
 		})
 	}
 
-	// Generate token pair
-	tokenPair, err := h.authService.GenerateTokenPair(user.ID)
+	h.logger.Info("User passed password check", "user_id", user.ID, "username", user.Username)
+
+	return h.signIn(c, user, fiber.StatusOK)
+}
+
+// signIn issues tokens for a user whose password was checked, or the MFA
+// challenge they must pass first
+func (h *AuthHandler) signIn(c *fiber.Ctx, user *models.User, status int) error {
+	challenge, err := h.mfaService.LoginChallenge(c.Context(), user)
+	if err != nil {
+		h.logger.Error("Failed to check MFA", "error", err, "user_id", user.ID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to sign in",
+		})
+	}
+	if challenge != nil {
+		return c.Status(status).JSON(fiber.Map{
+			"user":         userSummary(user),
+			"mfa_required": true,
+			"challenge":    challenge,
+		})
+	}
+
+	h.authService.ClearLoginFailures(c.Context(), user)
+	return h.issueSession(c, user, status, nil)
+}
+
+// issueSession starts a session for the user and responds with its tokens
+func (h *AuthHandler) issueSession(c *fiber.Ctx, user *models.User, status int, extra fiber.Map) error {
+	tokenPair, err := h.authService.GenerateTokenPair(c.Context(), user, sessionInfo(c))
 	if err != nil {
 		h.logger.Error("Failed to generate token pair", "error", err, "user_id", user.ID)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
@@ -104,16 +174,25 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 
 	h.logger.Info("User logged in successfully", "user_id", user.ID, "username", user.Username)
 
-	return c.JSON(fiber.Map{
-		"user": fiber.Map{
-			"id":       user.ID,
-			"username": user.Username,
-			"email":    user.Email,
-			"role":     user.Role,
-			"status":   user.Status,
-		},
+	response := fiber.Map{
+		"user":  userSummary(user),
 		"token": tokenPair,
-	})
+	}
+	for k, v := range extra {
+		response[k] = v
+	}
+	return c.Status(status).JSON(response)
+}
+
+func userSummary(user *models.User) fiber.Map {
+	return fiber.Map{
+		"id":          user.ID,
+		"username":    user.Username,
+		"email":       user.Email,
+		"role":        user.Role,
+		"status":      user.Status,
+		"mfa_enabled": user.MFAEnabled,
+	}
 }
 
 func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
@@ -125,9 +204,13 @@ func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 		})
 	}
 
-	tokenPair, err := h.authService.RefreshToken(req.RefreshToken)
+	tokenPair, err := h.authService.RefreshToken(c.Context(), req.RefreshToken)
 	if err != nil {
-		h.logger.Warn("Failed token refresh", "error", err)
+		if errors.Is(err, interfaces.ErrRefreshTokenReused) {
+			h.logger.Warn("Refresh token reused, session revoked", "error", err, "ip", c.IP())
+		} else {
+			h.logger.Warn("Failed token refresh", "error", err)
+		}
 		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 			"error": "Invalid refresh token",
 		})
@@ -139,3 +222,136 @@ func (h *AuthHandler) RefreshToken(c *fiber.Ctx) error {
 		"token": tokenPair,
 	})
 }
+
+// Logout revokes the session of the access token used for the request
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	sessionToken, _ := c.Locals("session_id").(string)
+	if err := h.authService.Logout(c.Context(), sessionToken); err != nil && !errors.Is(err, interfaces.ErrSessionNotFound) {
+		h.logger.Error("Failed to log out", "error", err, "user_id", c.Locals("user_id"))
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log out",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListSessions lists the caller's active sessions, marking the current one
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	currentUserID, _ := c.Locals("user_id").(string)
+	userID, err := uuid.Parse(currentUserID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid user ID in token",
+		})
+	}
+
+	sessions, err := h.authService.ListSessions(c.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to list sessions", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list sessions",
+		})
+	}
+
+	current, _ := c.Locals("session_id").(string)
+	response := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, SessionResponse{
+			ID:         session.ID,
+			IPAddress:  session.IPAddress,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.SessionToken == current,
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions": response,
+	})
+}
+
+// RevokeSession signs out one of the caller's sessions
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	currentUserID, _ := c.Locals("user_id").(string)
+	userID, err := uuid.Parse(currentUserID)
+	if err != nil {
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid user ID in token",
+		})
+	}
+
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid session ID",
+		})
+	}
+
+	if err := h.authService.RevokeSession(c.Context(), userID, sessionID); err != nil {
+		if errors.Is(err, interfaces.ErrSessionNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+		h.logger.Error("Failed to revoke session", "error", err, "user_id", userID, "session_id", sessionID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	h.logger.Info("Session revoked", "user_id", userID, "session_id", sessionID)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetLoginLockout shows a user's failed logins and lockout
+func (h *AuthHandler) GetLoginLockout(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	lockout, err := h.authService.GetLoginLockout(c.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to get login lockout", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get login lockout",
+		})
+	}
+
+	return c.JSON(lockout)
+}
+
+// UnlockAccount lifts a user's login lockout
+func (h *AuthHandler) UnlockAccount(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	if err := h.authService.UnlockAccount(c.Context(), userID); err != nil {
+		h.logger.Error("Failed to unlock account", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlock account",
+		})
+	}
+
+	h.logger.Info("Account unlocked", "user_id", userID, "by", c.Locals("user_id"))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// sessionInfo describes the client starting a session
+func sessionInfo(c *fiber.Ctx) interfaces.SessionInfo {
+	return interfaces.SessionInfo{
+		IPAddress: c.IP(),
+		UserAgent: c.Get("User-Agent"),
+	}
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type ClientConfigHandler struct {
+	clientConfigService interfaces.ClientConfigService
+	logger              *logger.Logger
+}
+
+type GenerateConfigRequest struct {
+	NodeID     string `json:"node_id" validate:"required,uuid"`
+	ConfigName string `json:"config_name"`
+	Activate   bool   `json:"activate"`
+}
+
+type UpdateConfigRequest struct {
+	ConfigName *string                `json:"config_name"`
+	ConfigData map[string]interface{} `json:"config_data"`
+}
+
+func NewClientConfigHandler(clientConfigService interfaces.ClientConfigService, logger *logger.Logger) *ClientConfigHandler {
+	return &ClientConfigHandler{
+		clientConfigService: clientConfigService,
+		logger:              logger,
+	}
+}
+
+func (h *ClientConfigHandler) ListConfigs(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+
+	configs, err := h.clientConfigService.ListConfigs(c.Context(), userID, deviceID)
+	if err != nil {
+		return h.configError(c, err, "Failed to get configs")
+	}
+
+	return c.JSON(fiber.Map{
+		"configs": configs,
+	})
+}
+
+func (h *ClientConfigHandler) GetConfig(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+	configID, ok := configParam(c)
+	if !ok {
+		return nil
+	}
+
+	config, err := h.clientConfigService.GetConfig(c.Context(), userID, deviceID, configID)
+	if err != nil {
+		return h.configError(c, err, "Failed to get config")
+	}
+
+	return c.JSON(config)
+}
+
+// GenerateConfig renders a config for the device on one of the user's nodes
+func (h *ClientConfigHandler) GenerateConfig(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+
+	var req GenerateConfigRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	nodeID, err := uuid.Parse(req.NodeID)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid node ID",
+		})
+	}
+
+	config, err := h.clientConfigService.GenerateConfig(c.Context(), userID, deviceID, &interfaces.GenerateConfigRequest{
+		NodeID:     nodeID,
+		ConfigName: req.ConfigName,
+		Activate:   req.Activate,
+	})
+	if err != nil {
+		return h.configError(c, err, "Failed to generate config")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(config)
+}
+
+func (h *ClientConfigHandler) UpdateConfig(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+	configID, ok := configParam(c)
+	if !ok {
+		return nil
+	}
+
+	var req UpdateConfigRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	config, err := h.clientConfigService.UpdateConfig(c.Context(), userID, deviceID, configID, req.ConfigName, req.ConfigData)
+	if err != nil {
+		return h.configError(c, err, "Failed to update config")
+	}
+
+	return c.JSON(config)
+}
+
+func (h *ClientConfigHandler) DeleteConfig(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+	configID, ok := configParam(c)
+	if !ok {
+		return nil
+	}
+
+	if err := h.clientConfigService.DeleteConfig(c.Context(), userID, deviceID, configID); err != nil {
+		return h.configError(c, err, "Failed to delete config")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ActivateConfig makes the config the one the device uses
+func (h *ClientConfigHandler) ActivateConfig(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+	configID, ok := configParam(c)
+	if !ok {
+		return nil
+	}
+
+	config, err := h.clientConfigService.ActivateConfig(c.Context(), userID, deviceID, configID)
+	if err != nil {
+		return h.configError(c, err, "Failed to activate config")
+	}
+
+	return c.JSON(config)
+}
+
+// RegenerateCredentials rotates the device secret and returns the rewritten configs
+func (h *ClientConfigHandler) RegenerateCredentials(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+
+	configs, err := h.clientConfigService.RegenerateCredentials(c.Context(), userID, deviceID)
+	if err != nil {
+		return h.configError(c, err, "Failed to regenerate credentials")
+	}
+
+	return c.JSON(fiber.Map{
+		"configs": configs,
+	})
+}
+
+// DownloadConfig serves the config as a file for the Hysteria2 client,
+// YAML unless ?format=json is given
+func (h *ClientConfigHandler) DownloadConfig(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+	configID, ok := configParam(c)
+	if !ok {
+		return nil
+	}
+
+	config, err := h.clientConfigService.GetConfig(c.Context(), userID, deviceID, configID)
+	if err != nil {
+		return h.configError(c, err, "Failed to get config")
+	}
+
+	format := c.Query("format", interfaces.ConfigFormatYAML)
+	data, contentType, err := h.clientConfigService.RenderConfig(config, format)
+	if err != nil {
+		return h.configError(c, err, "Failed to render config")
+	}
+
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="hysteria2-%s.%s"`, config.ID, format))
+	return c.Send(data)
+}
+
+func (h *ClientConfigHandler) configError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, interfaces.ErrDeviceNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Device not found",
+		})
+	case errors.Is(err, interfaces.ErrConfigNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Config not found",
+		})
+	case errors.Is(err, interfaces.ErrNodeNotAssigned),
+		errors.Is(err, interfaces.ErrInvalidClientConfig),
+		errors.Is(err, interfaces.ErrUnsupportedFormat):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": message,
+	})
+}
+
+func configParam(c *fiber.Ctx) (uuid.UUID, bool) {
+	configID, err := uuid.Parse(c.Params("configId"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid config ID",
+		})
+		return uuid.Nil, false
+	}
+	return configID, true
+}
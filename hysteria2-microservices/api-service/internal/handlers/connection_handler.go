@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type ConnectionHandler struct {
+	hysteriaService interfaces.HysteriaService
+	logger          *logger.Logger
+}
+
+func NewConnectionHandler(hysteriaService interfaces.HysteriaService, logger *logger.Logger) *ConnectionHandler {
+	return &ConnectionHandler{
+		hysteriaService: hysteriaService,
+		logger:          logger,
+	}
+}
+
+// GetConnections lists live connections across all nodes, filtered by
+// ?user_id=, ?device_id= and ?node_id=. Users without users:read only see
+// their own.
+func (h *ConnectionHandler) GetConnections(c *fiber.Ctx) error {
+	filter := interfaces.ConnectionFilter{
+		UserID:   c.Query("user_id"),
+		DeviceID: c.Query("device_id"),
+		NodeID:   c.Query("node_id"),
+	}
+	for _, id := range []string{filter.UserID, filter.DeviceID, filter.NodeID} {
+		if id == "" {
+			continue
+		}
+		if _, err := uuid.Parse(id); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid filter ID",
+			})
+		}
+	}
+
+	if !can(c, models.PermUsersRead) {
+		filter.UserID, _ = c.Locals("user_id").(string)
+	}
+
+	connections, err := h.hysteriaService.GetActiveConnections(c.Context(), filter)
+	if err != nil {
+		h.logger.Error("Failed to get connections", "error", err)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Failed to get connections",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"connections": connections,
+		"total":       len(connections),
+	})
+}
+
+// DisconnectUser kicks the user, or only ?device_id=, off every node
+func (h *ConnectionHandler) DisconnectUser(c *fiber.Ctx) error {
+	userID, ok := authorizedUserID(c, models.PermConnectionsManage)
+	if !ok {
+		return nil
+	}
+
+	deviceID := c.Query("device_id")
+	if err := h.hysteriaService.DisconnectUser(c.Context(), userID.String(), deviceID); err != nil {
+		if errors.Is(err, interfaces.ErrDeviceNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Device not found",
+			})
+		}
+		h.logger.Error("Failed to disconnect user", "error", err, "user_id", userID, "device_id", deviceID)
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": "Failed to disconnect user",
+		})
+	}
+
+	h.logger.Info("User disconnected", "user_id", userID, "device_id", deviceID)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
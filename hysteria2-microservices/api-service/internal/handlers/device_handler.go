@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"errors"
+
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type DeviceHandler struct {
+	deviceService interfaces.DeviceService
+	logger        *logger.Logger
+}
+
+type CreateDeviceRequest struct {
+	Name      string `json:"name" validate:"required,min=1,max=100"`
+	DeviceID  string `json:"device_id" validate:"omitempty,max=255"`
+	PublicKey string `json:"public_key"`
+}
+
+type RenameDeviceRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=100"`
+}
+
+func NewDeviceHandler(deviceService interfaces.DeviceService, logger *logger.Logger) *DeviceHandler {
+	return &DeviceHandler{
+		deviceService: deviceService,
+		logger:        logger,
+	}
+}
+
+// CreateDevice enrolls a device and returns its Hysteria2 credential
+func (h *DeviceHandler) CreateDevice(c *fiber.Ctx) error {
+	userID, ok := authorizedUserID(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+
+	var req CreateDeviceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Device name is required",
+		})
+	}
+
+	enrollment, err := h.deviceService.CreateDevice(c.Context(), userID, &interfaces.CreateDeviceRequest{
+		Name:      req.Name,
+		DeviceID:  req.DeviceID,
+		PublicKey: req.PublicKey,
+	})
+	if err != nil {
+		return h.deviceError(c, err, "Failed to create device")
+	}
+
+	h.logger.Info("Device created", "user_id", userID, "device_id", enrollment.Device.ID)
+
+	return c.Status(fiber.StatusCreated).JSON(enrollment)
+}
+
+func (h *DeviceHandler) RenameDevice(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+
+	var req RenameDeviceRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if req.Name == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Device name is required",
+		})
+	}
+
+	device, err := h.deviceService.RenameDevice(c.Context(), userID, deviceID, req.Name)
+	if err != nil {
+		return h.deviceError(c, err, "Failed to rename device")
+	}
+
+	return c.JSON(device)
+}
+
+// BlockDevice revokes the device on every node and disconnects it
+func (h *DeviceHandler) BlockDevice(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersSuspend)
+	if !ok {
+		return nil
+	}
+
+	device, result, err := h.deviceService.BlockDevice(c.Context(), userID, deviceID)
+	if err != nil {
+		return h.deviceError(c, err, "Failed to block device")
+	}
+
+	h.logger.Info("Device blocked", "user_id", userID, "device_id", deviceID)
+
+	return c.JSON(fiber.Map{
+		"device": device,
+		"nodes":  result,
+	})
+}
+
+func (h *DeviceHandler) UnblockDevice(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersSuspend)
+	if !ok {
+		return nil
+	}
+
+	device, result, err := h.deviceService.UnblockDevice(c.Context(), userID, deviceID)
+	if err != nil {
+		return h.deviceError(c, err, "Failed to unblock device")
+	}
+
+	h.logger.Info("Device unblocked", "user_id", userID, "device_id", deviceID)
+
+	return c.JSON(fiber.Map{
+		"device": device,
+		"nodes":  result,
+	})
+}
+
+func (h *DeviceHandler) DeleteDevice(c *fiber.Ctx) error {
+	userID, deviceID, ok := deviceParams(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+
+	if _, err := h.deviceService.DeleteDevice(c.Context(), userID, deviceID); err != nil {
+		return h.deviceError(c, err, "Failed to delete device")
+	}
+
+	h.logger.Info("Device deleted", "user_id", userID, "device_id", deviceID)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *DeviceHandler) deviceError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, interfaces.ErrDeviceNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Device not found",
+		})
+	case errors.Is(err, interfaces.ErrDeviceExists):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": "Device already registered",
+		})
+	case errors.Is(err, interfaces.ErrDeviceLimitReached):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": message,
+	})
+}
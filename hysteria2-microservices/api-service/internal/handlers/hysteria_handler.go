@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+type HysteriaHandler struct {
+	authService   interfaces.AuthService
+	deviceService interfaces.DeviceService
+	planService   interfaces.PlanService
+	authSecret    string
+	logger        *logger.Logger
+}
+
+// HysteriaAuthRequest is the body Hysteria2 POSTs when auth.type is "http"
+type HysteriaAuthRequest struct {
+	Addr string `json:"addr"`
+	Auth string `json:"auth"`
+	Tx   uint64 `json:"tx"`
+}
+
+func NewHysteriaHandler(authService interfaces.AuthService, deviceService interfaces.DeviceService, planService interfaces.PlanService, authSecret string, logger *logger.Logger) *HysteriaHandler {
+	return &HysteriaHandler{
+		authService:   authService,
+		deviceService: deviceService,
+		planService:   planService,
+		authSecret:    authSecret,
+		logger:        logger,
+	}
+}
+
+// Authenticate implements the Hysteria2 HTTP auth backend. Rejections are
+// reported in the body, Hysteria ignores the status. Every request is
+// rejected while no node secret is configured. Accepted clients get the
+// user's speed caps as "up" and "down" in bytes per second from the
+// client's side, the same response the agent's local auth hook gives.
+func (h *HysteriaHandler) Authenticate(c *fiber.Ctx) error {
+	if h.authSecret == "" || subtle.ConstantTimeCompare([]byte(c.Query("secret")), []byte(h.authSecret)) != 1 {
+		h.logger.Warn("Hysteria auth request with invalid node secret", "ip", c.IP())
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"ok": false,
+			"id": "",
+		})
+	}
+
+	var req HysteriaAuthRequest
+	if err := c.BodyParser(&req); err != nil {
+		h.logger.Error("Failed to parse hysteria auth request", "error", err)
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"ok": false,
+			"id": "",
+		})
+	}
+
+	device, user, err := h.authService.AuthenticateHysteria(c.Context(), req.Auth)
+	if err != nil {
+		h.logger.Warn("Hysteria auth rejected", "addr", req.Addr, "error", err)
+		return c.JSON(fiber.Map{
+			"ok": false,
+			"id": "",
+		})
+	}
+
+	h.logger.Debug("Hysteria auth accepted", "addr", req.Addr, "device_id", device.ID)
+
+	// Recorded off the request path, Hysteria waits on this response
+	go func(deviceID uuid.UUID, addr string, at time.Time) {
+		if err := h.deviceService.RecordConnection(context.Background(), deviceID, addr, at); err != nil {
+			h.logger.Error("Failed to record device connection", "error", err, "device_id", deviceID)
+		}
+	}(device.ID, req.Addr, time.Now())
+
+	resp := fiber.Map{
+		"ok": true,
+		"id": device.ID.String(),
+	}
+	upMbps, downMbps, err := h.planService.Bandwidth(c.Context(), user)
+	if err != nil {
+		h.logger.Warn("Failed to get speed caps, node defaults apply", "error", err, "user_id", user.ID)
+	}
+	if upMbps > 0 {
+		resp["up"] = mbpsToBytes(upMbps)
+	}
+	if downMbps > 0 {
+		resp["down"] = mbpsToBytes(downMbps)
+	}
+
+	return c.JSON(resp)
+}
+
+// mbpsToBytes converts Mbit/s to bytes per second
+func mbpsToBytes(mbps int) uint64 {
+	return uint64(mbps) * 1000 * 1000 / 8
+}
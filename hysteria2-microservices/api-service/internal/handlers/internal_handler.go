@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"crypto/subtle"
+
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// InternalHandler serves calls made by other backend services rather than users
+type InternalHandler struct {
+	webSocketService interfaces.WebSocketService
+	trafficService   interfaces.TrafficService
+	secret           string
+	logger           *logger.Logger
+}
+
+func NewInternalHandler(webSocketService interfaces.WebSocketService, trafficService interfaces.TrafficService, secret string, logger *logger.Logger) *InternalHandler {
+	return &InternalHandler{
+		webSocketService: webSocketService,
+		trafficService:   trafficService,
+		secret:           secret,
+		logger:           logger,
+	}
+}
+
+// RequireSecret rejects requests without the shared X-Internal-Secret header.
+// Internal routes stay closed while no secret is configured.
+func (h *InternalHandler) RequireSecret() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if h.secret == "" || subtle.ConstantTimeCompare([]byte(c.Get("X-Internal-Secret")), []byte(h.secret)) != 1 {
+			h.logger.Warn("Internal request with invalid secret", "path", c.Path(), "ip", c.IP())
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Invalid internal secret",
+			})
+		}
+		return c.Next()
+	}
+}
+
+// NodeEvent receives a node status transition from the orchestrator and
+// pushes it to connected admins
+func (h *InternalHandler) NodeEvent(c *fiber.Ctx) error {
+	var event models.NodeEvent
+	if err := c.BodyParser(&event); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	h.logger.Info("Node status changed", "node_id", event.NodeID, "from", event.FromStatus, "to", event.ToStatus, "reason", event.Reason)
+	h.webSocketService.BroadcastNodeEvent(&event)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// NodeMetric receives a node's latest metrics from the orchestrator and
+// pushes them to the admins following the node
+func (h *InternalHandler) NodeMetric(c *fiber.Ctx) error {
+	var metric models.NodeMetric
+	if err := c.BodyParser(&metric); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	h.webSocketService.BroadcastNodeMetric(&metric)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Deployment receives a deployment status change from the orchestrator and
+// pushes it to the admins following deployments
+func (h *InternalHandler) Deployment(c *fiber.Ctx) error {
+	var deployment models.Deployment
+	if err := c.BodyParser(&deployment); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	h.logger.Info("Deployment updated", "deployment_id", deployment.ID, "node_id", deployment.NodeID, "status", deployment.Status)
+	h.webSocketService.BroadcastDeployment(&deployment)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// Traffic records a traffic report the orchestrator collected from a node.
+// The orchestrator only confirms the report to the node after a success, so
+// a failed report is sent again.
+func (h *InternalHandler) Traffic(c *fiber.Ctx) error {
+	var report models.TrafficReport
+	if err := c.BodyParser(&report); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.trafficService.RecordTraffic(c.Context(), &report); err != nil {
+		h.logger.Error("Failed to record reported traffic", "report_id", report.ID, "records", len(report.Stats), "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to record traffic",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// MFAHandler serves TOTP enrollment, the second login step and the MFA
+// policy. Sessions are issued through the AuthHandler once a code passed.
+type MFAHandler struct {
+	mfaService  interfaces.MFAService
+	authHandler *AuthHandler
+	logger      *logger.Logger
+}
+
+type MFAChallengeRequest struct {
+	ChallengeToken string `json:"challenge_token" validate:"required"`
+	Code           string `json:"code"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+type MFAPolicyRequest struct {
+	RequiredRoles []string `json:"required_roles"`
+}
+
+func NewMFAHandler(mfaService interfaces.MFAService, authHandler *AuthHandler, logger *logger.Logger) *MFAHandler {
+	return &MFAHandler{
+		mfaService:  mfaService,
+		authHandler: authHandler,
+		logger:      logger,
+	}
+}
+
+// Verify completes a login with a TOTP or recovery code
+func (h *MFAHandler) Verify(c *fiber.Ctx) error {
+	var req MFAChallengeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	user, err := h.mfaService.VerifyChallenge(c.Context(), req.ChallengeToken, req.Code)
+	if err != nil {
+		h.logger.Warn("Failed MFA verification", "error", err, "ip", c.IP())
+		return h.mfaError(c, err, "Failed to verify code")
+	}
+
+	return h.authHandler.issueSession(c, user, fiber.StatusOK, nil)
+}
+
+// SetupChallenge starts enrollment for a user whose role requires MFA
+// before they can sign in
+func (h *MFAHandler) SetupChallenge(c *fiber.Ctx) error {
+	var req MFAChallengeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	enrollment, err := h.mfaService.BeginChallengeEnrollment(c.Context(), req.ChallengeToken)
+	if err != nil {
+		return h.mfaError(c, err, "Failed to start MFA enrollment")
+	}
+
+	return c.JSON(enrollment)
+}
+
+// ConfirmSetupChallenge enables MFA with a first code and signs the user in
+func (h *MFAHandler) ConfirmSetupChallenge(c *fiber.Ctx) error {
+	var req MFAChallengeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	user, codes, err := h.mfaService.ConfirmChallengeEnrollment(c.Context(), req.ChallengeToken, req.Code)
+	if err != nil {
+		h.logger.Warn("Failed MFA enrollment", "error", err, "ip", c.IP())
+		return h.mfaError(c, err, "Failed to enable MFA")
+	}
+
+	h.logger.Info("MFA enabled", "user_id", user.ID)
+
+	return h.authHandler.issueSession(c, user, fiber.StatusOK, fiber.Map{
+		"recovery_codes": codes,
+	})
+}
+
+// Enroll starts enrollment for the signed-in user
+func (h *MFAHandler) Enroll(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil
+	}
+
+	enrollment, err := h.mfaService.BeginEnrollment(c.Context(), userID)
+	if err != nil {
+		return h.mfaError(c, err, "Failed to start MFA enrollment")
+	}
+
+	return c.JSON(enrollment)
+}
+
+// ConfirmEnroll enables MFA and returns the recovery codes
+func (h *MFAHandler) ConfirmEnroll(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil
+	}
+
+	var req MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	codes, err := h.mfaService.ConfirmEnrollment(c.Context(), userID, req.Code)
+	if err != nil {
+		return h.mfaError(c, err, "Failed to enable MFA")
+	}
+
+	h.logger.Info("MFA enabled", "user_id", userID)
+
+	return c.JSON(fiber.Map{
+		"recovery_codes": codes,
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after a TOTP code
+func (h *MFAHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil
+	}
+
+	var req MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	codes, err := h.mfaService.RegenerateRecoveryCodes(c.Context(), userID, req.Code)
+	if err != nil {
+		return h.mfaError(c, err, "Failed to regenerate recovery codes")
+	}
+
+	h.logger.Info("MFA recovery codes regenerated", "user_id", userID)
+
+	return c.JSON(fiber.Map{
+		"recovery_codes": codes,
+	})
+}
+
+// Disable turns MFA off after a TOTP or recovery code
+func (h *MFAHandler) Disable(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil
+	}
+
+	var req MFACodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.mfaService.Disable(c.Context(), userID, req.Code); err != nil {
+		return h.mfaError(c, err, "Failed to disable MFA")
+	}
+
+	h.logger.Info("MFA disabled", "user_id", userID)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetPolicy lists the roles that must use MFA
+func (h *MFAHandler) GetPolicy(c *fiber.Ctx) error {
+	roles, err := h.mfaService.RequiredRoles(c.Context())
+	if err != nil {
+		return h.mfaError(c, err, "Failed to get MFA policy")
+	}
+
+	return c.JSON(fiber.Map{
+		"required_roles": roles,
+	})
+}
+
+// UpdatePolicy replaces the roles that must use MFA
+func (h *MFAHandler) UpdatePolicy(c *fiber.Ctx) error {
+	var req MFAPolicyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	roles, err := h.mfaService.SetRequiredRoles(c.Context(), req.RequiredRoles)
+	if err != nil {
+		return h.mfaError(c, err, "Failed to update MFA policy")
+	}
+
+	h.logger.Info("MFA policy updated", "required_roles", roles, "by", c.Locals("user_id"))
+
+	return c.JSON(fiber.Map{
+		"required_roles": roles,
+	})
+}
+
+func (h *MFAHandler) mfaError(c *fiber.Ctx, err error, message string) error {
+	var retry *interfaces.RetryError
+	if errors.As(err, &retry) {
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ratelimit.RetryAfterSeconds(retry.RetryAfter)))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many failed logins, try again later",
+		})
+	}
+
+	switch {
+	case errors.Is(err, interfaces.ErrMFAChallengeInvalid):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid or expired challenge",
+		})
+	case errors.Is(err, interfaces.ErrMFACodeInvalid):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": "Invalid code",
+		})
+	case errors.Is(err, interfaces.ErrMFATooManyAttempts):
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": "Too many attempts",
+		})
+	case errors.Is(err, interfaces.ErrMFAAlreadyEnabled),
+		errors.Is(err, interfaces.ErrMFANotEnabled):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, interfaces.ErrMFARequired):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, interfaces.ErrUnknownRole):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": message,
+	})
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"errors"
+
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/password"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// PasswordHandler serves password changes and the forgot/reset flow
+type PasswordHandler struct {
+	passwordService interfaces.PasswordService
+	logger          *logger.Logger
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required"`
+}
+
+func NewPasswordHandler(passwordService interfaces.PasswordService, logger *logger.Logger) *PasswordHandler {
+	return &PasswordHandler{
+		passwordService: passwordService,
+		logger:          logger,
+	}
+}
+
+// ChangePassword replaces the caller's password. The session used for the
+// request stays signed in.
+func (h *PasswordHandler) ChangePassword(c *fiber.Ctx) error {
+	userID, ok := currentUserID(c)
+	if !ok {
+		return nil
+	}
+
+	var req ChangePasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	sessionToken, _ := c.Locals("session_id").(string)
+	if err := h.passwordService.ChangePassword(c.Context(), userID, sessionToken, req.CurrentPassword, req.NewPassword); err != nil {
+		return h.passwordError(c, err, "Failed to change password")
+	}
+
+	h.logger.Info("Password changed", "user_id", userID)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ForgotPassword answers the same whether or not the email belongs to an
+// account
+func (h *PasswordHandler) ForgotPassword(c *fiber.Ctx) error {
+	var req ForgotPasswordRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.passwordService.RequestReset(c.Context(), req.Email); err != nil {
+		h.logger.Error("Failed to send password reset email", "error", err)
+	}
+
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// ResetPassword sets a new password with the token from a reset email
+func (h *PasswordHandler) ResetPassword(c *fiber.Ctx) error {
+	var req ResetPasswordRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	user, err := h.passwordService.ResetPassword(c.Context(), req.Token, req.NewPassword)
+	if err != nil {
+		h.logger.Warn("Failed password reset", "error", err, "ip", c.IP())
+		return h.passwordError(c, err, "Failed to reset password")
+	}
+
+	h.logger.Info("Password reset", "user_id", user.ID)
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *PasswordHandler) passwordError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, password.ErrTooShort), errors.Is(err, password.ErrTooLong):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, interfaces.ErrPasswordIncorrect):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Current password is incorrect",
+		})
+	case errors.Is(err, interfaces.ErrResetTokenInvalid):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid or expired reset link",
+		})
+	}
+
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": message,
+	})
+}
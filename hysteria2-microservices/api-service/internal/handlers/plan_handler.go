@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"errors"
+
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// PlanHandler serves the plan catalogue and the users' subscriptions to
+// plans. The subscription feed of share links is served by the
+// SubscriptionHandler.
+type PlanHandler struct {
+	planService interfaces.PlanService
+	logger      *logger.Logger
+}
+
+type PlanRequest struct {
+	Name         string   `json:"name" validate:"required"`
+	Description  *string  `json:"description"`
+	DataLimit    int64    `json:"data_limit" validate:"min=0"`
+	UpMbps       int      `json:"up_mbps" validate:"min=0"`
+	DownMbps     int      `json:"down_mbps" validate:"min=0"`
+	DeviceLimit  int      `json:"device_limit" validate:"min=0"`
+	NodeGroups   []string `json:"node_groups"`
+	DurationDays int      `json:"duration_days" validate:"min=0"`
+	// Only read on updates, new plans are active
+	IsActive *bool `json:"is_active"`
+}
+
+type AssignPlanRequest struct {
+	PlanID uuid.UUID `json:"plan_id" validate:"required"`
+	Note   *string   `json:"note"`
+}
+
+type ExtendSubscriptionRequest struct {
+	Days int     `json:"days" validate:"min=0"` // 0 extends by the plan's duration
+	Note *string `json:"note"`
+}
+
+func NewPlanHandler(planService interfaces.PlanService, logger *logger.Logger) *PlanHandler {
+	return &PlanHandler{
+		planService: planService,
+		logger:      logger,
+	}
+}
+
+// ListPlans lists the active plans, and inactive ones too for callers who
+// manage plans
+func (h *PlanHandler) ListPlans(c *fiber.Ctx) error {
+	plans, err := h.planService.ListPlans(c.Context(), can(c, models.PermPlansManage))
+	if err != nil {
+		h.logger.Error("Failed to list plans", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list plans",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"plans": plans,
+	})
+}
+
+func (h *PlanHandler) GetPlan(c *fiber.Ctx) error {
+	planID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plan ID",
+		})
+	}
+
+	plan, err := h.planService.GetPlan(c.Context(), planID)
+	if err == nil && !plan.IsActive && !can(c, models.PermPlansManage) {
+		err = interfaces.ErrPlanNotFound
+	}
+	if err != nil {
+		return h.planError(c, err, "Failed to get plan")
+	}
+
+	return c.JSON(plan)
+}
+
+func (h *PlanHandler) CreatePlan(c *fiber.Ctx) error {
+	var req PlanRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if msg := validatePlan(&req); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	plan := &models.Plan{}
+	applyPlanRequest(plan, &req)
+	if err := h.planService.CreatePlan(c.Context(), plan); err != nil {
+		return h.planError(c, err, "Failed to create plan")
+	}
+
+	h.logger.Info("Plan created", "plan_id", plan.ID, "name", plan.Name, "by", c.Locals("user_id"))
+
+	return c.Status(fiber.StatusCreated).JSON(plan)
+}
+
+// UpdatePlan replaces a plan's settings. Changed limits apply to its
+// subscribers right away.
+func (h *PlanHandler) UpdatePlan(c *fiber.Ctx) error {
+	planID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plan ID",
+		})
+	}
+
+	var req PlanRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if msg := validatePlan(&req); msg != "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": msg,
+		})
+	}
+
+	plan, err := h.planService.GetPlan(c.Context(), planID)
+	if err != nil {
+		return h.planError(c, err, "Failed to update plan")
+	}
+	applyPlanRequest(plan, &req)
+	if req.IsActive != nil {
+		plan.IsActive = *req.IsActive
+	}
+
+	if err := h.planService.UpdatePlan(c.Context(), plan); err != nil {
+		return h.planError(c, err, "Failed to update plan")
+	}
+
+	h.logger.Info("Plan updated", "plan_id", plan.ID, "by", c.Locals("user_id"))
+
+	return c.JSON(plan)
+}
+
+func (h *PlanHandler) DeletePlan(c *fiber.Ctx) error {
+	planID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid plan ID",
+		})
+	}
+
+	if err := h.planService.DeletePlan(c.Context(), planID); err != nil {
+		return h.planError(c, err, "Failed to delete plan")
+	}
+
+	h.logger.Info("Plan deleted", "plan_id", planID, "by", c.Locals("user_id"))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// GetSubscription returns the user's current subscription with its plan
+func (h *PlanHandler) GetSubscription(c *fiber.Ctx) error {
+	userID, ok := authorizedUserID(c, models.PermUsersRead)
+	if !ok {
+		return nil
+	}
+
+	subscription, err := h.planService.GetSubscription(c.Context(), userID)
+	if err != nil {
+		return h.planError(c, err, "Failed to get subscription")
+	}
+
+	return c.JSON(subscription)
+}
+
+// AssignPlan starts a new subscription for the user, replacing the current one
+func (h *PlanHandler) AssignPlan(c *fiber.Ctx) error {
+	userID, actorID, ok := subscriptionParams(c)
+	if !ok {
+		return nil
+	}
+
+	var req AssignPlanRequest
+	if err := c.BodyParser(&req); err != nil || req.PlanID == uuid.Nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	subscription, err := h.planService.Assign(c.Context(), userID, req.PlanID, actorID, req.Note)
+	if err != nil {
+		return h.planError(c, err, "Failed to assign plan")
+	}
+
+	h.logger.Info("Plan assigned", "user_id", userID, "plan_id", req.PlanID, "by", actorID)
+
+	return c.Status(fiber.StatusCreated).JSON(subscription)
+}
+
+// ExtendSubscription extends or renews the user's subscription
+func (h *PlanHandler) ExtendSubscription(c *fiber.Ctx) error {
+	userID, actorID, ok := subscriptionParams(c)
+	if !ok {
+		return nil
+	}
+
+	var req ExtendSubscriptionRequest
+	if err := c.BodyParser(&req); err != nil || req.Days < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	subscription, err := h.planService.Extend(c.Context(), userID, req.Days, actorID, req.Note)
+	if err != nil {
+		return h.planError(c, err, "Failed to extend subscription")
+	}
+
+	h.logger.Info("Subscription extended", "user_id", userID, "days", req.Days, "ends_at", subscription.EndsAt, "by", actorID)
+
+	return c.JSON(subscription)
+}
+
+// ChangePlan upgrades or downgrades the user's active subscription
+func (h *PlanHandler) ChangePlan(c *fiber.Ctx) error {
+	userID, actorID, ok := subscriptionParams(c)
+	if !ok {
+		return nil
+	}
+
+	var req AssignPlanRequest
+	if err := c.BodyParser(&req); err != nil || req.PlanID == uuid.Nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	subscription, err := h.planService.ChangePlan(c.Context(), userID, req.PlanID, actorID, req.Note)
+	if err != nil {
+		return h.planError(c, err, "Failed to change plan")
+	}
+
+	h.logger.Info("Subscription plan changed", "user_id", userID, "plan_id", req.PlanID, "by", actorID)
+
+	return c.JSON(subscription)
+}
+
+// GetHistory lists the changes to the user's subscriptions, newest first
+func (h *PlanHandler) GetHistory(c *fiber.Ctx) error {
+	userID, ok := authorizedUserID(c, models.PermUsersRead)
+	if !ok {
+		return nil
+	}
+	page, limit := pagination(c)
+
+	history, total, err := h.planService.History(c.Context(), userID, page, limit)
+	if err != nil {
+		h.logger.Error("Failed to list subscription history", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list subscription history",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"history": history,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+func (h *PlanHandler) planError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, interfaces.ErrPlanNotFound):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Plan not found",
+		})
+	case errors.Is(err, interfaces.ErrNoSubscription):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User has no subscription",
+		})
+	case errors.Is(err, interfaces.ErrPlanInUse), errors.Is(err, interfaces.ErrPlanNameTaken):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, interfaces.ErrPlanInactive),
+		errors.Is(err, interfaces.ErrSubscriptionNoEnd),
+		errors.Is(err, interfaces.ErrExtensionDaysMissing):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": message,
+	})
+}
+
+// subscriptionParams parses :userId and the ID of the admin changing the
+// user's subscription. The error response is already sent when it reports
+// false.
+func subscriptionParams(c *fiber.Ctx) (uuid.UUID, uuid.UUID, bool) {
+	userID, err := uuid.Parse(c.Params("userId"))
+	if err != nil {
+		c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	actorID, ok := currentUserID(c)
+	if !ok {
+		return uuid.Nil, uuid.Nil, false
+	}
+	return userID, actorID, true
+}
+
+// validatePlan returns what is wrong with a plan request, or ""
+func validatePlan(req *PlanRequest) string {
+	switch {
+	case req.Name == "":
+		return "name is required"
+	case req.DataLimit < 0 || req.UpMbps < 0 || req.DownMbps < 0 || req.DeviceLimit < 0 || req.DurationDays < 0:
+		return "Limits must not be negative"
+	}
+	for _, group := range req.NodeGroups {
+		if group == "" {
+			return "node_groups must not contain empty names"
+		}
+	}
+	return ""
+}
+
+func applyPlanRequest(plan *models.Plan, req *PlanRequest) {
+	plan.Name = req.Name
+	plan.Description = req.Description
+	plan.DataLimit = req.DataLimit
+	plan.UpMbps = req.UpMbps
+	plan.DownMbps = req.DownMbps
+	plan.DeviceLimit = req.DeviceLimit
+	plan.NodeGroups = req.NodeGroups
+	plan.DurationDays = req.DurationDays
+}
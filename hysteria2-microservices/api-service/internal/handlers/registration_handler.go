@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// RegistrationHandler serves email verification and the admin side of
+// self-registration: invite codes and the approval queue
+type RegistrationHandler struct {
+	registrationService interfaces.RegistrationService
+	logger              *logger.Logger
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type CreateInviteRequest struct {
+	MaxUses     int        `json:"max_uses"` // 0 means a single use, -1 unlimited
+	ExpiresAt   *time.Time `json:"expires_at"`
+	DataLimit   int64      `json:"data_limit"`
+	ValidDays   int        `json:"valid_days"`
+	DeviceLimit int        `json:"device_limit"`
+	Note        *string    `json:"note"`
+}
+
+func NewRegistrationHandler(registrationService interfaces.RegistrationService, logger *logger.Logger) *RegistrationHandler {
+	return &RegistrationHandler{
+		registrationService: registrationService,
+		logger:              logger,
+	}
+}
+
+// GetPolicy tells clients which registration form to show
+func (h *RegistrationHandler) GetPolicy(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"mode": h.registrationService.Mode(),
+	})
+}
+
+// VerifyEmail activates an account from the link in the verification email
+func (h *RegistrationHandler) VerifyEmail(c *fiber.Ctx) error {
+	user, err := h.registrationService.VerifyEmail(c.Context(), c.Query("token"))
+	if err != nil {
+		if errors.Is(err, interfaces.ErrVerificationTokenInvalid) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid or expired verification link",
+			})
+		}
+		h.logger.Error("Failed to verify email", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to verify email",
+		})
+	}
+
+	h.logger.Info("Email verified", "user_id", user.ID)
+
+	return c.JSON(fiber.Map{
+		"user":     userSummary(user),
+		"verified": true,
+	})
+}
+
+// ResendVerification answers the same whether or not the email belongs to
+// an account awaiting verification
+func (h *RegistrationHandler) ResendVerification(c *fiber.Ctx) error {
+	var req ResendVerificationRequest
+	if err := c.BodyParser(&req); err != nil || req.Email == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if err := h.registrationService.ResendVerification(c.Context(), req.Email); err != nil {
+		h.logger.Error("Failed to resend verification email", "error", err)
+	}
+
+	return c.SendStatus(fiber.StatusAccepted)
+}
+
+// CreateInvite mints an invite code
+func (h *RegistrationHandler) CreateInvite(c *fiber.Ctx) error {
+	currentUserID, ok := currentUserID(c)
+	if !ok {
+		return nil
+	}
+
+	var req CreateInviteRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+	if req.MaxUses < -1 || req.DataLimit < 0 || req.ValidDays < 0 || req.DeviceLimit < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Limits must not be negative",
+		})
+	}
+	if req.ExpiresAt != nil && req.ExpiresAt.Before(time.Now()) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "expires_at must be in the future",
+		})
+	}
+
+	maxUses := req.MaxUses
+	if maxUses == -1 {
+		maxUses = 0
+	} else if maxUses == 0 {
+		maxUses = 1
+	}
+
+	invite, err := h.registrationService.CreateInvite(c.Context(), currentUserID, &interfaces.CreateInviteRequest{
+		MaxUses:     maxUses,
+		ExpiresAt:   req.ExpiresAt,
+		DataLimit:   req.DataLimit,
+		ValidDays:   req.ValidDays,
+		DeviceLimit: req.DeviceLimit,
+		Note:        req.Note,
+	})
+	if err != nil {
+		h.logger.Error("Failed to create invite code", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to create invite code",
+		})
+	}
+
+	h.logger.Info("Invite code created", "invite_id", invite.ID, "by", currentUserID)
+
+	return c.Status(fiber.StatusCreated).JSON(invite)
+}
+
+func (h *RegistrationHandler) ListInvites(c *fiber.Ctx) error {
+	page, limit := pagination(c)
+
+	invites, total, err := h.registrationService.ListInvites(c.Context(), page, limit)
+	if err != nil {
+		h.logger.Error("Failed to list invite codes", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list invite codes",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"invites": invites,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+	})
+}
+
+// DeleteInvite revokes an invite code. Accounts registered with it stay.
+func (h *RegistrationHandler) DeleteInvite(c *fiber.Ctx) error {
+	inviteID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid invite ID",
+		})
+	}
+
+	if err := h.registrationService.DeleteInvite(c.Context(), inviteID); err != nil {
+		if errors.Is(err, interfaces.ErrInviteNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Invite code not found",
+			})
+		}
+		h.logger.Error("Failed to delete invite code", "error", err, "invite_id", inviteID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete invite code",
+		})
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ListPending lists the accounts awaiting approval
+func (h *RegistrationHandler) ListPending(c *fiber.Ctx) error {
+	page, limit := pagination(c)
+
+	users, total, err := h.registrationService.ListPending(c.Context(), page, limit)
+	if err != nil {
+		h.logger.Error("Failed to list pending registrations", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to list pending registrations",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"users": users,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+	})
+}
+
+func (h *RegistrationHandler) Approve(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	user, err := h.registrationService.Approve(c.Context(), userID)
+	if err != nil {
+		return h.pendingError(c, err, "Failed to approve registration")
+	}
+
+	h.logger.Info("Registration approved", "user_id", userID, "by", c.Locals("user_id"))
+
+	return c.JSON(user)
+}
+
+func (h *RegistrationHandler) Reject(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid user ID",
+		})
+	}
+
+	if err := h.registrationService.Reject(c.Context(), userID); err != nil {
+		return h.pendingError(c, err, "Failed to reject registration")
+	}
+
+	h.logger.Info("Registration rejected", "user_id", userID, "by", c.Locals("user_id"))
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *RegistrationHandler) pendingError(c *fiber.Ctx, err error, message string) error {
+	if errors.Is(err, interfaces.ErrUserNotPending) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No pending registration for this user",
+		})
+	}
+
+	h.logger.Error(message, "error", err)
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": message,
+	})
+}
+
+// pagination reads the page and limit query parameters, allowing at most
+// 100 entries per page
+func pagination(c *fiber.Ctx) (int, int) {
+	page := 1
+	limit := 10
+
+	if p := c.Query("page"); p != "" {
+		if parsed, err := strconv.Atoi(p); err == nil && parsed > 0 {
+			page = parsed
+		}
+	}
+
+	if l := c.Query("limit"); l != "" {
+		if parsed, err := strconv.Atoi(l); err == nil && parsed > 0 && parsed <= 100 {
+			limit = parsed
+		}
+	}
+
+	return page, limit
+}
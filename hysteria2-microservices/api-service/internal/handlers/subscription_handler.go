@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type SubscriptionHandler struct {
+	shareLinkService interfaces.ShareLinkService
+	publicURL        string
+	logger           *logger.Logger
+}
+
+func NewSubscriptionHandler(shareLinkService interfaces.ShareLinkService, publicURL string, logger *logger.Logger) *SubscriptionHandler {
+	return &SubscriptionHandler{
+		shareLinkService: shareLinkService,
+		publicURL:        strings.TrimRight(publicURL, "/"),
+		logger:           logger,
+	}
+}
+
+// GetUserSubscription returns the user's hy2:// links and subscription URL
+func (h *SubscriptionHandler) GetUserSubscription(c *fiber.Ctx) error {
+	userID, ok := authorizedUserID(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+
+	links, err := h.shareLinkService.GetShareLinks(c.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to build share links", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to build share links",
+		})
+	}
+
+	token, err := h.shareLinkService.GetSubscriptionToken(c.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to get subscription token", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get subscription token",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"subscription_url": h.subscriptionURL(token),
+		"links":            links,
+	})
+}
+
+// RotateSubscriptionToken issues a new subscription URL and invalidates the old one
+func (h *SubscriptionHandler) RotateSubscriptionToken(c *fiber.Ctx) error {
+	userID, ok := authorizedUserID(c, models.PermUsersWrite)
+	if !ok {
+		return nil
+	}
+
+	token, err := h.shareLinkService.RotateSubscriptionToken(c.Context(), userID)
+	if err != nil {
+		h.logger.Error("Failed to rotate subscription token", "error", err, "user_id", userID)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to rotate subscription token",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"subscription_url": h.subscriptionURL(token),
+	})
+}
+
+// GetSubscription serves the subscription feed to VPN clients. The token in
+// the URL is the only credential, clients cannot send a JWT.
+func (h *SubscriptionHandler) GetSubscription(c *fiber.Ctx) error {
+	feed, err := h.shareLinkService.RenderSubscription(c.Context(), c.Params("token"), c.Query("format"))
+	if err != nil {
+		switch {
+		case errors.Is(err, interfaces.ErrUnsupportedFormat):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Unsupported format, use plain, base64, clash or singbox",
+			})
+		case errors.Is(err, interfaces.ErrSubscriptionNotFound):
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Subscription not found",
+			})
+		case errors.Is(err, interfaces.ErrSubscriptionInactive):
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Subscription is not active",
+			})
+		}
+		h.logger.Error("Failed to render subscription", "error", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to render subscription",
+		})
+	}
+
+	// Traffic and expiry shown by clients that understand the header
+	userInfo := fmt.Sprintf("upload=0; download=%d; total=%d", feed.User.DataUsed, feed.User.DataLimit)
+	if feed.User.ExpiryDate != nil {
+		userInfo += fmt.Sprintf("; expire=%d", feed.User.ExpiryDate.Unix())
+	}
+	c.Set("Subscription-Userinfo", userInfo)
+	c.Set("Profile-Update-Interval", "12")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderContentType, feed.ContentType)
+
+	return c.Send(feed.Body)
+}
+
+func (h *SubscriptionHandler) subscriptionURL(token string) string {
+	return h.publicURL + "/api/v1/sub/" + token
+}
@@ -3,11 +3,11 @@ package handlers
 import (
 	"time"
 
+	"hysteria2-microservices/api-service/internal/models"
 	"hysteria2-microservices/api-service/internal/services/interfaces"
 	"hysteria2-microservices/api-service/pkg/logger"
 
 	"github.com/gofiber/fiber/v2"
-	"github.com/google/uuid"
 )
 
 type TrafficHandler struct {
@@ -23,12 +23,9 @@ func NewTrafficHandler(trafficService interfaces.TrafficService, logger *logger.
 }
 
 func (h *TrafficHandler) GetUserTraffic(c *fiber.Ctx) error {
-	userIDStr := c.Params("userId")
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid user ID",
-		})
+	userID, ok := authorizedUserID(c, models.PermUsersRead)
+	if !ok {
+		return nil
 	}
 
 	// Parse time range
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"strconv"
 
 	"hysteria2-microservices/api-service/internal/models"
@@ -12,34 +13,42 @@ import (
 )
 
 type UserHandler struct {
-	userService interfaces.UserService
-	logger      *logger.Logger
+	userService        interfaces.UserService
+	enforcementService interfaces.EnforcementService
+	logger             *logger.Logger
 }
 
 type CreateUserRequest struct {
-	Username  string  `json:"username" validate:"required,min=3,max=50"`
-	Email     string  `json:"email" validate:"required,email"`
-	Password  string  `json:"password" validate:"required,min=8"`
-	FullName  *string `json:"full_name"`
-	Role      string  `json:"role" validate:"omitempty,oneof=admin user"`
-	DataLimit int64   `json:"data_limit" validate:"min=0"`
-	Notes     *string `json:"notes"`
+	Username    string  `json:"username" validate:"required,min=3,max=50"`
+	Email       string  `json:"email" validate:"required,email"`
+	Password    string  `json:"password" validate:"required,min=8"`
+	FullName    *string `json:"full_name"`
+	Role        string  `json:"role" validate:"omitempty,oneof=admin operator support user"`
+	DataLimit   int64   `json:"data_limit" validate:"min=0"`
+	DeviceLimit int     `json:"device_limit" validate:"min=0"`
+	UpMbps      int     `json:"up_mbps" validate:"min=0"`
+	DownMbps    int     `json:"down_mbps" validate:"min=0"`
+	Notes       *string `json:"notes"`
 }
 
 type UpdateUserRequest struct {
-	Username  *string `json:"username" validate:"omitempty,min=3,max=50"`
-	Email     *string `json:"email" validate:"omitempty,email"`
-	FullName  *string `json:"full_name"`
-	Status    *string `json:"status" validate:"omitempty,oneof=active suspended deleted"`
-	Role      *string `json:"role" validate:"omitempty,oneof=admin user"`
-	DataLimit *int64  `json:"data_limit" validate:"omitempty,min=0"`
-	Notes     *string `json:"notes"`
+	Username    *string `json:"username" validate:"omitempty,min=3,max=50"`
+	Email       *string `json:"email" validate:"omitempty,email"`
+	FullName    *string `json:"full_name"`
+	Status      *string `json:"status" validate:"omitempty,oneof=active suspended deleted"`
+	Role        *string `json:"role" validate:"omitempty,oneof=admin operator support user"`
+	DataLimit   *int64  `json:"data_limit" validate:"omitempty,min=0"`
+	DeviceLimit *int    `json:"device_limit" validate:"omitempty,min=0"`
+	UpMbps      *int    `json:"up_mbps" validate:"omitempty,min=0"`
+	DownMbps    *int    `json:"down_mbps" validate:"omitempty,min=0"`
+	Notes       *string `json:"notes"`
 }
 
-func NewUserHandler(userService interfaces.UserService, logger *logger.Logger) *UserHandler {
+func NewUserHandler(userService interfaces.UserService, enforcementService interfaces.EnforcementService, logger *logger.Logger) *UserHandler {
 	return &UserHandler{
-		userService: userService,
-		logger:      logger,
+		userService:        userService,
+		enforcementService: enforcementService,
+		logger:             logger,
 	}
 }
 
@@ -80,12 +89,9 @@ func (h *UserHandler) GetUsers(c *fiber.Ctx) error {
 }
 
 func (h *UserHandler) GetUser(c *fiber.Ctx) error {
-	id := c.Params("id")
-	userID, err := uuid.Parse(id)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid user ID",
-		})
+	userID, ok := parseUserID(c, "id", models.PermUsersRead)
+	if !ok {
+		return nil
 	}
 
 	user, err := h.userService.GetUserByID(c.Context(), userID)
@@ -107,16 +113,32 @@ func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
 			"error": "Invalid request body",
 		})
 	}
+	if req.Role == "" {
+		req.Role = models.RoleUser
+	}
+	if !models.ValidRole(req.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role",
+		})
+	}
+	if req.UpMbps < 0 || req.DownMbps < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Speed limits must not be negative",
+		})
+	}
 
 	user := &models.User{
-		Username:  req.Username,
-		Email:     req.Email,
-		Password:  req.Password, // Will be hashed in service
-		FullName:  req.FullName,
-		Role:      req.Role,
-		DataLimit: req.DataLimit,
-		Status:    "active",
-		Notes:     req.Notes,
+		Username:    req.Username,
+		Email:       req.Email,
+		Password:    req.Password, // Hashed by the service
+		FullName:    req.FullName,
+		Role:        req.Role,
+		DataLimit:   req.DataLimit,
+		DeviceLimit: req.DeviceLimit,
+		UpMbps:      req.UpMbps,
+		DownMbps:    req.DownMbps,
+		Status:      "active",
+		Notes:       req.Notes,
 	}
 
 	if err := h.userService.CreateUser(c.Context(), user); err != nil {
@@ -131,15 +153,23 @@ func (h *UserHandler) CreateUser(c *fiber.Ctx) error {
 	return c.Status(fiber.StatusCreated).JSON(user)
 }
 
+// UpdateUser edits a user. Users may edit their own profile, users:suspend
+// allows changing the status of anyone and users:write allows everything.
 func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
-	id := c.Params("id")
-	userID, err := uuid.Parse(id)
+	userID, err := uuid.Parse(c.Params("id"))
 	if err != nil {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Invalid user ID",
 		})
 	}
 
+	self := isSelf(c, userID)
+	if !self && !can(c, models.PermUsersWrite) && !can(c, models.PermUsersSuspend) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient permissions",
+		})
+	}
+
 	var req UpdateUserRequest
 	if err := c.BodyParser(&req); err != nil {
 		h.logger.Error("Failed to parse update user request", "error", err)
@@ -148,6 +178,22 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	if field := forbiddenUpdate(c, self, &req); field != "" {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient permissions to change " + field,
+		})
+	}
+	if req.Role != nil && !models.ValidRole(*req.Role) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid role",
+		})
+	}
+	if (req.UpMbps != nil && *req.UpMbps < 0) || (req.DownMbps != nil && *req.DownMbps < 0) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Speed limits must not be negative",
+		})
+	}
+
 	user, err := h.userService.GetUserByID(c.Context(), userID)
 	if err != nil {
 		h.logger.Error("Failed to get user for update", "error", err, "user_id", userID)
@@ -156,6 +202,14 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Activating a pending registration is an approval, which needs users:write
+	pending := user.Status == models.UserStatusPendingVerification || user.Status == models.UserStatusPendingApproval
+	if req.Status != nil && pending && !can(c, models.PermUsersWrite) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "Insufficient permissions to change status",
+		})
+	}
+
 	// Update fields if provided
 	if req.Username != nil {
 		user.Username = *req.Username
@@ -166,15 +220,21 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 	if req.FullName != nil {
 		user.FullName = req.FullName
 	}
-	if req.Status != nil {
-		user.Status = *req.Status
-	}
 	if req.Role != nil {
 		user.Role = *req.Role
 	}
 	if req.DataLimit != nil {
 		user.DataLimit = *req.DataLimit
 	}
+	if req.DeviceLimit != nil {
+		user.DeviceLimit = *req.DeviceLimit
+	}
+	if req.UpMbps != nil {
+		user.UpMbps = *req.UpMbps
+	}
+	if req.DownMbps != nil {
+		user.DownMbps = *req.DownMbps
+	}
 	if req.Notes != nil {
 		user.Notes = req.Notes
 	}
@@ -186,11 +246,57 @@ func (h *UserHandler) UpdateUser(c *fiber.Ctx) error {
 		})
 	}
 
+	// Status changes sign the user out and update the nodes
+	if req.Status != nil && *req.Status != user.Status {
+		updated, err := h.enforcementService.SetStatus(c.Context(), userID, *req.Status)
+		if err != nil && updated != nil {
+			// Stored and the user signed out, only dropping live connections failed
+			h.logger.Warn("User status changed but not applied on every node", "error", err, "user_id", userID)
+		} else if err != nil {
+			if errors.Is(err, interfaces.ErrUserStatusChanged) {
+				return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+					"error": err.Error(),
+				})
+			}
+			h.logger.Error("Failed to change user status", "error", err, "user_id", userID)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+				"error": "Failed to change user status",
+			})
+		}
+		user = updated
+	}
+
 	h.logger.Info("User updated successfully", "user_id", userID, "username", user.Username)
 
 	return c.JSON(user)
 }
 
+// forbiddenUpdate returns the first field of the request the caller may not
+// change, or "" when the whole update is allowed
+func forbiddenUpdate(c *fiber.Ctx, self bool, req *UpdateUserRequest) string {
+	if can(c, models.PermUsersWrite) {
+		return ""
+	}
+
+	switch {
+	case !self && (req.Username != nil || req.Email != nil || req.FullName != nil):
+		return "profile"
+	case req.Role != nil:
+		return "role"
+	case req.DataLimit != nil:
+		return "data_limit"
+	case req.DeviceLimit != nil:
+		return "device_limit"
+	case req.UpMbps != nil, req.DownMbps != nil:
+		return "bandwidth"
+	case req.Notes != nil:
+		return "notes"
+	case req.Status != nil && (!can(c, models.PermUsersSuspend) || *req.Status == "deleted"):
+		return "status"
+	}
+	return ""
+}
+
 func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 	id := c.Params("id")
 	userID, err := uuid.Parse(id)
@@ -213,12 +319,9 @@ func (h *UserHandler) DeleteUser(c *fiber.Ctx) error {
 }
 
 func (h *UserHandler) GetUserDevices(c *fiber.Ctx) error {
-	userIDStr := c.Params("userId")
-	userID, err := uuid.Parse(userIDStr)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid user ID",
-		})
+	userID, ok := authorizedUserID(c, models.PermUsersRead)
+	if !ok {
+		return nil
 	}
 
 	devices, err := h.userService.GetUserDevices(c.Context(), userID)
@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// fakeUserService keeps users in memory and records updates. Methods the
+// tests do not need panic through the embedded nil interface.
+type fakeUserService struct {
+	interfaces.UserService
+
+	users   map[uuid.UUID]*models.User
+	updated *models.User
+}
+
+func (s *fakeUserService) GetUserByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (s *fakeUserService) UpdateUser(ctx context.Context, user *models.User) error {
+	copied := *user
+	s.updated = &copied
+	return nil
+}
+
+// fakeEnforcementService records status changes
+type fakeEnforcementService struct {
+	interfaces.EnforcementService
+
+	users  *fakeUserService
+	status string
+}
+
+func (s *fakeEnforcementService) SetStatus(ctx context.Context, userID uuid.UUID, status string) (*models.User, error) {
+	s.status = status
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	user.Status = status
+	return user, nil
+}
+
+// withCaller stands in for the JWT middleware and signs every request in
+// as the given user and role
+func withCaller(userID uuid.UUID, role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Locals("user_id", userID.String())
+		c.Locals("role", role)
+		return c.Next()
+	}
+}
+
+func TestUpdateUserPermissions(t *testing.T) {
+	callerID := uuid.New()
+	otherID := uuid.New()
+
+	tests := []struct {
+		name       string
+		role       string
+		target     uuid.UUID
+		body       string
+		wantStatus int
+	}{
+		{"user edits own profile", models.RoleUser, callerID, `{"full_name":"Alice"}`, fiber.StatusOK},
+		{"user edits another user", models.RoleUser, otherID, `{"full_name":"Mallory"}`, fiber.StatusForbidden},
+		{"user suspends another user", models.RoleUser, otherID, `{"status":"suspended"}`, fiber.StatusForbidden},
+		{"user changes own role", models.RoleUser, callerID, `{"role":"admin"}`, fiber.StatusForbidden},
+		{"user changes own status", models.RoleUser, callerID, `{"status":"suspended"}`, fiber.StatusForbidden},
+		{"user changes own data limit", models.RoleUser, callerID, `{"data_limit":0}`, fiber.StatusForbidden},
+		{"user changes own device limit", models.RoleUser, callerID, `{"device_limit":100}`, fiber.StatusForbidden},
+		{"user changes own bandwidth", models.RoleUser, callerID, `{"up_mbps":1000}`, fiber.StatusForbidden},
+		{"user hides a role change in a profile edit", models.RoleUser, callerID, `{"full_name":"Alice","role":"admin"}`, fiber.StatusForbidden},
+		{"support suspends a user", models.RoleSupport, otherID, `{"status":"suspended"}`, fiber.StatusOK},
+		{"support reactivates a user", models.RoleSupport, otherID, `{"status":"active"}`, fiber.StatusOK},
+		{"support deletes a user", models.RoleSupport, otherID, `{"status":"deleted"}`, fiber.StatusForbidden},
+		{"support edits a profile", models.RoleSupport, otherID, `{"email":"mallory@example.com"}`, fiber.StatusForbidden},
+		{"support changes a role", models.RoleSupport, otherID, `{"role":"support"}`, fiber.StatusForbidden},
+		{"support changes a data limit", models.RoleSupport, otherID, `{"data_limit":0}`, fiber.StatusForbidden},
+		{"support suspends and changes limits", models.RoleSupport, otherID, `{"status":"suspended","device_limit":100}`, fiber.StatusForbidden},
+		{"operator edits a profile", models.RoleOperator, otherID, `{"full_name":"Mallory"}`, fiber.StatusForbidden},
+		{"operator suspends a user", models.RoleOperator, otherID, `{"status":"suspended"}`, fiber.StatusForbidden},
+		{"operator changes a data limit", models.RoleOperator, otherID, `{"data_limit":0}`, fiber.StatusForbidden},
+		{"admin changes role and limits", models.RoleAdmin, otherID, `{"role":"support","data_limit":1024}`, fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeUserService{users: map[uuid.UUID]*models.User{
+				callerID: {ID: callerID, Username: "alice", Role: tt.role, Status: "active"},
+				otherID:  {ID: otherID, Username: "bob", Role: models.RoleUser, Status: "active"},
+			}}
+			enforcement := &fakeEnforcementService{users: users}
+			handler := NewUserHandler(users, enforcement, logger.NewLogger("error"))
+
+			app := fiber.New()
+			app.Put("/users/:id", withCaller(callerID, tt.role), handler.UpdateUser)
+
+			req := httptest.NewRequest(fiber.MethodPut, "/users/"+tt.target.String(), strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("request: %v", err)
+			}
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == fiber.StatusForbidden && (users.updated != nil || enforcement.status != "") {
+				t.Error("forbidden update was applied")
+			}
+		})
+	}
+}
@@ -1,11 +1,14 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"hysteria2-microservices/api-service/internal/models"
 	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/cache"
 	"hysteria2-microservices/api-service/pkg/logger"
 
 	"github.com/gofiber/fiber/v2"
@@ -17,31 +20,58 @@ import (
 type WSMessageType string
 
 const (
-	WSTrafficUpdate WSMessageType = "traffic_update"
-	WSUserStatus    WSMessageType = "user_status"
-	WSDeviceOnline  WSMessageType = "device_online"
-	WSError         WSMessageType = "error"
+	WSTrafficUpdate    WSMessageType = "traffic_update"
+	WSUserStatus       WSMessageType = "user_status"
+	WSDeviceOnline     WSMessageType = "device_online"
+	WSNodeEvent        WSMessageType = "node_event"
+	WSNodeMetric       WSMessageType = "node_metric"
+	WSDeploymentUpdate WSMessageType = "deployment_update"
+	WSSubscribed       WSMessageType = "subscribed"
+	WSUnsubscribed     WSMessageType = "unsubscribed"
+	WSError            WSMessageType = "error"
 )
 
+// Messages sent by clients
+const (
+	WSSubscribe   WSMessageType = "subscribe"
+	WSUnsubscribe WSMessageType = "unsubscribe"
+)
+
+// WSMessage is sent in both directions. Topic names the topic an event was
+// published on, or the topic a client (un)subscribes.
 type WSMessage struct {
 	Type      WSMessageType `json:"type"`
+	Topic     string        `json:"topic,omitempty"`
 	UserID    string        `json:"user_id,omitempty"`
 	Data      interface{}   `json:"data,omitempty"`
 	Timestamp time.Time     `json:"timestamp"`
 }
 
 type WebSocketHandler struct {
-	trafficService serviceInterfaces.TrafficService
-	logger         *logger.Logger
-	clients        map[string]*ws.Conn
+	logger     *logger.Logger
+	hub        *wsHub
+	redis      *cache.RedisClient
+	instanceID string // identifies this replica in the presence sets
 }
 
-func NewWebSocketHandler(trafficService serviceInterfaces.TrafficService, logger *logger.Logger) *WebSocketHandler {
-	return &WebSocketHandler{
-		trafficService: trafficService,
-		logger:         logger,
-		clients:        make(map[string]*ws.Conn),
+// Compile-time check that the handler can be handed to services as their event sink
+var _ serviceInterfaces.WebSocketService = (*WebSocketHandler)(nil)
+
+// NewWebSocketHandler starts the local hub and joins the other replicas
+// through Redis
+func NewWebSocketHandler(redis *cache.RedisClient, logger *logger.Logger) *WebSocketHandler {
+	h := &WebSocketHandler{
+		logger:     logger,
+		hub:        newWSHub(logger),
+		redis:      redis,
+		instanceID: uuid.NewString(),
+	}
+	go h.hub.run()
+	go h.trackPresence(context.Background())
+	if redis != nil {
+		go h.subscribe(context.Background())
 	}
+	return h
 }
 
 // WebSocket upgrade middleware
@@ -62,28 +92,44 @@ func (h *WebSocketHandler) WebSocketUpgrade() func(*fiber.Ctx) error {
 			return
 		}
 
-		// Register client
-		clientKey := fmt.Sprintf("user_%s", userIDStr)
-		h.clients[clientKey] = c
+		role, _ := c.Locals("role").(string)
+		client := newWSClient(c, userIDStr, role)
+		h.hub.register <- client
 		h.logger.Info("WebSocket client connected", "user_id", userIDStr)
 
-		// Clean up on disconnect
+		// The connection is released when this handler returns, so wait for
+		// the write pump to stop using it
+		writerDone := make(chan struct{})
+		go func() {
+			client.writePump()
+			close(writerDone)
+		}()
+
 		defer func() {
-			delete(h.clients, clientKey)
+			h.hub.unregister <- client
+			<-writerDone
 			h.logger.Info("WebSocket client disconnected", "user_id", userIDStr)
 		}()
 
+		for _, topic := range defaultTopics(client) {
+			if err := h.setSubscription(client, topic, true); err != nil {
+				h.logger.Warn("Failed to subscribe WebSocket client", "error", err, "user_id", userIDStr, "topic", topic)
+			}
+		}
+
 		// Send welcome message
-		welcomeMsg := WSMessage{
+		h.sendMessage(client, WSMessage{
 			Type:      WSUserStatus,
 			UserID:    userIDStr,
 			Data:      map[string]string{"status": "connected"},
 			Timestamp: time.Now(),
-		}
-		if err := h.sendMessage(c, welcomeMsg); err != nil {
-			h.logger.Error("Failed to send welcome message", "error", err)
-			return
-		}
+		})
+
+		c.SetReadLimit(wsMaxMessageSize)
+		c.SetReadDeadline(time.Now().Add(wsPongWait))
+		c.SetPongHandler(func(string) error {
+			return c.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
 
 		// Handle incoming messages
 		for {
@@ -97,109 +143,171 @@ func (h *WebSocketHandler) WebSocketUpgrade() func(*fiber.Ctx) error {
 			}
 
 			// Handle client messages (ping, subscribe, etc.)
-			h.handleClientMessage(c, userIDStr, msg)
+			h.handleClientMessage(client, msg)
 		}
 	})
 }
 
-func (h *WebSocketHandler) handleClientMessage(c *ws.Conn, userID string, msg WSMessage) {
+func (h *WebSocketHandler) handleClientMessage(client *wsClient, msg WSMessage) {
 	switch msg.Type {
 	case "ping":
 		// Respond to ping
-		pongMsg := WSMessage{
+		h.sendMessage(client, WSMessage{
 			Type:      "pong",
-			UserID:    userID,
+			UserID:    client.userID,
 			Data:      map[string]string{"timestamp": time.Now().Format(time.RFC3339)},
 			Timestamp: time.Now(),
-		}
-		h.sendMessage(c, pongMsg)
+		})
+
+	case WSSubscribe, WSUnsubscribe:
+		h.handleSubscription(client, msg.Type == WSSubscribe, msg.Topic)
 
 	case "subscribe_traffic":
-		// Client wants to subscribe to traffic updates
-		h.logger.Info("Client subscribed to traffic updates", "user_id", userID)
+		// Older clients ask for their own traffic updates this way
+		h.handleSubscription(client, true, userTopic(client.userID, "traffic"))
 
 	default:
-		h.logger.Warn("Unknown WebSocket message type", "type", msg.Type, "user_id", userID)
+		h.logger.Warn("Unknown WebSocket message type", "type", msg.Type, "user_id", client.userID)
 	}
 }
 
-func (h *WebSocketHandler) sendMessage(c *ws.Conn, msg WSMessage) error {
-	return c.WriteJSON(msg)
+// handleSubscription applies a client's (un)subscribe request and answers
+// with the outcome
+func (h *WebSocketHandler) handleSubscription(client *wsClient, subscribe bool, topic string) {
+	canonical, err := authorizeTopic(client, topic)
+	if err == nil {
+		err = h.setSubscription(client, canonical, subscribe)
+	}
+	if err != nil {
+		if errors.Is(err, errWSTopicForbidden) {
+			h.logger.Warn("WebSocket subscription denied", "user_id", client.userID, "topic", topic)
+		}
+		h.sendMessage(client, WSMessage{
+			Type:      WSError,
+			Topic:     topic,
+			Data:      map[string]string{"error": err.Error()},
+			Timestamp: time.Now(),
+		})
+		return
+	}
+
+	reply := WSUnsubscribed
+	if subscribe {
+		reply = WSSubscribed
+	}
+	h.sendMessage(client, WSMessage{
+		Type:      reply,
+		Topic:     canonical,
+		Timestamp: time.Now(),
+	})
 }
 
-// Broadcast traffic update to specific user
-func (h *WebSocketHandler) BroadcastTrafficUpdate(userID uuid.UUID, stats *models.TrafficStats) {
-	clientKey := fmt.Sprintf("user_%s", userID.String())
-	if conn, exists := h.clients[clientKey]; exists {
-		msg := WSMessage{
-			Type:      WSTrafficUpdate,
-			UserID:    userID.String(),
-			Data:      stats,
-			Timestamp: time.Now(),
-		}
+// setSubscription has the hub add the client to the topic or remove it.
+// The topic must already be authorized.
+func (h *WebSocketHandler) setSubscription(client *wsClient, topic string, subscribe bool) error {
+	result := make(chan error, 1)
+	h.hub.subscriptions <- wsSubscription{
+		client:    client,
+		topic:     topic,
+		subscribe: subscribe,
+		result:    result,
+	}
+	return <-result
+}
 
-		if err := h.sendMessage(conn, msg); err != nil {
-			h.logger.Error("Failed to send traffic update", "error", err, "user_id", userID)
-			// Remove dead connection
-			delete(h.clients, clientKey)
-		} else {
-			h.logger.Debug("Traffic update sent", "user_id", userID, "upload", stats.Upload, "download", stats.Download)
-		}
+// sendMessage queues a reply for a single connection
+func (h *WebSocketHandler) sendMessage(client *wsClient, msg WSMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		h.logger.Error("Failed to encode WebSocket message", "error", err, "type", msg.Type)
+		return
+	}
+	if !client.enqueue(data) {
+		h.logger.Warn("WebSocket send queue full, message dropped", "type", msg.Type, "user_id", client.userID)
+	}
+}
+
+// publish sends a message to the sockets subscribed to any of the topics on
+// every replica. The message carries the first topic. Delivery never waits
+// on a client, slow ones are dropped by the hub.
+func (h *WebSocketHandler) publish(msg WSMessage, topics ...string) {
+	msg.Topic = topics[0]
+	data, err := json.Marshal(msg)
+	if err != nil {
+		h.logger.Error("Failed to encode WebSocket message", "error", err, "type", msg.Type)
+		return
 	}
+	h.relay(wsBroadcast{topics: topics, data: data})
+}
+
+// Broadcast traffic update to specific user
+func (h *WebSocketHandler) BroadcastTrafficUpdate(userID uuid.UUID, stats *models.TrafficStats) {
+	h.publish(WSMessage{
+		Type:      WSTrafficUpdate,
+		UserID:    userID.String(),
+		Data:      stats,
+		Timestamp: time.Now(),
+	}, userTopic(userID.String(), "traffic"))
 }
 
 // Broadcast user status update
 func (h *WebSocketHandler) BroadcastUserStatus(userID uuid.UUID, status string) {
-	clientKey := fmt.Sprintf("user_%s", userID.String())
-	if conn, exists := h.clients[clientKey]; exists {
-		msg := WSMessage{
-			Type:      WSUserStatus,
-			UserID:    userID.String(),
-			Data:      map[string]string{"status": status},
-			Timestamp: time.Now(),
-		}
-
-		if err := h.sendMessage(conn, msg); err != nil {
-			h.logger.Error("Failed to send user status", "error", err, "user_id", userID)
-			delete(h.clients, clientKey)
-		}
-	}
+	h.publish(WSMessage{
+		Type:      WSUserStatus,
+		UserID:    userID.String(),
+		Data:      map[string]string{"status": status},
+		Timestamp: time.Now(),
+	}, userTopic(userID.String(), "status"))
 }
 
 // Broadcast device online/offline status
 func (h *WebSocketHandler) BroadcastDeviceStatus(deviceID uuid.UUID, userID uuid.UUID, online bool) {
-	clientKey := fmt.Sprintf("user_%s", userID.String())
-	if conn, exists := h.clients[clientKey]; exists {
-		status := "offline"
-		if online {
-			status = "online"
-		}
+	status := "offline"
+	if online {
+		status = "online"
+	}
 
-		msg := WSMessage{
-			Type:      WSDeviceOnline,
-			UserID:    userID.String(),
-			Data:      map[string]interface{}{"device_id": deviceID.String(), "status": status},
-			Timestamp: time.Now(),
-		}
+	h.publish(WSMessage{
+		Type:      WSDeviceOnline,
+		UserID:    userID.String(),
+		Data:      map[string]interface{}{"device_id": deviceID.String(), "status": status},
+		Timestamp: time.Now(),
+	}, userTopic(userID.String(), "devices"))
+}
 
-		if err := h.sendMessage(conn, msg); err != nil {
-			h.logger.Error("Failed to send device status", "error", err, "device_id", deviceID)
-			delete(h.clients, clientKey)
-		}
-	}
+// Broadcast node status changes to the admins following the node or the fleet
+func (h *WebSocketHandler) BroadcastNodeEvent(event *models.NodeEvent) {
+	h.publish(WSMessage{
+		Type:      WSNodeEvent,
+		Data:      event,
+		Timestamp: time.Now(),
+	}, nodeTopic(event.NodeID.String(), "status"), wsTopicFleetStatus)
+}
+
+// Broadcast a node's latest metrics to the admins following the node or the fleet
+func (h *WebSocketHandler) BroadcastNodeMetric(metric *models.NodeMetric) {
+	h.publish(WSMessage{
+		Type:      WSNodeMetric,
+		Data:      metric,
+		Timestamp: time.Now(),
+	}, nodeTopic(metric.NodeID.String(), "metrics"), wsTopicFleetMetrics)
 }
 
-// Get connected clients count
+// Broadcast deployment progress to the admins following deployments
+func (h *WebSocketHandler) BroadcastDeployment(deployment *models.Deployment) {
+	h.publish(WSMessage{
+		Type:      WSDeploymentUpdate,
+		Data:      deployment,
+		Timestamp: time.Now(),
+	}, wsTopicDeployments)
+}
+
+// GetConnectedClientsCount counts the sockets held by this replica
 func (h *WebSocketHandler) GetConnectedClientsCount() int {
-	return len(h.clients)
+	return h.hub.count()
 }
 
-// Get connected clients for specific user
+// IsUserConnected reports whether the user has a socket on any replica
 func (h *WebSocketHandler) IsUserConnected(userID uuid.UUID) bool {
-	clientKey := fmt.Sprintf("user_%s", userID.String())
-	_, exists := h.clients[clientKey]
-	return exists
+	return h.present(userID.String())
 }
-
-// Ensure WebSocketHandler implements WebSocketService interface
-var _ serviceInterfaces.WebSocketService = (*WebSocketHandler)(nil)
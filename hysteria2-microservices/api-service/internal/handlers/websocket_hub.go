@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	ws "github.com/gofiber/websocket/v2"
+)
+
+const (
+	// Time allowed to write a message to the peer
+	wsWriteWait = 10 * time.Second
+	// Time allowed to read the next pong from the peer
+	wsPongWait = 60 * time.Second
+	// Pings are sent before the peer's read deadline expires
+	wsPingPeriod = wsPongWait * 9 / 10
+	// Largest message accepted from a client
+	wsMaxMessageSize = 4096
+	// Messages queued per connection before it counts as too slow
+	wsSendBuffer = 64
+	// Topics a single connection may subscribe to
+	wsMaxTopics = 32
+)
+
+// wsClient is a single WebSocket connection. Only its write pump writes to
+// the connection, everyone else queues on send.
+type wsClient struct {
+	conn   *ws.Conn
+	userID string
+	role   string
+	send   chan []byte
+
+	// topics is owned by the hub's run loop
+	topics map[string]bool
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newWSClient(conn *ws.Conn, userID, role string) *wsClient {
+	return &wsClient{
+		conn:   conn,
+		userID: userID,
+		role:   role,
+		send:   make(chan []byte, wsSendBuffer),
+		topics: make(map[string]bool),
+		closed: make(chan struct{}),
+	}
+}
+
+// can reports whether the client's role grants the permission
+func (c *wsClient) can(permission models.Permission) bool {
+	return models.HasPermission(c.role, permission)
+}
+
+// enqueue queues a message without blocking, reporting false when the
+// client's queue is full
+func (c *wsClient) enqueue(data []byte) bool {
+	select {
+	case <-c.closed:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the write pump, which closes the connection
+func (c *wsClient) close() {
+	c.closeOnce.Do(func() { close(c.closed) })
+}
+
+// writePump delivers queued messages and keeps the connection alive with
+// pings. It returns when the client is closed or a write fails.
+func (c *wsClient) writePump() {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+	}()
+
+	for {
+		select {
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(ws.TextMessage, data); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := c.conn.WriteMessage(ws.PingMessage, nil); err != nil {
+				return
+			}
+		case <-c.closed:
+			c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			c.conn.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""))
+			return
+		}
+	}
+}
+
+// wsBroadcast is a message for every connection subscribed to any of the
+// topics. A connection subscribed to several of them gets it once.
+type wsBroadcast struct {
+	topics []string
+	data   []byte
+}
+
+// wsSubscription adds a connection to a topic or removes it. The outcome is
+// reported on result.
+type wsSubscription struct {
+	client    *wsClient
+	topic     string
+	subscribe bool
+	result    chan error
+}
+
+// wsPresence reports a user's first local connection opening or the last
+// one closing
+type wsPresence struct {
+	userID string
+	online bool
+}
+
+// wsHub tracks the open connections and their topics. Its run loop is the
+// only writer of the client and topic sets, readers take the read lock.
+type wsHub struct {
+	logger *logger.Logger
+
+	mu      sync.RWMutex
+	clients map[string]map[*wsClient]bool // by user ID
+	topics  map[string]map[*wsClient]bool
+
+	register      chan *wsClient
+	unregister    chan *wsClient
+	subscriptions chan wsSubscription
+	broadcast     chan wsBroadcast
+	presence      chan wsPresence
+}
+
+func newWSHub(logger *logger.Logger) *wsHub {
+	return &wsHub{
+		logger:        logger,
+		clients:       make(map[string]map[*wsClient]bool),
+		topics:        make(map[string]map[*wsClient]bool),
+		register:      make(chan *wsClient),
+		unregister:    make(chan *wsClient),
+		subscriptions: make(chan wsSubscription),
+		broadcast:     make(chan wsBroadcast, 256),
+		presence:      make(chan wsPresence, 256),
+	}
+}
+
+func (h *wsHub) run() {
+	for {
+		select {
+		case client := <-h.register:
+			h.mu.Lock()
+			conns, ok := h.clients[client.userID]
+			if !ok {
+				conns = make(map[*wsClient]bool)
+				h.clients[client.userID] = conns
+			}
+			conns[client] = true
+			h.mu.Unlock()
+			if !ok {
+				h.presence <- wsPresence{userID: client.userID, online: true}
+			}
+
+		case client := <-h.unregister:
+			h.remove(client)
+
+		case sub := <-h.subscriptions:
+			sub.result <- h.subscription(sub)
+
+		case msg := <-h.broadcast:
+			for _, client := range h.targets(msg) {
+				if !client.enqueue(msg.data) {
+					// A client that cannot keep up is dropped rather than
+					// holding up everyone else. It reconnects and resyncs.
+					h.logger.Warn("Dropping slow WebSocket client", "user_id", client.userID)
+					h.remove(client)
+				}
+			}
+		}
+	}
+}
+
+func (h *wsHub) targets(msg wsBroadcast) []*wsClient {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if len(msg.topics) == 1 {
+		targets := make([]*wsClient, 0, len(h.topics[msg.topics[0]]))
+		for client := range h.topics[msg.topics[0]] {
+			targets = append(targets, client)
+		}
+		return targets
+	}
+
+	seen := make(map[*wsClient]bool)
+	var targets []*wsClient
+	for _, topic := range msg.topics {
+		for client := range h.topics[topic] {
+			if !seen[client] {
+				seen[client] = true
+				targets = append(targets, client)
+			}
+		}
+	}
+	return targets
+}
+
+func (h *wsHub) subscription(sub wsSubscription) error {
+	client := sub.client
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !sub.subscribe {
+		delete(client.topics, sub.topic)
+		h.leave(client, sub.topic)
+		return nil
+	}
+
+	if client.topics[sub.topic] {
+		return nil
+	}
+	// Connections already removed by the hub must not rejoin a topic
+	if !h.clients[client.userID][client] {
+		return errWSClientClosed
+	}
+	if len(client.topics) >= wsMaxTopics {
+		return errWSTooManyTopics
+	}
+
+	client.topics[sub.topic] = true
+	conns, ok := h.topics[sub.topic]
+	if !ok {
+		conns = make(map[*wsClient]bool)
+		h.topics[sub.topic] = conns
+	}
+	conns[client] = true
+	return nil
+}
+
+// leave drops the client from a topic, the caller holds the write lock
+func (h *wsHub) leave(client *wsClient, topic string) {
+	if conns, ok := h.topics[topic]; ok {
+		delete(conns, client)
+		if len(conns) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+func (h *wsHub) remove(client *wsClient) {
+	offline := false
+	h.mu.Lock()
+	if conns, ok := h.clients[client.userID]; ok && conns[client] {
+		delete(conns, client)
+		if len(conns) == 0 {
+			delete(h.clients, client.userID)
+			offline = true
+		}
+	}
+	for topic := range client.topics {
+		h.leave(client, topic)
+	}
+	client.topics = make(map[string]bool)
+	h.mu.Unlock()
+	client.close()
+
+	if offline {
+		h.presence <- wsPresence{userID: client.userID, online: false}
+	}
+}
+
+func (h *wsHub) count() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	n := 0
+	for _, conns := range h.clients {
+		n += len(conns)
+	}
+	return n
+}
+
+// userIDs lists the users with a local connection
+func (h *wsHub) userIDs() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	ids := make([]string, 0, len(h.clients))
+	for id := range h.clients {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (h *wsHub) connected(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients[userID]) > 0
+}
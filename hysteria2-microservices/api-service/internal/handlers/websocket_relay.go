@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// Events are relayed through Redis so every api-service replica can deliver
+// them to the sockets it holds
+const wsEventsChannel = "ws:events"
+
+const (
+	// wsPresencePrefix + user ID is a set of the replicas holding a socket of
+	// the user. Replicas refresh the TTL while the user stays connected, so a
+	// crashed replica's entries expire on their own.
+	wsPresencePrefix  = "ws:presence:"
+	wsPresenceTTL     = 90 * time.Second
+	wsPresenceRefresh = 30 * time.Second
+	wsRedisTimeout    = 2 * time.Second
+)
+
+// wsEvent is the Redis payload of a broadcast
+type wsEvent struct {
+	Topics  []string        `json:"topics"`
+	Message json.RawMessage `json:"message"`
+}
+
+// relay publishes a broadcast for all replicas. The local hub receives it
+// back through the subscription like every other replica. Without Redis the
+// event is only delivered locally.
+func (h *WebSocketHandler) relay(target wsBroadcast) {
+	if h.redis == nil {
+		h.hub.broadcast <- target
+		return
+	}
+
+	payload, err := json.Marshal(wsEvent{
+		Topics:  target.topics,
+		Message: target.data,
+	})
+	if err != nil {
+		h.logger.Error("Failed to encode WebSocket event", "error", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wsRedisTimeout)
+	defer cancel()
+	if err := h.redis.Publish(ctx, wsEventsChannel, payload); err != nil {
+		h.logger.Error("Failed to publish WebSocket event, delivering locally", "error", err)
+		h.hub.broadcast <- target
+	}
+}
+
+// subscribe feeds events published by any replica to the local hub. The
+// Redis client reconnects on its own, the loop only ends on shutdown.
+func (h *WebSocketHandler) subscribe(ctx context.Context) {
+	pubsub := h.redis.Subscribe(ctx, wsEventsChannel)
+	defer pubsub.Close()
+
+	for msg := range pubsub.Channel() {
+		var event wsEvent
+		if err := json.Unmarshal([]byte(msg.Payload), &event); err != nil {
+			h.logger.Warn("Invalid WebSocket event on Redis", "error", err)
+			continue
+		}
+		if len(event.Topics) == 0 {
+			continue
+		}
+		h.hub.broadcast <- wsBroadcast{
+			topics: event.Topics,
+			data:   event.Message,
+		}
+	}
+}
+
+// trackPresence mirrors the hub's local users into the shared presence sets
+func (h *WebSocketHandler) trackPresence(ctx context.Context) {
+	ticker := time.NewTicker(wsPresenceRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case p := <-h.hub.presence:
+			if p.online {
+				h.markPresent(ctx, p.userID)
+			} else {
+				h.markAbsent(ctx, p.userID)
+			}
+		case <-ticker.C:
+			for _, userID := range h.hub.userIDs() {
+				h.markPresent(ctx, userID)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (h *WebSocketHandler) markPresent(ctx context.Context, userID string) {
+	if h.redis == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, wsRedisTimeout)
+	defer cancel()
+
+	key := wsPresencePrefix + userID
+	if err := h.redis.SAdd(ctx, key, h.instanceID); err != nil {
+		h.logger.Warn("Failed to record WebSocket presence", "error", err, "user_id", userID)
+		return
+	}
+	h.redis.Expire(ctx, key, wsPresenceTTL)
+}
+
+func (h *WebSocketHandler) markAbsent(ctx context.Context, userID string) {
+	if h.redis == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, wsRedisTimeout)
+	defer cancel()
+
+	if err := h.redis.SRem(ctx, wsPresencePrefix+userID, h.instanceID); err != nil {
+		h.logger.Warn("Failed to clear WebSocket presence", "error", err, "user_id", userID)
+	}
+}
+
+// present reports whether any replica holds a socket of the user, falling
+// back to the local hub when Redis is unavailable
+func (h *WebSocketHandler) present(userID string) bool {
+	if h.redis == nil {
+		return h.hub.connected(userID)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), wsRedisTimeout)
+	defer cancel()
+	n, err := h.redis.Exists(ctx, wsPresencePrefix+userID)
+	if err != nil {
+		h.logger.Warn("Failed to read WebSocket presence", "error", err, "user_id", userID)
+		return h.hub.connected(userID)
+	}
+	return n > 0
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"hysteria2-microservices/api-service/internal/models"
+
+	"github.com/google/uuid"
+)
+
+// Topics a WebSocket client can subscribe to. Users may follow their own
+// user topics, other users' topics need users:read and the node topics
+// nodes:read.
+//
+//	user:<id>:traffic   traffic updates of a user
+//	user:<id>:status    account status changes of a user
+//	user:<id>:devices   device changes of a user
+//	node:<id>:metrics   metrics reported by a node
+//	node:<id>:status    status transitions of a node
+//	fleet:metrics       metrics reported by any node
+//	fleet:status        status transitions of any node
+//	deployments         config deployment progress on any node
+const (
+	wsTopicFleetMetrics = "fleet:metrics"
+	wsTopicFleetStatus  = "fleet:status"
+	wsTopicDeployments  = "deployments"
+)
+
+var (
+	errWSUnknownTopic   = errors.New("unknown topic")
+	errWSTopicForbidden = errors.New("not allowed to subscribe to this topic")
+	errWSTooManyTopics  = fmt.Errorf("subscribed to too many topics, the limit is %d", wsMaxTopics)
+	errWSClientClosed   = errors.New("connection closed")
+)
+
+func userTopic(userID, stream string) string {
+	return "user:" + userID + ":" + stream
+}
+
+func nodeTopic(nodeID, stream string) string {
+	return "node:" + nodeID + ":" + stream
+}
+
+// authorizeTopic checks that the client may follow the topic and returns it
+// in canonical form
+func authorizeTopic(client *wsClient, topic string) (string, error) {
+	switch topic {
+	case wsTopicFleetMetrics, wsTopicFleetStatus, wsTopicDeployments:
+		if !client.can(models.PermNodesRead) {
+			return "", errWSTopicForbidden
+		}
+		return topic, nil
+	}
+
+	parts := strings.Split(topic, ":")
+	if len(parts) != 3 {
+		return "", errWSUnknownTopic
+	}
+	id, err := uuid.Parse(parts[1])
+	if err != nil {
+		return "", errWSUnknownTopic
+	}
+
+	switch parts[0] {
+	case "user":
+		switch parts[2] {
+		case "traffic", "status", "devices":
+		default:
+			return "", errWSUnknownTopic
+		}
+		if id.String() != client.userID && !client.can(models.PermUsersRead) {
+			return "", errWSTopicForbidden
+		}
+		return userTopic(id.String(), parts[2]), nil
+
+	case "node":
+		switch parts[2] {
+		case "metrics", "status":
+		default:
+			return "", errWSUnknownTopic
+		}
+		if !client.can(models.PermNodesRead) {
+			return "", errWSTopicForbidden
+		}
+		return nodeTopic(id.String(), parts[2]), nil
+	}
+
+	return "", errWSUnknownTopic
+}
+
+// defaultTopics are subscribed on connect, matching what clients received
+// before topics existed
+func defaultTopics(client *wsClient) []string {
+	topics := []string{
+		userTopic(client.userID, "traffic"),
+		userTopic(client.userID, "status"),
+		userTopic(client.userID, "devices"),
+	}
+	if client.can(models.PermNodesRead) {
+		topics = append(topics, wsTopicFleetStatus)
+	}
+	return topics
+}
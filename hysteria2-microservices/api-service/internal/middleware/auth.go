@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"hysteria2-microservices/api-service/internal/models"
 	"hysteria2-microservices/api-service/internal/services/interfaces"
 	"hysteria2-microservices/api-service/pkg/logger"
 	"strings"
@@ -27,8 +28,8 @@ func JWTAuth(authService interfaces.AuthService) fiber.Handler {
 
 		token := tokenParts[1]
 
-		// Validate token
-		claims, err := authService.ValidateToken(token)
+		// Validate token, rejecting tokens of revoked sessions
+		claims, err := authService.ValidateToken(c.Context(), token)
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid or expired token",
@@ -39,6 +40,7 @@ func JWTAuth(authService interfaces.AuthService) fiber.Handler {
 		c.Locals("user_id", claims.UserID)
 		c.Locals("username", claims.Username)
 		c.Locals("role", claims.Role)
+		c.Locals("session_id", claims.SessionID)
 
 		return c.Next()
 	}
@@ -103,3 +105,18 @@ func RequireRole(requiredRole string) fiber.Handler {
 		return c.Next()
 	}
 }
+
+// RequirePermission allows only roles granting the permission. Routes that
+// users may also call for themselves check ownership in the handler instead.
+func RequirePermission(permission models.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		role, _ := c.Locals("role").(string)
+		if !models.HasPermission(role, permission) {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+				"error": "Insufficient permissions",
+			})
+		}
+
+		return c.Next()
+	}
+}
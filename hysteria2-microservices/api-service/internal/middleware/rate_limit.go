@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"strconv"
+
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/ratelimit"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RateLimitKey picks what a rate limit counts requests by
+type RateLimitKey func(c *fiber.Ctx) string
+
+// ByIP counts requests per client IP
+func ByIP(c *fiber.Ctx) string {
+	return "ip:" + c.IP()
+}
+
+// ByUser counts requests per signed-in user, falling back to the client IP
+// on routes without a token
+func ByUser(c *fiber.Ctx) string {
+	if userID, ok := c.Locals("user_id").(string); ok && userID != "" {
+		return "user:" + userID
+	}
+	return ByIP(c)
+}
+
+// RateLimit rejects requests beyond the rule with 429 and a Retry-After
+// header. Each rule has its own counters, so routes sharing a key do not
+// share a budget. Requests pass when Redis is unavailable.
+func RateLimit(limiter *ratelimit.Limiter, rule ratelimit.Rule, key RateLimitKey, logger *logger.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		result, err := limiter.Allow(c.Context(), rule, key(c))
+		if err != nil {
+			logger.Warn("Rate limit check failed, allowing request", "error", err, "rule", rule.Name)
+			return c.Next()
+		}
+
+		c.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		if !result.Allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ratelimit.RetryAfterSeconds(result.RetryAfter)))
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Too many requests",
+			})
+		}
+
+		return c.Next()
+	}
+}
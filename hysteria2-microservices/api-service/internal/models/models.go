@@ -1,43 +1,72 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// Statuses of self-registered accounts that cannot sign in yet
+const (
+	UserStatusPendingVerification = "pending_verification"
+	UserStatusPendingApproval     = "pending_approval"
+)
+
 type User struct {
 	ID         uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 	Username   string     `json:"username" gorm:"uniqueIndex;not null"`
 	Email      string     `json:"email" gorm:"uniqueIndex;not null"`
 	Password   string     `json:"-" gorm:"not null"` // Never return password in JSON
 	FullName   *string    `json:"full_name"`
-	Status     string     `json:"status" gorm:"default:'active';check:status IN ('active','suspended','deleted')"`
-	Role       string     `json:"role" gorm:"default:'user';check:role IN ('admin','user')"`
+	Status     string     `json:"status" gorm:"default:'active';check:status IN ('active','suspended','deleted','pending_verification','pending_approval')"`
+	Role       string     `json:"role" gorm:"default:'user';check:role IN ('admin','operator','support','user')"`
 	DataLimit  int64      `json:"data_limit" gorm:"default:0"`
 	DataUsed   int64      `json:"data_used" gorm:"default:0"`
 	ExpiryDate *time.Time `json:"expiry_date"`
-	CreatedAt  time.Time  `json:"created_at"`
-	UpdatedAt  time.Time  `json:"updated_at"`
-	LastLogin  *time.Time `json:"last_login"`
-	Notes      *string    `json:"notes"`
+	// DeviceLimit caps the user's devices, 0 uses the server default
+	DeviceLimit int `json:"device_limit" gorm:"default:0"`
+	// Speed caps per client in Mbit/s, the client's upload and download.
+	// 0 uses the plan's cap, and without one the node's.
+	UpMbps    int        `json:"up_mbps" gorm:"default:0"`
+	DownMbps  int        `json:"down_mbps" gorm:"default:0"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	LastLogin *time.Time `json:"last_login"`
+	Notes     *string    `json:"notes"`
+
+	// Secret path segment of the user's subscription URL
+	SubscriptionToken *string `json:"-" gorm:"uniqueIndex;size:64"`
+
+	// TOTP second factor. The secret is set when enrollment starts and
+	// MFAEnabled once the user confirmed a code. TOTPLastStep is the time
+	// step of the last accepted code, which cannot be used again.
+	MFAEnabled   bool    `json:"mfa_enabled" gorm:"default:false"`
+	TOTPSecret   *string `json:"-" gorm:"size:64"`
+	TOTPLastStep int64   `json:"-" gorm:"default:0"`
+
+	// Set once the user followed the verification link sent on registration
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// Invite code the user registered with
+	InviteCodeID *uuid.UUID `json:"invite_code_id" gorm:"type:uuid"`
 
 	// Relations
 	Devices []Device `json:"devices,omitempty" gorm:"foreignKey:UserID"`
 }
 
 type Device struct {
-	ID        uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
-	UserID    uuid.UUID  `json:"user_id" gorm:"not null"`
-	Name      string     `json:"name" gorm:"not null"`
-	DeviceID  string     `json:"device_id" gorm:"uniqueIndex;not null"`
-	PublicKey string     `json:"public_key" gorm:"not null"`
-	IPAddress *string    `json:"ip_address"`
-	Status    string     `json:"status" gorm:"default:'active';check:status IN ('active','inactive','blocked')"`
-	DataUsed  int64      `json:"data_used" gorm:"default:0"`
-	CreatedAt time.Time  `json:"created_at"`
-	LastSeen  *time.Time `json:"last_seen"`
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID     uuid.UUID  `json:"user_id" gorm:"not null"`
+	Name       string     `json:"name" gorm:"not null"`
+	DeviceID   string     `json:"device_id" gorm:"uniqueIndex;not null"`
+	PublicKey  string     `json:"public_key" gorm:"not null"`
+	AuthSecret string     `json:"-" gorm:"size:64"` // Hysteria2 credential, sent as "<id>:<auth_secret>"
+	IPAddress  *string    `json:"ip_address"`
+	Status     string     `json:"status" gorm:"default:'active';check:status IN ('active','inactive','blocked')"`
+	DataUsed   int64      `json:"data_used" gorm:"default:0"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeen   *time.Time `json:"last_seen"`
 
 	// Relations
 	User User `json:"user,omitempty" gorm:"foreignKey:UserID"`
@@ -47,12 +76,13 @@ type Session struct {
 	ID           uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 	UserID       uuid.UUID  `json:"user_id" gorm:"not null"`
 	DeviceID     *uuid.UUID `json:"device_id"`
-	SessionToken string     `json:"-" gorm:"uniqueIndex;not null"`
-	RefreshToken *string    `json:"-" gorm:"uniqueIndex"`
+	SessionToken string     `json:"-" gorm:"uniqueIndex;not null"` // opaque ID carried in the session's tokens
+	RefreshToken *string    `json:"-" gorm:"uniqueIndex"`          // SHA-256 of the current refresh token
 	IPAddress    *string    `json:"ip_address"`
 	UserAgent    *string    `json:"user_agent"`
 	ExpiresAt    time.Time  `json:"expires_at" gorm:"not null"`
 	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at"`
 	IsActive     bool       `json:"is_active" gorm:"default:true"`
 
 	// Relations
@@ -60,6 +90,117 @@ type Session struct {
 	Device *Device `json:"device,omitempty" gorm:"foreignKey:DeviceID"`
 }
 
+// MFARecoveryCode is a single-use code that replaces a TOTP code when the
+// user lost their authenticator
+type MFARecoveryCode struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"size:64;not null"` // SHA-256 of the normalized code
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// MFARequiredRole marks a role whose members must use a second factor
+type MFARequiredRole struct {
+	Role      string    `json:"role" gorm:"primaryKey;size:20"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// InviteCode admits self-registration when registration is invite-only.
+// Accounts created with it start with its data limit and validity.
+type InviteCode struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Code      string     `json:"code" gorm:"uniqueIndex;size:32;not null"`
+	MaxUses   int        `json:"max_uses" gorm:"default:1"` // 0 allows unlimited uses
+	Uses      int        `json:"uses" gorm:"default:0"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	// Defaults for accounts registered with the code
+	DataLimit   int64     `json:"data_limit" gorm:"default:0"`
+	ValidDays   int       `json:"valid_days" gorm:"default:0"` // 0 means no expiry date
+	DeviceLimit int       `json:"device_limit" gorm:"default:0"`
+	Note        *string   `json:"note"`
+	CreatedBy   uuid.UUID `json:"created_by" gorm:"type:uuid;not null"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Plan is a service tier users subscribe to. Zero limits mean unlimited.
+type Plan struct {
+	ID          uuid.UUID `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	Name        string    `json:"name" gorm:"uniqueIndex;size:100;not null"`
+	Description *string   `json:"description"`
+	// Data quota per monthly period in bytes
+	DataLimit int64 `json:"data_limit" gorm:"default:0"`
+	// Speed cap per client in Mbit/s, unless the user has its own
+	UpMbps      int `json:"up_mbps" gorm:"default:0"`
+	DownMbps    int `json:"down_mbps" gorm:"default:0"`
+	DeviceLimit int `json:"device_limit" gorm:"default:0"`
+	// Node groups the plan may connect to, matched against the "group" key
+	// of the node metadata. Empty allows all assigned nodes.
+	NodeGroups []string `json:"node_groups" gorm:"type:jsonb;serializer:json"`
+	// Length of a subscription, 0 for one that does not end
+	DurationDays int `json:"duration_days" gorm:"default:0"`
+	// Inactive plans are kept for existing subscriptions but cannot be
+	// assigned
+	IsActive  bool      `json:"is_active" gorm:"default:true"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Subscription statuses. A user has at most one active subscription.
+const (
+	SubscriptionActive   = "active"
+	SubscriptionExpired  = "expired"
+	SubscriptionReplaced = "replaced"
+)
+
+// Subscription puts a user on a plan. The data quota resets every month
+// counted from StartedAt; ResetAt is the next reset.
+type Subscription struct {
+	ID        uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index;uniqueIndex:idx_subscriptions_active_user,where:status = 'active'"`
+	PlanID    uuid.UUID  `json:"plan_id" gorm:"type:uuid;not null;index"`
+	Status    string     `json:"status" gorm:"size:20;default:'active';index;check:status IN ('active','expired','replaced')"`
+	StartedAt time.Time  `json:"started_at" gorm:"not null"`
+	RenewedAt *time.Time `json:"renewed_at"`
+	EndsAt    *time.Time `json:"ends_at"` // nil for no end
+	ResetAt   time.Time  `json:"reset_at" gorm:"not null;index"`
+	// Violation the user was suspended for while on this subscription,
+	// lifted by the next quota reset or an extension
+	SuspendReason *string   `json:"suspend_reason" gorm:"size:20"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	// Relations
+	Plan *Plan `json:"plan,omitempty" gorm:"foreignKey:PlanID"`
+}
+
+// Subscription history events
+const (
+	SubscriptionEventAssigned    = "assigned"
+	SubscriptionEventExtended    = "extended"
+	SubscriptionEventPlanChanged = "plan_changed"
+	SubscriptionEventReplaced    = "replaced"
+	SubscriptionEventQuotaReset  = "quota_reset"
+	SubscriptionEventExpired     = "expired"
+)
+
+// SubscriptionHistory records a change to a subscription. ActorID is the
+// admin who made it, nil for changes made by the enforcement sweep.
+type SubscriptionHistory struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
+	SubscriptionID uuid.UUID  `json:"subscription_id" gorm:"type:uuid;not null;index"`
+	UserID         uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	Event          string     `json:"event" gorm:"size:20;not null"`
+	FromPlanID     *uuid.UUID `json:"from_plan_id" gorm:"type:uuid"`
+	ToPlanID       *uuid.UUID `json:"to_plan_id" gorm:"type:uuid"`
+	FromEndsAt     *time.Time `json:"from_ends_at"`
+	ToEndsAt       *time.Time `json:"to_ends_at"`
+	DataUsed       int64      `json:"data_used"` // usage when the event happened
+	ActorID        *uuid.UUID `json:"actor_id" gorm:"type:uuid"`
+	Note           *string    `json:"note"`
+	CreatedAt      time.Time  `json:"created_at"`
+}
+
 type TrafficStats struct {
 	ID         uuid.UUID  `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 	UserID     uuid.UUID  `json:"user_id" gorm:"not null"`
@@ -75,12 +216,24 @@ type TrafficStats struct {
 	Device *Device `json:"device,omitempty" gorm:"foreignKey:DeviceID"`
 }
 
+// TrafficReport is one node traffic report forwarded by the orchestrator.
+// Reports with an ID are stored when recorded so a report the node resends
+// after a lost acknowledgement is not counted twice.
+type TrafficReport struct {
+	ID        string    `json:"id" gorm:"size:100;primaryKey"`
+	NodeID    string    `json:"node_id" gorm:"size:100;not null;index"`
+	CreatedAt time.Time `json:"created_at"`
+
+	Stats []*TrafficStats `json:"stats" gorm:"-"`
+}
+
 type HysteriaConfig struct {
 	ID         uuid.UUID              `json:"id" gorm:"type:uuid;default:gen_random_uuid();primaryKey"`
 	UserID     uuid.UUID              `json:"user_id" gorm:"not null"`
 	DeviceID   *uuid.UUID             `json:"device_id"`
+	NodeID     *uuid.UUID             `json:"node_id" gorm:"type:uuid;index"` // node the config was generated for
 	ConfigName string                 `json:"config_name" gorm:"not null"`
-	ConfigData map[string]interface{} `json:"config_data" gorm:"type:jsonb;not null"`
+	ConfigData map[string]interface{} `json:"config_data" gorm:"type:jsonb;serializer:json;not null"`
 	IsActive   bool                   `json:"is_active" gorm:"default:true"`
 	CreatedAt  time.Time              `json:"created_at"`
 	UpdatedAt  time.Time              `json:"updated_at"`
@@ -125,6 +278,10 @@ type Connection struct {
 	ID          string    `json:"id"`
 	UserID      string    `json:"user_id"`
 	DeviceID    string    `json:"device_id"`
+	DeviceName  string    `json:"device_name,omitempty"`
+	NodeID      string    `json:"node_id"`
+	NodeName    string    `json:"node_name"`
+	Streams     int       `json:"streams"` // open Hysteria2 connections of the device
 	Address     string    `json:"address"`
 	Upload      int64     `json:"upload"`
 	Download    int64     `json:"download"`
@@ -142,6 +299,7 @@ type VPSNode struct {
 	GRPCPort      int                    `json:"grpc_port" gorm:"default:50051"`
 	Status        string                 `json:"status" gorm:"size:20;default:'offline';index"`
 	Version       string                 `json:"version" gorm:"size:50"`
+	ConfigVersion string                 `json:"config_version" gorm:"size:50"`
 	Capabilities  map[string]interface{} `json:"capabilities" gorm:"type:jsonb"`
 	CreatedAt     time.Time              `json:"created_at" gorm:"default:CURRENT_TIMESTAMP"`
 	LastHeartbeat *time.Time             `json:"last_heartbeat" gorm:"index"`
@@ -192,6 +350,48 @@ type Deployment struct {
 	Node *VPSNode `json:"node,omitempty" gorm:"foreignKey:NodeID"`
 }
 
+// NodeEvent is a node status transition reported by the orchestrator
+type NodeEvent struct {
+	ID         uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	NodeID     uuid.UUID `json:"node_id" gorm:"not null;index"`
+	Type       string    `json:"type" gorm:"size:50;not null"`
+	FromStatus string    `json:"from_status" gorm:"size:20"`
+	ToStatus   string    `json:"to_status" gorm:"size:20"`
+	Reason     string    `json:"reason" gorm:"type:text"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ConfigVersion is a rendered Hysteria2 server config deployed by the orchestrator
+type ConfigVersion struct {
+	ID        uuid.UUID `json:"id" gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	NodeID    uuid.UUID `json:"node_id" gorm:"not null"`
+	Version   string    `json:"version" gorm:"size:50;not null"`
+	Checksum  string    `json:"checksum" gorm:"size:64;not null"`
+	Content   string    `json:"content" gorm:"type:text;not null"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ShareLink is a hy2:// URI for one device on one node, with the parameters
+// it was built from for clients that take structured configs
+type ShareLink struct {
+	NodeID       uuid.UUID `json:"node_id"`
+	NodeName     string    `json:"node_name"`
+	Location     string    `json:"location"`
+	DeviceID     uuid.UUID `json:"device_id"`
+	DeviceName   string    `json:"device_name"`
+	Name         string    `json:"name"`
+	Server       string    `json:"server"`
+	Port         int       `json:"port"`
+	HopPorts     string    `json:"hop_ports,omitempty"`
+	Auth         string    `json:"-"`
+	Obfs         string    `json:"obfs,omitempty"`
+	ObfsPassword string    `json:"-"`
+	SNI          string    `json:"sni,omitempty"`
+	Insecure     bool      `json:"insecure"`
+	PinSHA256    string    `json:"pin_sha256,omitempty"`
+	URI          string    `json:"uri"`
+}
+
 type ServiceStatus struct {
 	IsRunning         bool          `json:"is_running"`
 	Version           string        `json:"version"`
@@ -215,6 +415,10 @@ func (Session) TableName() string {
 	return "sessions"
 }
 
+func (SubscriptionHistory) TableName() string {
+	return "subscription_history"
+}
+
 func (TrafficStats) TableName() string {
 	return "traffic_stats"
 }
@@ -239,6 +443,14 @@ func (Deployment) TableName() string {
 	return "deployments"
 }
 
+func (NodeEvent) TableName() string {
+	return "node_events"
+}
+
+func (ConfigVersion) TableName() string {
+	return "config_versions"
+}
+
 // Hooks
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	if u.ID == uuid.Nil {
@@ -251,6 +463,9 @@ func (d *Device) BeforeCreate(tx *gorm.DB) error {
 	if d.ID == uuid.Nil {
 		d.ID = uuid.New()
 	}
+	if d.AuthSecret == "" {
+		d.AuthSecret = strings.ReplaceAll(uuid.NewString(), "-", "")
+	}
 	return nil
 }
 
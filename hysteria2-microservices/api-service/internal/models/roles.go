@@ -0,0 +1,64 @@
+package models
+
+// User roles. Every role can manage its own account, devices and traffic,
+// the permissions below grant access to other users and the node fleet.
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
+	RoleSupport  = "support"
+	RoleUser     = "user"
+)
+
+// Permission names an action on resources that do not belong to the caller
+type Permission string
+
+const (
+	// Read any user with their devices, traffic and connections
+	PermUsersRead Permission = "users:read"
+	// Create, edit and delete any user and their devices
+	PermUsersWrite Permission = "users:write"
+	// Suspend and reactivate users
+	PermUsersSuspend Permission = "users:suspend"
+	// Read nodes, their metrics and deployments
+	PermNodesRead Permission = "nodes:read"
+	// Create, edit, restart and delete nodes
+	PermNodesWrite Permission = "nodes:write"
+	// Disconnect any user's live sessions
+	PermConnectionsManage Permission = "connections:manage"
+	// Change security policies such as the roles required to use MFA
+	PermSecurityManage Permission = "security:manage"
+	// Create, edit and delete plans
+	PermPlansManage Permission = "plans:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	RoleAdmin: {
+		PermUsersRead, PermUsersWrite, PermUsersSuspend,
+		PermNodesRead, PermNodesWrite, PermConnectionsManage,
+		PermSecurityManage, PermPlansManage,
+	},
+	RoleOperator: {
+		PermUsersRead, PermNodesRead, PermNodesWrite, PermConnectionsManage,
+	},
+	RoleSupport: {
+		PermUsersRead, PermUsersSuspend, PermNodesRead,
+	},
+	RoleUser: {},
+}
+
+// ValidRole reports whether role is one of the known roles
+func ValidRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// HasPermission reports whether the role grants the permission. Unknown
+// roles grant nothing.
+func HasPermission(role string, permission Permission) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"hysteria2-microservices/api-service/internal/models"
 	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
@@ -46,18 +47,60 @@ func (r *deviceRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([
 	return devices, err
 }
 
+func (r *deviceRepository) CountByUserID(ctx context.Context, userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Device{}).Where("user_id = ?", userID).Count(&count).Error
+	return count, err
+}
+
 func (r *deviceRepository) Update(ctx context.Context, device *models.Device) error {
 	return r.db.WithContext(ctx).Save(device).Error
 }
 
+func (r *deviceRepository) UpdateName(ctx context.Context, id uuid.UUID, name string) error {
+	return r.db.WithContext(ctx).Model(&models.Device{}).Where("id = ?", id).Update("name", name).Error
+}
+
+func (r *deviceRepository) UpdateStatus(ctx context.Context, id uuid.UUID, status string) error {
+	return r.db.WithContext(ctx).Model(&models.Device{}).Where("id = ?", id).Update("status", status).Error
+}
+
 func (r *deviceRepository) UpdateLastSeen(ctx context.Context, id uuid.UUID) error {
 	return r.db.WithContext(ctx).Model(&models.Device{}).Where("id = ?", id).Update("last_seen", gorm.Expr("NOW()")).Error
 }
 
+func (r *deviceRepository) UpdateAuthSecret(ctx context.Context, id uuid.UUID, secret string) error {
+	return r.db.WithContext(ctx).Model(&models.Device{}).Where("id = ?", id).Update("auth_secret", secret).Error
+}
+
+// RecordConnection stores where and when the device last connected. Events
+// arrive from several nodes out of order, so older ones are ignored.
+func (r *deviceRepository) RecordConnection(ctx context.Context, id uuid.UUID, ipAddress string, at time.Time) error {
+	return r.db.WithContext(ctx).Model(&models.Device{}).
+		Where("id = ? AND (last_seen IS NULL OR last_seen < ?)", id, at).
+		Updates(map[string]interface{}{
+			"last_seen":  at,
+			"ip_address": ipAddress,
+		}).Error
+}
+
 func (r *deviceRepository) UpdateDataUsage(ctx context.Context, id uuid.UUID, dataUsed int64) error {
 	return r.db.WithContext(ctx).Model(&models.Device{}).Where("id = ?", id).Update("data_used", dataUsed).Error
 }
 
+// Delete removes the device with its client configs. Sessions and traffic
+// history are kept and detached from the device.
 func (r *deviceRepository) Delete(ctx context.Context, id uuid.UUID) error {
-	return r.db.WithContext(ctx).Delete(&models.Device{}, "id = ?", id).Error
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Session{}).Where("device_id = ?", id).Update("device_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.TrafficStats{}).Where("device_id = ?", id).Update("device_id", nil).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&models.HysteriaConfig{}, "device_id = ?", id).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Device{}, "id = ?", id).Error
+	})
 }
@@ -0,0 +1,68 @@
+package repositories
+
+import (
+	"context"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type hysteriaConfigRepository struct {
+	db *gorm.DB
+}
+
+func NewHysteriaConfigRepository(db *gorm.DB) repoInterfaces.HysteriaConfigRepository {
+	return &hysteriaConfigRepository{db: db}
+}
+
+func (r *hysteriaConfigRepository) Create(ctx context.Context, config *models.HysteriaConfig) error {
+	return r.db.WithContext(ctx).Create(config).Error
+}
+
+func (r *hysteriaConfigRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.HysteriaConfig, error) {
+	var config models.HysteriaConfig
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&config).Error
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func (r *hysteriaConfigRepository) GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.HysteriaConfig, error) {
+	var configs []*models.HysteriaConfig
+	err := r.db.WithContext(ctx).Where("user_id = ?", userID).Order("created_at DESC").Find(&configs).Error
+	return configs, err
+}
+
+func (r *hysteriaConfigRepository) GetByDeviceID(ctx context.Context, deviceID uuid.UUID) ([]*models.HysteriaConfig, error) {
+	var configs []*models.HysteriaConfig
+	err := r.db.WithContext(ctx).Where("device_id = ?", deviceID).Order("created_at DESC").Find(&configs).Error
+	return configs, err
+}
+
+func (r *hysteriaConfigRepository) GetActiveByUserID(ctx context.Context, userID uuid.UUID) ([]*models.HysteriaConfig, error) {
+	var configs []*models.HysteriaConfig
+	err := r.db.WithContext(ctx).Where("user_id = ? AND is_active = ?", userID, true).Order("created_at DESC").Find(&configs).Error
+	return configs, err
+}
+
+func (r *hysteriaConfigRepository) Update(ctx context.Context, config *models.HysteriaConfig) error {
+	return r.db.WithContext(ctx).Save(config).Error
+}
+
+func (r *hysteriaConfigRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&models.HysteriaConfig{}, "id = ?", id).Error
+}
+
+// SetActive flags every config of the user, or only those of one device
+// when deviceID is set
+func (r *hysteriaConfigRepository) SetActive(ctx context.Context, userID uuid.UUID, deviceID *uuid.UUID, active bool) error {
+	query := r.db.WithContext(ctx).Model(&models.HysteriaConfig{}).Where("user_id = ?", userID)
+	if deviceID != nil {
+		query = query.Where("device_id = ?", *deviceID)
+	}
+	return query.Update("is_active", active).Error
+}
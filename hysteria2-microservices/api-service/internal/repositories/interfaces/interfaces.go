@@ -16,8 +16,81 @@ type UserRepository interface {
 	Update(ctx context.Context, user *models.User) error
 	Delete(ctx context.Context, id uuid.UUID) error
 	List(ctx context.Context, offset, limit int, search string, status, role string) ([]*models.User, int64, error)
+	GetBySubscriptionToken(ctx context.Context, token string) (*models.User, error)
+	SetSubscriptionToken(ctx context.Context, id uuid.UUID, token string) error
 	UpdateLastLogin(ctx context.Context, id uuid.UUID) error
 	UpdateDataUsage(ctx context.Context, id uuid.UUID, dataUsed int64) error
+	TransitionStatus(ctx context.Context, id uuid.UUID, from, to string) (bool, error)
+	ListQuotaViolations(ctx context.Context, now time.Time) ([]*models.User, error)
+	UpdateMFA(ctx context.Context, id uuid.UUID, totpSecret *string, enabled bool) error
+	RecordTOTPStep(ctx context.Context, id uuid.UUID, step int64) (bool, error)
+	MarkEmailVerified(ctx context.Context, id uuid.UUID, status string) (bool, error)
+	UpdatePassword(ctx context.Context, id uuid.UUID, hash string) error
+}
+
+// InviteCodeRepository stores registration invite codes
+type InviteCodeRepository interface {
+	Create(ctx context.Context, invite *models.InviteCode) error
+	GetByID(ctx context.Context, id uuid.UUID) (*models.InviteCode, error)
+	List(ctx context.Context, offset, limit int) ([]*models.InviteCode, int64, error)
+	Delete(ctx context.Context, id uuid.UUID) error
+	Redeem(ctx context.Context, code string, now time.Time) (*models.InviteCode, error)
+	Release(ctx context.Context, id uuid.UUID) error
+}
+
+// PlanRepository stores the plans users can subscribe to
+type PlanRepository interface {
+	Create(ctx context.Context, plan *models.Plan) error
+	GetByID(ctx context.Context, id uuid.UUID) (*models.Plan, error)
+	GetByName(ctx context.Context, name string) (*models.Plan, error)
+	List(ctx context.Context, includeInactive bool) ([]*models.Plan, error)
+	Update(ctx context.Context, plan *models.Plan) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	CountSubscriptions(ctx context.Context, planID uuid.UUID) (int64, error)
+}
+
+// UserLimits are the limits of a subscription's plan mirrored onto the user,
+// where the Hysteria2 auth hook and the device checks read them
+type UserLimits struct {
+	DataLimit   int64
+	DeviceLimit int
+	ExpiryDate  *time.Time
+	// ResetDataUsed starts a new quota period
+	ResetDataUsed bool
+	// Reactivate lifts a suspension made by quota enforcement
+	Reactivate bool
+}
+
+// SubscriptionChange is written in a single transaction: the subscription
+// it replaces, the subscription itself, its history and the user's limits.
+// Subscription is created when its ID is unset and saved otherwise.
+type SubscriptionChange struct {
+	Replaced     *models.Subscription
+	Subscription *models.Subscription
+	History      []*models.SubscriptionHistory
+	Limits       *UserLimits
+}
+
+// SubscriptionRepository stores the users' plan subscriptions and their
+// history
+type SubscriptionRepository interface {
+	GetActiveByUserID(ctx context.Context, userID uuid.UUID) (*models.Subscription, error)
+	GetLatestByUserID(ctx context.Context, userID uuid.UUID) (*models.Subscription, error)
+	ListDue(ctx context.Context, now time.Time) ([]*models.Subscription, error)
+	Apply(ctx context.Context, change *SubscriptionChange) error
+	SetSuspendReason(ctx context.Context, id uuid.UUID, reason *string) error
+	SyncPlanLimits(ctx context.Context, plan *models.Plan) ([]uuid.UUID, error)
+	ListHistory(ctx context.Context, userID uuid.UUID, offset, limit int) ([]*models.SubscriptionHistory, int64, error)
+}
+
+// MFARepository stores recovery codes and the roles required to use MFA
+type MFARepository interface {
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, hashes []string) error
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, hash string) (bool, error)
+	DeleteRecoveryCodes(ctx context.Context, userID uuid.UUID) error
+	CountUnusedRecoveryCodes(ctx context.Context, userID uuid.UUID) (int64, error)
+	GetRequiredRoles(ctx context.Context) ([]string, error)
+	SetRequiredRoles(ctx context.Context, roles []string) error
 }
 
 type DeviceRepository interface {
@@ -25,17 +98,32 @@ type DeviceRepository interface {
 	GetByID(ctx context.Context, id uuid.UUID) (*models.Device, error)
 	GetByDeviceID(ctx context.Context, deviceID string) (*models.Device, error)
 	GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Device, error)
+	CountByUserID(ctx context.Context, userID uuid.UUID) (int64, error)
 	Update(ctx context.Context, device *models.Device) error
+	UpdateName(ctx context.Context, id uuid.UUID, name string) error
+	UpdateStatus(ctx context.Context, id uuid.UUID, status string) error
 	UpdateLastSeen(ctx context.Context, id uuid.UUID) error
+	RecordConnection(ctx context.Context, id uuid.UUID, ipAddress string, at time.Time) error
+	UpdateAuthSecret(ctx context.Context, id uuid.UUID, secret string) error
 	UpdateDataUsage(ctx context.Context, id uuid.UUID, dataUsed int64) error
 	Delete(ctx context.Context, id uuid.UUID) error
 }
 
+// NodeRepository reads the node fleet managed by the orchestrator
+type NodeRepository interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*models.VPSNode, error)
+	GetAssignedToUser(ctx context.Context, userID uuid.UUID) ([]*models.VPSNode, error)
+	GetConfigVersion(ctx context.Context, nodeID uuid.UUID, version string) (*models.ConfigVersion, error)
+}
+
 type SessionRepository interface {
 	Create(ctx context.Context, session *models.Session) error
 	GetByToken(ctx context.Context, token string) (*models.Session, error)
 	GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Session, error)
+	GetActiveByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Session, error)
 	Update(ctx context.Context, session *models.Session) error
+	RotateRefreshToken(ctx context.Context, id uuid.UUID, oldHash, newHash string, expiresAt time.Time) (bool, error)
+	Revoke(ctx context.Context, id uuid.UUID) error
 	Delete(ctx context.Context, id uuid.UUID) error
 	DeleteExpired(ctx context.Context) error
 	InvalidateUserSessions(ctx context.Context, userID uuid.UUID) error
@@ -43,6 +131,7 @@ type SessionRepository interface {
 
 type TrafficRepository interface {
 	Create(ctx context.Context, traffic *models.TrafficStats) error
+	CreateBatch(ctx context.Context, report *models.TrafficReport) (bool, error)
 	GetByUserID(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.TrafficStats, error)
 	GetByDeviceID(ctx context.Context, deviceID uuid.UUID, from, to time.Time) ([]*models.TrafficStats, error)
 	GetSummary(ctx context.Context, from, to time.Time) (*models.TrafficSummary, error)
@@ -52,7 +141,9 @@ type TrafficRepository interface {
 
 type HysteriaConfigRepository interface {
 	Create(ctx context.Context, config *models.HysteriaConfig) error
+	GetByID(ctx context.Context, id uuid.UUID) (*models.HysteriaConfig, error)
 	GetByUserID(ctx context.Context, userID uuid.UUID) ([]*models.HysteriaConfig, error)
+	GetByDeviceID(ctx context.Context, deviceID uuid.UUID) ([]*models.HysteriaConfig, error)
 	GetActiveByUserID(ctx context.Context, userID uuid.UUID) ([]*models.HysteriaConfig, error)
 	Update(ctx context.Context, config *models.HysteriaConfig) error
 	Delete(ctx context.Context, id uuid.UUID) error
@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type inviteCodeRepository struct {
+	db *gorm.DB
+}
+
+func NewInviteCodeRepository(db *gorm.DB) repoInterfaces.InviteCodeRepository {
+	return &inviteCodeRepository{db: db}
+}
+
+func (r *inviteCodeRepository) Create(ctx context.Context, invite *models.InviteCode) error {
+	return r.db.WithContext(ctx).Create(invite).Error
+}
+
+func (r *inviteCodeRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.InviteCode, error) {
+	var invite models.InviteCode
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&invite).Error
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+func (r *inviteCodeRepository) List(ctx context.Context, offset, limit int) ([]*models.InviteCode, int64, error) {
+	var invites []*models.InviteCode
+	var total int64
+
+	query := r.db.WithContext(ctx).Model(&models.InviteCode{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Offset(offset).Limit(limit).Order("created_at DESC").Find(&invites).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return invites, total, nil
+}
+
+func (r *inviteCodeRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&models.InviteCode{}, "id = ?", id).Error
+}
+
+// Redeem uses up one use of a code that has not expired or run out. It
+// returns gorm.ErrRecordNotFound when the code cannot be used.
+func (r *inviteCodeRepository) Redeem(ctx context.Context, code string, now time.Time) (*models.InviteCode, error) {
+	var invite models.InviteCode
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.InviteCode{}).
+			Where("code = ?", code).
+			Where("max_uses = 0 OR uses < max_uses").
+			Where("expires_at IS NULL OR expires_at > ?", now).
+			Update("uses", gorm.Expr("uses + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		return tx.Where("code = ?", code).First(&invite).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// Release gives back a use taken by Redeem when registration failed
+func (r *inviteCodeRepository) Release(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&models.InviteCode{}).
+		Where("id = ? AND uses > 0", id).
+		Update("uses", gorm.Expr("uses - 1")).Error
+}
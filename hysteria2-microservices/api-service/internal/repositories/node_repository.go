@@ -0,0 +1,50 @@
+package repositories
+
+import (
+	"context"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type nodeRepository struct {
+	db *gorm.DB
+}
+
+func NewNodeRepository(db *gorm.DB) repoInterfaces.NodeRepository {
+	return &nodeRepository{db: db}
+}
+
+func (r *nodeRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.VPSNode, error) {
+	var node models.VPSNode
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&node).Error
+	if err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// GetAssignedToUser returns the nodes with an active assignment for the user.
+// Revoked nodes are left out since they no longer accept clients.
+func (r *nodeRepository) GetAssignedToUser(ctx context.Context, userID uuid.UUID) ([]*models.VPSNode, error) {
+	var nodes []*models.VPSNode
+	err := r.db.WithContext(ctx).
+		Joins("JOIN node_assignments ON node_assignments.node_id = vps_nodes.id").
+		Where("node_assignments.user_id = ? AND node_assignments.is_active = ?", userID, true).
+		Where("vps_nodes.status <> ?", "revoked").
+		Order("vps_nodes.name").
+		Find(&nodes).Error
+	return nodes, err
+}
+
+func (r *nodeRepository) GetConfigVersion(ctx context.Context, nodeID uuid.UUID, version string) (*models.ConfigVersion, error) {
+	var config models.ConfigVersion
+	err := r.db.WithContext(ctx).Where("node_id = ? AND version = ?", nodeID, version).First(&config).Error
+	if err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
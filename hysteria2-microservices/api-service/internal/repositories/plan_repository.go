@@ -0,0 +1,66 @@
+package repositories
+
+import (
+	"context"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type planRepository struct {
+	db *gorm.DB
+}
+
+func NewPlanRepository(db *gorm.DB) repoInterfaces.PlanRepository {
+	return &planRepository{db: db}
+}
+
+func (r *planRepository) Create(ctx context.Context, plan *models.Plan) error {
+	return r.db.WithContext(ctx).Create(plan).Error
+}
+
+func (r *planRepository) GetByID(ctx context.Context, id uuid.UUID) (*models.Plan, error) {
+	var plan models.Plan
+	err := r.db.WithContext(ctx).Where("id = ?", id).First(&plan).Error
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (r *planRepository) GetByName(ctx context.Context, name string) (*models.Plan, error) {
+	var plan models.Plan
+	err := r.db.WithContext(ctx).Where("name = ?", name).First(&plan).Error
+	if err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func (r *planRepository) List(ctx context.Context, includeInactive bool) ([]*models.Plan, error) {
+	var plans []*models.Plan
+	query := r.db.WithContext(ctx)
+	if !includeInactive {
+		query = query.Where("is_active = ?", true)
+	}
+	err := query.Order("name").Find(&plans).Error
+	return plans, err
+}
+
+func (r *planRepository) Update(ctx context.Context, plan *models.Plan) error {
+	return r.db.WithContext(ctx).Save(plan).Error
+}
+
+func (r *planRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&models.Plan{}, "id = ?", id).Error
+}
+
+// CountSubscriptions counts the subscriptions of any status on the plan
+func (r *planRepository) CountSubscriptions(ctx context.Context, planID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).Model(&models.Subscription{}).Where("plan_id = ?", planID).Count(&count).Error
+	return count, err
+}
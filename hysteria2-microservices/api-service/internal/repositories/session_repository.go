@@ -2,6 +2,7 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"hysteria2-microservices/api-service/internal/models"
 	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
@@ -37,10 +38,39 @@ func (r *sessionRepository) GetByUserID(ctx context.Context, userID uuid.UUID) (
 	return sessions, err
 }
 
+// GetActiveByUserID lists the user's sessions that are neither revoked nor
+// expired, most recently used first
+func (r *sessionRepository) GetActiveByUserID(ctx context.Context, userID uuid.UUID) ([]*models.Session, error) {
+	var sessions []*models.Session
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND is_active = ? AND expires_at > NOW()", userID, true).
+		Order("COALESCE(last_used_at, created_at) DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
 func (r *sessionRepository) Update(ctx context.Context, session *models.Session) error {
 	return r.db.WithContext(ctx).Save(session).Error
 }
 
+// RotateRefreshToken replaces the session's refresh token hash if it still
+// is oldHash. It reports false when another refresh or a revocation got
+// there first.
+func (r *sessionRepository) RotateRefreshToken(ctx context.Context, id uuid.UUID, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.Session{}).
+		Where("id = ? AND refresh_token = ? AND is_active = ?", id, oldHash, true).
+		Updates(map[string]interface{}{
+			"refresh_token": newHash,
+			"expires_at":    expiresAt,
+			"last_used_at":  time.Now(),
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *sessionRepository) Revoke(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Model(&models.Session{}).Where("id = ?", id).Update("is_active", false).Error
+}
+
 func (r *sessionRepository) Delete(ctx context.Context, id uuid.UUID) error {
 	return r.db.WithContext(ctx).Delete(&models.Session{}, "id = ?", id).Error
 }
@@ -50,5 +80,5 @@ func (r *sessionRepository) DeleteExpired(ctx context.Context) error {
 }
 
 func (r *sessionRepository) InvalidateUserSessions(ctx context.Context, userID uuid.UUID) error {
-	return r.db.WithContext(ctx).Where("user_id = ?", userID).Update("is_active", false).Error
+	return r.db.WithContext(ctx).Model(&models.Session{}).Where("user_id = ?", userID).Update("is_active", false).Error
 }
@@ -0,0 +1,155 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type subscriptionRepository struct {
+	db *gorm.DB
+}
+
+func NewSubscriptionRepository(db *gorm.DB) repoInterfaces.SubscriptionRepository {
+	return &subscriptionRepository{db: db}
+}
+
+func (r *subscriptionRepository) GetActiveByUserID(ctx context.Context, userID uuid.UUID) (*models.Subscription, error) {
+	var subscription models.Subscription
+	err := r.db.WithContext(ctx).Preload("Plan").
+		Where("user_id = ? AND status = ?", userID, models.SubscriptionActive).
+		First(&subscription).Error
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// GetLatestByUserID returns the active subscription or, if there is none,
+// the one that expired last
+func (r *subscriptionRepository) GetLatestByUserID(ctx context.Context, userID uuid.UUID) (*models.Subscription, error) {
+	var subscription models.Subscription
+	err := r.db.WithContext(ctx).Preload("Plan").
+		Where("user_id = ? AND status IN ?", userID, []string{models.SubscriptionActive, models.SubscriptionExpired}).
+		Order("CASE WHEN status = 'active' THEN 0 ELSE 1 END, created_at DESC").
+		First(&subscription).Error
+	if err != nil {
+		return nil, err
+	}
+	return &subscription, nil
+}
+
+// ListDue returns active subscriptions whose quota period or term is over
+func (r *subscriptionRepository) ListDue(ctx context.Context, now time.Time) ([]*models.Subscription, error) {
+	var subscriptions []*models.Subscription
+	err := r.db.WithContext(ctx).Preload("Plan").
+		Where("status = ?", models.SubscriptionActive).
+		Where("reset_at <= ? OR (ends_at IS NOT NULL AND ends_at <= ?)", now, now).
+		Find(&subscriptions).Error
+	return subscriptions, err
+}
+
+func (r *subscriptionRepository) Apply(ctx context.Context, change *repoInterfaces.SubscriptionChange) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if change.Replaced != nil {
+			err := tx.Model(&models.Subscription{}).Where("id = ?", change.Replaced.ID).
+				Update("status", models.SubscriptionReplaced).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		subscription := change.Subscription
+		if subscription.ID == uuid.Nil {
+			if err := tx.Omit("Plan").Create(subscription).Error; err != nil {
+				return err
+			}
+		} else if err := tx.Omit("Plan").Save(subscription).Error; err != nil {
+			return err
+		}
+
+		// Entries without a subscription are about the one written here
+		for _, entry := range change.History {
+			if entry.SubscriptionID == uuid.Nil {
+				entry.SubscriptionID = subscription.ID
+			}
+			entry.UserID = subscription.UserID
+		}
+		if len(change.History) > 0 {
+			if err := tx.Create(change.History).Error; err != nil {
+				return err
+			}
+		}
+
+		if change.Limits == nil {
+			return nil
+		}
+		return applyUserLimits(tx, subscription.UserID, change.Limits)
+	})
+}
+
+func applyUserLimits(tx *gorm.DB, userID uuid.UUID, limits *repoInterfaces.UserLimits) error {
+	updates := map[string]interface{}{
+		"data_limit":   limits.DataLimit,
+		"device_limit": limits.DeviceLimit,
+		"expiry_date":  limits.ExpiryDate,
+	}
+	if limits.ResetDataUsed {
+		updates["data_used"] = 0
+	}
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Updates(updates).Error; err != nil {
+		return err
+	}
+
+	if !limits.Reactivate {
+		return nil
+	}
+	return tx.Model(&models.User{}).Where("id = ? AND status = ?", userID, "suspended").
+		Update("status", "active").Error
+}
+
+func (r *subscriptionRepository) SetSuspendReason(ctx context.Context, id uuid.UUID, reason *string) error {
+	return r.db.WithContext(ctx).Model(&models.Subscription{}).Where("id = ?", id).Update("suspend_reason", reason).Error
+}
+
+// SyncPlanLimits mirrors changed plan limits onto the users subscribed to it
+// and returns their IDs
+func (r *subscriptionRepository) SyncPlanLimits(ctx context.Context, plan *models.Plan) ([]uuid.UUID, error) {
+	var userIDs []uuid.UUID
+	err := r.db.WithContext(ctx).Model(&models.Subscription{}).
+		Where("plan_id = ? AND status = ?", plan.ID, models.SubscriptionActive).
+		Pluck("user_id", &userIDs).Error
+	if err != nil || len(userIDs) == 0 {
+		return nil, err
+	}
+
+	err = r.db.WithContext(ctx).Model(&models.User{}).Where("id IN ?", userIDs).Updates(map[string]interface{}{
+		"data_limit":   plan.DataLimit,
+		"device_limit": plan.DeviceLimit,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return userIDs, nil
+}
+
+func (r *subscriptionRepository) ListHistory(ctx context.Context, userID uuid.UUID, offset, limit int) ([]*models.SubscriptionHistory, int64, error) {
+	var history []*models.SubscriptionHistory
+	var total int64
+
+	query := r.db.WithContext(ctx).Model(&models.SubscriptionHistory{}).Where("user_id = ?", userID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Offset(offset).Limit(limit).Order("created_at DESC").Find(&history).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return history, total, nil
+}
@@ -9,6 +9,7 @@ import (
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type trafficRepository struct {
@@ -23,6 +24,56 @@ func (r *trafficRepository) Create(ctx context.Context, traffic *models.TrafficS
 	return r.db.WithContext(ctx).Create(traffic).Error
 }
 
+// CreateBatch stores the traffic rows of a report and adds them to the
+// per-user and per-device usage counters in a single transaction. The
+// counters are incremented in the database so concurrent reports never
+// overwrite each other. A report with an ID is stored along with its rows;
+// it reports false, storing nothing, when that report was already recorded.
+func (r *trafficRepository) CreateBatch(ctx context.Context, report *models.TrafficReport) (bool, error) {
+	if len(report.Stats) == 0 {
+		return true, nil
+	}
+
+	recorded := true
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if report.ID != "" {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(report)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				recorded = false
+				return nil
+			}
+		}
+
+		// total is generated by the database
+		if err := tx.Omit("Total").Create(&report.Stats).Error; err != nil {
+			return err
+		}
+
+		for _, s := range report.Stats {
+			total := s.Upload + s.Download
+			if err := tx.Model(&models.User{}).Where("id = ?", s.UserID).
+				Update("data_used", gorm.Expr("data_used + ?", total)).Error; err != nil {
+				return err
+			}
+			if s.DeviceID != nil {
+				if err := tx.Model(&models.Device{}).Where("id = ?", *s.DeviceID).
+					Update("data_used", gorm.Expr("data_used + ?", total)).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return recorded, nil
+}
+
 func (r *trafficRepository) GetByUserID(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.TrafficStats, error) {
 	var traffic []*models.TrafficStats
 	err := r.db.WithContext(ctx).
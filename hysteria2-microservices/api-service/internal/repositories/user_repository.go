@@ -2,6 +2,8 @@ package repositories
 
 import (
 	"context"
+	"time"
+
 	"hysteria2-microservices/api-service/internal/models"
 	"hysteria2-microservices/api-service/internal/repositories/interfaces"
 
@@ -48,8 +50,21 @@ func (r *userRepository) GetByEmail(ctx context.Context, email string) (*models.
 	return &user, nil
 }
 
+// Update stores the user's profile, role and limits. Usage, status,
+// credentials and MFA state have their own updates and are left alone.
 func (r *userRepository) Update(ctx context.Context, user *models.User) error {
-	return r.db.WithContext(ctx).Save(user).Error
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"username":     user.Username,
+		"email":        user.Email,
+		"full_name":    user.FullName,
+		"role":         user.Role,
+		"data_limit":   user.DataLimit,
+		"device_limit": user.DeviceLimit,
+		"up_mbps":      user.UpMbps,
+		"down_mbps":    user.DownMbps,
+		"notes":        user.Notes,
+		"updated_at":   time.Now(),
+	}).Error
 }
 
 func (r *userRepository) Delete(ctx context.Context, id uuid.UUID) error {
@@ -87,6 +102,19 @@ func (r *userRepository) List(ctx context.Context, offset, limit int, search str
 	return users, total, nil
 }
 
+func (r *userRepository) GetBySubscriptionToken(ctx context.Context, token string) (*models.User, error) {
+	var user models.User
+	err := r.db.WithContext(ctx).Where("subscription_token = ?", token).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *userRepository) SetSubscriptionToken(ctx context.Context, id uuid.UUID, token string) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Update("subscription_token", token).Error
+}
+
 func (r *userRepository) UpdateLastLogin(ctx context.Context, id uuid.UUID) error {
 	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Update("last_login", gorm.Expr("NOW()")).Error
 }
@@ -94,3 +122,59 @@ func (r *userRepository) UpdateLastLogin(ctx context.Context, id uuid.UUID) erro
 func (r *userRepository) UpdateDataUsage(ctx context.Context, id uuid.UUID, dataUsed int64) error {
 	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Update("data_used", dataUsed).Error
 }
+
+// TransitionStatus changes the user status only if it currently equals from,
+// so concurrent callers cannot apply the same transition twice
+func (r *userRepository) TransitionStatus(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.User{}).Where("id = ? AND status = ?", id, from).Update("status", to)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// ListQuotaViolations returns active users that are over their data limit or past their expiry date
+func (r *userRepository) ListQuotaViolations(ctx context.Context, now time.Time) ([]*models.User, error) {
+	var users []*models.User
+	err := r.db.WithContext(ctx).Preload("Devices").
+		Where("status = ?", "active").
+		Where("(data_limit > 0 AND data_used >= data_limit) OR (expiry_date IS NOT NULL AND expiry_date <= ?)", now).
+		Find(&users).Error
+	return users, err
+}
+
+// UpdateMFA stores the user's TOTP secret and whether MFA is enabled. A new
+// secret resets the last used time step.
+func (r *userRepository) UpdateMFA(ctx context.Context, id uuid.UUID, totpSecret *string, enabled bool) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_secret":    totpSecret,
+		"mfa_enabled":    enabled,
+		"totp_last_step": 0,
+	}).Error
+}
+
+// RecordTOTPStep remembers the time step of an accepted code. It reports
+// false when a code of this or a later step was already used.
+func (r *userRepository) RecordTOTPStep(ctx context.Context, id uuid.UUID, step int64) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND totp_last_step < ?", id, step).
+		Update("totp_last_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// MarkEmailVerified records the verified email of a user awaiting
+// verification and moves them to status. It reports false when the user
+// was not awaiting verification.
+func (r *userRepository) MarkEmailVerified(ctx context.Context, id uuid.UUID, status string) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.User{}).
+		Where("id = ? AND status = ?", id, models.UserStatusPendingVerification).
+		Updates(map[string]interface{}{
+			"email_verified_at": gorm.Expr("NOW()"),
+			"status":            status,
+		})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *userRepository) UpdatePassword(ctx context.Context, id uuid.UUID, hash string) error {
+	return r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Update("password", hash).Error
+}
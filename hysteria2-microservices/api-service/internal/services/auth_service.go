@@ -3,174 +3,537 @@ package services
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"hysteria2-microservices/api-service/internal/models"
-	"hysteria2-microservices/api-service/internal/repositories/interfaces"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
 	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/internal/utils"
 	"hysteria2-microservices/api-service/pkg/cache"
+	"hysteria2-microservices/api-service/pkg/password"
+	"hysteria2-microservices/api-service/pkg/ratelimit"
 
 	"github.com/google/uuid"
-	"golang.org/x/crypto/argon2"
+	"gorm.io/gorm"
 )
 
 type authService struct {
-	userRepo    interfaces.UserRepository
-	sessionRepo interfaces.SessionRepository
-	redis       *cache.RedisClient
-	jwtSecret   string
-	jwtExpiry   time.Duration
+	userRepo         repoInterfaces.UserRepository
+	deviceRepo       repoInterfaces.DeviceRepository
+	sessionRepo      repoInterfaces.SessionRepository
+	subscriptionRepo repoInterfaces.SubscriptionRepository
+	redis            *cache.RedisClient
+	passwords        *password.Hasher
+	limiter          *ratelimit.Limiter
+	lockout          ratelimit.Lockout
+	jwtSecret        string
+	jwtExpiry        time.Duration
 }
 
-func NewAuthService(userRepo interfaces.UserRepository, sessionRepo interfaces.SessionRepository, redis *cache.RedisClient, jwtSecret string, jwtExpiry time.Duration) interfaces.AuthService {
+func NewAuthService(userRepo repoInterfaces.UserRepository, deviceRepo repoInterfaces.DeviceRepository, sessionRepo repoInterfaces.SessionRepository, subscriptionRepo repoInterfaces.SubscriptionRepository, redis *cache.RedisClient, passwords *password.Hasher, limiter *ratelimit.Limiter, lockout ratelimit.Lockout, jwtSecret string, jwtExpiry time.Duration) interfaces.AuthService {
 	return &authService{
-		userRepo:    userRepo,
-		sessionRepo: sessionRepo,
-		redis:       redis,
-		jwtSecret:   jwtSecret,
-		jwtExpiry:   jwtExpiry,
+		userRepo:         userRepo,
+		deviceRepo:       deviceRepo,
+		sessionRepo:      sessionRepo,
+		subscriptionRepo: subscriptionRepo,
+		redis:            redis,
+		passwords:        passwords,
+		limiter:          limiter,
+		lockout:          lockout,
+		jwtSecret:        jwtSecret,
+		jwtExpiry:        jwtExpiry,
 	}
 }
 
-func (s *authService) Register(ctx context.Context, username, email, password string) (*models.User, error) {
-	// Check if user already exists
-	if _, err := s.userRepo.GetByUsername(ctx, username); err == nil {
-		return nil, fmt.Errorf("username already exists")
-	}
-	if _, err := s.userRepo.GetByEmail(ctx, email); err == nil {
-		return nil, fmt.Errorf("email already exists")
+// Login checks the credentials of an account that is neither locked out
+// nor over its attempt limit. Both are checked before the password is
+// hashed and apply to unknown emails alike, so they reveal nothing about
+// which accounts exist. A correct password does not clear the failed
+// logins; that happens once the whole sign-in, including a second factor,
+// succeeded.
+func (s *authService) Login(ctx context.Context, email, password string) (*models.User, error) {
+	account := loginAccountKey(email)
+	if err := s.checkLoginAllowed(ctx, account); err != nil {
+		return nil, err
 	}
 
-	// Hash password
-	hashedPassword, err := s.hashPassword(password)
+	// Get user by email
+	user, err := s.userRepo.GetByEmail(ctx, email)
 	if err != nil {
-		return nil, fmt.Errorf("failed to hash password: %w", err)
+		s.recordLoginFailure(ctx, account)
+		return nil, fmt.Errorf("invalid credentials")
 	}
 
-	// Create user
-	user := &models.User{
-		Username: username,
-		Email:    email,
-		Password: hashedPassword,
-		Status:   "active",
-		Role:     "user",
+	// Check password
+	match, needsRehash := s.passwords.Verify(password, user.Password)
+	if !match {
+		s.recordLoginFailure(ctx, account)
+		return nil, fmt.Errorf("invalid credentials")
 	}
 
-	if err := s.userRepo.Create(ctx, user); err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+	// Upgrade hashes made with older parameters while the password is known
+	if needsRehash {
+		if hashed, err := s.passwords.Hash(password); err == nil {
+			if err := s.userRepo.UpdatePassword(ctx, user.ID, hashed); err != nil {
+				fmt.Printf("Failed to upgrade password hash: %v\n", err)
+			}
+		}
+	}
+
+	// Check if user is active
+	switch user.Status {
+	case "active":
+	case models.UserStatusPendingVerification:
+		return nil, interfaces.ErrEmailNotVerified
+	case models.UserStatusPendingApproval:
+		return nil, interfaces.ErrAccountPendingApproval
+	default:
+		return nil, fmt.Errorf("account is not active")
 	}
 
 	// Update last login
 	if err := s.userRepo.UpdateLastLogin(ctx, user.ID); err != nil {
-		// Log error but don't fail registration
 		fmt.Printf("Failed to update last login: %v\n", err)
 	}
 
 	return user, nil
 }
 
-func (s *authService) Login(ctx context.Context, email, password string) (*models.User, error) {
-	// Get user by email
-	user, err := s.userRepo.GetByEmail(ctx, email)
+const (
+	// loginFailuresPrefix + account counts consecutive failed logins,
+	// loginLockoutPrefix + account is set while the account is locked out
+	loginFailuresPrefix = "auth:login_failures:"
+	loginLockoutPrefix  = "auth:lockout:"
+	// Failures are forgotten a day after the last one
+	loginFailuresTTL = 24 * time.Hour
+)
+
+// loginAccountRule caps login attempts per account on top of the per-IP
+// limit, slowing down attacks spread over many addresses
+var loginAccountRule = ratelimit.Rule{Name: "login_account", Limit: 20, Window: 15 * time.Minute}
+
+// GetLoginLockout reports the user's failed logins and remaining lockout
+func (s *authService) GetLoginLockout(ctx context.Context, userID uuid.UUID) (*interfaces.LoginLockout, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
 	if err != nil {
-		return nil, fmt.Errorf("invalid credentials")
+		return nil, fmt.Errorf("user not found")
 	}
+	account := loginAccountKey(user.Email)
 
-	// Check password
-	if !s.verifyPassword(password, user.Password) {
-		return nil, fmt.Errorf("invalid credentials")
+	status := &interfaces.LoginLockout{}
+	var failures int
+	if err := s.redis.Get(ctx, loginFailuresPrefix+account, &failures); err == nil {
+		status.FailedAttempts = failures
 	}
+	ttl, err := s.redis.TTL(ctx, loginLockoutPrefix+account)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lockout: %w", err)
+	}
+	if ttl > 0 {
+		status.Locked = true
+		status.RetryAfterSeconds = ratelimit.RetryAfterSeconds(ttl)
+	}
+	return status, nil
+}
 
-	// Check if user is active
+// UnlockAccount lifts a lockout and resets the user's failed logins and
+// attempt limit
+func (s *authService) UnlockAccount(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+	account := loginAccountKey(user.Email)
+
+	if err := s.redis.Del(ctx, loginFailuresPrefix+account, loginLockoutPrefix+account); err != nil {
+		return fmt.Errorf("failed to unlock account: %w", err)
+	}
+	if err := s.limiter.Reset(ctx, loginAccountRule, account); err != nil {
+		return fmt.Errorf("failed to reset login limit: %w", err)
+	}
+	return nil
+}
+
+// CheckLoginAllowed applies the login lockout and attempt limit to a later
+// sign-in step of the user, such as the MFA code
+func (s *authService) CheckLoginAllowed(ctx context.Context, user *models.User) error {
+	return s.checkLoginAllowed(ctx, loginAccountKey(user.Email))
+}
+
+// RecordLoginFailure counts a failed sign-in step, such as a wrong MFA code,
+// towards the user's lockout
+func (s *authService) RecordLoginFailure(ctx context.Context, user *models.User) {
+	s.recordLoginFailure(ctx, loginAccountKey(user.Email))
+}
+
+// ClearLoginFailures resets the user's failed logins once they fully signed in
+func (s *authService) ClearLoginFailures(ctx context.Context, user *models.User) {
+	s.clearLoginFailures(ctx, loginAccountKey(user.Email))
+}
+
+// checkLoginAllowed rejects attempts on locked accounts and attempts over the
+// per-account limit. Logins stay possible while Redis is unavailable.
+func (s *authService) checkLoginAllowed(ctx context.Context, account string) error {
+	ttl, err := s.redis.TTL(ctx, loginLockoutPrefix+account)
+	if err != nil {
+		fmt.Printf("Failed to check login lockout: %v\n", err)
+		return nil
+	}
+	if ttl > 0 {
+		return &interfaces.RetryError{Err: interfaces.ErrAccountLocked, RetryAfter: ttl}
+	}
+
+	result, err := s.limiter.Allow(ctx, loginAccountRule, account)
+	if err != nil {
+		fmt.Printf("Failed to check login limit: %v\n", err)
+		return nil
+	}
+	if !result.Allowed {
+		return &interfaces.RetryError{Err: interfaces.ErrTooManyLoginAttempts, RetryAfter: result.RetryAfter}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed login and locks the account once the
+// failures reach the lockout threshold, longer with every further failure
+func (s *authService) recordLoginFailure(ctx context.Context, account string) {
+	failures, err := s.redis.Incr(ctx, loginFailuresPrefix+account)
+	if err != nil {
+		fmt.Printf("Failed to record login failure: %v\n", err)
+		return
+	}
+	s.redis.Expire(ctx, loginFailuresPrefix+account, loginFailuresTTL)
+
+	if d := s.lockout.Duration(int(failures)); d > 0 {
+		if err := s.redis.Set(ctx, loginLockoutPrefix+account, failures, d); err != nil {
+			fmt.Printf("Failed to lock account: %v\n", err)
+		}
+	}
+}
+
+func (s *authService) clearLoginFailures(ctx context.Context, account string) {
+	if err := s.redis.Del(ctx, loginFailuresPrefix+account); err != nil {
+		fmt.Printf("Failed to clear login failures: %v\n", err)
+	}
+}
+
+// loginAccountKey identifies an account by email regardless of case
+func loginAccountKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// revokedSessionPrefix + session token marks a revoked session in Redis.
+// Entries live as long as an access token, after that the session's access
+// tokens are expired anyway and refreshes are checked against the database.
+const revokedSessionPrefix = "auth:revoked_session:"
+
+// GenerateTokenPair starts a session for the user and issues its first token
+// pair. The tokens carry the user's current username and role, which the API
+// authorizes requests by.
+func (s *authService) GenerateTokenPair(ctx context.Context, user *models.User, info interfaces.SessionInfo) (*interfaces.TokenPair, error) {
+	sessionToken, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate session token: %w", err)
+	}
+
+	tokens, refreshHash, err := s.issueTokens(user, sessionToken)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &models.Session{
+		UserID:       user.ID,
+		SessionToken: sessionToken,
+		RefreshToken: &refreshHash,
+		ExpiresAt:    time.Now().Add(s.refreshExpiry()),
+		IsActive:     true,
+	}
+	if info.IPAddress != "" {
+		session.IPAddress = &info.IPAddress
+	}
+	if info.UserAgent != "" {
+		session.UserAgent = &info.UserAgent
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// ValidateToken accepts access tokens of sessions that were not revoked
+func (s *authService) ValidateToken(ctx context.Context, token string) (*interfaces.Claims, error) {
+	claims, err := utils.ValidateJWT(token, s.jwtSecret)
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenType != interfaces.TokenTypeAccess {
+		return nil, fmt.Errorf("not an access token")
+	}
+	if claims.SessionID == "" {
+		return nil, fmt.Errorf("token has no session")
+	}
+
+	revoked, err := s.sessionRevoked(ctx, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("session revoked")
+	}
+	return claims, nil
+}
+
+// RefreshToken rotates the session's refresh token and issues a new pair
+// from the user's current record, so role changes and suspensions apply
+// from the next refresh on. Presenting a refresh token that was already
+// rotated revokes the session.
+func (s *authService) RefreshToken(ctx context.Context, refreshToken string) (*interfaces.TokenPair, error) {
+	// Validate refresh token
+	claims, err := utils.ValidateJWT(refreshToken, s.jwtSecret)
+	if err != nil {
+		return nil, fmt.Errorf("invalid refresh token: %w", err)
+	}
+	if claims.TokenType != interfaces.TokenTypeRefresh {
+		return nil, fmt.Errorf("not a refresh token")
+	}
+
+	session, err := s.sessionRepo.GetByToken(ctx, claims.SessionID)
+	if err != nil {
+		return nil, interfaces.ErrSessionNotFound
+	}
+	if !session.IsActive || time.Now().After(session.ExpiresAt) {
+		return nil, fmt.Errorf("session revoked or expired")
+	}
+
+	presented := hashToken(refreshToken)
+	if session.RefreshToken == nil || subtle.ConstantTimeCompare([]byte(presented), []byte(*session.RefreshToken)) != 1 {
+		return nil, s.revokeReused(ctx, session)
+	}
+
+	user, err := s.userRepo.GetByID(ctx, session.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
 	if user.Status != "active" {
 		return nil, fmt.Errorf("account is not active")
 	}
 
-	// Update last login
-	if err := s.userRepo.UpdateLastLogin(ctx, user.ID); err != nil {
-		fmt.Printf("Failed to update last login: %v\n", err)
+	tokens, refreshHash, err := s.issueTokens(user, session.SessionToken)
+	if err != nil {
+		return nil, err
 	}
 
-	return user, nil
+	rotated, err := s.sessionRepo.RotateRefreshToken(ctx, session.ID, presented, refreshHash, time.Now().Add(s.refreshExpiry()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+	if !rotated {
+		// A concurrent refresh used the same token
+		return nil, s.revokeReused(ctx, session)
+	}
+
+	return tokens, nil
+}
+
+// Logout revokes the session an access token belongs to, identified by the
+// token's session claim
+func (s *authService) Logout(ctx context.Context, sessionToken string) error {
+	session, err := s.sessionRepo.GetByToken(ctx, sessionToken)
+	if err != nil {
+		return interfaces.ErrSessionNotFound
+	}
+	return s.revoke(ctx, session)
+}
+
+// ListSessions returns the user's sessions that are still usable
+func (s *authService) ListSessions(ctx context.Context, userID uuid.UUID) ([]*models.Session, error) {
+	return s.sessionRepo.GetActiveByUserID(ctx, userID)
+}
+
+// RevokeSession signs one of the user's sessions out
+func (s *authService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error {
+	sessions, err := s.sessionRepo.GetActiveByUserID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	for _, session := range sessions {
+		if session.ID == sessionID {
+			return s.revoke(ctx, session)
+		}
+	}
+	return interfaces.ErrSessionNotFound
 }
 
-func (s *authService) GenerateTokenPair(userID uuid.UUID) (*interfaces.TokenPair, error) {
+// InvalidateUserSessions signs the user out everywhere
+func (s *authService) InvalidateUserSessions(ctx context.Context, userID uuid.UUID) error {
+	sessions, err := s.sessionRepo.GetActiveByUserID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to list sessions: %w", err)
+	}
+	if err := s.sessionRepo.InvalidateUserSessions(ctx, userID); err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if err := s.denySession(ctx, session.SessionToken); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// issueTokens signs an access and a refresh token for the session and
+// returns them with the hash of the refresh token to store
+func (s *authService) issueTokens(user *models.User, sessionToken string) (*interfaces.TokenPair, string, error) {
+	claims := interfaces.Claims{
+		UserID:    user.ID.String(),
+		Username:  user.Username,
+		Role:      user.Role,
+		TokenType: interfaces.TokenTypeAccess,
+		SessionID: sessionToken,
+	}
+
 	// Generate access token
-	accessToken, err := generateJWT(userID, s.jwtSecret, s.jwtExpiry)
+	accessToken, err := utils.GenerateJWT(&claims, s.jwtSecret, s.jwtExpiry)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate access token: %w", err)
+		return nil, "", fmt.Errorf("failed to generate access token: %w", err)
 	}
 
 	// Generate refresh token (longer expiry)
-	refreshToken, err := generateJWT(userID, s.jwtSecret, s.jwtExpiry*24)
+	claims.TokenType = interfaces.TokenTypeRefresh
+	refreshToken, err := utils.GenerateJWT(&claims, s.jwtSecret, s.refreshExpiry())
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+		return nil, "", fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
 	return &interfaces.TokenPair{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		ExpiresIn:    int64(s.jwtExpiry.Seconds()),
-	}, nil
+	}, hashToken(refreshToken), nil
 }
 
-func (s *authService) ValidateToken(token string) (*interfaces.Claims, error) {
-	return validateJWT(token, s.jwtSecret)
+func (s *authService) refreshExpiry() time.Duration {
+	return s.jwtExpiry * 24
 }
 
-func (s *authService) RefreshToken(refreshToken string) (*interfaces.TokenPair, error) {
-	// Validate refresh token
-	claims, err := validateJWT(refreshToken, s.jwtSecret)
-	if err != nil {
-		return nil, fmt.Errorf("invalid refresh token: %w", err)
+// revokeReused handles a refresh token presented after it was rotated. The
+// session is revoked because the token may have been stolen.
+func (s *authService) revokeReused(ctx context.Context, session *models.Session) error {
+	if err := s.revoke(ctx, session); err != nil {
+		return fmt.Errorf("%w, revoking the session failed: %v", interfaces.ErrRefreshTokenReused, err)
 	}
+	return interfaces.ErrRefreshTokenReused
+}
 
-	// Generate new token pair
-	return s.GenerateTokenPair(uuid.MustParse(claims.UserID))
+// revoke deactivates the session and denies its outstanding access tokens
+func (s *authService) revoke(ctx context.Context, session *models.Session) error {
+	if err := s.sessionRepo.Revoke(ctx, session.ID); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+	return s.denySession(ctx, session.SessionToken)
 }
 
-func (s *authService) InvalidateUserSessions(ctx context.Context, userID uuid.UUID) error {
-	return s.sessionRepo.InvalidateUserSessions(ctx, userID)
+func (s *authService) denySession(ctx context.Context, sessionToken string) error {
+	if err := s.redis.Set(ctx, revokedSessionPrefix+sessionToken, true, s.jwtExpiry); err != nil {
+		return fmt.Errorf("failed to deny session tokens: %w", err)
+	}
+	return nil
 }
 
-func (s *authService) hashPassword(password string) (string, error) {
-	// Generate salt
-	salt := make([]byte, 32)
-	if _, err := rand.Read(salt); err != nil {
-		return "", err
+// sessionRevoked consults the deny list, falling back to the session itself
+// while Redis is unavailable
+func (s *authService) sessionRevoked(ctx context.Context, sessionToken string) (bool, error) {
+	n, err := s.redis.Exists(ctx, revokedSessionPrefix+sessionToken)
+	if err == nil {
+		return n > 0, nil
 	}
 
-	// Hash password with Argon2
-	hash := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+	session, dbErr := s.sessionRepo.GetByToken(ctx, sessionToken)
+	if dbErr != nil {
+		return false, fmt.Errorf("failed to check session: %w", dbErr)
+	}
+	return !session.IsActive, nil
+}
 
-	// Combine salt and hash
-	hashedPassword := make([]byte, 0, len(salt)+len(hash))
-	hashedPassword = append(hashedPassword, salt...)
-	hashedPassword = append(hashedPassword, hash...)
+// randomToken returns 32 random bytes, hex encoded
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
 
-	return fmt.Sprintf("%x", hashedPassword), nil
+// hashToken returns the SHA-256 of a token as stored in the database
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
 
-func (s *authService) verifyPassword(password, hashedPassword string) bool {
-	// Decode hex string
-	hashBytes := make([]byte, len(hashedPassword)/2)
-	if _, err := fmt.Sscanf(hashedPassword, "%x", &hashBytes); err != nil {
-		return false
+// AuthenticateHysteria validates a per-device Hysteria2 credential of the form
+// "<device_id>:<auth_secret>" and checks that the owning account may connect
+// under the same quota rules as enforcement. The account is returned along
+// with the device.
+func (s *authService) AuthenticateHysteria(ctx context.Context, auth string) (*models.Device, *models.User, error) {
+	deviceID, secret, ok := strings.Cut(auth, ":")
+	if !ok || deviceID == "" || secret == "" {
+		return nil, nil, fmt.Errorf("malformed credential")
+	}
+
+	// Share links and client configs identify the device by its UUID, the
+	// Hysteria2 client ID. Older credentials used the client-chosen device_id.
+	var device *models.Device
+	var err error
+	if id, parseErr := uuid.Parse(deviceID); parseErr == nil {
+		device, err = s.deviceRepo.GetByID(ctx, id)
+	} else {
+		device, err = s.deviceRepo.GetByDeviceID(ctx, deviceID)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid credentials")
 	}
 
-	if len(hashBytes) < 32 {
-		return false
+	// Compare secrets using constant time
+	if device.AuthSecret == "" || subtle.ConstantTimeCompare([]byte(device.AuthSecret), []byte(secret)) != 1 {
+		return nil, nil, fmt.Errorf("invalid credentials")
 	}
 
-	// Extract salt and hash
-	salt := hashBytes[:32]
-	storedHash := hashBytes[32:]
+	if device.Status != "active" {
+		return nil, nil, fmt.Errorf("device is not active")
+	}
 
-	// Hash input password with same parameters
-	computedHash := argon2.IDKey([]byte(password), salt, 1, 64*1024, 4, 32)
+	user, err := s.userRepo.GetByID(ctx, device.UserID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("user not found")
+	}
+
+	if user.Status != "active" {
+		return nil, nil, fmt.Errorf("account is not active")
+	}
+
+	// The limits of an active plan replace the user's own, as in enforcement
+	subscription, err := s.subscriptionRepo.GetActiveByUserID(ctx, user.ID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if subscription != nil && subscription.Plan == nil {
+		subscription = nil
+	}
+
+	switch quotaViolation(user, subscription, time.Now()) {
+	case ViolationQuotaExceeded:
+		return nil, nil, fmt.Errorf("data limit exceeded")
+	case ViolationExpired:
+		return nil, nil, fmt.Errorf("account expired")
+	}
 
-	// Compare hashes using constant time
-	return subtle.ConstantTimeCompare(storedHash, computedHash) == 1
+	return device, user, nil
 }
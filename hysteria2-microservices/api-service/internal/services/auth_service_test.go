@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/cache"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// fakeUserRepo keeps users in memory. Methods the tests do not need panic
+// through the embedded nil interface.
+type fakeUserRepo struct {
+	repoInterfaces.UserRepository
+
+	mu    sync.Mutex
+	users map[uuid.UUID]*models.User
+}
+
+func newFakeUserRepo(users ...*models.User) *fakeUserRepo {
+	r := &fakeUserRepo{users: make(map[uuid.UUID]*models.User)}
+	for _, user := range users {
+		r.users[user.ID] = user
+	}
+	return r
+}
+
+func (r *fakeUserRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok {
+		return nil, errors.New("record not found")
+	}
+	copied := *user
+	return &copied, nil
+}
+
+func (r *fakeUserRepo) RecordTOTPStep(ctx context.Context, id uuid.UUID, step int64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[id]
+	if !ok || user.TOTPLastStep >= step {
+		return false, nil
+	}
+	user.TOTPLastStep = step
+	return true, nil
+}
+
+// fakeSessionRepo keeps sessions in memory with the conditional rotation of
+// the real repository
+type fakeSessionRepo struct {
+	repoInterfaces.SessionRepository
+
+	mu       sync.Mutex
+	sessions map[uuid.UUID]*models.Session
+	// beforeRotate, if set, runs at the start of RotateRefreshToken
+	beforeRotate func()
+}
+
+func newFakeSessionRepo(sessions ...*models.Session) *fakeSessionRepo {
+	r := &fakeSessionRepo{sessions: make(map[uuid.UUID]*models.Session)}
+	for _, session := range sessions {
+		r.sessions[session.ID] = session
+	}
+	return r
+}
+
+func (r *fakeSessionRepo) GetByToken(ctx context.Context, token string) (*models.Session, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, session := range r.sessions {
+		if session.SessionToken == token {
+			copied := *session
+			return &copied, nil
+		}
+	}
+	return nil, errors.New("record not found")
+}
+
+func (r *fakeSessionRepo) RotateRefreshToken(ctx context.Context, id uuid.UUID, oldHash, newHash string, expiresAt time.Time) (bool, error) {
+	if r.beforeRotate != nil {
+		r.beforeRotate()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	session, ok := r.sessions[id]
+	if !ok || !session.IsActive || session.RefreshToken == nil || *session.RefreshToken != oldHash {
+		return false, nil
+	}
+	session.RefreshToken = &newHash
+	session.ExpiresAt = expiresAt
+	return true, nil
+}
+
+func (r *fakeSessionRepo) Revoke(ctx context.Context, id uuid.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if session, ok := r.sessions[id]; ok {
+		session.IsActive = false
+	}
+	return nil
+}
+
+func (r *fakeSessionRepo) isActive(id uuid.UUID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.sessions[id].IsActive
+}
+
+// fakeDeviceRepo looks devices up by ID
+type fakeDeviceRepo struct {
+	repoInterfaces.DeviceRepository
+
+	devices map[uuid.UUID]*models.Device
+}
+
+func (r *fakeDeviceRepo) GetByID(ctx context.Context, id uuid.UUID) (*models.Device, error) {
+	device, ok := r.devices[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return device, nil
+}
+
+// fakeSubscriptionRepo returns a fixed active subscription, or none
+type fakeSubscriptionRepo struct {
+	repoInterfaces.SubscriptionRepository
+
+	active *models.Subscription
+}
+
+func (r *fakeSubscriptionRepo) GetActiveByUserID(ctx context.Context, userID uuid.UUID) (*models.Subscription, error) {
+	if r.active == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return r.active, nil
+}
+
+// newTestRedis returns a client for an in-memory Redis
+func newTestRedis(t *testing.T) (*cache.RedisClient, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	redis := cache.NewRedisClient("redis://" + mr.Addr())
+	t.Cleanup(func() { redis.Close() })
+	return redis, mr
+}
+
+type refreshFixture struct {
+	auth     *authService
+	sessions *fakeSessionRepo
+	session  *models.Session
+	redis    *miniredis.Miniredis
+	tokens   *interfaces.TokenPair
+}
+
+// newRefreshFixture signs a user in with a session holding the refresh
+// token it returns
+func newRefreshFixture(t *testing.T) *refreshFixture {
+	t.Helper()
+	user := &models.User{ID: uuid.New(), Username: "alice", Role: "user", Status: "active"}
+	redis, mr := newTestRedis(t)
+	auth := &authService{
+		redis:     redis,
+		jwtSecret: "test-secret",
+		jwtExpiry: time.Hour,
+	}
+
+	session := &models.Session{
+		ID:           uuid.New(),
+		UserID:       user.ID,
+		SessionToken: "session-token",
+		ExpiresAt:    time.Now().Add(auth.refreshExpiry()),
+		IsActive:     true,
+	}
+	tokens, refreshHash, err := auth.issueTokens(user, session.SessionToken)
+	if err != nil {
+		t.Fatalf("issueTokens: %v", err)
+	}
+	session.RefreshToken = &refreshHash
+
+	sessions := newFakeSessionRepo(session)
+	auth.userRepo = newFakeUserRepo(user)
+	auth.sessionRepo = sessions
+	return &refreshFixture{auth: auth, sessions: sessions, session: session, redis: mr, tokens: tokens}
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		// presented returns the refresh tokens to present in order, given
+		// the pair of the login and the one of the first refresh
+		presented func(login, first *interfaces.TokenPair) []string
+		wantErr   error
+	}{
+		{
+			name: "rotated token is accepted once",
+			presented: func(login, first *interfaces.TokenPair) []string {
+				return []string{first.RefreshToken}
+			},
+		},
+		{
+			name: "reusing the rotated token",
+			presented: func(login, first *interfaces.TokenPair) []string {
+				return []string{login.RefreshToken}
+			},
+			wantErr: interfaces.ErrRefreshTokenReused,
+		},
+		{
+			name: "reusing the current token after its rotation",
+			presented: func(login, first *interfaces.TokenPair) []string {
+				return []string{first.RefreshToken, first.RefreshToken}
+			},
+			wantErr: interfaces.ErrRefreshTokenReused,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newRefreshFixture(t)
+
+			first, err := f.auth.RefreshToken(ctx, f.tokens.RefreshToken)
+			if err != nil {
+				t.Fatalf("first refresh: %v", err)
+			}
+			if first.RefreshToken == f.tokens.RefreshToken {
+				t.Fatal("refresh returned the presented token")
+			}
+
+			var lastErr error
+			for _, token := range tt.presented(f.tokens, first) {
+				_, lastErr = f.auth.RefreshToken(ctx, token)
+			}
+			if !errors.Is(lastErr, tt.wantErr) {
+				t.Fatalf("refresh error = %v, want %v", lastErr, tt.wantErr)
+			}
+
+			revoked := tt.wantErr != nil
+			if active := f.sessions.isActive(f.session.ID); active == revoked {
+				t.Errorf("session active = %v, want %v", active, !revoked)
+			}
+			if denied := f.redis.Exists(revokedSessionPrefix + f.session.SessionToken); denied != revoked {
+				t.Errorf("session denied = %v, want %v", denied, revoked)
+			}
+		})
+	}
+}
+
+func TestRefreshTokenAfterReuseRevocation(t *testing.T) {
+	ctx := context.Background()
+	f := newRefreshFixture(t)
+
+	first, err := f.auth.RefreshToken(ctx, f.tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("first refresh: %v", err)
+	}
+	if _, err := f.auth.RefreshToken(ctx, f.tokens.RefreshToken); !errors.Is(err, interfaces.ErrRefreshTokenReused) {
+		t.Fatalf("reuse error = %v, want %v", err, interfaces.ErrRefreshTokenReused)
+	}
+
+	// The legitimate holder of the latest token is signed out as well
+	if _, err := f.auth.RefreshToken(ctx, first.RefreshToken); err == nil {
+		t.Error("refresh with the latest token succeeded after the session was revoked")
+	}
+	if _, err := f.auth.ValidateToken(ctx, first.AccessToken); err == nil {
+		t.Error("access token still valid after the session was revoked")
+	}
+}
+
+func TestRefreshTokenConcurrentRotation(t *testing.T) {
+	ctx := context.Background()
+	f := newRefreshFixture(t)
+
+	// Another refresh with the same token rotates it between the read of
+	// the session and the conditional update
+	f.sessions.beforeRotate = func() {
+		f.sessions.beforeRotate = nil
+		f.sessions.RotateRefreshToken(ctx, f.session.ID, *f.session.RefreshToken, "concurrent", f.session.ExpiresAt)
+	}
+
+	if _, err := f.auth.RefreshToken(ctx, f.tokens.RefreshToken); !errors.Is(err, interfaces.ErrRefreshTokenReused) {
+		t.Fatalf("refresh error = %v, want %v", err, interfaces.ErrRefreshTokenReused)
+	}
+	if f.sessions.isActive(f.session.ID) {
+		t.Error("session still active after concurrent reuse")
+	}
+}
+
+func TestRefreshTokenRejectsAccessToken(t *testing.T) {
+	f := newRefreshFixture(t)
+	if _, err := f.auth.RefreshToken(context.Background(), f.tokens.AccessToken); err == nil {
+		t.Fatal("refresh with an access token succeeded")
+	}
+	if !f.sessions.isActive(f.session.ID) {
+		t.Error("session revoked for a token of the wrong type")
+	}
+}
+
+func TestAuthenticateHysteriaLimits(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	plan := func(dataLimit int64, endsAt *time.Time) *models.Subscription {
+		return &models.Subscription{
+			Status: models.SubscriptionActive,
+			EndsAt: endsAt,
+			Plan:   &models.Plan{DataLimit: dataLimit},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		user         models.User
+		subscription *models.Subscription
+		wantErr      bool
+	}{
+		{"within user limit", models.User{DataUsed: 99, DataLimit: 100}, nil, false},
+		{"user limit exceeded", models.User{DataUsed: 100, DataLimit: 100}, nil, true},
+		{"user expired", models.User{ExpiryDate: &past}, nil, true},
+		{"plan limit replaces user limit", models.User{DataUsed: 150, DataLimit: 100}, plan(200, nil), false},
+		{"plan limit exceeded", models.User{DataUsed: 200, DataLimit: 1000}, plan(200, nil), true},
+		{"plan ended", models.User{}, plan(0, &past), true},
+		{"plan end replaces user expiry", models.User{ExpiryDate: &past}, plan(0, &future), false},
+		{"suspended", models.User{Status: "suspended"}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := tt.user
+			user.ID = uuid.New()
+			if user.Status == "" {
+				user.Status = "active"
+			}
+			device := &models.Device{ID: uuid.New(), UserID: user.ID, AuthSecret: "secret", Status: "active"}
+			auth := &authService{
+				userRepo:         newFakeUserRepo(&user),
+				deviceRepo:       &fakeDeviceRepo{devices: map[uuid.UUID]*models.Device{device.ID: device}},
+				subscriptionRepo: &fakeSubscriptionRepo{active: tt.subscription},
+			}
+
+			_, _, err := auth.AuthenticateHysteria(context.Background(), device.ID.String()+":secret")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AuthenticateHysteria error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
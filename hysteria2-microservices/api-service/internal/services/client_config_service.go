@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/orchestrator"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+// Local proxies the generated client configs expose
+const (
+	clientSocks5Listen = "127.0.0.1:1080"
+	clientHTTPListen   = "127.0.0.1:8080"
+)
+
+type clientConfigService struct {
+	configRepo       repoInterfaces.HysteriaConfigRepository
+	deviceRepo       repoInterfaces.DeviceRepository
+	nodeRepo         repoInterfaces.NodeRepository
+	subscriptionRepo repoInterfaces.SubscriptionRepository
+	orchestrator     *orchestrator.Client
+	logger           *logger.Logger
+}
+
+func NewClientConfigService(configRepo repoInterfaces.HysteriaConfigRepository, deviceRepo repoInterfaces.DeviceRepository, nodeRepo repoInterfaces.NodeRepository, subscriptionRepo repoInterfaces.SubscriptionRepository, orchestratorClient *orchestrator.Client, logger *logger.Logger) serviceInterfaces.ClientConfigService {
+	return &clientConfigService{
+		configRepo:       configRepo,
+		deviceRepo:       deviceRepo,
+		nodeRepo:         nodeRepo,
+		subscriptionRepo: subscriptionRepo,
+		orchestrator:     orchestratorClient,
+		logger:           logger,
+	}
+}
+
+func (s *clientConfigService) ListConfigs(ctx context.Context, userID, deviceID uuid.UUID) ([]*models.HysteriaConfig, error) {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return s.configRepo.GetByDeviceID(ctx, device.ID)
+}
+
+func (s *clientConfigService) GetConfig(ctx context.Context, userID, deviceID, configID uuid.UUID) (*models.HysteriaConfig, error) {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return s.config(ctx, device, configID)
+}
+
+// GenerateConfig renders a client config for one of the user's nodes from
+// the node's current parameters. The device's first config becomes active.
+func (s *clientConfigService) GenerateConfig(ctx context.Context, userID, deviceID uuid.UUID, req *serviceInterfaces.GenerateConfigRequest) (*models.HysteriaConfig, error) {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if device.Status != "active" || device.AuthSecret == "" {
+		return nil, fmt.Errorf("%w: device is %s", serviceInterfaces.ErrInvalidClientConfig, device.Status)
+	}
+
+	nodes, err := userNodes(ctx, s.nodeRepo, s.subscriptionRepo, userID)
+	if err != nil {
+		return nil, err
+	}
+	var node *models.VPSNode
+	for _, n := range nodes {
+		if n.ID == req.NodeID {
+			node = n
+			break
+		}
+	}
+	if node == nil {
+		return nil, serviceInterfaces.ErrNodeNotAssigned
+	}
+
+	existing, err := s.configRepo.GetByDeviceID(ctx, device.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device configs: %w", err)
+	}
+
+	name := req.ConfigName
+	if name == "" {
+		name = node.Name
+	}
+
+	endpoint := loadNodeEndpoint(ctx, s.nodeRepo, node, s.logger)
+	config := &models.HysteriaConfig{
+		UserID:     userID,
+		DeviceID:   &device.ID,
+		NodeID:     &node.ID,
+		ConfigName: name,
+		ConfigData: endpoint.clientConfig(hysteriaAuth(device)),
+		IsActive:   false,
+	}
+	if err := s.configRepo.Create(ctx, config); err != nil {
+		return nil, fmt.Errorf("failed to create config: %w", err)
+	}
+
+	if req.Activate || len(existing) == 0 {
+		return s.activate(ctx, config)
+	}
+	return config, nil
+}
+
+// UpdateConfig renames a config or replaces its data. Replaced data must
+// still name a server and carry an auth credential.
+func (s *clientConfigService) UpdateConfig(ctx context.Context, userID, deviceID, configID uuid.UUID, name *string, data map[string]interface{}) (*models.HysteriaConfig, error) {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.config(ctx, device, configID)
+	if err != nil {
+		return nil, err
+	}
+
+	if name != nil {
+		if *name == "" {
+			return nil, fmt.Errorf("%w: config name is required", serviceInterfaces.ErrInvalidClientConfig)
+		}
+		config.ConfigName = *name
+	}
+	if data != nil {
+		if server, _ := data["server"].(string); server == "" {
+			return nil, fmt.Errorf("%w: server is required", serviceInterfaces.ErrInvalidClientConfig)
+		}
+		if auth, _ := data["auth"].(string); auth == "" {
+			return nil, fmt.Errorf("%w: auth is required", serviceInterfaces.ErrInvalidClientConfig)
+		}
+		config.ConfigData = data
+	}
+
+	if err := s.configRepo.Update(ctx, config); err != nil {
+		return nil, fmt.Errorf("failed to update config: %w", err)
+	}
+	return config, nil
+}
+
+func (s *clientConfigService) DeleteConfig(ctx context.Context, userID, deviceID, configID uuid.UUID) error {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return err
+	}
+	config, err := s.config(ctx, device, configID)
+	if err != nil {
+		return err
+	}
+	return s.configRepo.Delete(ctx, config.ID)
+}
+
+// ActivateConfig makes the config the device's only active one
+func (s *clientConfigService) ActivateConfig(ctx context.Context, userID, deviceID, configID uuid.UUID) (*models.HysteriaConfig, error) {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	config, err := s.config(ctx, device, configID)
+	if err != nil {
+		return nil, err
+	}
+	return s.activate(ctx, config)
+}
+
+// RegenerateCredentials issues the device a new Hysteria2 secret and rewrites
+// its configs. Nodes are told to drop sessions opened with the old secret.
+func (s *clientConfigService) RegenerateCredentials(ctx context.Context, userID, deviceID uuid.UUID) ([]*models.HysteriaConfig, error) {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+	device.AuthSecret = hex.EncodeToString(buf)
+	if err := s.deviceRepo.UpdateAuthSecret(ctx, device.ID, device.AuthSecret); err != nil {
+		return nil, fmt.Errorf("failed to store secret: %w", err)
+	}
+
+	configs, err := s.configRepo.GetByDeviceID(ctx, device.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device configs: %w", err)
+	}
+	for _, config := range configs {
+		if config.ConfigData == nil {
+			config.ConfigData = make(map[string]interface{})
+		}
+		config.ConfigData["auth"] = hysteriaAuth(device)
+		if err := s.configRepo.Update(ctx, config); err != nil {
+			return nil, fmt.Errorf("failed to update config %s: %w", config.ID, err)
+		}
+	}
+
+	// The new secret is already stored, nodes that miss the push pick it up
+	// on their next resync
+	result, err := s.orchestrator.SyncUser(ctx, userID.String(), []string{device.ID.String()})
+	if err != nil {
+		s.logger.Error("Failed to push rotated credentials to nodes", "error", err, "user_id", userID, "device_id", device.ID)
+	} else if len(result.Errors) > 0 {
+		s.logger.Warn("Rotated credentials not pushed to every node", "user_id", userID, "device_id", device.ID, "failed", len(result.Errors), "nodes", result.NodesTotal)
+	}
+
+	return configs, nil
+}
+
+// RenderConfig encodes the config data for the official Hysteria2 client
+func (s *clientConfigService) RenderConfig(config *models.HysteriaConfig, format string) ([]byte, string, error) {
+	switch format {
+	case "", serviceInterfaces.ConfigFormatYAML:
+		data, err := yaml.Marshal(config.ConfigData)
+		return data, "application/yaml", err
+	case serviceInterfaces.ConfigFormatJSON:
+		data, err := json.MarshalIndent(config.ConfigData, "", "  ")
+		return data, "application/json", err
+	default:
+		return nil, "", serviceInterfaces.ErrUnsupportedFormat
+	}
+}
+
+func (s *clientConfigService) activate(ctx context.Context, config *models.HysteriaConfig) (*models.HysteriaConfig, error) {
+	if err := s.configRepo.SetActive(ctx, config.UserID, config.DeviceID, false); err != nil {
+		return nil, fmt.Errorf("failed to deactivate configs: %w", err)
+	}
+	config.IsActive = true
+	if err := s.configRepo.Update(ctx, config); err != nil {
+		return nil, fmt.Errorf("failed to activate config: %w", err)
+	}
+	return config, nil
+}
+
+// config loads a config and checks that it belongs to the device
+func (s *clientConfigService) config(ctx context.Context, device *models.Device, configID uuid.UUID) (*models.HysteriaConfig, error) {
+	config, err := s.configRepo.GetByID(ctx, configID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, serviceInterfaces.ErrConfigNotFound
+		}
+		return nil, err
+	}
+	if config.DeviceID == nil || *config.DeviceID != device.ID {
+		return nil, serviceInterfaces.ErrConfigNotFound
+	}
+	return config, nil
+}
+
+// clientConfig renders an official Hysteria2 client config for the node.
+// Port hopping uses the client's "host:range" server syntax.
+func (e *nodeEndpoint) clientConfig(auth string) map[string]interface{} {
+	port := strconv.Itoa(e.port)
+	if e.hopPorts != "" {
+		port = e.hopPorts
+	}
+
+	config := map[string]interface{}{
+		"server": net.JoinHostPort(e.server, port),
+		"auth":   auth,
+		"socks5": map[string]interface{}{"listen": clientSocks5Listen},
+		"http":   map[string]interface{}{"listen": clientHTTPListen},
+	}
+
+	tls := make(map[string]interface{})
+	if e.sni != "" {
+		tls["sni"] = e.sni
+	}
+	if e.insecure {
+		tls["insecure"] = true
+	}
+	if e.pinSHA256 != "" {
+		tls["pinSHA256"] = e.pinSHA256
+	}
+	if len(tls) > 0 {
+		config["tls"] = tls
+	}
+
+	if e.obfs != "" {
+		config["obfs"] = map[string]interface{}{
+			"type": e.obfs,
+			e.obfs: map[string]interface{}{"password": e.obfsPassword},
+		}
+	}
+
+	return config
+}
@@ -0,0 +1,210 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/cache"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/orchestrator"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type deviceService struct {
+	deviceRepo        repoInterfaces.DeviceRepository
+	userRepo          repoInterfaces.UserRepository
+	orchestrator      *orchestrator.Client
+	webSocketService  serviceInterfaces.WebSocketService
+	redis             *cache.RedisClient
+	logger            *logger.Logger
+	maxDevicesPerUser int
+}
+
+func NewDeviceService(deviceRepo repoInterfaces.DeviceRepository, userRepo repoInterfaces.UserRepository, orchestratorClient *orchestrator.Client, webSocketService serviceInterfaces.WebSocketService, redis *cache.RedisClient, logger *logger.Logger, maxDevicesPerUser int) serviceInterfaces.DeviceService {
+	return &deviceService{
+		deviceRepo:        deviceRepo,
+		userRepo:          userRepo,
+		orchestrator:      orchestratorClient,
+		webSocketService:  webSocketService,
+		redis:             redis,
+		logger:            logger,
+		maxDevicesPerUser: maxDevicesPerUser,
+	}
+}
+
+// CreateDevice registers a device within the user's device limit and pushes
+// its credential to the nodes
+func (s *deviceService) CreateDevice(ctx context.Context, userID uuid.UUID, req *serviceInterfaces.CreateDeviceRequest) (*serviceInterfaces.DeviceEnrollment, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	limit := user.DeviceLimit
+	if limit == 0 {
+		limit = s.maxDevicesPerUser
+	}
+	if limit > 0 {
+		count, err := s.deviceRepo.CountByUserID(ctx, userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count devices: %w", err)
+		}
+		if count >= int64(limit) {
+			return nil, fmt.Errorf("%w: %d of %d", serviceInterfaces.ErrDeviceLimitReached, count, limit)
+		}
+	}
+
+	clientDeviceID := req.DeviceID
+	if clientDeviceID == "" {
+		clientDeviceID = uuid.NewString()
+	}
+	if _, err := s.deviceRepo.GetByDeviceID(ctx, clientDeviceID); err == nil {
+		return nil, serviceInterfaces.ErrDeviceExists
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to check device: %w", err)
+	}
+
+	device := &models.Device{
+		UserID:    userID,
+		Name:      req.Name,
+		DeviceID:  clientDeviceID,
+		PublicKey: req.PublicKey,
+		Status:    "active",
+	}
+	if err := s.deviceRepo.Create(ctx, device); err != nil {
+		return nil, fmt.Errorf("failed to create device: %w", err)
+	}
+	s.invalidateDevices(ctx, userID)
+
+	// Nodes that authenticate locally only accept the device once they have
+	// its secret, the rest go through the HTTP auth backend
+	s.syncNodes(ctx, device, nil)
+
+	return &serviceInterfaces.DeviceEnrollment{
+		Device:     device,
+		Credential: hysteriaAuth(device),
+	}, nil
+}
+
+func (s *deviceService) RenameDevice(ctx context.Context, userID, deviceID uuid.UUID, name string) (*models.Device, error) {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.deviceRepo.UpdateName(ctx, device.ID, name); err != nil {
+		return nil, fmt.Errorf("failed to rename device: %w", err)
+	}
+	device.Name = name
+	s.invalidateDevices(ctx, userID)
+	return device, nil
+}
+
+// BlockDevice revokes the device's credential on every node and disconnects
+// its live sessions
+func (s *deviceService) BlockDevice(ctx context.Context, userID, deviceID uuid.UUID) (*models.Device, *serviceInterfaces.NodeSyncResult, error) {
+	device, err := s.setStatus(ctx, userID, deviceID, "blocked")
+	if err != nil {
+		return nil, nil, err
+	}
+	result := s.syncNodes(ctx, device, []string{device.ID.String()})
+	go s.webSocketService.BroadcastDeviceStatus(device.ID, userID, false)
+	return device, result, nil
+}
+
+// UnblockDevice restores the device and pushes its credential back to the nodes
+func (s *deviceService) UnblockDevice(ctx context.Context, userID, deviceID uuid.UUID) (*models.Device, *serviceInterfaces.NodeSyncResult, error) {
+	device, err := s.setStatus(ctx, userID, deviceID, "active")
+	if err != nil {
+		return nil, nil, err
+	}
+	return device, s.syncNodes(ctx, device, nil), nil
+}
+
+// DeleteDevice removes the device and its configs and disconnects it
+func (s *deviceService) DeleteDevice(ctx context.Context, userID, deviceID uuid.UUID) (*serviceInterfaces.NodeSyncResult, error) {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.deviceRepo.Delete(ctx, device.ID); err != nil {
+		return nil, fmt.Errorf("failed to delete device: %w", err)
+	}
+	s.invalidateDevices(ctx, userID)
+
+	result := s.syncNodes(ctx, device, []string{device.ID.String()})
+	go s.webSocketService.BroadcastDeviceStatus(device.ID, userID, false)
+	return result, nil
+}
+
+// RecordConnection stores the address a device connected from, as reported
+// by the auth backend or by the nodes
+func (s *deviceService) RecordConnection(ctx context.Context, deviceID uuid.UUID, addr string, at time.Time) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = strings.Trim(addr, "[]")
+	}
+	if err := s.deviceRepo.RecordConnection(ctx, deviceID, host, at); err != nil {
+		return fmt.Errorf("failed to record connection: %w", err)
+	}
+	return nil
+}
+
+func (s *deviceService) setStatus(ctx context.Context, userID, deviceID uuid.UUID, status string) (*models.Device, error) {
+	device, err := userDevice(ctx, s.deviceRepo, userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.deviceRepo.UpdateStatus(ctx, device.ID, status); err != nil {
+		return nil, fmt.Errorf("failed to update device status: %w", err)
+	}
+	device.Status = status
+	s.invalidateDevices(ctx, userID)
+	return device, nil
+}
+
+// syncNodes pushes the user's devices to the nodes, kicking the listed
+// clients. The change is already stored, so nodes that miss the push pick it
+// up on their next resync and a failure is only reported.
+func (s *deviceService) syncNodes(ctx context.Context, device *models.Device, kickClientIDs []string) *serviceInterfaces.NodeSyncResult {
+	resp, err := s.orchestrator.SyncUser(ctx, device.UserID.String(), kickClientIDs)
+	if err != nil {
+		s.logger.Error("Failed to push device change to nodes", "error", err, "user_id", device.UserID, "device_id", device.ID)
+		return &serviceInterfaces.NodeSyncResult{Errors: []string{err.Error()}}
+	}
+	if len(resp.Errors) > 0 {
+		s.logger.Warn("Device change not pushed to every node", "user_id", device.UserID, "device_id", device.ID, "failed", len(resp.Errors), "nodes", resp.NodesTotal)
+	}
+	return &serviceInterfaces.NodeSyncResult{
+		NodesTotal:   resp.NodesTotal,
+		NodesUpdated: resp.NodesKicked,
+		Errors:       resp.Errors,
+	}
+}
+
+func (s *deviceService) invalidateDevices(ctx context.Context, userID uuid.UUID) {
+	s.redis.Del(ctx, fmt.Sprintf("user_devices:%s", userID.String()))
+}
+
+// userDevice loads a device and checks that it belongs to the user
+func userDevice(ctx context.Context, deviceRepo repoInterfaces.DeviceRepository, userID, deviceID uuid.UUID) (*models.Device, error) {
+	device, err := deviceRepo.GetByID(ctx, deviceID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, serviceInterfaces.ErrDeviceNotFound
+		}
+		return nil, err
+	}
+	if device.UserID != userID {
+		return nil, serviceInterfaces.ErrDeviceNotFound
+	}
+	return device, nil
+}
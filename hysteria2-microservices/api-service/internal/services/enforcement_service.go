@@ -0,0 +1,254 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/cache"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/orchestrator"
+
+	"github.com/google/uuid"
+)
+
+const (
+	ViolationQuotaExceeded = "quota_exceeded"
+	ViolationExpired       = "expired"
+)
+
+type enforcementService struct {
+	userRepo         repoInterfaces.UserRepository
+	authService      serviceInterfaces.AuthService
+	planService      serviceInterfaces.PlanService
+	orchestrator     *orchestrator.Client
+	webSocketService serviceInterfaces.WebSocketService
+	redis            *cache.RedisClient
+	logger           *logger.Logger
+	interval         time.Duration
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+func NewEnforcementService(userRepo repoInterfaces.UserRepository, authService serviceInterfaces.AuthService, planService serviceInterfaces.PlanService, orchestratorClient *orchestrator.Client, wsService serviceInterfaces.WebSocketService, redis *cache.RedisClient, logger *logger.Logger, interval time.Duration) serviceInterfaces.EnforcementService {
+	if interval <= 0 {
+		interval = time.Minute
+	}
+
+	return &enforcementService{
+		userRepo:         userRepo,
+		authService:      authService,
+		planService:      planService,
+		orchestrator:     orchestratorClient,
+		webSocketService: wsService,
+		redis:            redis,
+		logger:           logger,
+		interval:         interval,
+		stopChan:         make(chan struct{}),
+	}
+}
+
+// Start runs the periodic sweep that rolls subscriptions over to their next
+// quota period and catches users whose plan expired without any traffic
+// being reported for them
+func (s *enforcementService) Start() {
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		s.sweep()
+		for {
+			select {
+			case <-ticker.C:
+				s.sweep()
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+
+	s.logger.Info("Quota enforcement started", "interval", s.interval)
+}
+
+func (s *enforcementService) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+	})
+}
+
+func (s *enforcementService) CheckUser(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if user.Status != "active" {
+		return nil
+	}
+
+	reason := quotaViolation(user, s.activeSubscription(ctx, user.ID), time.Now())
+	if reason == "" {
+		return nil
+	}
+
+	return s.suspend(ctx, user, reason)
+}
+
+func (s *enforcementService) sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.interval)
+	defer cancel()
+
+	// Quota resets come first so users whose new period started are not
+	// suspended for the usage of the last one
+	if err := s.planService.ProcessDue(ctx, time.Now()); err != nil {
+		s.logger.Error("Failed to process due subscriptions", "error", err)
+	}
+
+	users, err := s.userRepo.ListQuotaViolations(ctx, time.Now())
+	if err != nil {
+		s.logger.Error("Failed to list quota violations", "error", err)
+		return
+	}
+
+	for _, user := range users {
+		reason := quotaViolation(user, s.activeSubscription(ctx, user.ID), time.Now())
+		if reason == "" {
+			continue
+		}
+		if err := s.suspend(ctx, user, reason); err != nil {
+			s.logger.Error("Failed to enforce quota", "user_id", user.ID, "reason", reason, "error", err)
+		}
+	}
+}
+
+// SetStatus applies an admin's status change with the same side effects as
+// enforcement: the user's sessions are revoked, the change is broadcast, and
+// a user leaving active is dropped from every node while a reactivated one
+// is pushed back to them. The change only applies if the status did not
+// change concurrently.
+func (s *enforcementService) SetStatus(ctx context.Context, userID uuid.UUID, status string) (*models.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Status == status {
+		return user, nil
+	}
+
+	from := user.Status
+	changed, err := s.userRepo.TransitionStatus(ctx, user.ID, from, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to change user status: %w", err)
+	}
+	if !changed {
+		return nil, serviceInterfaces.ErrUserStatusChanged
+	}
+	user.Status = status
+
+	s.logger.Info("User status changed", "user_id", user.ID, "from", from, "to", status)
+
+	return user, s.applyStatus(ctx, user)
+}
+
+// suspend moves the user to suspended, revokes their sessions and drops their
+// live Hysteria2 connections. The status change happens first so the auth hook
+// rejects reconnect attempts before the kick goes out.
+func (s *enforcementService) suspend(ctx context.Context, user *models.User, reason string) error {
+	changed, err := s.userRepo.TransitionStatus(ctx, user.ID, "active", "suspended")
+	if err != nil {
+		return fmt.Errorf("failed to suspend user: %w", err)
+	}
+	if !changed {
+		// Already handled by a concurrent check or changed by an admin
+		return nil
+	}
+	user.Status = "suspended"
+
+	s.logger.Info("User suspended", "user_id", user.ID, "reason", reason, "data_used", user.DataUsed, "data_limit", user.DataLimit)
+
+	if err := s.planService.RecordSuspension(ctx, user.ID, reason); err != nil {
+		s.logger.Error("Failed to record suspension on subscription", "user_id", user.ID, "error", err)
+	}
+
+	return s.applyStatus(ctx, user)
+}
+
+// applyStatus propagates a stored status change of the user
+func (s *enforcementService) applyStatus(ctx context.Context, user *models.User) error {
+	s.redis.Del(ctx, fmt.Sprintf("user:%s", user.ID.String()))
+
+	if err := s.authService.InvalidateUserSessions(ctx, user.ID); err != nil {
+		s.logger.Error("Failed to invalidate sessions", "user_id", user.ID, "error", err)
+	}
+
+	if s.webSocketService != nil {
+		go s.webSocketService.BroadcastUserStatus(user.ID, user.Status)
+	}
+
+	if user.Status == "active" {
+		syncUserNodes(ctx, s.orchestrator, s.logger, user.ID, false)
+		return nil
+	}
+
+	clientIDs := make([]string, 0, len(user.Devices))
+	for _, device := range user.Devices {
+		clientIDs = append(clientIDs, device.ID.String())
+	}
+	if len(clientIDs) == 0 || s.orchestrator == nil {
+		return nil
+	}
+
+	result, err := s.orchestrator.KickUser(ctx, user.ID.String(), clientIDs)
+	if err != nil {
+		return fmt.Errorf("failed to kick user from nodes: %w", err)
+	}
+	if len(result.Errors) > 0 {
+		s.logger.Warn("User kick incomplete", "user_id", user.ID, "nodes_kicked", result.NodesKicked, "nodes_total", result.NodesTotal, "errors", result.Errors)
+	}
+
+	return nil
+}
+
+// activeSubscription returns the user's active subscription, or nil if they
+// have none or it cannot be read, in which case the user's own limits apply
+func (s *enforcementService) activeSubscription(ctx context.Context, userID uuid.UUID) *models.Subscription {
+	subscription, err := s.planService.GetSubscription(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, serviceInterfaces.ErrNoSubscription) {
+			s.logger.Warn("Failed to get subscription", "user_id", userID, "error", err)
+		}
+		return nil
+	}
+	if subscription.Status != models.SubscriptionActive || subscription.Plan == nil {
+		return nil
+	}
+	return subscription
+}
+
+// quotaViolation returns why the user may no longer connect, or "" if they
+// may. The plan of an active subscription sets the limits, otherwise the
+// user's own apply.
+func quotaViolation(user *models.User, subscription *models.Subscription, now time.Time) string {
+	if subscription != nil {
+		return limitViolation(user.DataUsed, subscription.Plan.DataLimit, subscription.EndsAt, now)
+	}
+	return limitViolation(user.DataUsed, user.DataLimit, user.ExpiryDate, now)
+}
+
+// limitViolation checks usage against a data limit, where 0 means
+// unlimited, and an optional expiry date
+func limitViolation(dataUsed, dataLimit int64, expiry *time.Time, now time.Time) string {
+	if dataLimit > 0 && dataUsed >= dataLimit {
+		return ViolationQuotaExceeded
+	}
+	if expiry != nil && !expiry.After(now) {
+		return ViolationExpired
+	}
+	return ""
+}
@@ -0,0 +1,51 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+)
+
+func TestQuotaViolation(t *testing.T) {
+	now := time.Date(2024, time.June, 1, 0, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	subscription := func(dataLimit int64, endsAt *time.Time) *models.Subscription {
+		return &models.Subscription{
+			Status: models.SubscriptionActive,
+			EndsAt: endsAt,
+			Plan:   &models.Plan{DataLimit: dataLimit},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		user         models.User
+		subscription *models.Subscription
+		want         string
+	}{
+		{"unlimited", models.User{DataUsed: 1 << 40}, nil, ""},
+		{"below limit", models.User{DataUsed: 99, DataLimit: 100}, nil, ""},
+		{"at limit", models.User{DataUsed: 100, DataLimit: 100}, nil, ViolationQuotaExceeded},
+		{"over limit", models.User{DataUsed: 101, DataLimit: 100}, nil, ViolationQuotaExceeded},
+		{"expired", models.User{ExpiryDate: &past}, nil, ViolationExpired},
+		{"expires now", models.User{ExpiryDate: &now}, nil, ViolationExpired},
+		{"not expired", models.User{ExpiryDate: &future}, nil, ""},
+		{"quota wins over expiry", models.User{DataUsed: 100, DataLimit: 100, ExpiryDate: &past}, nil, ViolationQuotaExceeded},
+		{"plan limit replaces user limit", models.User{DataUsed: 150, DataLimit: 100}, subscription(200, nil), ""},
+		{"plan limit exceeded", models.User{DataUsed: 200, DataLimit: 1000}, subscription(200, nil), ViolationQuotaExceeded},
+		{"unlimited plan", models.User{DataUsed: 1 << 40, DataLimit: 100}, subscription(0, nil), ""},
+		{"subscription ended", models.User{}, subscription(0, &past), ViolationExpired},
+		{"subscription end replaces user expiry", models.User{ExpiryDate: &past}, subscription(0, &future), ""},
+		{"subscription without end", models.User{ExpiryDate: &past}, subscription(0, nil), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := quotaViolation(&tt.user, tt.subscription, now); got != tt.want {
+				t.Errorf("quotaViolation = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/orchestrator"
+
+	"github.com/google/uuid"
+)
+
+type hysteriaService struct {
+	deviceRepo   repoInterfaces.DeviceRepository
+	orchestrator *orchestrator.Client
+	logger       *logger.Logger
+}
+
+func NewHysteriaService(deviceRepo repoInterfaces.DeviceRepository, orchestratorClient *orchestrator.Client, logger *logger.Logger) serviceInterfaces.HysteriaService {
+	return &hysteriaService{
+		deviceRepo:   deviceRepo,
+		orchestrator: orchestratorClient,
+		logger:       logger,
+	}
+}
+
+// GetActiveConnections aggregates the clients online on every node. Nodes
+// that could not be reached are logged and left out.
+func (s *hysteriaService) GetActiveConnections(ctx context.Context, filter serviceInterfaces.ConnectionFilter) ([]models.Connection, error) {
+	online, err := s.orchestrator.ListConnections(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list connections: %w", err)
+	}
+	if len(online.Errors) > 0 {
+		s.logger.Warn("Connections missing from unreachable nodes", "failed", len(online.Errors), "nodes", online.NodesTotal)
+	}
+
+	now := time.Now()
+	connections := make([]models.Connection, 0, len(online.Clients))
+	for _, client := range online.Clients {
+		if filter.UserID != "" && client.UserID != filter.UserID {
+			continue
+		}
+		if filter.DeviceID != "" && client.ClientID != filter.DeviceID {
+			continue
+		}
+		if filter.NodeID != "" && client.NodeID != filter.NodeID {
+			continue
+		}
+
+		conn := models.Connection{
+			ID:         client.NodeID + ":" + client.ClientID,
+			UserID:     client.UserID,
+			DeviceID:   client.ClientID,
+			DeviceName: client.DeviceName,
+			NodeID:     client.NodeID,
+			NodeName:   client.NodeName,
+			Streams:    client.Connections,
+			Address:    client.Address,
+		}
+		if client.ConnectedAt != nil {
+			conn.ConnectedAt = *client.ConnectedAt
+			conn.Duration = int64(now.Sub(*client.ConnectedAt).Seconds())
+		}
+		connections = append(connections, conn)
+	}
+
+	return connections, nil
+}
+
+// DisconnectUser drops the live sessions of a user, or of one of the user's
+// devices, on every node. Access is not revoked, clients may reconnect.
+func (s *hysteriaService) DisconnectUser(ctx context.Context, userID, deviceID string) error {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return fmt.Errorf("invalid user ID: %w", err)
+	}
+
+	var clientIDs []string
+	if deviceID != "" {
+		did, err := uuid.Parse(deviceID)
+		if err != nil {
+			return serviceInterfaces.ErrDeviceNotFound
+		}
+		device, err := userDevice(ctx, s.deviceRepo, uid, did)
+		if err != nil {
+			return err
+		}
+		clientIDs = []string{device.ID.String()}
+	}
+
+	result, err := s.orchestrator.DisconnectUser(ctx, uid.String(), clientIDs)
+	if err != nil {
+		return fmt.Errorf("failed to disconnect user: %w", err)
+	}
+	if result.NodesTotal > 0 && result.NodesKicked == 0 {
+		return fmt.Errorf("failed to disconnect user on any node: %s", strings.Join(result.Errors, "; "))
+	}
+	if len(result.Errors) > 0 {
+		s.logger.Warn("User not disconnected on every node", "user_id", userID, "device_id", deviceID, "failed", len(result.Errors), "nodes", result.NodesTotal)
+	}
+
+	return nil
+}
+
+// GetServiceStatus summarises the fleet from the nodes' live connections
+func (s *hysteriaService) GetServiceStatus(ctx context.Context) (*models.ServiceStatus, error) {
+	online, err := s.orchestrator.ListConnections(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list connections: %w", err)
+	}
+
+	status := &models.ServiceStatus{IsRunning: online.NodesQueried > 0}
+	for _, client := range online.Clients {
+		status.ActiveConnections += int64(client.Connections)
+	}
+	return status, nil
+}
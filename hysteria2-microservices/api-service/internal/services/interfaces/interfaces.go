@@ -2,6 +2,7 @@ package interfaces
 
 import (
 	"context"
+	"errors"
 	"hysteria2-microservices/api-service/internal/models"
 	"time"
 
@@ -9,12 +10,249 @@ import (
 )
 
 type AuthService interface {
-	Register(ctx context.Context, username, email, password string) (*models.User, error)
 	Login(ctx context.Context, email, password string) (*models.User, error)
-	GenerateTokenPair(userID uuid.UUID) (*TokenPair, error)
-	ValidateToken(token string) (*Claims, error)
-	RefreshToken(refreshToken string) (*TokenPair, error)
+	GenerateTokenPair(ctx context.Context, user *models.User, info SessionInfo) (*TokenPair, error)
+	ValidateToken(ctx context.Context, token string) (*Claims, error)
+	RefreshToken(ctx context.Context, refreshToken string) (*TokenPair, error)
+	Logout(ctx context.Context, sessionToken string) error
+	ListSessions(ctx context.Context, userID uuid.UUID) ([]*models.Session, error)
+	RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) error
 	InvalidateUserSessions(ctx context.Context, userID uuid.UUID) error
+	GetLoginLockout(ctx context.Context, userID uuid.UUID) (*LoginLockout, error)
+	UnlockAccount(ctx context.Context, userID uuid.UUID) error
+	CheckLoginAllowed(ctx context.Context, user *models.User) error
+	RecordLoginFailure(ctx context.Context, user *models.User)
+	ClearLoginFailures(ctx context.Context, user *models.User)
+	AuthenticateHysteria(ctx context.Context, auth string) (*models.Device, *models.User, error)
+}
+
+// SessionInfo describes the client a session is started for
+type SessionInfo struct {
+	IPAddress string
+	UserAgent string
+}
+
+// LoginLockout reports an account's failed logins and how long it stays
+// locked
+type LoginLockout struct {
+	FailedAttempts    int  `json:"failed_attempts"`
+	Locked            bool `json:"locked"`
+	RetryAfterSeconds int  `json:"retry_after_seconds"`
+}
+
+// RetryError rejects a request that may be retried after RetryAfter
+type RetryError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryError) Error() string { return e.Err.Error() }
+
+func (e *RetryError) Unwrap() error { return e.Err }
+
+var (
+	// ErrAccountLocked means the account failed too many logins in a row
+	ErrAccountLocked = errors.New("account temporarily locked")
+	// ErrTooManyLoginAttempts means the account's login attempts hit the
+	// rate limit
+	ErrTooManyLoginAttempts = errors.New("too many login attempts")
+	// ErrEmailNotVerified and ErrAccountPendingApproval reject logins with
+	// the right password on self-registered accounts that are not active yet
+	ErrEmailNotVerified       = errors.New("email address not verified")
+	ErrAccountPendingApproval = errors.New("account awaiting approval")
+)
+
+// ErrUserStatusChanged means the user's status changed while another change
+// of it was being applied
+var ErrUserStatusChanged = errors.New("user status changed concurrently")
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	// ErrRefreshTokenReused means an already rotated refresh token was
+	// presented again. The session is revoked, as the token may be stolen.
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// MFA challenge purposes. A verify challenge is exchanged for tokens with a
+// TOTP or recovery code, an enroll challenge lets a user whose role requires
+// MFA set it up before the first sign-in.
+const (
+	MFAChallengeVerify = "verify"
+	MFAChallengeEnroll = "enroll"
+)
+
+// MFAChallenge is the short-lived token the first login step returns when a
+// second factor is needed
+type MFAChallenge struct {
+	Token     string `json:"challenge_token"`
+	Purpose   string `json:"purpose"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+// MFAEnrollment is a new TOTP secret waiting to be confirmed with a code
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+var (
+	ErrMFAChallengeInvalid = errors.New("invalid or expired MFA challenge")
+	ErrMFACodeInvalid      = errors.New("invalid MFA code")
+	ErrMFATooManyAttempts  = errors.New("too many MFA attempts")
+	ErrMFAAlreadyEnabled   = errors.New("MFA is already enabled")
+	ErrMFANotEnabled       = errors.New("MFA is not enabled")
+	ErrMFARequired         = errors.New("MFA is required for this role")
+	ErrUnknownRole         = errors.New("unknown role")
+)
+
+// MFAService handles TOTP enrollment, recovery codes and the second login
+// step. Codes are TOTP codes or, where noted, single-use recovery codes.
+type MFAService interface {
+	// LoginChallenge returns the challenge a user must pass after their
+	// password was checked, or nil when no second factor is needed
+	LoginChallenge(ctx context.Context, user *models.User) (*MFAChallenge, error)
+	// VerifyChallenge exchanges a verify challenge and a TOTP or recovery
+	// code for the user to sign in
+	VerifyChallenge(ctx context.Context, challengeToken, code string) (*models.User, error)
+	// BeginChallengeEnrollment and ConfirmChallengeEnrollment set MFA up
+	// through an enroll challenge and sign the user in
+	BeginChallengeEnrollment(ctx context.Context, challengeToken string) (*MFAEnrollment, error)
+	ConfirmChallengeEnrollment(ctx context.Context, challengeToken, code string) (*models.User, []string, error)
+	BeginEnrollment(ctx context.Context, userID uuid.UUID) (*MFAEnrollment, error)
+	ConfirmEnrollment(ctx context.Context, userID uuid.UUID, code string) ([]string, error)
+	RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, code string) ([]string, error)
+	Disable(ctx context.Context, userID uuid.UUID, code string) error
+	RequiredRoles(ctx context.Context) ([]string, error)
+	SetRequiredRoles(ctx context.Context, roles []string) ([]string, error)
+}
+
+// Registration modes for self-registration
+const (
+	// Anyone may register and gets an active account
+	RegistrationOpen = "open"
+	// Nobody may register, accounts are created by admins
+	RegistrationClosed = "closed"
+	// Registering requires an invite code
+	RegistrationInvite = "invite"
+	// Accounts become active once the email address is verified
+	RegistrationEmail = "email"
+	// Accounts become active once an admin approves them. A valid invite
+	// code skips the approval.
+	RegistrationApproval = "approval"
+)
+
+var (
+	ErrRegistrationClosed       = errors.New("registration is closed")
+	ErrInviteRequired           = errors.New("an invite code is required")
+	ErrInviteInvalid            = errors.New("invalid or expired invite code")
+	ErrInviteNotFound           = errors.New("invite code not found")
+	ErrVerificationTokenInvalid = errors.New("invalid or expired verification token")
+	ErrUserNotPending           = errors.New("user is not awaiting approval")
+)
+
+// RegisterRequest is a self-registration. InviteCode is optional unless the
+// mode is invite-only.
+type RegisterRequest struct {
+	Username   string
+	Email      string
+	Password   string
+	InviteCode string
+}
+
+// CreateInviteRequest mints an invite code. A MaxUses of 0 allows unlimited
+// uses, the other zero values mean no expiry and no account defaults.
+type CreateInviteRequest struct {
+	MaxUses     int
+	ExpiresAt   *time.Time
+	DataLimit   int64
+	ValidDays   int
+	DeviceLimit int
+	Note        *string
+}
+
+// RegistrationService applies the self-registration policy: the mode,
+// invite codes, email verification and the admin approval queue
+type RegistrationService interface {
+	Mode() string
+	// Register creates an account. Its status tells whether the user can
+	// sign in or awaits email verification or approval.
+	Register(ctx context.Context, req *RegisterRequest) (*models.User, error)
+	VerifyEmail(ctx context.Context, token string) (*models.User, error)
+	// ResendVerification sends a new link if the email belongs to an
+	// account awaiting verification, and silently does nothing otherwise
+	ResendVerification(ctx context.Context, email string) error
+	CreateInvite(ctx context.Context, createdBy uuid.UUID, req *CreateInviteRequest) (*models.InviteCode, error)
+	ListInvites(ctx context.Context, page, limit int) ([]*models.InviteCode, int64, error)
+	DeleteInvite(ctx context.Context, id uuid.UUID) error
+	ListPending(ctx context.Context, page, limit int) ([]*models.User, int64, error)
+	Approve(ctx context.Context, userID uuid.UUID) (*models.User, error)
+	Reject(ctx context.Context, userID uuid.UUID) error
+}
+
+var (
+	// ErrPasswordIncorrect rejects a password change with a wrong current
+	// password
+	ErrPasswordIncorrect = errors.New("current password is incorrect")
+	// ErrResetTokenInvalid means the reset link is unknown, expired, used
+	// or issued before the password last changed
+	ErrResetTokenInvalid = errors.New("invalid or expired reset token")
+)
+
+// PasswordService changes passwords of signed in users and resets forgotten
+// ones through an emailed link
+type PasswordService interface {
+	// ChangePassword replaces the password of a signed in user. Depending
+	// on configuration the user's other sessions are revoked.
+	ChangePassword(ctx context.Context, userID uuid.UUID, sessionToken, currentPassword, newPassword string) error
+	// RequestReset mails a reset link if the email belongs to an account,
+	// and silently does nothing otherwise
+	RequestReset(ctx context.Context, email string) error
+	// ResetPassword sets a new password with a reset token and signs the
+	// user out everywhere
+	ResetPassword(ctx context.Context, token, newPassword string) (*models.User, error)
+}
+
+var (
+	ErrPlanNotFound         = errors.New("plan not found")
+	ErrPlanInactive         = errors.New("plan is not available")
+	ErrPlanInUse            = errors.New("plan has subscriptions")
+	ErrPlanNameTaken        = errors.New("plan name already exists")
+	ErrNoSubscription       = errors.New("user has no subscription")
+	ErrSubscriptionNoEnd    = errors.New("subscription does not end")
+	ErrExtensionDaysMissing = errors.New("extension needs a number of days")
+)
+
+// PlanService manages plans and the users' subscriptions to them. Every
+// change to a subscription is recorded in its history with the admin who
+// made it.
+type PlanService interface {
+	CreatePlan(ctx context.Context, plan *models.Plan) error
+	GetPlan(ctx context.Context, id uuid.UUID) (*models.Plan, error)
+	ListPlans(ctx context.Context, includeInactive bool) ([]*models.Plan, error)
+	// UpdatePlan also applies changed limits to the plan's subscribers
+	UpdatePlan(ctx context.Context, plan *models.Plan) error
+	DeletePlan(ctx context.Context, id uuid.UUID) error
+	// GetSubscription returns the user's active subscription or, if there
+	// is none, the one that expired last
+	GetSubscription(ctx context.Context, userID uuid.UUID) (*models.Subscription, error)
+	// Assign starts a new subscription, replacing the active one
+	Assign(ctx context.Context, userID, planID, actorID uuid.UUID, note *string) (*models.Subscription, error)
+	// Extend pushes the end of the subscription back by days, or by the
+	// plan's duration if days is 0. An expired subscription is renewed.
+	Extend(ctx context.Context, userID uuid.UUID, days int, actorID uuid.UUID, note *string) (*models.Subscription, error)
+	// ChangePlan moves the active subscription to another plan, keeping its
+	// term and the data used in the current period
+	ChangePlan(ctx context.Context, userID, planID, actorID uuid.UUID, note *string) (*models.Subscription, error)
+	History(ctx context.Context, userID uuid.UUID, page, limit int) ([]*models.SubscriptionHistory, int64, error)
+	// ProcessDue resets the quota of subscriptions whose period is over and
+	// expires those whose term ended
+	ProcessDue(ctx context.Context, now time.Time) error
+	// RecordSuspension notes that enforcement suspended the user, so the
+	// next quota reset or extension that resolves the reason lifts it
+	RecordSuspension(ctx context.Context, userID uuid.UUID, reason string) error
+	// Bandwidth returns the user's speed caps in Mbit/s, the user's own or
+	// else those of the active plan. 0 leaves the node's.
+	Bandwidth(ctx context.Context, user *models.User) (upMbps, downMbps int, err error)
 }
 
 type UserService interface {
@@ -41,26 +279,145 @@ type NodeService interface {
 }
 
 type TrafficService interface {
-	RecordTraffic(ctx context.Context, stats *models.TrafficStats) error
+	RecordTraffic(ctx context.Context, report *models.TrafficReport) error
 	GetUserTraffic(ctx context.Context, userID uuid.UUID, from, to time.Time) ([]*models.TrafficStats, error)
 	GetTrafficSummary(ctx context.Context, from, to time.Time) (*models.TrafficSummary, error)
 	UpdateUserTraffic(ctx context.Context, userID uuid.UUID, upload, download int64) error
 	UpdateDeviceTraffic(ctx context.Context, deviceID uuid.UUID, upload, download int64) error
 }
 
+// EnforcementService suspends users that run out of quota or pass their expiry date
+type EnforcementService interface {
+	CheckUser(ctx context.Context, userID uuid.UUID) error
+	SetStatus(ctx context.Context, userID uuid.UUID, status string) (*models.User, error)
+	Start()
+	Stop()
+}
+
+// HysteriaService covers fleet-wide Hysteria2 operations. Client configs are
+// managed by ClientConfigService.
+// ConnectionFilter narrows the live connections list, empty fields match all
+type ConnectionFilter struct {
+	UserID   string
+	DeviceID string
+	NodeID   string
+}
+
+// HysteriaService reports and controls live Hysteria2 connections across
+// the node fleet
 type HysteriaService interface {
-	GenerateUserConfig(ctx context.Context, userID, deviceID string) (*models.HysteriaConfig, error)
-	UpdateUserConfig(ctx context.Context, userID, deviceID string, config *models.HysteriaConfig) error
-	ReloadConfiguration(ctx context.Context) error
-	GetActiveConnections(ctx context.Context) ([]models.Connection, error)
+	GetActiveConnections(ctx context.Context, filter ConnectionFilter) ([]models.Connection, error)
 	DisconnectUser(ctx context.Context, userID, deviceID string) error
 	GetServiceStatus(ctx context.Context) (*models.ServiceStatus, error)
 }
 
+// Subscription feed formats served on the subscription URL
+const (
+	FeedFormatPlain   = "plain"
+	FeedFormatBase64  = "base64"
+	FeedFormatClash   = "clash"
+	FeedFormatSingBox = "singbox"
+)
+
+var (
+	ErrSubscriptionNotFound = errors.New("subscription not found")
+	ErrSubscriptionInactive = errors.New("subscription is not active")
+	ErrUnsupportedFormat    = errors.New("unsupported format")
+)
+
+// ShareLinkService renders hy2:// links for a user's devices on every node
+// assigned to the user, and serves them as a subscription feed
+type ShareLinkService interface {
+	GetShareLinks(ctx context.Context, userID uuid.UUID) ([]*models.ShareLink, error)
+	GetSubscriptionToken(ctx context.Context, userID uuid.UUID) (string, error)
+	RotateSubscriptionToken(ctx context.Context, userID uuid.UUID) (string, error)
+	RenderSubscription(ctx context.Context, token, format string) (*SubscriptionFeed, error)
+}
+
+// SubscriptionFeed is a rendered subscription document
+type SubscriptionFeed struct {
+	User        *models.User
+	ContentType string
+	Body        []byte
+}
+
+var (
+	ErrDeviceExists       = errors.New("device already registered")
+	ErrDeviceLimitReached = errors.New("device limit reached")
+)
+
+// CreateDeviceRequest enrolls a client device for a user
+type CreateDeviceRequest struct {
+	Name      string
+	DeviceID  string
+	PublicKey string
+}
+
+// DeviceEnrollment is a newly created device with its Hysteria2 credential,
+// which is only ever shown at creation
+type DeviceEnrollment struct {
+	Device     *models.Device `json:"device"`
+	Credential string         `json:"credential"`
+}
+
+// NodeSyncResult reports how many nodes applied a device change
+type NodeSyncResult struct {
+	NodesTotal   int      `json:"nodes_total"`
+	NodesUpdated int      `json:"nodes_updated"`
+	Errors       []string `json:"errors,omitempty"`
+}
+
+// DeviceService manages a user's devices. Status changes are pushed to the
+// nodes so a blocked device loses access and is disconnected immediately.
+type DeviceService interface {
+	CreateDevice(ctx context.Context, userID uuid.UUID, req *CreateDeviceRequest) (*DeviceEnrollment, error)
+	RenameDevice(ctx context.Context, userID, deviceID uuid.UUID, name string) (*models.Device, error)
+	BlockDevice(ctx context.Context, userID, deviceID uuid.UUID) (*models.Device, *NodeSyncResult, error)
+	UnblockDevice(ctx context.Context, userID, deviceID uuid.UUID) (*models.Device, *NodeSyncResult, error)
+	DeleteDevice(ctx context.Context, userID, deviceID uuid.UUID) (*NodeSyncResult, error)
+	RecordConnection(ctx context.Context, deviceID uuid.UUID, addr string, at time.Time) error
+}
+
+// Client config download formats
+const (
+	ConfigFormatJSON = "json"
+	ConfigFormatYAML = "yaml"
+)
+
+var (
+	ErrDeviceNotFound      = errors.New("device not found")
+	ErrConfigNotFound      = errors.New("config not found")
+	ErrNodeNotAssigned     = errors.New("node is not assigned to the user")
+	ErrInvalidClientConfig = errors.New("invalid client config")
+)
+
+// GenerateConfigRequest selects the node a client config is generated for
+type GenerateConfigRequest struct {
+	NodeID     uuid.UUID
+	ConfigName string
+	Activate   bool
+}
+
+// ClientConfigService manages the Hysteria2 client configs of a user's
+// devices. Every call is scoped to a device owned by the user.
+type ClientConfigService interface {
+	ListConfigs(ctx context.Context, userID, deviceID uuid.UUID) ([]*models.HysteriaConfig, error)
+	GetConfig(ctx context.Context, userID, deviceID, configID uuid.UUID) (*models.HysteriaConfig, error)
+	GenerateConfig(ctx context.Context, userID, deviceID uuid.UUID, req *GenerateConfigRequest) (*models.HysteriaConfig, error)
+	UpdateConfig(ctx context.Context, userID, deviceID, configID uuid.UUID, name *string, data map[string]interface{}) (*models.HysteriaConfig, error)
+	DeleteConfig(ctx context.Context, userID, deviceID, configID uuid.UUID) error
+	ActivateConfig(ctx context.Context, userID, deviceID, configID uuid.UUID) (*models.HysteriaConfig, error)
+	RegenerateCredentials(ctx context.Context, userID, deviceID uuid.UUID) ([]*models.HysteriaConfig, error)
+	RenderConfig(config *models.HysteriaConfig, format string) ([]byte, string, error)
+}
+
 type WebSocketService interface {
 	BroadcastTrafficUpdate(userID uuid.UUID, stats *models.TrafficStats)
 	BroadcastUserStatus(userID uuid.UUID, status string)
 	BroadcastDeviceStatus(deviceID uuid.UUID, userID uuid.UUID, online bool)
+	BroadcastNodeEvent(event *models.NodeEvent)
+	BroadcastNodeMetric(metric *models.NodeMetric)
+	BroadcastDeployment(deployment *models.Deployment)
 	GetConnectedClientsCount() int
 	IsUserConnected(userID uuid.UUID) bool
 }
@@ -71,8 +428,16 @@ type TokenPair struct {
 	ExpiresIn    int64  `json:"expires_in"`
 }
 
+// Token types, so a refresh token is never accepted as an access token
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
 type Claims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	TokenType string `json:"token_type"`
+	SessionID string `json:"session_id"` // the session's SessionToken
 }
@@ -0,0 +1,458 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/cache"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/totp"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// mfaChallengePrefix + hash of the challenge token holds the pending
+	// second login step
+	mfaChallengePrefix = "mfa:challenge:"
+	// mfaAttemptsPrefix + user ID counts failed codes, across challenges so
+	// a new login does not grant new attempts
+	mfaAttemptsPrefix = "mfa:attempts:"
+	mfaChallengeTTL   = 5 * time.Minute
+	mfaMaxAttempts    = 5
+	// Codes of the previous and next period are accepted for clock drift
+	mfaSkew = 1
+
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 10
+)
+
+// mfaChallenge is the Redis record of a challenge token
+type mfaChallenge struct {
+	UserID  uuid.UUID `json:"user_id"`
+	Purpose string    `json:"purpose"`
+}
+
+type mfaService struct {
+	userRepo    repoInterfaces.UserRepository
+	mfaRepo     repoInterfaces.MFARepository
+	authService serviceInterfaces.AuthService
+	redis       *cache.RedisClient
+	logger      *logger.Logger
+	issuer      string
+}
+
+func NewMFAService(userRepo repoInterfaces.UserRepository, mfaRepo repoInterfaces.MFARepository, authService serviceInterfaces.AuthService, redis *cache.RedisClient, logger *logger.Logger, issuer string) serviceInterfaces.MFAService {
+	return &mfaService{
+		userRepo:    userRepo,
+		mfaRepo:     mfaRepo,
+		authService: authService,
+		redis:       redis,
+		logger:      logger,
+		issuer:      issuer,
+	}
+}
+
+// LoginChallenge asks users with MFA for a code, and users whose role
+// requires MFA but who have not set it up to enroll first
+func (s *mfaService) LoginChallenge(ctx context.Context, user *models.User) (*serviceInterfaces.MFAChallenge, error) {
+	if user.MFAEnabled {
+		return s.newChallenge(ctx, user.ID, serviceInterfaces.MFAChallengeVerify)
+	}
+
+	required, err := s.roleRequiresMFA(ctx, user.Role)
+	if err != nil {
+		return nil, err
+	}
+	if required {
+		return s.newChallenge(ctx, user.ID, serviceInterfaces.MFAChallengeEnroll)
+	}
+	return nil, nil
+}
+
+func (s *mfaService) VerifyChallenge(ctx context.Context, challengeToken, code string) (*models.User, error) {
+	key, challenge, err := s.getChallenge(ctx, challengeToken, serviceInterfaces.MFAChallengeVerify)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.activeUser(ctx, challenge.UserID)
+	if err != nil {
+		s.redis.Del(ctx, mfaChallengePrefix+key)
+		return nil, err
+	}
+	if !user.MFAEnabled {
+		s.redis.Del(ctx, mfaChallengePrefix+key)
+		return nil, serviceInterfaces.ErrMFAChallengeInvalid
+	}
+
+	if err := s.attemptLogin(ctx, key, user, func() error { return s.checkCode(ctx, user, code, true) }); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// BeginChallengeEnrollment starts enrollment for a user who cannot sign in
+// before setting up MFA. The challenge stays valid for the confirmation.
+func (s *mfaService) BeginChallengeEnrollment(ctx context.Context, challengeToken string) (*serviceInterfaces.MFAEnrollment, error) {
+	_, challenge, err := s.getChallenge(ctx, challengeToken, serviceInterfaces.MFAChallengeEnroll)
+	if err != nil {
+		return nil, err
+	}
+	return s.BeginEnrollment(ctx, challenge.UserID)
+}
+
+func (s *mfaService) ConfirmChallengeEnrollment(ctx context.Context, challengeToken, code string) (*models.User, []string, error) {
+	key, challenge, err := s.getChallenge(ctx, challengeToken, serviceInterfaces.MFAChallengeEnroll)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := s.activeUser(ctx, challenge.UserID)
+	if err != nil {
+		s.redis.Del(ctx, mfaChallengePrefix+key)
+		return nil, nil, err
+	}
+
+	var codes []string
+	err = s.attemptLogin(ctx, key, user, func() error {
+		var err error
+		codes, err = s.enable(ctx, user, code)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user.MFAEnabled = true
+	return user, codes, nil
+}
+
+// BeginEnrollment stores a new secret for the user. MFA stays off until a
+// code from it is confirmed.
+func (s *mfaService) BeginEnrollment(ctx context.Context, userID uuid.UUID) (*serviceInterfaces.MFAEnrollment, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if user.MFAEnabled {
+		return nil, serviceInterfaces.ErrMFAAlreadyEnabled
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate secret: %w", err)
+	}
+	if err := s.userRepo.UpdateMFA(ctx, user.ID, &secret, false); err != nil {
+		return nil, fmt.Errorf("failed to store secret: %w", err)
+	}
+
+	return &serviceInterfaces.MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: totp.ProvisioningURI(secret, s.issuer, user.Email),
+	}, nil
+}
+
+// ConfirmEnrollment enables MFA once the user proved their authenticator
+// works and returns the recovery codes, which are only shown once
+func (s *mfaService) ConfirmEnrollment(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	var codes []string
+	err = s.attempt(ctx, userID.String(), func() error {
+		var err error
+		codes, err = s.enable(ctx, user, code)
+		return err
+	})
+	return codes, err
+}
+
+func (s *mfaService) RegenerateRecoveryCodes(ctx context.Context, userID uuid.UUID, code string) ([]string, error) {
+	user, err := s.enabledUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.attempt(ctx, userID.String(), func() error { return s.checkCode(ctx, user, code, false) }); err != nil {
+		return nil, err
+	}
+	return s.replaceRecoveryCodes(ctx, userID)
+}
+
+// Disable turns MFA off unless the user's role requires it
+func (s *mfaService) Disable(ctx context.Context, userID uuid.UUID, code string) error {
+	user, err := s.enabledUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	required, err := s.roleRequiresMFA(ctx, user.Role)
+	if err != nil {
+		return err
+	}
+	if required {
+		return serviceInterfaces.ErrMFARequired
+	}
+
+	if err := s.attempt(ctx, userID.String(), func() error { return s.checkCode(ctx, user, code, true) }); err != nil {
+		return err
+	}
+
+	if err := s.userRepo.UpdateMFA(ctx, userID, nil, false); err != nil {
+		return fmt.Errorf("failed to disable MFA: %w", err)
+	}
+	if err := s.mfaRepo.DeleteRecoveryCodes(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	return nil
+}
+
+func (s *mfaService) RequiredRoles(ctx context.Context) ([]string, error) {
+	return s.mfaRepo.GetRequiredRoles(ctx)
+}
+
+// SetRequiredRoles replaces the roles that must use MFA. Members of a newly
+// required role are asked to enroll on their next login, sessions they
+// already hold stay valid.
+func (s *mfaService) SetRequiredRoles(ctx context.Context, roles []string) ([]string, error) {
+	seen := make(map[string]bool, len(roles))
+	unique := make([]string, 0, len(roles))
+	for _, role := range roles {
+		if !models.ValidRole(role) {
+			return nil, fmt.Errorf("%w: %q", serviceInterfaces.ErrUnknownRole, role)
+		}
+		if !seen[role] {
+			seen[role] = true
+			unique = append(unique, role)
+		}
+	}
+
+	if err := s.mfaRepo.SetRequiredRoles(ctx, unique); err != nil {
+		return nil, fmt.Errorf("failed to store required roles: %w", err)
+	}
+	return s.mfaRepo.GetRequiredRoles(ctx)
+}
+
+// enable confirms the user's pending secret with a TOTP code and issues the
+// first recovery codes
+func (s *mfaService) enable(ctx context.Context, user *models.User, code string) ([]string, error) {
+	if user.MFAEnabled {
+		return nil, serviceInterfaces.ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == nil {
+		return nil, serviceInterfaces.ErrMFANotEnabled
+	}
+
+	step, ok := totp.Validate(*user.TOTPSecret, normalizeCode(code), time.Now(), mfaSkew)
+	if !ok {
+		return nil, serviceInterfaces.ErrMFACodeInvalid
+	}
+
+	if err := s.userRepo.UpdateMFA(ctx, user.ID, user.TOTPSecret, true); err != nil {
+		return nil, fmt.Errorf("failed to enable MFA: %w", err)
+	}
+	if _, err := s.userRepo.RecordTOTPStep(ctx, user.ID, step); err != nil {
+		s.logger.Warn("Failed to record TOTP step", "error", err, "user_id", user.ID)
+	}
+	return s.replaceRecoveryCodes(ctx, user.ID)
+}
+
+// checkCode accepts a TOTP code that was not used before and, if allowed, an
+// unused recovery code
+func (s *mfaService) checkCode(ctx context.Context, user *models.User, code string, allowRecovery bool) error {
+	code = normalizeCode(code)
+
+	if user.TOTPSecret != nil {
+		if step, ok := totp.Validate(*user.TOTPSecret, code, time.Now(), mfaSkew); ok {
+			recorded, err := s.userRepo.RecordTOTPStep(ctx, user.ID, step)
+			if err != nil {
+				return fmt.Errorf("failed to record TOTP step: %w", err)
+			}
+			if !recorded {
+				// Replayed code
+				return serviceInterfaces.ErrMFACodeInvalid
+			}
+			return nil
+		}
+	}
+
+	if !allowRecovery || len(code) != recoveryCodeLength {
+		return serviceInterfaces.ErrMFACodeInvalid
+	}
+	used, err := s.mfaRepo.UseRecoveryCode(ctx, user.ID, hashToken(code))
+	if err != nil {
+		return fmt.Errorf("failed to check recovery code: %w", err)
+	}
+	if !used {
+		return serviceInterfaces.ErrMFACodeInvalid
+	}
+
+	s.logger.Info("Recovery code used", "user_id", user.ID)
+	return nil
+}
+
+// attemptLogin runs the code check of a login challenge. Wrong codes count
+// towards the user's login lockout like wrong passwords, and the failed
+// logins are only cleared once the code passed. The challenge is dropped
+// when the account is locked or out of attempts.
+func (s *mfaService) attemptLogin(ctx context.Context, key string, user *models.User, check func() error) error {
+	if err := s.authService.CheckLoginAllowed(ctx, user); err != nil {
+		s.redis.Del(ctx, mfaChallengePrefix+key)
+		return err
+	}
+
+	if err := s.attempt(ctx, user.ID.String(), check); err != nil {
+		switch {
+		case errors.Is(err, serviceInterfaces.ErrMFACodeInvalid):
+			s.authService.RecordLoginFailure(ctx, user)
+		case errors.Is(err, serviceInterfaces.ErrMFATooManyAttempts):
+			s.redis.Del(ctx, mfaChallengePrefix+key)
+		}
+		return err
+	}
+
+	s.redis.Del(ctx, mfaChallengePrefix+key)
+	s.authService.ClearLoginFailures(ctx, user)
+	return nil
+}
+
+// attempt runs check unless the user ran out of attempts, counting failed
+// codes within the challenge lifetime
+func (s *mfaService) attempt(ctx context.Context, key string, check func() error) error {
+	attemptsKey := mfaAttemptsPrefix + key
+	n, err := s.redis.Incr(ctx, attemptsKey)
+	if err != nil {
+		return fmt.Errorf("failed to count MFA attempts: %w", err)
+	}
+	if n == 1 {
+		s.redis.Expire(ctx, attemptsKey, mfaChallengeTTL)
+	}
+	if n > mfaMaxAttempts {
+		return serviceInterfaces.ErrMFATooManyAttempts
+	}
+
+	if err := check(); err != nil {
+		return err
+	}
+	s.redis.Del(ctx, attemptsKey)
+	return nil
+}
+
+func (s *mfaService) newChallenge(ctx context.Context, userID uuid.UUID, purpose string) (*serviceInterfaces.MFAChallenge, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %w", err)
+	}
+
+	challenge := mfaChallenge{UserID: userID, Purpose: purpose}
+	if err := s.redis.Set(ctx, mfaChallengePrefix+hashToken(token), challenge, mfaChallengeTTL); err != nil {
+		return nil, fmt.Errorf("failed to store challenge: %w", err)
+	}
+
+	return &serviceInterfaces.MFAChallenge{
+		Token:     token,
+		Purpose:   purpose,
+		ExpiresIn: int64(mfaChallengeTTL.Seconds()),
+	}, nil
+}
+
+// getChallenge loads a challenge of the given purpose and returns it with
+// the hash of its token, which keys its Redis entries
+func (s *mfaService) getChallenge(ctx context.Context, token, purpose string) (string, *mfaChallenge, error) {
+	if token == "" {
+		return "", nil, serviceInterfaces.ErrMFAChallengeInvalid
+	}
+
+	key := hashToken(token)
+	var challenge mfaChallenge
+	if err := s.redis.Get(ctx, mfaChallengePrefix+key, &challenge); err != nil {
+		return "", nil, serviceInterfaces.ErrMFAChallengeInvalid
+	}
+	if challenge.Purpose != purpose {
+		return "", nil, serviceInterfaces.ErrMFAChallengeInvalid
+	}
+	return key, &challenge, nil
+}
+
+// activeUser re-reads the user so a suspension between the two login steps
+// invalidates the challenge
+func (s *mfaService) activeUser(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil || user.Status != "active" {
+		return nil, serviceInterfaces.ErrMFAChallengeInvalid
+	}
+	return user, nil
+}
+
+func (s *mfaService) enabledUser(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	if !user.MFAEnabled {
+		return nil, serviceInterfaces.ErrMFANotEnabled
+	}
+	return user, nil
+}
+
+func (s *mfaService) roleRequiresMFA(ctx context.Context, role string) (bool, error) {
+	roles, err := s.mfaRepo.GetRequiredRoles(ctx)
+	if err != nil {
+		return false, fmt.Errorf("failed to get required roles: %w", err)
+	}
+	for _, r := range roles {
+		if r == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// replaceRecoveryCodes issues a new set of recovery codes, invalidating the
+// previous ones. Only their hashes are stored.
+func (s *mfaService) replaceRecoveryCodes(ctx context.Context, userID uuid.UUID) ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		hashes[i] = hashToken(code)
+		// Shown grouped for readability, normalizeCode drops the dash
+		codes[i] = code[:recoveryCodeLength/2] + "-" + code[recoveryCodeLength/2:]
+	}
+
+	if err := s.mfaRepo.ReplaceRecoveryCodes(ctx, userID, hashes); err != nil {
+		return nil, fmt.Errorf("failed to store recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCode returns 50 random bits as lowercase base32
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.ToLower(recoveryEncoding.EncodeToString(b))[:recoveryCodeLength], nil
+}
+
+// normalizeCode strips the separators users type or paste along with codes
+func normalizeCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
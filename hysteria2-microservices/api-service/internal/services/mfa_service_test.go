@@ -0,0 +1,75 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	"hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/totp"
+
+	"github.com/google/uuid"
+)
+
+func TestCheckCodeRejectsReplay(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	current := totp.Step(time.Now())
+	code := func(step int64) string {
+		c, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatalf("Code: %v", err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name string
+		// lastStep is the step of the last accepted code
+		lastStep int64
+		code     string
+		wantErr  error
+	}{
+		{"unused code", current - 2, code(current), nil},
+		{"code of the next step", current, code(current + 1), nil},
+		{"replayed code", current, code(current), interfaces.ErrMFACodeInvalid},
+		{"older code after a newer one", current, code(current - 1), interfaces.ErrMFACodeInvalid},
+		{"code outside the skew", current - 5, code(current - 2), interfaces.ErrMFACodeInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{ID: uuid.New(), MFAEnabled: true, TOTPSecret: &secret, TOTPLastStep: tt.lastStep}
+			s := &mfaService{userRepo: newFakeUserRepo(user)}
+
+			if err := s.checkCode(context.Background(), user, tt.code, false); !errors.Is(err, tt.wantErr) {
+				t.Errorf("checkCode = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckCodeAcceptsOnce(t *testing.T) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatalf("Code: %v", err)
+	}
+
+	user := &models.User{ID: uuid.New(), MFAEnabled: true, TOTPSecret: &secret}
+	s := &mfaService{userRepo: newFakeUserRepo(user)}
+	ctx := context.Background()
+
+	if err := s.checkCode(ctx, user, code, false); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if err := s.checkCode(ctx, user, code, false); !errors.Is(err, interfaces.ErrMFACodeInvalid) {
+		t.Errorf("second use = %v, want %v", err, interfaces.ErrMFACodeInvalid)
+	}
+}
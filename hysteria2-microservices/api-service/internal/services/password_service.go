@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/cache"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/mailer"
+	"hysteria2-microservices/api-service/pkg/password"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// passwordResetPrefix + hash of the token holds the pending reset
+	passwordResetPrefix = "password_reset:"
+	passwordResetTTL    = time.Hour
+)
+
+// passwordReset is the Redis record of a reset token. Stamp is derived from
+// the password hash at the time the link was sent, so links stop working
+// once the password changes.
+type passwordReset struct {
+	UserID uuid.UUID `json:"user_id"`
+	Stamp  string    `json:"stamp"`
+}
+
+type passwordService struct {
+	userRepo       repoInterfaces.UserRepository
+	authService    serviceInterfaces.AuthService
+	redis          *cache.RedisClient
+	passwords      *password.Hasher
+	mailer         mailer.Mailer
+	logger         *logger.Logger
+	resetURL       string
+	revokeOnChange bool
+}
+
+func NewPasswordService(userRepo repoInterfaces.UserRepository, authService serviceInterfaces.AuthService, redis *cache.RedisClient, passwords *password.Hasher, mailer mailer.Mailer, logger *logger.Logger, resetURL string, revokeOnChange bool) serviceInterfaces.PasswordService {
+	return &passwordService{
+		userRepo:       userRepo,
+		authService:    authService,
+		redis:          redis,
+		passwords:      passwords,
+		mailer:         mailer,
+		logger:         logger,
+		resetURL:       resetURL,
+		revokeOnChange: revokeOnChange,
+	}
+}
+
+func (s *passwordService) ChangePassword(ctx context.Context, userID uuid.UUID, sessionToken, currentPassword, newPassword string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("user not found")
+	}
+	if match, _ := s.passwords.Verify(currentPassword, user.Password); !match {
+		return serviceInterfaces.ErrPasswordIncorrect
+	}
+
+	if err := s.setPassword(ctx, user, newPassword); err != nil {
+		return err
+	}
+
+	if s.revokeOnChange {
+		s.revokeOtherSessions(ctx, userID, sessionToken)
+	}
+	return nil
+}
+
+func (s *passwordService) RequestReset(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return nil
+	}
+	// Accounts that cannot sign in yet have nothing to reset
+	if user.Status == models.UserStatusPendingVerification || user.Status == models.UserStatusPendingApproval {
+		return nil
+	}
+
+	token, err := randomToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+	reset := passwordReset{UserID: user.ID, Stamp: hashToken(user.Password)}
+	if err := s.redis.Set(ctx, passwordResetPrefix+hashToken(token), reset, passwordResetTTL); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+
+	link := s.resetURL + "?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\nopen this link within %d minutes to choose a new password:\n\n%s\n\nIf you did not ask for a password reset, ignore this email.\n",
+			user.Username, int(passwordResetTTL.Minutes()), link),
+	})
+}
+
+// ResetPassword checks the new password before it consumes the token, so a
+// rejected password can be retried with the same link
+func (s *passwordService) ResetPassword(ctx context.Context, token, newPassword string) (*models.User, error) {
+	if token == "" {
+		return nil, serviceInterfaces.ErrResetTokenInvalid
+	}
+	if err := password.Validate(newPassword); err != nil {
+		return nil, err
+	}
+
+	var reset passwordReset
+	if err := s.redis.GetDel(ctx, passwordResetPrefix+hashToken(token), &reset); err != nil {
+		return nil, serviceInterfaces.ErrResetTokenInvalid
+	}
+
+	user, err := s.userRepo.GetByID(ctx, reset.UserID)
+	if err != nil || hashToken(user.Password) != reset.Stamp {
+		return nil, serviceInterfaces.ErrResetTokenInvalid
+	}
+
+	if err := s.setPassword(ctx, user, newPassword); err != nil {
+		return nil, err
+	}
+
+	// Whoever knew the old password is signed out, and the owner may sign
+	// in right away even if the account was locked
+	if err := s.authService.InvalidateUserSessions(ctx, user.ID); err != nil {
+		s.logger.Error("Failed to revoke sessions after password reset", "error", err, "user_id", user.ID)
+	}
+	if err := s.authService.UnlockAccount(ctx, user.ID); err != nil {
+		s.logger.Warn("Failed to clear lockout after password reset", "error", err, "user_id", user.ID)
+	}
+
+	return user, nil
+}
+
+func (s *passwordService) setPassword(ctx context.Context, user *models.User, newPassword string) error {
+	if err := password.Validate(newPassword); err != nil {
+		return err
+	}
+	hashed, err := s.passwords.Hash(newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	if err := s.userRepo.UpdatePassword(ctx, user.ID, hashed); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	user.Password = hashed
+	return nil
+}
+
+// revokeOtherSessions signs the user out everywhere but the session the
+// password was changed from. The change itself stands if this fails.
+func (s *passwordService) revokeOtherSessions(ctx context.Context, userID uuid.UUID, sessionToken string) {
+	sessions, err := s.authService.ListSessions(ctx, userID)
+	if err != nil {
+		s.logger.Error("Failed to list sessions after password change", "error", err, "user_id", userID)
+		return
+	}
+	for _, session := range sessions {
+		if session.SessionToken == sessionToken {
+			continue
+		}
+		if err := s.authService.RevokeSession(ctx, userID, session.ID); err != nil {
+			s.logger.Error("Failed to revoke session after password change", "error", err, "user_id", userID, "session_id", session.ID)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/cache"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/orchestrator"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type planService struct {
+	planRepo         repoInterfaces.PlanRepository
+	subscriptionRepo repoInterfaces.SubscriptionRepository
+	userRepo         repoInterfaces.UserRepository
+	orchestrator     *orchestrator.Client
+	redis            *cache.RedisClient
+	logger           *logger.Logger
+}
+
+func NewPlanService(planRepo repoInterfaces.PlanRepository, subscriptionRepo repoInterfaces.SubscriptionRepository, userRepo repoInterfaces.UserRepository, orchestratorClient *orchestrator.Client, redis *cache.RedisClient, logger *logger.Logger) serviceInterfaces.PlanService {
+	return &planService{
+		planRepo:         planRepo,
+		subscriptionRepo: subscriptionRepo,
+		userRepo:         userRepo,
+		orchestrator:     orchestratorClient,
+		redis:            redis,
+		logger:           logger,
+	}
+}
+
+// CreatePlan stores a new plan. Plans start out active.
+func (s *planService) CreatePlan(ctx context.Context, plan *models.Plan) error {
+	if _, err := s.planRepo.GetByName(ctx, plan.Name); err == nil {
+		return serviceInterfaces.ErrPlanNameTaken
+	}
+	plan.IsActive = true
+	return s.planRepo.Create(ctx, plan)
+}
+
+func (s *planService) GetPlan(ctx context.Context, id uuid.UUID) (*models.Plan, error) {
+	plan, err := s.planRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, serviceInterfaces.ErrPlanNotFound
+		}
+		return nil, fmt.Errorf("failed to get plan: %w", err)
+	}
+	return plan, nil
+}
+
+func (s *planService) ListPlans(ctx context.Context, includeInactive bool) ([]*models.Plan, error) {
+	return s.planRepo.List(ctx, includeInactive)
+}
+
+// UpdatePlan stores the plan and applies its limits to the subscribers.
+// Changed speed limits are pushed to the nodes.
+func (s *planService) UpdatePlan(ctx context.Context, plan *models.Plan) error {
+	if existing, err := s.planRepo.GetByName(ctx, plan.Name); err == nil && existing.ID != plan.ID {
+		return serviceInterfaces.ErrPlanNameTaken
+	}
+	previous, err := s.GetPlan(ctx, plan.ID)
+	if err != nil {
+		return err
+	}
+	if err := s.planRepo.Update(ctx, plan); err != nil {
+		return fmt.Errorf("failed to update plan: %w", err)
+	}
+
+	userIDs, err := s.subscriptionRepo.SyncPlanLimits(ctx, plan)
+	if err != nil {
+		return fmt.Errorf("failed to apply plan limits: %w", err)
+	}
+	bandwidthChanged := previous.UpMbps != plan.UpMbps || previous.DownMbps != plan.DownMbps
+	for _, userID := range userIDs {
+		s.invalidateUser(ctx, userID)
+		if bandwidthChanged {
+			syncUserNodes(ctx, s.orchestrator, s.logger, userID, true)
+		}
+	}
+	return nil
+}
+
+// DeletePlan removes a plan nobody ever subscribed to. Plans with
+// subscriptions are kept for their history and can be deactivated instead.
+func (s *planService) DeletePlan(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.GetPlan(ctx, id); err != nil {
+		return err
+	}
+	count, err := s.planRepo.CountSubscriptions(ctx, id)
+	if err != nil {
+		return fmt.Errorf("failed to count subscriptions: %w", err)
+	}
+	if count > 0 {
+		return serviceInterfaces.ErrPlanInUse
+	}
+	return s.planRepo.Delete(ctx, id)
+}
+
+func (s *planService) GetSubscription(ctx context.Context, userID uuid.UUID) (*models.Subscription, error) {
+	subscription, err := s.subscriptionRepo.GetLatestByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, serviceInterfaces.ErrNoSubscription
+		}
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	return subscription, nil
+}
+
+func (s *planService) Assign(ctx context.Context, userID, planID, actorID uuid.UUID, note *string) (*models.Subscription, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+	plan, err := s.GetPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.IsActive {
+		return nil, serviceInterfaces.ErrPlanInactive
+	}
+
+	now := time.Now()
+	subscription := &models.Subscription{
+		UserID:    userID,
+		PlanID:    plan.ID,
+		Status:    models.SubscriptionActive,
+		StartedAt: now,
+		EndsAt:    planEnd(plan, now),
+		ResetAt:   nextReset(now, now),
+		Plan:      plan,
+	}
+	assigned := &models.SubscriptionHistory{
+		Event:    models.SubscriptionEventAssigned,
+		ToPlanID: &plan.ID,
+		ToEndsAt: subscription.EndsAt,
+		DataUsed: user.DataUsed,
+		ActorID:  &actorID,
+		Note:     note,
+	}
+	change := &repoInterfaces.SubscriptionChange{
+		Subscription: subscription,
+		Limits:       planLimits(plan, subscription.EndsAt),
+	}
+	change.Limits.ResetDataUsed = true
+
+	current, err := s.subscriptionRepo.GetLatestByUserID(ctx, userID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if current != nil {
+		assigned.FromPlanID = &current.PlanID
+		assigned.FromEndsAt = current.EndsAt
+		if current.Status == models.SubscriptionActive {
+			change.Replaced = current
+			change.History = append(change.History, &models.SubscriptionHistory{
+				SubscriptionID: current.ID,
+				Event:          models.SubscriptionEventReplaced,
+				FromPlanID:     &current.PlanID,
+				ToPlanID:       &plan.ID,
+				FromEndsAt:     current.EndsAt,
+				DataUsed:       user.DataUsed,
+				ActorID:        &actorID,
+				Note:           note,
+			})
+		}
+		// The new subscription starts with fresh limits, whatever the
+		// old one was suspended for no longer applies
+		change.Limits.Reactivate = current.SuspendReason != nil
+	}
+	change.History = append(change.History, assigned)
+
+	upBefore, downBefore, err := s.Bandwidth(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.subscriptionRepo.Apply(ctx, change); err != nil {
+		return nil, fmt.Errorf("failed to assign plan: %w", err)
+	}
+	s.invalidateUser(ctx, userID)
+	upMbps, downMbps := effectiveBandwidth(user, plan)
+	syncUserNodes(ctx, s.orchestrator, s.logger, userID, upMbps != upBefore || downMbps != downBefore)
+
+	return subscription, nil
+}
+
+func (s *planService) Extend(ctx context.Context, userID uuid.UUID, days int, actorID uuid.UUID, note *string) (*models.Subscription, error) {
+	subscription, err := s.GetSubscription(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if subscription.EndsAt == nil {
+		return nil, serviceInterfaces.ErrSubscriptionNoEnd
+	}
+	if subscription.Plan == nil {
+		return nil, fmt.Errorf("subscription %s has no plan", subscription.ID)
+	}
+	if days == 0 {
+		days = subscription.Plan.DurationDays
+	}
+	if days <= 0 {
+		return nil, serviceInterfaces.ErrExtensionDaysMissing
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	// Time left on an active subscription is kept, an expired one restarts
+	// from now
+	now := time.Now()
+	from := *subscription.EndsAt
+	if from.Before(now) {
+		from = now
+	}
+	endsAt := from.AddDate(0, 0, days)
+
+	extended := &models.SubscriptionHistory{
+		Event:      models.SubscriptionEventExtended,
+		FromPlanID: &subscription.PlanID,
+		ToPlanID:   &subscription.PlanID,
+		FromEndsAt: subscription.EndsAt,
+		ToEndsAt:   &endsAt,
+		DataUsed:   user.DataUsed,
+		ActorID:    &actorID,
+		Note:       note,
+	}
+
+	limits := planLimits(subscription.Plan, &endsAt)
+	dataUsed := user.DataUsed
+	if subscription.Status == models.SubscriptionExpired {
+		// Renewal starts a new quota period
+		subscription.Status = models.SubscriptionActive
+		subscription.ResetAt = nextReset(subscription.StartedAt, now)
+		limits.ResetDataUsed = true
+		dataUsed = 0
+	}
+	subscription.RenewedAt = &now
+	subscription.EndsAt = &endsAt
+	liftSuspension(subscription, limits, dataUsed, now)
+
+	err = s.subscriptionRepo.Apply(ctx, &repoInterfaces.SubscriptionChange{
+		Subscription: subscription,
+		History:      []*models.SubscriptionHistory{extended},
+		Limits:       limits,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to extend subscription: %w", err)
+	}
+	s.invalidateUser(ctx, userID)
+
+	return subscription, nil
+}
+
+func (s *planService) ChangePlan(ctx context.Context, userID, planID, actorID uuid.UUID, note *string) (*models.Subscription, error) {
+	subscription, err := s.subscriptionRepo.GetActiveByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, serviceInterfaces.ErrNoSubscription
+		}
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	plan, err := s.GetPlan(ctx, planID)
+	if err != nil {
+		return nil, err
+	}
+	if !plan.IsActive {
+		return nil, serviceInterfaces.ErrPlanInactive
+	}
+	if plan.ID == subscription.PlanID {
+		return subscription, nil
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("user not found")
+	}
+
+	changed := &models.SubscriptionHistory{
+		Event:      models.SubscriptionEventPlanChanged,
+		FromPlanID: &subscription.PlanID,
+		ToPlanID:   &plan.ID,
+		FromEndsAt: subscription.EndsAt,
+		ToEndsAt:   subscription.EndsAt,
+		DataUsed:   user.DataUsed,
+		ActorID:    &actorID,
+		Note:       note,
+	}
+
+	previousPlan := subscription.Plan
+	subscription.PlanID = plan.ID
+	subscription.Plan = plan
+	limits := planLimits(plan, subscription.EndsAt)
+	liftSuspension(subscription, limits, user.DataUsed, time.Now())
+
+	err = s.subscriptionRepo.Apply(ctx, &repoInterfaces.SubscriptionChange{
+		Subscription: subscription,
+		History:      []*models.SubscriptionHistory{changed},
+		Limits:       limits,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to change plan: %w", err)
+	}
+	s.invalidateUser(ctx, userID)
+	upBefore, downBefore := effectiveBandwidth(user, previousPlan)
+	upMbps, downMbps := effectiveBandwidth(user, plan)
+	syncUserNodes(ctx, s.orchestrator, s.logger, userID, upMbps != upBefore || downMbps != downBefore)
+
+	return subscription, nil
+}
+
+func (s *planService) History(ctx context.Context, userID uuid.UUID, page, limit int) ([]*models.SubscriptionHistory, int64, error) {
+	return s.subscriptionRepo.ListHistory(ctx, userID, (page-1)*limit, limit)
+}
+
+func (s *planService) ProcessDue(ctx context.Context, now time.Time) error {
+	subscriptions, err := s.subscriptionRepo.ListDue(ctx, now)
+	if err != nil {
+		return fmt.Errorf("failed to list due subscriptions: %w", err)
+	}
+
+	for _, subscription := range subscriptions {
+		if err := s.processDue(ctx, subscription, now); err != nil {
+			s.logger.Error("Failed to process subscription", "subscription_id", subscription.ID, "user_id", subscription.UserID, "error", err)
+		}
+	}
+	return nil
+}
+
+// processDue expires a subscription whose term ended, leaving the
+// suspension to quota enforcement, or starts its next quota period
+func (s *planService) processDue(ctx context.Context, subscription *models.Subscription, now time.Time) error {
+	if subscription.Plan == nil {
+		return fmt.Errorf("subscription has no plan")
+	}
+	user, err := s.userRepo.GetByID(ctx, subscription.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to get user: %w", err)
+	}
+
+	entry := &models.SubscriptionHistory{
+		FromPlanID: &subscription.PlanID,
+		FromEndsAt: subscription.EndsAt,
+		DataUsed:   user.DataUsed,
+	}
+	change := &repoInterfaces.SubscriptionChange{
+		Subscription: subscription,
+		History:      []*models.SubscriptionHistory{entry},
+	}
+
+	if subscription.EndsAt != nil && !subscription.EndsAt.After(now) {
+		subscription.Status = models.SubscriptionExpired
+		entry.Event = models.SubscriptionEventExpired
+		s.logger.Info("Subscription expired", "subscription_id", subscription.ID, "user_id", subscription.UserID)
+	} else {
+		subscription.ResetAt = nextReset(subscription.StartedAt, now)
+		entry.Event = models.SubscriptionEventQuotaReset
+		entry.ToPlanID = &subscription.PlanID
+		entry.ToEndsAt = subscription.EndsAt
+		change.Limits = planLimits(subscription.Plan, subscription.EndsAt)
+		change.Limits.ResetDataUsed = true
+		liftSuspension(subscription, change.Limits, 0, now)
+		s.logger.Info("Subscription quota reset", "subscription_id", subscription.ID, "user_id", subscription.UserID, "data_used", user.DataUsed)
+	}
+
+	if err := s.subscriptionRepo.Apply(ctx, change); err != nil {
+		return err
+	}
+	s.invalidateUser(ctx, subscription.UserID)
+	return nil
+}
+
+func (s *planService) RecordSuspension(ctx context.Context, userID uuid.UUID, reason string) error {
+	subscription, err := s.subscriptionRepo.GetLatestByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to get subscription: %w", err)
+	}
+	return s.subscriptionRepo.SetSuspendReason(ctx, subscription.ID, &reason)
+}
+
+func (s *planService) Bandwidth(ctx context.Context, user *models.User) (int, int, error) {
+	if user.UpMbps > 0 && user.DownMbps > 0 {
+		return user.UpMbps, user.DownMbps, nil
+	}
+
+	subscription, err := s.subscriptionRepo.GetActiveByUserID(ctx, user.ID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user.UpMbps, user.DownMbps, nil
+		}
+		return 0, 0, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	upMbps, downMbps := effectiveBandwidth(user, subscription.Plan)
+	return upMbps, downMbps, nil
+}
+
+func (s *planService) invalidateUser(ctx context.Context, userID uuid.UUID) {
+	s.redis.Del(ctx, fmt.Sprintf("user:%s", userID.String()))
+}
+
+// effectiveBandwidth returns the user's own speed caps, falling back to the
+// plan's where the user has none. plan may be nil.
+func effectiveBandwidth(user *models.User, plan *models.Plan) (int, int) {
+	upMbps, downMbps := user.UpMbps, user.DownMbps
+	if plan != nil {
+		if upMbps == 0 {
+			upMbps = plan.UpMbps
+		}
+		if downMbps == 0 {
+			downMbps = plan.DownMbps
+		}
+	}
+	return upMbps, downMbps
+}
+
+// planLimits are the user limits a subscription to the plan sets
+func planLimits(plan *models.Plan, endsAt *time.Time) *repoInterfaces.UserLimits {
+	return &repoInterfaces.UserLimits{
+		DataLimit:   plan.DataLimit,
+		DeviceLimit: plan.DeviceLimit,
+		ExpiryDate:  endsAt,
+	}
+}
+
+// liftSuspension reactivates a user suspended by enforcement once the new
+// limits no longer give a reason to
+func liftSuspension(subscription *models.Subscription, limits *repoInterfaces.UserLimits, dataUsed int64, now time.Time) {
+	if subscription.SuspendReason == nil {
+		return
+	}
+	if limitViolation(dataUsed, limits.DataLimit, limits.ExpiryDate, now) != "" {
+		return
+	}
+	subscription.SuspendReason = nil
+	limits.Reactivate = true
+}
+
+func planEnd(plan *models.Plan, from time.Time) *time.Time {
+	if plan.DurationDays <= 0 {
+		return nil
+	}
+	endsAt := from.AddDate(0, 0, plan.DurationDays)
+	return &endsAt
+}
+
+// nextReset returns the first monthly anniversary of start after now.
+// Counting from start keeps the day of month, where stepping from the last
+// reset would drift after short months.
+func nextReset(start, now time.Time) time.Time {
+	for months := 1; ; months++ {
+		if next := addMonths(start, months); next.After(now) {
+			return next
+		}
+	}
+}
+
+// addMonths moves t by the given number of months, clamping the day to the
+// end of a shorter target month. AddDate would carry the overflow into the
+// next month, turning Jan 31 plus one month into Mar 3.
+func addMonths(t time.Time, months int) time.Time {
+	year, month, day := t.Date()
+	hour, minute, sec := t.Clock()
+
+	first := time.Date(year, month+time.Month(months), 1, hour, minute, sec, t.Nanosecond(), t.Location())
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, hour, minute, sec, t.Nanosecond(), t.Location())
+}
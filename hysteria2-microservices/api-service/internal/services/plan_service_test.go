@@ -0,0 +1,59 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 12, 30, 0, 0, time.UTC)
+}
+
+func TestAddMonths(t *testing.T) {
+	tests := []struct {
+		name   string
+		t      time.Time
+		months int
+		want   time.Time
+	}{
+		{"mid month", date(2024, time.March, 15), 1, date(2024, time.April, 15)},
+		{"end of january to leap february", date(2024, time.January, 31), 1, date(2024, time.February, 29)},
+		{"end of january to february", date(2023, time.January, 31), 1, date(2023, time.February, 28)},
+		{"end of january to march", date(2023, time.January, 31), 2, date(2023, time.March, 31)},
+		{"31st to 30 day month", date(2024, time.March, 31), 1, date(2024, time.April, 30)},
+		{"across the year", date(2023, time.December, 31), 2, date(2024, time.February, 29)},
+		{"leap day to next year", date(2024, time.February, 29), 12, date(2025, time.February, 28)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addMonths(tt.t, tt.months); !got.Equal(tt.want) {
+				t.Errorf("addMonths(%v, %d) = %v, want %v", tt.t, tt.months, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextReset(t *testing.T) {
+	tests := []struct {
+		name  string
+		start time.Time
+		now   time.Time
+		want  time.Time
+	}{
+		{"first month", date(2024, time.March, 15), date(2024, time.March, 20), date(2024, time.April, 15)},
+		{"later month", date(2024, time.March, 15), date(2024, time.July, 1), date(2024, time.July, 15)},
+		{"on the anniversary", date(2024, time.March, 15), date(2024, time.April, 15), date(2024, time.May, 15)},
+		{"just before the anniversary", date(2024, time.March, 15), date(2024, time.April, 15).Add(-time.Second), date(2024, time.April, 15)},
+		{"clamped in february", date(2024, time.January, 31), date(2024, time.February, 1), date(2024, time.February, 29)},
+		{"day restored after february", date(2024, time.January, 31), date(2024, time.February, 29), date(2024, time.March, 31)},
+		{"clamped in a 30 day month", date(2023, time.January, 31), date(2023, time.April, 1), date(2023, time.April, 30)},
+		{"after a year", date(2023, time.January, 31), date(2024, time.February, 10), date(2024, time.February, 29)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextReset(tt.start, tt.now); !got.Equal(tt.want) {
+				t.Errorf("nextReset(%v, %v) = %v, want %v", tt.start, tt.now, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,289 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/cache"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/mailer"
+	"hysteria2-microservices/api-service/pkg/password"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// emailVerificationPrefix + hash of the token holds the ID of the user
+	// the verification link was sent to
+	emailVerificationPrefix = "registration:verify_email:"
+	emailVerificationTTL    = 24 * time.Hour
+
+	// Invite codes are 16 base32 characters
+	inviteCodeBytes = 10
+)
+
+type registrationService struct {
+	userRepo   repoInterfaces.UserRepository
+	inviteRepo repoInterfaces.InviteCodeRepository
+	redis      *cache.RedisClient
+	passwords  *password.Hasher
+	mailer     mailer.Mailer
+	logger     *logger.Logger
+	mode       string
+	publicURL  string
+}
+
+func NewRegistrationService(userRepo repoInterfaces.UserRepository, inviteRepo repoInterfaces.InviteCodeRepository, redis *cache.RedisClient, passwords *password.Hasher, mailer mailer.Mailer, logger *logger.Logger, mode, publicURL string) serviceInterfaces.RegistrationService {
+	return &registrationService{
+		userRepo:   userRepo,
+		inviteRepo: inviteRepo,
+		redis:      redis,
+		passwords:  passwords,
+		mailer:     mailer,
+		logger:     logger,
+		mode:       mode,
+		publicURL:  strings.TrimRight(publicURL, "/"),
+	}
+}
+
+func (s *registrationService) Mode() string {
+	return s.mode
+}
+
+func (s *registrationService) Register(ctx context.Context, req *serviceInterfaces.RegisterRequest) (*models.User, error) {
+	if s.mode == serviceInterfaces.RegistrationClosed {
+		return nil, serviceInterfaces.ErrRegistrationClosed
+	}
+	code := normalizeInviteCode(req.InviteCode)
+	if s.mode == serviceInterfaces.RegistrationInvite && code == "" {
+		return nil, serviceInterfaces.ErrInviteRequired
+	}
+
+	// Check if user already exists
+	if _, err := s.userRepo.GetByUsername(ctx, req.Username); err == nil {
+		return nil, fmt.Errorf("username already exists")
+	}
+	if _, err := s.userRepo.GetByEmail(ctx, req.Email); err == nil {
+		return nil, fmt.Errorf("email already exists")
+	}
+
+	if err := password.Validate(req.Password); err != nil {
+		return nil, err
+	}
+	hashedPassword, err := s.passwords.Hash(req.Password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user := &models.User{
+		Username: req.Username,
+		Email:    req.Email,
+		Password: hashedPassword,
+		Status:   "active",
+		Role:     models.RoleUser,
+	}
+
+	var invite *models.InviteCode
+	if code != "" {
+		invite, err = s.inviteRepo.Redeem(ctx, code, time.Now())
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, serviceInterfaces.ErrInviteInvalid
+			}
+			return nil, fmt.Errorf("failed to redeem invite code: %w", err)
+		}
+		applyInvite(user, invite)
+	}
+
+	switch s.mode {
+	case serviceInterfaces.RegistrationEmail:
+		user.Status = models.UserStatusPendingVerification
+	case serviceInterfaces.RegistrationApproval:
+		if invite == nil {
+			user.Status = models.UserStatusPendingApproval
+		}
+	}
+
+	if err := s.userRepo.Create(ctx, user); err != nil {
+		if invite != nil {
+			if releaseErr := s.inviteRepo.Release(ctx, invite.ID); releaseErr != nil {
+				s.logger.Warn("Failed to release invite code", "error", releaseErr, "invite_id", invite.ID)
+			}
+		}
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	if user.Status == models.UserStatusPendingVerification {
+		// The user can ask for another link if this one is lost
+		if err := s.sendVerification(ctx, user); err != nil {
+			s.logger.Error("Failed to send verification email", "error", err, "user_id", user.ID)
+		}
+	}
+
+	return user, nil
+}
+
+// VerifyEmail activates the account the verification link was sent for
+func (s *registrationService) VerifyEmail(ctx context.Context, token string) (*models.User, error) {
+	if token == "" {
+		return nil, serviceInterfaces.ErrVerificationTokenInvalid
+	}
+
+	key := emailVerificationPrefix + hashToken(token)
+	var userID uuid.UUID
+	if err := s.redis.Get(ctx, key, &userID); err != nil {
+		return nil, serviceInterfaces.ErrVerificationTokenInvalid
+	}
+
+	verified, err := s.userRepo.MarkEmailVerified(ctx, userID, "active")
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify email: %w", err)
+	}
+	s.redis.Del(ctx, key)
+	if !verified {
+		return nil, serviceInterfaces.ErrVerificationTokenInvalid
+	}
+
+	return s.userRepo.GetByID(ctx, userID)
+}
+
+func (s *registrationService) ResendVerification(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil || user.Status != models.UserStatusPendingVerification {
+		return nil
+	}
+	return s.sendVerification(ctx, user)
+}
+
+func (s *registrationService) CreateInvite(ctx context.Context, createdBy uuid.UUID, req *serviceInterfaces.CreateInviteRequest) (*models.InviteCode, error) {
+	code, err := generateInviteCode()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate invite code: %w", err)
+	}
+
+	invite := &models.InviteCode{
+		Code:        code,
+		MaxUses:     req.MaxUses,
+		ExpiresAt:   req.ExpiresAt,
+		DataLimit:   req.DataLimit,
+		ValidDays:   req.ValidDays,
+		DeviceLimit: req.DeviceLimit,
+		Note:        req.Note,
+		CreatedBy:   createdBy,
+	}
+	if err := s.inviteRepo.Create(ctx, invite); err != nil {
+		return nil, fmt.Errorf("failed to create invite code: %w", err)
+	}
+	return invite, nil
+}
+
+func (s *registrationService) ListInvites(ctx context.Context, page, limit int) ([]*models.InviteCode, int64, error) {
+	return s.inviteRepo.List(ctx, (page-1)*limit, limit)
+}
+
+func (s *registrationService) DeleteInvite(ctx context.Context, id uuid.UUID) error {
+	if _, err := s.inviteRepo.GetByID(ctx, id); err != nil {
+		return serviceInterfaces.ErrInviteNotFound
+	}
+	return s.inviteRepo.Delete(ctx, id)
+}
+
+// ListPending lists the accounts awaiting approval, newest first
+func (s *registrationService) ListPending(ctx context.Context, page, limit int) ([]*models.User, int64, error) {
+	return s.userRepo.List(ctx, (page-1)*limit, limit, "", models.UserStatusPendingApproval, "")
+}
+
+func (s *registrationService) Approve(ctx context.Context, userID uuid.UUID) (*models.User, error) {
+	approved, err := s.userRepo.TransitionStatus(ctx, userID, models.UserStatusPendingApproval, "active")
+	if err != nil {
+		return nil, fmt.Errorf("failed to approve user: %w", err)
+	}
+	if !approved {
+		return nil, serviceInterfaces.ErrUserNotPending
+	}
+
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	err = s.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Your account was approved",
+		Body:    fmt.Sprintf("Hello %s,\n\nyour account was approved, you can sign in now.\n", user.Username),
+	})
+	if err != nil {
+		s.logger.Warn("Failed to send approval email", "error", err, "user_id", user.ID)
+	}
+	return user, nil
+}
+
+// Reject deletes an account awaiting approval, freeing its username and
+// email for a new registration
+func (s *registrationService) Reject(ctx context.Context, userID uuid.UUID) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil || user.Status != models.UserStatusPendingApproval {
+		return serviceInterfaces.ErrUserNotPending
+	}
+	if err := s.userRepo.Delete(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+	return nil
+}
+
+// sendVerification mails a new verification link. Earlier links stay valid
+// until they expire.
+func (s *registrationService) sendVerification(ctx context.Context, user *models.User) error {
+	token, err := randomToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+	if err := s.redis.Set(ctx, emailVerificationPrefix+hashToken(token), user.ID, emailVerificationTTL); err != nil {
+		return fmt.Errorf("failed to store token: %w", err)
+	}
+
+	link := s.publicURL + "/api/v1/auth/verify-email?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, &mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nplease confirm your email address by opening this link within %d hours:\n\n%s\n\nIf you did not register, ignore this email.\n",
+			user.Username, int(emailVerificationTTL.Hours()), link),
+	})
+}
+
+// applyInvite gives a new account the defaults of its invite code
+func applyInvite(user *models.User, invite *models.InviteCode) {
+	user.InviteCodeID = &invite.ID
+	user.DataLimit = invite.DataLimit
+	user.DeviceLimit = invite.DeviceLimit
+	if invite.ValidDays > 0 {
+		expiry := time.Now().AddDate(0, 0, invite.ValidDays)
+		user.ExpiryDate = &expiry
+	}
+}
+
+var inviteEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func generateInviteCode() (string, error) {
+	b := make([]byte, inviteCodeBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return inviteEncoding.EncodeToString(b), nil
+}
+
+// normalizeInviteCode accepts codes with any case and grouping
+func normalizeInviteCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
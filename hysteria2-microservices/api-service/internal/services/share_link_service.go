@@ -0,0 +1,474 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"hysteria2-microservices/api-service/internal/models"
+	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
+	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
+	"hysteria2-microservices/api-service/pkg/logger"
+
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+)
+
+const defaultHysteriaPort = 443
+
+// Node metadata keys that override what is derived from the deployed config.
+// obfs_password is written by the orchestrator when a config with a new
+// Salamander password was deployed.
+const (
+	nodeMetaPublicHost   = "public_host"
+	nodeMetaPort         = "port"
+	nodeMetaHopPorts     = "hop_ports"
+	nodeMetaSNI          = "sni"
+	nodeMetaInsecure     = "insecure"
+	nodeMetaPinSHA256    = "pin_sha256"
+	nodeMetaObfsPassword = "obfs_password"
+	// Node group that plans allow or exclude
+	nodeMetaGroup = "group"
+)
+
+type shareLinkService struct {
+	userRepo         repoInterfaces.UserRepository
+	deviceRepo       repoInterfaces.DeviceRepository
+	nodeRepo         repoInterfaces.NodeRepository
+	subscriptionRepo repoInterfaces.SubscriptionRepository
+	logger           *logger.Logger
+}
+
+func NewShareLinkService(userRepo repoInterfaces.UserRepository, deviceRepo repoInterfaces.DeviceRepository, nodeRepo repoInterfaces.NodeRepository, subscriptionRepo repoInterfaces.SubscriptionRepository, logger *logger.Logger) serviceInterfaces.ShareLinkService {
+	return &shareLinkService{
+		userRepo:         userRepo,
+		deviceRepo:       deviceRepo,
+		nodeRepo:         nodeRepo,
+		subscriptionRepo: subscriptionRepo,
+		logger:           logger,
+	}
+}
+
+// GetShareLinks builds one link per active device and node the user may use
+func (s *shareLinkService) GetShareLinks(ctx context.Context, userID uuid.UUID) ([]*models.ShareLink, error) {
+	devices, err := s.deviceRepo.GetByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get devices: %w", err)
+	}
+
+	nodes, err := userNodes(ctx, s.nodeRepo, s.subscriptionRepo, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]*models.ShareLink, 0, len(devices)*len(nodes))
+	for _, node := range nodes {
+		endpoint := loadNodeEndpoint(ctx, s.nodeRepo, node, s.logger)
+		for _, device := range devices {
+			if device.Status != "active" || device.AuthSecret == "" {
+				continue
+			}
+			links = append(links, endpoint.shareLink(node, device))
+		}
+	}
+
+	return links, nil
+}
+
+// GetSubscriptionToken returns the user's subscription token, creating it on first use
+func (s *shareLinkService) GetSubscriptionToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if user.SubscriptionToken != nil && *user.SubscriptionToken != "" {
+		return *user.SubscriptionToken, nil
+	}
+	return s.RotateSubscriptionToken(ctx, userID)
+}
+
+// RotateSubscriptionToken replaces the token, so a leaked subscription URL stops working
+func (s *shareLinkService) RotateSubscriptionToken(ctx context.Context, userID uuid.UUID) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate subscription token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := s.userRepo.SetSubscriptionToken(ctx, userID, token); err != nil {
+		return "", fmt.Errorf("failed to store subscription token: %w", err)
+	}
+	return token, nil
+}
+
+// RenderSubscription renders the links of the token's owner in the requested format
+func (s *shareLinkService) RenderSubscription(ctx context.Context, token, format string) (*serviceInterfaces.SubscriptionFeed, error) {
+	if format == "" {
+		format = serviceInterfaces.FeedFormatBase64
+	}
+	switch format {
+	case serviceInterfaces.FeedFormatPlain, serviceInterfaces.FeedFormatBase64,
+		serviceInterfaces.FeedFormatClash, serviceInterfaces.FeedFormatSingBox:
+	default:
+		return nil, serviceInterfaces.ErrUnsupportedFormat
+	}
+
+	if token == "" {
+		return nil, serviceInterfaces.ErrSubscriptionNotFound
+	}
+	user, err := s.userRepo.GetBySubscriptionToken(ctx, token)
+	if err != nil {
+		return nil, serviceInterfaces.ErrSubscriptionNotFound
+	}
+	if user.Status != "active" {
+		return nil, serviceInterfaces.ErrSubscriptionInactive
+	}
+
+	links, err := s.GetShareLinks(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &serviceInterfaces.SubscriptionFeed{User: user}
+	switch format {
+	case serviceInterfaces.FeedFormatPlain:
+		feed.ContentType = "text/plain; charset=utf-8"
+		feed.Body = []byte(plainFeed(links))
+	case serviceInterfaces.FeedFormatBase64:
+		feed.ContentType = "text/plain; charset=utf-8"
+		feed.Body = []byte(base64.StdEncoding.EncodeToString([]byte(plainFeed(links))))
+	case serviceInterfaces.FeedFormatClash:
+		feed.ContentType = "text/yaml; charset=utf-8"
+		feed.Body, err = clashFeed(links)
+	case serviceInterfaces.FeedFormatSingBox:
+		feed.ContentType = "application/json"
+		feed.Body, err = singBoxFeed(links)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s subscription: %w", format, err)
+	}
+
+	return feed, nil
+}
+
+// nodeEndpoint holds the connection parameters clients need for a node
+type nodeEndpoint struct {
+	server       string
+	port         int
+	hopPorts     string
+	obfs         string
+	obfsPassword string
+	sni          string
+	insecure     bool
+	pinSHA256    string
+}
+
+// userNodes returns the nodes assigned to the user that the plan of their
+// active subscription allows
+func userNodes(ctx context.Context, nodeRepo repoInterfaces.NodeRepository, subscriptionRepo repoInterfaces.SubscriptionRepository, userID uuid.UUID) ([]*models.VPSNode, error) {
+	nodes, err := nodeRepo.GetAssignedToUser(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assigned nodes: %w", err)
+	}
+
+	subscription, err := subscriptionRepo.GetActiveByUserID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nodes, nil
+		}
+		return nil, fmt.Errorf("failed to get subscription: %w", err)
+	}
+	if subscription.Plan == nil || len(subscription.Plan.NodeGroups) == 0 {
+		return nodes, nil
+	}
+
+	allowed := make([]*models.VPSNode, 0, len(nodes))
+	for _, node := range nodes {
+		group := metadataString(node.Metadata, nodeMetaGroup)
+		for _, g := range subscription.Plan.NodeGroups {
+			if g == group {
+				allowed = append(allowed, node)
+				break
+			}
+		}
+	}
+	return allowed, nil
+}
+
+// loadNodeEndpoint reads the node's parameters from the config version it
+// runs, then applies the overrides kept in its metadata
+func loadNodeEndpoint(ctx context.Context, nodeRepo repoInterfaces.NodeRepository, node *models.VPSNode, logger *logger.Logger) *nodeEndpoint {
+	endpoint := &nodeEndpoint{server: node.IPAddress, port: defaultHysteriaPort}
+
+	if node.ConfigVersion != "" {
+		config, err := nodeRepo.GetConfigVersion(ctx, node.ID, node.ConfigVersion)
+		if err != nil {
+			logger.Warn("Failed to load node config for share links", "node_id", node.ID, "version", node.ConfigVersion, "error", err)
+		} else if err := endpoint.applyServerConfig(config.Content); err != nil {
+			logger.Warn("Failed to parse node config for share links", "node_id", node.ID, "version", node.ConfigVersion, "error", err)
+		}
+	}
+
+	endpoint.applyMetadata(node.Metadata)
+	return endpoint
+}
+
+func (e *nodeEndpoint) applyServerConfig(content string) error {
+	var config struct {
+		Listen string `json:"listen"`
+		Obfs   struct {
+			Type       string `json:"type"`
+			Password   string `json:"password"`
+			Salamander struct {
+				Password string `json:"password"`
+			} `json:"salamander"`
+		} `json:"obfs"`
+		ACME struct {
+			Domains []string `json:"domains"`
+		} `json:"acme"`
+	}
+	if err := json.Unmarshal([]byte(content), &config); err != nil {
+		return err
+	}
+
+	if _, port, err := net.SplitHostPort(config.Listen); err == nil {
+		if p, err := strconv.Atoi(port); err == nil && p > 0 {
+			e.port = p
+		}
+	}
+
+	if config.Obfs.Type == "salamander" {
+		e.obfs = "salamander"
+		e.obfsPassword = config.Obfs.Salamander.Password
+		if e.obfsPassword == "" {
+			e.obfsPassword = config.Obfs.Password
+		}
+	}
+
+	if len(config.ACME.Domains) > 0 {
+		e.sni = config.ACME.Domains[0]
+	}
+	return nil
+}
+
+func (e *nodeEndpoint) applyMetadata(metadata map[string]interface{}) {
+	if v := metadataString(metadata, nodeMetaPublicHost); v != "" {
+		e.server = v
+	}
+	if p, err := strconv.Atoi(metadataString(metadata, nodeMetaPort)); err == nil && p > 0 {
+		e.port = p
+	}
+	if v := metadataString(metadata, nodeMetaHopPorts); v != "" {
+		e.hopPorts = v
+	}
+	if v := metadataString(metadata, nodeMetaSNI); v != "" {
+		e.sni = v
+	}
+	if v := metadataString(metadata, nodeMetaInsecure); v != "" {
+		e.insecure = v == "true" || v == "1"
+	}
+	if v := metadataString(metadata, nodeMetaPinSHA256); v != "" {
+		e.pinSHA256 = v
+	}
+	if v := metadataString(metadata, nodeMetaObfsPassword); v != "" {
+		e.obfs = "salamander"
+		e.obfsPassword = v
+	}
+}
+
+func metadataString(metadata map[string]interface{}, key string) string {
+	v, ok := metadata[key]
+	if !ok || v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+// shareLink renders the hy2:// URI in the form the Windows client parses:
+// obfs, obfs-password, sni, insecure, pinSHA256 and mport for port hopping
+func (e *nodeEndpoint) shareLink(node *models.VPSNode, device *models.Device) *models.ShareLink {
+	link := &models.ShareLink{
+		NodeID:       node.ID,
+		NodeName:     node.Name,
+		Location:     node.Location,
+		DeviceID:     device.ID,
+		DeviceName:   device.Name,
+		Name:         fmt.Sprintf("%s - %s", node.Name, device.Name),
+		Server:       e.server,
+		Port:         e.port,
+		HopPorts:     e.hopPorts,
+		Auth:         hysteriaAuth(device),
+		Obfs:         e.obfs,
+		ObfsPassword: e.obfsPassword,
+		SNI:          e.sni,
+		Insecure:     e.insecure,
+		PinSHA256:    e.pinSHA256,
+	}
+
+	query := url.Values{}
+	if link.Obfs != "" {
+		query.Set("obfs", link.Obfs)
+		query.Set("obfs-password", link.ObfsPassword)
+	}
+	if link.SNI != "" {
+		query.Set("sni", link.SNI)
+	}
+	if link.Insecure {
+		query.Set("insecure", "1")
+	}
+	if link.PinSHA256 != "" {
+		query.Set("pinSHA256", link.PinSHA256)
+	}
+	if link.HopPorts != "" {
+		query.Set("mport", link.HopPorts)
+	}
+
+	uri := url.URL{
+		Scheme:   "hy2",
+		User:     url.UserPassword(device.ID.String(), device.AuthSecret),
+		Host:     net.JoinHostPort(link.Server, strconv.Itoa(link.Port)),
+		Path:     "/",
+		RawQuery: query.Encode(),
+		Fragment: link.Name,
+	}
+	link.URI = uri.String()
+
+	return link
+}
+
+// hysteriaAuth is the device's Hysteria2 credential. The device UUID is the
+// client ID both the HTTP auth hook and node user tables know it by.
+func hysteriaAuth(device *models.Device) string {
+	return device.ID.String() + ":" + device.AuthSecret
+}
+
+func plainFeed(links []*models.ShareLink) string {
+	uris := make([]string, 0, len(links))
+	for _, link := range links {
+		uris = append(uris, link.URI)
+	}
+	return strings.Join(uris, "\n")
+}
+
+type clashProxy struct {
+	Name           string `yaml:"name"`
+	Type           string `yaml:"type"`
+	Server         string `yaml:"server"`
+	Port           int    `yaml:"port"`
+	Ports          string `yaml:"ports,omitempty"`
+	Password       string `yaml:"password"`
+	Obfs           string `yaml:"obfs,omitempty"`
+	ObfsPassword   string `yaml:"obfs-password,omitempty"`
+	SNI            string `yaml:"sni,omitempty"`
+	SkipCertVerify bool   `yaml:"skip-cert-verify"`
+	Fingerprint    string `yaml:"fingerprint,omitempty"`
+}
+
+type clashProxyGroup struct {
+	Name    string   `yaml:"name"`
+	Type    string   `yaml:"type"`
+	Proxies []string `yaml:"proxies"`
+}
+
+// clashFeed renders a Clash Meta (mihomo) profile with a selector over all links
+func clashFeed(links []*models.ShareLink) ([]byte, error) {
+	proxies := make([]clashProxy, 0, len(links))
+	names := make([]string, 0, len(links))
+	for _, link := range links {
+		proxies = append(proxies, clashProxy{
+			Name:           link.Name,
+			Type:           "hysteria2",
+			Server:         link.Server,
+			Port:           link.Port,
+			Ports:          link.HopPorts,
+			Password:       link.Auth,
+			Obfs:           link.Obfs,
+			ObfsPassword:   link.ObfsPassword,
+			SNI:            link.SNI,
+			SkipCertVerify: link.Insecure,
+			Fingerprint:    link.PinSHA256,
+		})
+		names = append(names, link.Name)
+	}
+
+	return yaml.Marshal(struct {
+		Proxies     []clashProxy      `yaml:"proxies"`
+		ProxyGroups []clashProxyGroup `yaml:"proxy-groups"`
+		Rules       []string          `yaml:"rules"`
+	}{
+		Proxies:     proxies,
+		ProxyGroups: []clashProxyGroup{{Name: "HysteryVPN", Type: "select", Proxies: names}},
+		Rules:       []string{"MATCH,HysteryVPN"},
+	})
+}
+
+type singBoxObfs struct {
+	Type     string `json:"type"`
+	Password string `json:"password"`
+}
+
+type singBoxTLS struct {
+	Enabled    bool   `json:"enabled"`
+	ServerName string `json:"server_name,omitempty"`
+	Insecure   bool   `json:"insecure,omitempty"`
+}
+
+type singBoxOutbound struct {
+	Type        string       `json:"type"`
+	Tag         string       `json:"tag"`
+	Server      string       `json:"server,omitempty"`
+	ServerPort  int          `json:"server_port,omitempty"`
+	ServerPorts []string     `json:"server_ports,omitempty"`
+	Password    string       `json:"password,omitempty"`
+	Obfs        *singBoxObfs `json:"obfs,omitempty"`
+	TLS         *singBoxTLS  `json:"tls,omitempty"`
+	Outbounds   []string     `json:"outbounds,omitempty"`
+}
+
+// singBoxFeed renders the outbounds section of a sing-box config with a
+// selector over all links
+func singBoxFeed(links []*models.ShareLink) ([]byte, error) {
+	outbounds := make([]singBoxOutbound, 0, len(links)+1)
+	tags := make([]string, 0, len(links))
+	for _, link := range links {
+		outbound := singBoxOutbound{
+			Type:       "hysteria2",
+			Tag:        link.Name,
+			Server:     link.Server,
+			ServerPort: link.Port,
+			Password:   link.Auth,
+			TLS: &singBoxTLS{
+				Enabled:    true,
+				ServerName: link.SNI,
+				Insecure:   link.Insecure,
+			},
+		}
+		if link.HopPorts != "" {
+			// sing-box writes port ranges as "start:end"
+			for _, ports := range strings.Split(link.HopPorts, ",") {
+				outbound.ServerPorts = append(outbound.ServerPorts, strings.ReplaceAll(strings.TrimSpace(ports), "-", ":"))
+			}
+		}
+		if link.Obfs != "" {
+			outbound.Obfs = &singBoxObfs{Type: link.Obfs, Password: link.ObfsPassword}
+		}
+		outbounds = append(outbounds, outbound)
+		tags = append(tags, link.Name)
+	}
+	if len(tags) > 0 {
+		outbounds = append(outbounds, singBoxOutbound{Type: "selector", Tag: "proxy", Outbounds: tags})
+	}
+
+	return json.MarshalIndent(struct {
+		Outbounds []singBoxOutbound `json:"outbounds"`
+	}{Outbounds: outbounds}, "", "  ")
+}
@@ -2,12 +2,14 @@ package services
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"hysteria2-microservices/api-service/internal/models"
 	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
 	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
 	"hysteria2-microservices/api-service/pkg/cache"
+	"hysteria2-microservices/api-service/pkg/logger"
 
 	"github.com/google/uuid"
 )
@@ -16,25 +18,50 @@ type trafficService struct {
 	trafficRepo      repoInterfaces.TrafficRepository
 	redis            *cache.RedisClient
 	webSocketService serviceInterfaces.WebSocketService
+	enforcement      serviceInterfaces.EnforcementService
+	logger           *logger.Logger
 }
 
-func NewTrafficService(trafficRepo repoInterfaces.TrafficRepository, redis *cache.RedisClient, wsService serviceInterfaces.WebSocketService) serviceInterfaces.TrafficService {
+func NewTrafficService(trafficRepo repoInterfaces.TrafficRepository, redis *cache.RedisClient, wsService serviceInterfaces.WebSocketService, enforcement serviceInterfaces.EnforcementService, logger *logger.Logger) serviceInterfaces.TrafficService {
 	return &trafficService{
 		trafficRepo:      trafficRepo,
 		redis:            redis,
 		webSocketService: wsService,
+		enforcement:      enforcement,
+		logger:           logger,
 	}
 }
 
-func (s *trafficService) RecordTraffic(ctx context.Context, stats *models.TrafficStats) error {
-	// Record traffic in database
-	if err := s.trafficRepo.Create(ctx, stats); err != nil {
-		return err
+// RecordTraffic stores the traffic of a node report and adds it to the
+// users' and devices' usage atomically. A report that was already recorded
+// is skipped. Users whose usage grew are then checked against their quota.
+// The traffic is counted at that point, so a failed check is only logged and
+// left to the enforcement sweep.
+func (s *trafficService) RecordTraffic(ctx context.Context, report *models.TrafficReport) error {
+	recorded, err := s.trafficRepo.CreateBatch(ctx, report)
+	if err != nil {
+		return fmt.Errorf("failed to store traffic: %w", err)
 	}
+	if !recorded {
+		s.logger.Info("Skipping traffic report that was already recorded", "report_id", report.ID, "node_id", report.NodeID)
+		return nil
+	}
+
+	checked := make(map[uuid.UUID]bool)
+	for _, st := range report.Stats {
+		// Send real-time update via WebSocket
+		if s.webSocketService != nil {
+			go s.webSocketService.BroadcastTrafficUpdate(st.UserID, st)
+		}
+
+		if s.enforcement == nil || checked[st.UserID] || st.Upload+st.Download == 0 {
+			continue
+		}
+		checked[st.UserID] = true
 
-	// Send real-time update via WebSocket
-	if s.webSocketService != nil && s.webSocketService.IsUserConnected(stats.UserID) {
-		go s.webSocketService.BroadcastTrafficUpdate(stats.UserID, stats)
+		if err := s.enforcement.CheckUser(ctx, st.UserID); err != nil {
+			s.logger.Error("Failed to enforce quota on recorded traffic", "error", err, "user_id", st.UserID)
+		}
 	}
 
 	return nil
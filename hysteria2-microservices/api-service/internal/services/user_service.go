@@ -8,25 +8,46 @@ import (
 	repoInterfaces "hysteria2-microservices/api-service/internal/repositories/interfaces"
 	serviceInterfaces "hysteria2-microservices/api-service/internal/services/interfaces"
 	"hysteria2-microservices/api-service/pkg/cache"
+	"hysteria2-microservices/api-service/pkg/logger"
+	"hysteria2-microservices/api-service/pkg/orchestrator"
+	"hysteria2-microservices/api-service/pkg/password"
 
 	"github.com/google/uuid"
 )
 
 type userService struct {
-	userRepo   repoInterfaces.UserRepository
-	deviceRepo repoInterfaces.DeviceRepository
-	redis      *cache.RedisClient
+	userRepo     repoInterfaces.UserRepository
+	deviceRepo   repoInterfaces.DeviceRepository
+	authService  serviceInterfaces.AuthService
+	orchestrator *orchestrator.Client
+	redis        *cache.RedisClient
+	passwords    *password.Hasher
+	logger       *logger.Logger
 }
 
-func NewUserService(userRepo repoInterfaces.UserRepository, deviceRepo repoInterfaces.DeviceRepository, redis *cache.RedisClient) serviceInterfaces.UserService {
+func NewUserService(userRepo repoInterfaces.UserRepository, deviceRepo repoInterfaces.DeviceRepository, authService serviceInterfaces.AuthService, orchestratorClient *orchestrator.Client, redis *cache.RedisClient, passwords *password.Hasher, logger *logger.Logger) serviceInterfaces.UserService {
 	return &userService{
-		userRepo:   userRepo,
-		deviceRepo: deviceRepo,
-		redis:      redis,
+		userRepo:     userRepo,
+		deviceRepo:   deviceRepo,
+		authService:  authService,
+		orchestrator: orchestratorClient,
+		redis:        redis,
+		passwords:    passwords,
+		logger:       logger,
 	}
 }
 
+// CreateUser stores a new user, hashing the plain text password it carries
 func (s *userService) CreateUser(ctx context.Context, user *models.User) error {
+	if err := password.Validate(user.Password); err != nil {
+		return err
+	}
+	hashed, err := s.passwords.Hash(user.Password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+	user.Password = hashed
+
 	return s.userRepo.Create(ctx, user)
 }
 
@@ -50,16 +71,50 @@ func (s *userService) GetUserByID(ctx context.Context, id uuid.UUID) (*models.Us
 	return user, nil
 }
 
+// UpdateUser stores the user's profile, role and limits. They are applied
+// to a fresh copy of the user, so a stale or cached copy cannot undo counted
+// usage or drop the credentials it does not carry. The status is kept:
+// status changes go through EnforcementService.SetStatus, which signs the
+// user out and updates the nodes. Changed speed limits are pushed to the
+// nodes, and a role change revokes the user's sessions as their tokens
+// carry the old role. user is refreshed with the stored record.
 func (s *userService) UpdateUser(ctx context.Context, user *models.User) error {
+	previous, err := s.userRepo.GetByID(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	updated := *previous
+	updated.Username = user.Username
+	updated.Email = user.Email
+	updated.FullName = user.FullName
+	updated.Role = user.Role
+	updated.DataLimit = user.DataLimit
+	updated.DeviceLimit = user.DeviceLimit
+	updated.UpMbps = user.UpMbps
+	updated.DownMbps = user.DownMbps
+	updated.Notes = user.Notes
+
 	// Update in database
-	if err := s.userRepo.Update(ctx, user); err != nil {
+	if err := s.userRepo.Update(ctx, &updated); err != nil {
 		return err
 	}
+	*user = updated
 
 	// Invalidate cache
 	cacheKey := fmt.Sprintf("user:%s", user.ID.String())
 	s.redis.Del(ctx, cacheKey)
 
+	if previous.UpMbps != user.UpMbps || previous.DownMbps != user.DownMbps {
+		syncUserNodes(ctx, s.orchestrator, s.logger, user.ID, true)
+	}
+
+	if previous.Role != user.Role {
+		if err := s.authService.InvalidateUserSessions(ctx, user.ID); err != nil {
+			return fmt.Errorf("failed to revoke sessions after role change: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -104,3 +159,35 @@ func (s *userService) GetUserDevices(ctx context.Context, userID uuid.UUID) ([]*
 func (s *userService) UpdateUserDataUsage(ctx context.Context, userID uuid.UUID, dataUsed int64) error {
 	return s.userRepo.UpdateDataUsage(ctx, userID, dataUsed)
 }
+
+// syncUserNodes pushes the user's entry, speed limits included, to the
+// nodes. Hysteria2 takes a client's limits from the auth response when it
+// connects, so with reconnect the user's clients are disconnected to pick
+// up changed limits, whether the node authenticates against its own table
+// or this service. The change is already stored, so nodes that miss the
+// push pick it up on their next resync and a failure is only logged.
+func syncUserNodes(ctx context.Context, client *orchestrator.Client, logger *logger.Logger, userID uuid.UUID, reconnect bool) {
+	if client == nil {
+		return
+	}
+	resp, err := client.SyncUser(ctx, userID.String(), nil)
+	if err != nil {
+		logger.Error("Failed to push user limits to nodes", "error", err, "user_id", userID)
+		return
+	}
+	if len(resp.Errors) > 0 {
+		logger.Warn("User limits not pushed to every node", "user_id", userID, "failed", len(resp.Errors), "nodes", resp.NodesTotal)
+	}
+
+	if !reconnect {
+		return
+	}
+	resp, err = client.DisconnectUser(ctx, userID.String(), nil)
+	if err != nil {
+		logger.Error("Failed to reconnect user for new limits", "error", err, "user_id", userID)
+		return
+	}
+	if len(resp.Errors) > 0 {
+		logger.Warn("User not reconnected on every node", "user_id", userID, "failed", len(resp.Errors), "nodes", resp.NodesTotal)
+	}
+}
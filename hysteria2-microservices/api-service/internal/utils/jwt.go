@@ -10,22 +10,22 @@ import (
 	"github.com/google/uuid"
 )
 
-func GenerateJWT(userID uuid.UUID, secret string, expiry time.Duration) (string, error) {
+// GenerateJWT signs the claims, which must carry the user ID, username,
+// role, token type and session. Every token gets a unique ID, so tokens
+// issued within the same second still differ.
+func GenerateJWT(claims *interfaces.Claims, secret string, expiry time.Duration) (string, error) {
 	now := time.Now()
-	claims := &interfaces.Claims{
-		UserID:   userID.String(),
-		Username: "", // Will be filled by service
-		Role:     "", // Will be filled by service
-	}
-
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id":  claims.UserID,
-		"username": claims.Username,
-		"role":     claims.Role,
-		"iat":      now.Unix(),
-		"exp":      now.Add(expiry).Unix(),
-		"iss":      "hysteria2-api",
-		"sub":      userID.String(),
+		"user_id":    claims.UserID,
+		"username":   claims.Username,
+		"role":       claims.Role,
+		"token_type": claims.TokenType,
+		"sid":        claims.SessionID,
+		"jti":        uuid.NewString(),
+		"iat":        now.Unix(),
+		"exp":        now.Add(expiry).Unix(),
+		"iss":        "hysteria2-api",
+		"sub":        claims.UserID,
 	})
 
 	return token.SignedString([]byte(secret))
@@ -59,10 +59,14 @@ func ValidateJWT(tokenString, secret string) (*interfaces.Claims, error) {
 
 	username, _ := claims["username"].(string)
 	role, _ := claims["role"].(string)
+	tokenType, _ := claims["token_type"].(string)
+	sessionID, _ := claims["sid"].(string)
 
 	return &interfaces.Claims{
-		UserID:   userID,
-		Username: username,
-		Role:     role,
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		TokenType: tokenType,
+		SessionID: sessionID,
 	}, nil
 }
@@ -46,6 +46,16 @@ func (r *RedisClient) Get(ctx context.Context, key string, dest interface{}) err
 	return json.Unmarshal([]byte(data), dest)
 }
 
+// GetDel reads a value and deletes its key in one step, so only one caller
+// can obtain it
+func (r *RedisClient) GetDel(ctx context.Context, key string, dest interface{}) error {
+	data, err := r.client.GetDel(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), dest)
+}
+
 func (r *RedisClient) Del(ctx context.Context, keys ...string) error {
 	return r.client.Del(ctx, keys...).Err()
 }
@@ -54,6 +64,16 @@ func (r *RedisClient) Exists(ctx context.Context, keys ...string) (int64, error)
 	return r.client.Exists(ctx, keys...).Result()
 }
 
+// Incr increments the counter at key, creating it at 1
+func (r *RedisClient) Incr(ctx context.Context, key string) (int64, error) {
+	return r.client.Incr(ctx, key).Result()
+}
+
+// RunScript evaluates a Lua script, loading it into Redis on first use
+func (r *RedisClient) RunScript(ctx context.Context, script *redis.Script, keys []string, args ...interface{}) (interface{}, error) {
+	return script.Run(ctx, r.client, keys, args...).Result()
+}
+
 func (r *RedisClient) Expire(ctx context.Context, key string, expiration time.Duration) error {
 	return r.client.Expire(ctx, key, expiration).Err()
 }
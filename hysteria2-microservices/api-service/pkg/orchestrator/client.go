@@ -0,0 +1,147 @@
+package orchestrator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client calls the orchestrator's REST API for fleet-wide operations. The
+// orchestrator requires the internal secret both services share.
+type Client struct {
+	baseURL    string
+	secret     string
+	httpClient *http.Client
+}
+
+func NewClient(baseURL, secret string) *Client {
+	return &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// KickUserRequest lists the Hysteria2 client IDs (device IDs) to disconnect
+type KickUserRequest struct {
+	ClientIDs []string `json:"client_ids"`
+}
+
+// KickUserResponse reports how many nodes accepted the kick
+type KickUserResponse struct {
+	NodesTotal  int      `json:"nodes_total"`
+	NodesKicked int      `json:"nodes_kicked"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// KickUser disconnects the given clients of a user on every online node
+func (c *Client) KickUser(ctx context.Context, userID string, clientIDs []string) (*KickUserResponse, error) {
+	var resp KickUserResponse
+	path := fmt.Sprintf("/api/v1/users/%s/kick", userID)
+	if err := c.do(ctx, http.MethodPost, path, KickUserRequest{ClientIDs: clientIDs}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// SyncUser pushes the user's active devices and speed limits to every
+// online node. Nodes disconnect clients that lost access and the listed
+// clients.
+func (c *Client) SyncUser(ctx context.Context, userID string, kickClientIDs []string) (*KickUserResponse, error) {
+	var resp KickUserResponse
+	path := fmt.Sprintf("/api/v1/users/%s/sync", userID)
+	if err := c.do(ctx, http.MethodPost, path, KickUserRequest{ClientIDs: kickClientIDs}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DisconnectUser drops the given clients of a user on every online node
+// without revoking access. No client IDs disconnects all of the user's devices.
+func (c *Client) DisconnectUser(ctx context.Context, userID string, clientIDs []string) (*KickUserResponse, error) {
+	var resp KickUserResponse
+	path := fmt.Sprintf("/api/v1/users/%s/disconnect", userID)
+	if err := c.do(ctx, http.MethodPost, path, KickUserRequest{ClientIDs: clientIDs}, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// OnlineClient is a Hysteria2 client connected to a node
+type OnlineClient struct {
+	NodeID      string     `json:"node_id"`
+	NodeName    string     `json:"node_name"`
+	ClientID    string     `json:"client_id"`
+	UserID      string     `json:"user_id"`
+	DeviceName  string     `json:"device_name"`
+	Connections int        `json:"connections"`
+	Address     string     `json:"address"`
+	ConnectedAt *time.Time `json:"connected_at"`
+}
+
+// OnlineClientsResponse lists the connected clients of every online node
+type OnlineClientsResponse struct {
+	Clients      []OnlineClient `json:"clients"`
+	NodesTotal   int            `json:"nodes_total"`
+	NodesQueried int            `json:"nodes_queried"`
+	Errors       []string       `json:"errors,omitempty"`
+}
+
+// ListConnections returns the clients connected across the fleet
+func (c *Client) ListConnections(ctx context.Context) (*OnlineClientsResponse, error) {
+	var resp OnlineClientsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/v1/connections", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body interface{}, dest interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("X-Internal-Secret", c.secret)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("orchestrator request %s failed: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("orchestrator request %s returned status %d: %s", path, resp.StatusCode, apiErr.Error)
+		}
+		return fmt.Errorf("orchestrator request %s returned status %d", path, resp.StatusCode)
+	}
+
+	if dest == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(dest); err != nil {
+		return fmt.Errorf("failed to decode orchestrator response: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+// Package password hashes user passwords with argon2id. Hashes are stored
+// in the PHC string format, which records the parameters next to the salt
+// and hash:
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
+//
+// so the parameters can be raised later. Hashes made with weaker parameters,
+// or in the old salt+hash hex format, still verify and are reported as
+// needing a rehash.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/crypto/argon2"
+)
+
+const (
+	// MinLength and MaxLength bound accepted passwords. The upper bound
+	// keeps hashing cost predictable.
+	MinLength = 8
+	MaxLength = 128
+)
+
+var (
+	ErrTooShort = fmt.Errorf("password must be at least %d characters", MinLength)
+	ErrTooLong  = fmt.Errorf("password must be at most %d characters", MaxLength)
+)
+
+var errMalformed = errors.New("malformed password hash")
+
+// Params are the argon2id cost parameters
+type Params struct {
+	Memory      uint32 // KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultParams follow the second recommended option of RFC 9106
+var DefaultParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  3,
+	Parallelism: 4,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// legacyParams produced the hex encoded salt+hash used before the PHC format
+var legacyParams = Params{
+	Memory:      64 * 1024,
+	Iterations:  1,
+	Parallelism: 4,
+	SaltLength:  32,
+	KeyLength:   32,
+}
+
+var b64 = base64.RawStdEncoding
+
+// Hasher hashes new passwords with its parameters
+type Hasher struct {
+	params Params
+}
+
+func NewHasher(params Params) *Hasher {
+	return &Hasher{params: params}
+}
+
+// Validate checks a new password against the length policy
+func Validate(password string) error {
+	n := utf8.RuneCountInString(password)
+	if n < MinLength {
+		return ErrTooShort
+	}
+	if n > MaxLength {
+		return ErrTooLong
+	}
+	return nil
+}
+
+// Hash returns the encoded argon2id hash of a password
+func (h *Hasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.params.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	p := h.params
+	key := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, p.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, p.Memory, p.Iterations, p.Parallelism,
+		b64.EncodeToString(salt), b64.EncodeToString(key)), nil
+}
+
+// Verify reports whether password matches the encoded hash and, if it does,
+// whether the hash should be replaced by one with the current parameters
+func (h *Hasher) Verify(password, encoded string) (match bool, needsRehash bool) {
+	params, salt, key, err := decode(encoded)
+	if err != nil {
+		return false, false
+	}
+
+	computed := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, computed) != 1 {
+		return false, false
+	}
+
+	current := h.params
+	needsRehash = !strings.HasPrefix(encoded, "$argon2id$") ||
+		params.Memory < current.Memory ||
+		params.Iterations < current.Iterations ||
+		params.Parallelism != current.Parallelism ||
+		uint32(len(salt)) < current.SaltLength ||
+		uint32(len(key)) < current.KeyLength
+	return true, needsRehash
+}
+
+// decode parses a PHC string or a legacy hex hash
+func decode(encoded string) (Params, []byte, []byte, error) {
+	if !strings.HasPrefix(encoded, "$") {
+		return decodeLegacy(encoded)
+	}
+
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return Params{}, nil, nil, errMalformed
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Params{}, nil, nil, errMalformed
+	}
+
+	var params Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Params{}, nil, nil, errMalformed
+	}
+	if params.Memory == 0 || params.Iterations == 0 || params.Parallelism == 0 {
+		return Params{}, nil, nil, errMalformed
+	}
+
+	salt, err := b64.DecodeString(parts[4])
+	if err != nil {
+		return Params{}, nil, nil, errMalformed
+	}
+	key, err := b64.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return Params{}, nil, nil, errMalformed
+	}
+
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+func decodeLegacy(encoded string) (Params, []byte, []byte, error) {
+	raw, err := hex.DecodeString(encoded)
+	if err != nil || len(raw) != int(legacyParams.SaltLength+legacyParams.KeyLength) {
+		return Params{}, nil, nil, errMalformed
+	}
+	return legacyParams, raw[:legacyParams.SaltLength], raw[legacyParams.SaltLength:], nil
+}
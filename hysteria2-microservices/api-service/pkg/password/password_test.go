@@ -0,0 +1,140 @@
+package password
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// testParams keep the tests fast, the format does not depend on the cost
+var testParams = Params{
+	Memory:      1024,
+	Iterations:  2,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+func mustHash(t *testing.T, params Params, password string) string {
+	t.Helper()
+	encoded, err := NewHasher(params).Hash(password)
+	if err != nil {
+		t.Fatalf("Hash: %v", err)
+	}
+	return encoded
+}
+
+func legacyHash(password string) string {
+	salt := make([]byte, legacyParams.SaltLength)
+	for i := range salt {
+		salt[i] = byte(i)
+	}
+	key := argon2.IDKey([]byte(password), salt, legacyParams.Iterations, legacyParams.Memory, legacyParams.Parallelism, legacyParams.KeyLength)
+	return hex.EncodeToString(append(salt, key...))
+}
+
+func TestHashFormat(t *testing.T) {
+	encoded := mustHash(t, testParams, "correct horse")
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=2,p=1$") {
+		t.Errorf("Hash = %s, want PHC string with the hasher's parameters", encoded)
+	}
+	if other := mustHash(t, testParams, "correct horse"); other == encoded {
+		t.Error("Hash reused the salt")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	hasher := NewHasher(testParams)
+	current := mustHash(t, testParams, "correct horse")
+
+	weaker := testParams
+	weaker.Iterations = 1
+	stronger := testParams
+	stronger.Memory = 2048
+	otherParallelism := testParams
+	otherParallelism.Parallelism = 2
+	shortSalt := testParams
+	shortSalt.SaltLength = 8
+	shortKey := testParams
+	shortKey.KeyLength = 16
+
+	tests := []struct {
+		name        string
+		password    string
+		encoded     string
+		match       bool
+		needsRehash bool
+	}{
+		{"current parameters", "correct horse", current, true, false},
+		{"wrong password", "battery staple", current, false, false},
+		{"empty password", "", current, false, false},
+		{"fewer iterations", "correct horse", mustHash(t, weaker, "correct horse"), true, true},
+		{"more memory", "correct horse", mustHash(t, stronger, "correct horse"), true, false},
+		{"other parallelism", "correct horse", mustHash(t, otherParallelism, "correct horse"), true, true},
+		{"shorter salt", "correct horse", mustHash(t, shortSalt, "correct horse"), true, true},
+		{"shorter key", "correct horse", mustHash(t, shortKey, "correct horse"), true, true},
+		{"legacy hex", "correct horse", legacyHash("correct horse"), true, true},
+		{"legacy hex wrong password", "battery staple", legacyHash("correct horse"), false, false},
+		{"wrong password does not report rehash", "battery staple", mustHash(t, weaker, "correct horse"), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			match, needsRehash := hasher.Verify(tt.password, tt.encoded)
+			if match != tt.match || needsRehash != tt.needsRehash {
+				t.Errorf("Verify = (%v, %v), want (%v, %v)", match, needsRehash, tt.match, tt.needsRehash)
+			}
+		})
+	}
+}
+
+func TestVerifyMalformed(t *testing.T) {
+	hasher := NewHasher(testParams)
+	valid := mustHash(t, testParams, "correct horse")
+	parts := strings.Split(valid, "$")
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"empty", ""},
+		{"bcrypt", "$2a$10$abcdefghijklmnopqrstuuJ0aQ8Ah3kTz9xWZb5c6i8T0yW1Q2e3a"},
+		{"argon2i", strings.Replace(valid, "$argon2id$", "$argon2i$", 1)},
+		{"other version", strings.Replace(valid, "$v=19$", "$v=16$", 1)},
+		{"zero memory", strings.Join([]string{"", parts[1], parts[2], "m=0,t=2,p=1", parts[4], parts[5]}, "$")},
+		{"missing hash", strings.Join(parts[:5], "$")},
+		{"invalid salt", strings.Join([]string{"", parts[1], parts[2], parts[3], "!!", parts[5]}, "$")},
+		{"empty hash", strings.Join([]string{"", parts[1], parts[2], parts[3], parts[4], ""}, "$")},
+		{"short legacy hex", legacyHash("correct horse")[:64]},
+		{"legacy not hex", strings.Repeat("z", 128)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if match, needsRehash := hasher.Verify("correct horse", tt.encoded); match || needsRehash {
+				t.Errorf("Verify(%q) = (%v, %v), want (false, false)", tt.encoded, match, needsRehash)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		want     error
+	}{
+		{"too short", "1234567", ErrTooShort},
+		{"minimum", "12345678", nil},
+		{"multibyte counts runes", strings.Repeat("ä", MinLength), nil},
+		{"maximum", strings.Repeat("a", MaxLength), nil},
+		{"too long", strings.Repeat("a", MaxLength+1), ErrTooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Validate(tt.password); got != tt.want {
+				t.Errorf("Validate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+// Package ratelimit implements sliding window rate limits and progressive
+// lockouts on top of Redis, so limits hold across all api-service replicas.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"hysteria2-microservices/api-service/pkg/cache"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "ratelimit:"
+
+// slidingWindow keeps the timestamps of accepted requests within the window
+// in a sorted set. Rejected requests are not recorded, so a client that
+// backs off regains access once its oldest request leaves the window.
+//
+// Returns {allowed, remaining, retry after in ms}.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+if count >= limit then
+	local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+	return {0, 0, tonumber(oldest[2]) + window - now}
+end
+
+redis.call('ZADD', key, now, ARGV[4])
+redis.call('PEXPIRE', key, window)
+return {1, limit - count - 1, 0}
+`)
+
+// Rule allows Limit requests per Window. Name separates the counters of
+// different rules for the same key.
+type Rule struct {
+	Name   string
+	Limit  int
+	Window time.Duration
+}
+
+// Result is the outcome of a request against a rule
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+type Limiter struct {
+	redis *cache.RedisClient
+}
+
+func NewLimiter(redis *cache.RedisClient) *Limiter {
+	return &Limiter{redis: redis}
+}
+
+// Allow records a request of key against the rule if it is within the limit
+func (l *Limiter) Allow(ctx context.Context, rule Rule, key string) (*Result, error) {
+	if rule.Limit <= 0 {
+		return &Result{Allowed: true, Limit: rule.Limit, Remaining: -1}, nil
+	}
+
+	now := time.Now().UnixMilli()
+	raw, err := l.redis.RunScript(ctx, slidingWindow,
+		[]string{keyPrefix + rule.Name + ":" + key},
+		now, rule.Window.Milliseconds(), rule.Limit, strconv.FormatInt(now, 10)+"-"+uuid.NewString(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check rate limit: %w", err)
+	}
+
+	values, ok := raw.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, fmt.Errorf("unexpected rate limit reply: %v", raw)
+	}
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	retryAfter, _ := values[2].(int64)
+
+	return &Result{
+		Allowed:    allowed == 1,
+		Limit:      rule.Limit,
+		Remaining:  int(remaining),
+		RetryAfter: time.Duration(retryAfter) * time.Millisecond,
+	}, nil
+}
+
+// Reset clears the counter of key for the rule
+func (l *Limiter) Reset(ctx context.Context, rule Rule, key string) error {
+	return l.redis.Del(ctx, keyPrefix+rule.Name+":"+key)
+}
+
+// Lockout locks a key out once it failed Threshold times in a row. Every
+// further failure doubles the lockout, starting at Base and capped at Max.
+type Lockout struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+}
+
+// Duration returns how long a key is locked after the given number of
+// consecutive failures, zero while below the threshold
+func (l Lockout) Duration(failures int) time.Duration {
+	if l.Threshold <= 0 || failures < l.Threshold {
+		return 0
+	}
+
+	d := l.Base
+	for i := l.Threshold; i < failures; i++ {
+		d *= 2
+		if d >= l.Max {
+			return l.Max
+		}
+	}
+	if d > l.Max {
+		return l.Max
+	}
+	return d
+}
+
+// RetryAfterSeconds rounds d up to whole seconds for the Retry-After header
+func RetryAfterSeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
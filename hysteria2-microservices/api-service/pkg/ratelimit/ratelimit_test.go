@@ -0,0 +1,201 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"hysteria2-microservices/api-service/pkg/cache"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestLimiter(t *testing.T) (*Limiter, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	redis := cache.NewRedisClient("redis://" + mr.Addr())
+	t.Cleanup(func() { redis.Close() })
+	return NewLimiter(redis), mr
+}
+
+func TestLockoutDuration(t *testing.T) {
+	lockout := Lockout{Threshold: 5, Base: time.Minute, Max: 15 * time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{7, 4 * time.Minute},
+		{8, 8 * time.Minute},
+		{9, 15 * time.Minute},
+		{100, 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got := lockout.Duration(tt.failures); got != tt.want {
+			t.Errorf("Duration(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLockoutDurationDisabled(t *testing.T) {
+	lockout := Lockout{Threshold: 0, Base: time.Minute, Max: time.Hour}
+	if got := lockout.Duration(1000); got != 0 {
+		t.Errorf("Duration with no threshold = %v, want 0", got)
+	}
+}
+
+func TestLockoutDurationBaseAboveMax(t *testing.T) {
+	lockout := Lockout{Threshold: 1, Base: time.Hour, Max: time.Minute}
+	if got := lockout.Duration(1); got != time.Minute {
+		t.Errorf("Duration(1) = %v, want %v", got, time.Minute)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want int
+	}{
+		{0, 1},
+		{time.Millisecond, 1},
+		{time.Second, 1},
+		{time.Second + time.Millisecond, 2},
+		{90 * time.Second, 90},
+	}
+	for _, tt := range tests {
+		if got := RetryAfterSeconds(tt.d); got != tt.want {
+			t.Errorf("RetryAfterSeconds(%v) = %d, want %d", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestAllowWithinLimit(t *testing.T) {
+	limiter, _ := newTestLimiter(t)
+	ctx := context.Background()
+	rule := Rule{Name: "test", Limit: 3, Window: time.Minute}
+
+	for i, want := range []int{2, 1, 0} {
+		res, err := limiter.Allow(ctx, rule, "key")
+		if err != nil {
+			t.Fatalf("Allow #%d: %v", i+1, err)
+		}
+		if !res.Allowed || res.Remaining != want || res.RetryAfter != 0 {
+			t.Fatalf("Allow #%d = %+v, want allowed with %d remaining", i+1, res, want)
+		}
+	}
+
+	res, err := limiter.Allow(ctx, rule, "key")
+	if err != nil {
+		t.Fatalf("Allow over limit: %v", err)
+	}
+	if res.Allowed || res.Remaining != 0 {
+		t.Fatalf("Allow over limit = %+v, want rejected", res)
+	}
+	if res.RetryAfter <= 0 || res.RetryAfter > rule.Window {
+		t.Errorf("RetryAfter = %v, want within (0, %v]", res.RetryAfter, rule.Window)
+	}
+}
+
+func TestAllowSlidesWindow(t *testing.T) {
+	limiter, _ := newTestLimiter(t)
+	ctx := context.Background()
+	rule := Rule{Name: "test", Limit: 2, Window: 200 * time.Millisecond}
+
+	for i := 0; i < 2; i++ {
+		if res, err := limiter.Allow(ctx, rule, "key"); err != nil || !res.Allowed {
+			t.Fatalf("Allow #%d = %+v, %v, want allowed", i+1, res, err)
+		}
+	}
+
+	// Rejected requests are not recorded, so retrying does not extend the wait
+	for i := 0; i < 3; i++ {
+		if res, err := limiter.Allow(ctx, rule, "key"); err != nil || res.Allowed {
+			t.Fatalf("retry #%d = %+v, %v, want rejected", i+1, res, err)
+		}
+	}
+
+	time.Sleep(rule.Window + 50*time.Millisecond)
+
+	res, err := limiter.Allow(ctx, rule, "key")
+	if err != nil {
+		t.Fatalf("Allow after window: %v", err)
+	}
+	if !res.Allowed || res.Remaining != 1 {
+		t.Errorf("Allow after window = %+v, want allowed with 1 remaining", res)
+	}
+}
+
+func TestAllowSeparatesKeysAndRules(t *testing.T) {
+	limiter, _ := newTestLimiter(t)
+	ctx := context.Background()
+	login := Rule{Name: "login", Limit: 1, Window: time.Minute}
+	register := Rule{Name: "register", Limit: 1, Window: time.Minute}
+
+	tests := []struct {
+		rule Rule
+		key  string
+		want bool
+	}{
+		{login, "a", true},
+		{login, "a", false},
+		{login, "b", true},
+		{register, "a", true},
+		{register, "a", false},
+	}
+	for i, tt := range tests {
+		res, err := limiter.Allow(ctx, tt.rule, tt.key)
+		if err != nil {
+			t.Fatalf("#%d Allow(%s, %s): %v", i, tt.rule.Name, tt.key, err)
+		}
+		if res.Allowed != tt.want {
+			t.Errorf("#%d Allow(%s, %s).Allowed = %v, want %v", i, tt.rule.Name, tt.key, res.Allowed, tt.want)
+		}
+	}
+}
+
+func TestAllowExpiresKey(t *testing.T) {
+	limiter, mr := newTestLimiter(t)
+	rule := Rule{Name: "test", Limit: 5, Window: time.Minute}
+
+	if _, err := limiter.Allow(context.Background(), rule, "key"); err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if ttl := mr.TTL(keyPrefix + "test:key"); ttl <= 0 || ttl > rule.Window {
+		t.Errorf("TTL = %v, want within (0, %v]", ttl, rule.Window)
+	}
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	// No Redis calls are made for rules without a limit
+	limiter := NewLimiter(nil)
+	res, err := limiter.Allow(context.Background(), Rule{Name: "test", Limit: 0, Window: time.Minute}, "key")
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	if !res.Allowed || res.Remaining != -1 {
+		t.Errorf("Allow = %+v, want allowed with -1 remaining", res)
+	}
+}
+
+func TestReset(t *testing.T) {
+	limiter, _ := newTestLimiter(t)
+	ctx := context.Background()
+	rule := Rule{Name: "test", Limit: 1, Window: time.Minute}
+
+	if res, err := limiter.Allow(ctx, rule, "key"); err != nil || !res.Allowed {
+		t.Fatalf("Allow = %+v, %v, want allowed", res, err)
+	}
+	if res, err := limiter.Allow(ctx, rule, "key"); err != nil || res.Allowed {
+		t.Fatalf("Allow over limit = %+v, %v, want rejected", res, err)
+	}
+	if err := limiter.Reset(ctx, rule, "key"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if res, err := limiter.Allow(ctx, rule, "key"); err != nil || !res.Allowed {
+		t.Errorf("Allow after reset = %+v, %v, want allowed", res, err)
+	}
+}
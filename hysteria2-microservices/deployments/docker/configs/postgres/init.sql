@@ -17,8 +17,8 @@ CREATE TABLE IF NOT EXISTS users (
     email VARCHAR(255) UNIQUE NOT NULL,
     password VARCHAR(255) NOT NULL,
     full_name VARCHAR(100),
-    status VARCHAR(20) DEFAULT 'active' CHECK (status IN ('active','suspended','deleted')),
-    role VARCHAR(20) DEFAULT 'user' CHECK (role IN ('admin','user')),
+    status VARCHAR(20) DEFAULT 'active' CHECK (status IN ('active','suspended','deleted','pending_verification','pending_approval')),
+    role VARCHAR(20) DEFAULT 'user' CHECK (role IN ('admin','operator','support','user')),
     data_limit BIGINT DEFAULT 0,
     data_used BIGINT DEFAULT 0,
     expiry_date TIMESTAMP WITH TIME ZONE,
@@ -35,6 +35,7 @@ CREATE TABLE IF NOT EXISTS devices (
     name VARCHAR(100) NOT NULL,
     device_id VARCHAR(255) UNIQUE NOT NULL,
     public_key VARCHAR(255) NOT NULL,
+    auth_secret VARCHAR(64),
     ip_address INET,
     status VARCHAR(20) DEFAULT 'active' CHECK (status IN ('active','inactive','blocked')),
     data_used BIGINT DEFAULT 0,
@@ -97,7 +98,7 @@ INSERT INTO users (username, email, password, role, status)
 VALUES (
     'admin',
     'admin@hysteria2.local',
-    '$argon2id$v=19$m=65536,t=3,p=4$abcdef123456789$abcdefghijklmnopqrstuvwxyz123456789',
+    '$argon2id$v=19$m=65536,t=3,p=4$byu10M4Fu4bmuuDp1Igykw$JNNvN+4NxIu/AGALWcYdu9kYe9dZXwjpSrMLCGN4c3A',
     'admin',
     'active'
 ) ON CONFLICT (username) DO NOTHING;
@@ -42,6 +42,18 @@ if [ -z "$MASTER_SERVER" ]; then
     exit 1
 fi
 
+read -p "Enter join token (POST /api/v1/join-tokens on the orchestrator): " NODE_JOIN_TOKEN
+if [ -z "$NODE_JOIN_TOKEN" ]; then
+    echo "❌ Join token is required"
+    exit 1
+fi
+
+read -p "Enter orchestrator CA fingerprint (from the join token response): " MASTER_CA_FINGERPRINT
+if [ -z "$MASTER_CA_FINGERPRINT" ]; then
+    echo "❌ CA fingerprint is required"
+    exit 1
+fi
+
 read -p "Enter node ID (unique identifier, e.g., node-us-east-1): " NODE_ID
 if [ -z "$NODE_ID" ]; then
     echo "❌ Node ID is required"
@@ -110,8 +122,10 @@ docker run -d \
     --restart unless-stopped \
     -p ${HYSTERIA_PORT}:${HYSTERIA_PORT}/udp \
     -p 50051:50051 \
+    -v ${CONTAINER_NAME}-data:/var/lib/hysteria-agent \
     -e MASTER_SERVER=${MASTER_SERVER} \
-    -e NODE_ID=${NODE_ID} \
+    -e MASTER_CA_FINGERPRINT=${MASTER_CA_FINGERPRINT} \
+    -e NODE_JOIN_TOKEN=${NODE_JOIN_TOKEN} \
     -e NODE_NAME="${NODE_NAME}" \
     -e NODE_HOSTNAME=${NODE_ID}.vpn.local \
     -e NODE_IP_ADDRESS=${NODE_IP_ADDRESS} \
@@ -65,10 +65,8 @@ if [ -z "$JWT_SECRET" ]; then
     JWT_SECRET=$(openssl rand -hex 32)
 fi
 
-read -p "Enter node auth token (default: random): " NODE_AUTH_TOKEN
-if [ -z "$NODE_AUTH_TOKEN" ]; then
-    NODE_AUTH_TOKEN=$(openssl rand -hex 16)
-fi
+# Shared by the API and the orchestrator, whose REST API requires it
+INTERNAL_API_SECRET=$(openssl rand -hex 32)
 
 # Create logs directory
 mkdir -p logs
@@ -87,7 +85,7 @@ GRPC_PORT=50052
 SERVER_HOST=0.0.0.0
 SERVER_PORT=8081
 JWT_SECRET=${JWT_SECRET}
-NODE_AUTH_TOKEN=${NODE_AUTH_TOKEN}
+INTERNAL_API_SECRET=${INTERNAL_API_SECRET}
 LOG_LEVEL=info
 LOG_FORMAT=json
 EOF
@@ -100,6 +98,7 @@ LOG_LEVEL=info
 ALLOW_ORIGINS=http://${SERVER_HOST}:3000
 JWT_EXPIRY_HOUR=24
 ORCHESTRATOR_URL=orchestrator-service:50052
+INTERNAL_API_SECRET=${INTERNAL_API_SECRET}
 EOF
 
 cat > web.env << EOF
@@ -125,7 +124,11 @@ if docker-compose ps postgres redis orchestrator-service api-service web-service
     echo "📝 Save these credentials:"
     echo "   DB Password: ${DB_PASSWORD}"
     echo "   JWT Secret: ${JWT_SECRET}"
-    echo "   Node Auth Token: ${NODE_AUTH_TOKEN}"
+    echo "   Internal API Secret: ${INTERNAL_API_SECRET}"
+    echo ""
+    echo "🔑 Enroll nodes with a single-use join token:"
+    echo "   curl -X POST -H \"X-Internal-Secret: ${INTERNAL_API_SECRET}\" http://${SERVER_HOST}:8081/api/v1/join-tokens"
+    echo "   The response includes the CA fingerprint the node installer asks for."
     echo ""
     echo "🔍 Logs: docker-compose logs -f"
     echo "🛑 Stop: docker-compose down"
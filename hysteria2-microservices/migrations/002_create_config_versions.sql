@@ -0,0 +1,17 @@
+-- Immutable rendered node configs
+CREATE TABLE IF NOT EXISTS config_versions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    node_id UUID NOT NULL REFERENCES vps_nodes(id) ON DELETE CASCADE,
+    version VARCHAR(50) NOT NULL,
+    checksum VARCHAR(64) NOT NULL,
+    content TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(node_id, version)
+);
+
+-- Deployments are ordered by creation to find the latest and pending ones
+ALTER TABLE deployments ADD COLUMN IF NOT EXISTS created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW();
+
+CREATE INDEX IF NOT EXISTS idx_config_versions_node_id ON config_versions(node_id);
+CREATE INDEX IF NOT EXISTS idx_config_versions_created_at ON config_versions(created_at);
+CREATE INDEX IF NOT EXISTS idx_deployments_status ON deployments(status);
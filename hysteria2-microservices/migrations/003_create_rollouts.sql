@@ -0,0 +1,41 @@
+-- Staged config rollouts
+CREATE TABLE IF NOT EXISTS rollouts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    selector_country VARCHAR(2),
+    selector_location VARCHAR(100),
+    selector_status VARCHAR(20),
+    selector_labels JSONB,
+    batch_size INTEGER DEFAULT 0,
+    batch_percent INTEGER DEFAULT 0,
+    pause_seconds INTEGER DEFAULT 0,
+    heartbeat_timeout_seconds INTEGER DEFAULT 90,
+    max_connection_drop_percent INTEGER DEFAULT 0,
+    status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'paused', 'halted', 'completed', 'cancelled')),
+    total_waves INTEGER DEFAULT 0,
+    current_wave INTEGER DEFAULT 0,
+    halt_reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    started_at TIMESTAMP WITH TIME ZONE,
+    finished_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Per-node progress of a rollout
+CREATE TABLE IF NOT EXISTS rollout_nodes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rollout_id UUID NOT NULL REFERENCES rollouts(id) ON DELETE CASCADE,
+    node_id UUID NOT NULL REFERENCES vps_nodes(id) ON DELETE CASCADE,
+    wave INTEGER NOT NULL,
+    status VARCHAR(20) DEFAULT 'pending' CHECK (status IN ('pending', 'deploying', 'success', 'failed')),
+    config_version VARCHAR(50),
+    deployment_id UUID REFERENCES deployments(id) ON DELETE SET NULL,
+    connections_before INTEGER DEFAULT 0,
+    error_message TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(rollout_id, node_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rollouts_status ON rollouts(status);
+CREATE INDEX IF NOT EXISTS idx_rollout_nodes_rollout_id ON rollout_nodes(rollout_id);
+CREATE INDEX IF NOT EXISTS idx_rollout_nodes_node_id ON rollout_nodes(node_id);
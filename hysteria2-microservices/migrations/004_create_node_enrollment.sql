@@ -0,0 +1,27 @@
+-- Single-use join tokens for node enrollment (only the SHA-256 is stored)
+CREATE TABLE IF NOT EXISTS join_tokens (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    token_hash VARCHAR(64) NOT NULL UNIQUE,
+    description VARCHAR(255),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    node_id UUID REFERENCES vps_nodes(id) ON DELETE SET NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Client certificates issued to nodes by the orchestrator CA
+CREATE TABLE IF NOT EXISTS node_certificates (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    node_id UUID NOT NULL REFERENCES vps_nodes(id) ON DELETE CASCADE,
+    serial_number VARCHAR(40) NOT NULL UNIQUE,
+    not_after TIMESTAMP WITH TIME ZONE NOT NULL,
+    revoked_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_certificates_node_id ON node_certificates(node_id);
+
+-- Revoked nodes keep their history but can no longer reach the MasterService
+ALTER TABLE vps_nodes DROP CONSTRAINT IF EXISTS vps_nodes_status_check;
+ALTER TABLE vps_nodes ADD CONSTRAINT vps_nodes_status_check
+    CHECK (status IN ('online', 'offline', 'maintenance', 'error', 'revoked'));
@@ -0,0 +1,2 @@
+-- Config version last applied by the agent, reported when it (re-)registers
+ALTER TABLE vps_nodes ADD COLUMN IF NOT EXISTS config_version VARCHAR(50);
@@ -0,0 +1,13 @@
+-- Node status transitions recorded by the orchestrator liveness monitor
+CREATE TABLE IF NOT EXISTS node_events (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    node_id UUID NOT NULL REFERENCES vps_nodes(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    from_status VARCHAR(20),
+    to_status VARCHAR(20),
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_events_node_id ON node_events(node_id);
+CREATE INDEX IF NOT EXISTS idx_node_events_created_at ON node_events(created_at);
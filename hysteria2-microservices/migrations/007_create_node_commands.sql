@@ -0,0 +1,16 @@
+-- Commands queued for nodes and delivered in heartbeat responses
+CREATE TABLE IF NOT EXISTS node_commands (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    node_id UUID NOT NULL REFERENCES vps_nodes(id) ON DELETE CASCADE,
+    type VARCHAR(50) NOT NULL,
+    params JSONB,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'delivered', 'succeeded', 'failed', 'cancelled')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    result TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    completed_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_commands_node_status ON node_commands(node_id, status);
@@ -0,0 +1,7 @@
+-- Operator and support roles for the API's permission model. The
+-- constraint is named users_role_check by init.sql and chk_users_role by
+-- the API's auto-migration.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_role_check;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS chk_users_role;
+ALTER TABLE users ADD CONSTRAINT users_role_check
+    CHECK (role IN ('admin', 'operator', 'support', 'user'));
@@ -0,0 +1,24 @@
+-- Self-registration states, email verification and invite codes. The
+-- status constraint is named users_status_check by init.sql and
+-- chk_users_status by the API's auto-migration.
+ALTER TABLE users DROP CONSTRAINT IF EXISTS users_status_check;
+ALTER TABLE users DROP CONSTRAINT IF EXISTS chk_users_status;
+ALTER TABLE users ADD CONSTRAINT users_status_check
+    CHECK (status IN ('active', 'suspended', 'deleted', 'pending_verification', 'pending_approval'));
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS invite_code_id UUID;
+
+CREATE TABLE IF NOT EXISTS invite_codes (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    code VARCHAR(32) UNIQUE NOT NULL,
+    max_uses INTEGER DEFAULT 1,
+    uses INTEGER DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    data_limit BIGINT DEFAULT 0,
+    valid_days INTEGER DEFAULT 0,
+    device_limit INTEGER DEFAULT 0,
+    note TEXT,
+    created_by UUID NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
@@ -0,0 +1,57 @@
+-- Plans and the users' subscriptions to them. The API's auto-migration
+-- creates the same tables. Users keep their data_limit, device_limit and
+-- expiry_date columns, which mirror the plan of an active subscription.
+CREATE TABLE IF NOT EXISTS plans (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(100) UNIQUE NOT NULL,
+    description TEXT,
+    data_limit BIGINT DEFAULT 0,
+    up_mbps INTEGER DEFAULT 0,
+    down_mbps INTEGER DEFAULT 0,
+    device_limit INTEGER DEFAULT 0,
+    node_groups JSONB,
+    duration_days INTEGER DEFAULT 0,
+    is_active BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS subscriptions (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    plan_id UUID NOT NULL REFERENCES plans(id),
+    status VARCHAR(20) DEFAULT 'active' CHECK (status IN ('active', 'expired', 'replaced')),
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    renewed_at TIMESTAMP WITH TIME ZONE,
+    ends_at TIMESTAMP WITH TIME ZONE,
+    reset_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    suspend_reason VARCHAR(20),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscriptions_user_id ON subscriptions(user_id);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_plan_id ON subscriptions(plan_id);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_status ON subscriptions(status);
+CREATE INDEX IF NOT EXISTS idx_subscriptions_reset_at ON subscriptions(reset_at);
+-- A user has at most one active subscription
+CREATE UNIQUE INDEX IF NOT EXISTS idx_subscriptions_active_user ON subscriptions(user_id) WHERE status = 'active';
+
+-- Every change to a subscription, made by an admin or the enforcement sweep
+CREATE TABLE IF NOT EXISTS subscription_history (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    subscription_id UUID NOT NULL REFERENCES subscriptions(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event VARCHAR(20) NOT NULL,
+    from_plan_id UUID,
+    to_plan_id UUID,
+    from_ends_at TIMESTAMP WITH TIME ZONE,
+    to_ends_at TIMESTAMP WITH TIME ZONE,
+    data_used BIGINT DEFAULT 0,
+    actor_id UUID,
+    note TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_history_user_id ON subscription_history(user_id);
+CREATE INDEX IF NOT EXISTS idx_subscription_history_subscription_id ON subscription_history(subscription_id);
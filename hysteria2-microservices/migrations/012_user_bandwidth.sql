@@ -0,0 +1,4 @@
+-- Per-user speed limits in Mbit/s, overriding those of the user's plan.
+-- 0 falls back to the plan, and without one to the node's bandwidth.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS up_mbps INTEGER DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS down_mbps INTEGER DEFAULT 0;
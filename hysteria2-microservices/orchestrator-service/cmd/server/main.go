@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
@@ -13,7 +14,10 @@ import (
 
 	"hysteryVPN/orchestrator-service/internal/config"
 	"hysteryVPN/orchestrator-service/internal/database"
+	"hysteryVPN/orchestrator-service/internal/handlers"
+	"hysteryVPN/orchestrator-service/internal/middleware"
 	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/pki"
 	"hysteryVPN/orchestrator-service/internal/repositories"
 	"hysteryVPN/orchestrator-service/internal/services"
 
@@ -43,18 +47,33 @@ func main() {
 	defer database.Close(db)
 
 	// Run migrations
-	if err := database.AutoMigrate(db, &models.VPSNode{}, &models.NodeAssignment{}, &models.NodeMetric{}, &models.Deployment{}, &models.User{}); err != nil {
+	if err := database.AutoMigrate(db, &models.VPSNode{}, &models.NodeAssignment{}, &models.NodeMetric{}, &models.Deployment{}, &models.ConfigVersion{}, &models.Rollout{}, &models.RolloutNode{}, &models.JoinToken{}, &models.NodeCertificate{}, &models.NodeEvent{}, &models.NodeCommand{}, &models.User{}); err != nil {
 		logger.Fatalf("Failed to run migrations: %v", err)
 	}
 
+	// Load the internal CA that signs node certificates
+	ca, err := pki.LoadOrCreateCA(cfg.Security.CACertFile, cfg.Security.CAKeyFile)
+	if err != nil {
+		logger.Fatalf("Failed to load CA: %v", err)
+	}
+	logger.Infof("Node CA fingerprint (sha256): %s", ca.Fingerprint())
+
 	// Initialize repositories
 	repos := setupRepositories(db)
 
 	// Initialize services
-	services := setupServices(repos, logger)
+	services := setupServices(repos, cfg, ca, logger)
+
+	// Finish deployments and rollouts interrupted by a restart
+	go services.DeploymentService.ResumePending(context.Background())
+	services.RolloutService.ResumeRunning()
+
+	// Track node liveness from heartbeats
+	services.NodeMonitor.Start()
+	defer services.NodeMonitor.Stop()
 
 	// Setup GRPC server
-	grpcServer := setupGRPCServer(services, cfg, logger)
+	grpcServer := setupGRPCServer(services, cfg, ca, logger)
 	go startGRPCServer(grpcServer, cfg, logger)
 
 	// Setup REST server
@@ -102,41 +121,97 @@ func setupLogger(cfg config.LoggingConfig) *logrus.Logger {
 
 func setupRepositories(db database.Database) *repositories.Repositories {
 	return &repositories.Repositories{
-		NodeRepo:       repositories.NewNodeRepository(db),
-		AssignmentRepo: repositories.NewNodeAssignmentRepository(db),
-		MetricRepo:     repositories.NewNodeMetricRepository(db),
-		DeploymentRepo: repositories.NewDeploymentRepository(db),
-		UserRepo:       repositories.NewUserRepository(db),
+		NodeRepo:          repositories.NewNodeRepository(db),
+		AssignmentRepo:    repositories.NewNodeAssignmentRepository(db),
+		MetricRepo:        repositories.NewNodeMetricRepository(db),
+		DeploymentRepo:    repositories.NewDeploymentRepository(db),
+		ConfigVersionRepo: repositories.NewConfigVersionRepository(db),
+		RolloutRepo:       repositories.NewRolloutRepository(db),
+		JoinTokenRepo:     repositories.NewJoinTokenRepository(db),
+		NodeCertRepo:      repositories.NewNodeCertificateRepository(db),
+		NodeEventRepo:     repositories.NewNodeEventRepository(db),
+		NodeCommandRepo:   repositories.NewNodeCommandRepository(db),
+		UserRepo:          repositories.NewUserRepository(db),
+		DeviceRepo:        repositories.NewDeviceRepository(db),
 	}
 }
 
-func setupServices(repos *repositories.Repositories, logger *logrus.Logger) *services.Services {
+func setupServices(repos *repositories.Repositories, cfg *config.Config, ca *pki.CA, logger *logrus.Logger) *services.Services {
+	// NodeManager calls authenticate to the nodes with a client certificate
+	clientCert, err := ca.IssueClientCertificate(365 * 24 * time.Hour)
+	if err != nil {
+		logger.Fatalf("Failed to issue node client certificate: %v", err)
+	}
+	nodeClients := services.NewNodeClientPool(ca, clientCert)
+	publisher := services.NewNodeEventPublisher(cfg.APIService.URL, cfg.APIService.InternalSecret, logger)
+	deploymentService := services.NewDeploymentService(repos.DeploymentRepo, repos.ConfigVersionRepo, repos.NodeRepo, nodeClients, publisher, logger)
+	enrollmentService := services.NewEnrollmentService(
+		repos.JoinTokenRepo,
+		repos.NodeCertRepo,
+		repos.NodeRepo,
+		ca,
+		time.Duration(cfg.Security.NodeCertValidityHours)*time.Hour,
+		time.Duration(cfg.Security.JoinTokenTTLHours)*time.Hour,
+		logger,
+	)
+	nodeMonitor := services.NewNodeMonitor(
+		repos.NodeRepo,
+		repos.NodeEventRepo,
+		repos.MetricRepo,
+		publisher,
+		time.Duration(cfg.Monitor.OfflineGraceSeconds)*time.Second,
+		time.Duration(cfg.Monitor.ErrorGraceSeconds)*time.Second,
+		time.Duration(cfg.Monitor.SweepIntervalSeconds)*time.Second,
+		time.Duration(cfg.Monitor.HeartbeatIntervalSeconds)*time.Second,
+		logger,
+	)
+
 	return &services.Services{
 		NodeService:       services.NewNodeService(repos.NodeRepo, logger),
 		AssignmentService: services.NewAssignmentService(repos.AssignmentRepo, logger),
 		MetricsService:    services.NewMetricsService(repos.MetricRepo, logger),
-		DeploymentService: services.NewDeploymentService(repos.DeploymentRepo, logger),
-		UserService:       services.NewUserService(repos.UserRepo, logger),
+		DeploymentService: deploymentService,
+		RolloutService:    services.NewRolloutService(repos.RolloutRepo, repos.NodeRepo, repos.MetricRepo, deploymentService, logger),
+		EnrollmentService: enrollmentService,
+		NodeMonitor:       nodeMonitor,
+		CommandService:    services.NewCommandService(repos.NodeCommandRepo, repos.NodeRepo, logger),
+		UserService:       services.NewUserService(repos.UserRepo, repos.DeviceRepo, nodeMonitor, nodeClients, logger),
+		TrafficService:    services.NewTrafficService(repos.DeviceRepo, publisher, logger),
 	}
 }
 
-func setupGRPCServer(services *services.Services, cfg *config.Config, logger *logrus.Logger) *grpc.Server {
-	// Setup TLS if configured
-	var opts []grpc.ServerOption
-
+func setupGRPCServer(services *services.Services, cfg *config.Config, ca *pki.CA, logger *logrus.Logger) *grpc.Server {
+	// Use the configured server certificate, or have the internal CA issue one
+	var serverCert tls.Certificate
+	var err error
 	if cfg.GRPC.HostKey != "" && cfg.GRPC.CertKey != "" {
-		creds, err := credentials.NewServerTLSFromFile(cfg.GRPC.CertKey, cfg.GRPC.HostKey)
-		if err != nil {
-			logger.Fatalf("Failed to create TLS credentials: %v", err)
-		}
-		opts = append(opts, grpc.Creds(creds))
+		serverCert, err = tls.LoadX509KeyPair(cfg.GRPC.CertKey, cfg.GRPC.HostKey)
+	} else {
+		serverCert, err = ca.IssueServerCertificate(cfg.GRPC.ServerNames, 365*24*time.Hour)
+	}
+	if err != nil {
+		logger.Fatalf("Failed to create TLS credentials: %v", err)
 	}
 
-	s := grpc.NewServer(opts...)
+	// Client certificates are optional at the TLS layer so nodes can enroll
+	// and admins can connect; NodeAuthInterceptor enforces them per method
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    ca.CertPool(),
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS12,
+	})
+
+	nodeAuth := handlers.NewNodeAuthInterceptor(services.EnrollmentService, logger)
+	s := grpc.NewServer(
+		grpc.Creds(creds),
+		grpc.UnaryInterceptor(nodeAuth.Unary()),
+		grpc.StreamInterceptor(nodeAuth.Stream()),
+	)
 
 	// Register services
-	node_management.RegisterMasterServiceServer(s, handlers.NewMasterServiceHandler(services.NodeService, logger))
-	node_management.RegisterAdminServiceServer(s, handlers.NewAdminServiceHandler(services.NodeService, services.MetricsService, logger))
+	node_management.RegisterMasterServiceServer(s, handlers.NewMasterServiceHandler(services, logger))
+	node_management.RegisterAdminServiceServer(s, handlers.NewAdminServiceHandler(services, logger))
 
 	// Enable reflection for development
 	reflection.Register(s)
@@ -170,7 +245,7 @@ func setupRESTServer(services *services.Services, cfg *config.Config, logger *lo
 	r.Use(middleware.CORS())
 
 	// Setup routes
-	handlers.SetupRoutes(r, services, logger)
+	handlers.SetupRoutes(r, services, cfg.APIService.InternalSecret, logger)
 
 	return r
 }
@@ -10,11 +10,13 @@ import (
 )
 
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Database DatabaseConfig `mapstructure:"database"`
-	GRPC     GRPCConfig     `mapstructure:"grpc"`
-	Security SecurityConfig `mapstructure:"security"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
+	Server     ServerConfig     `mapstructure:"server"`
+	Database   DatabaseConfig   `mapstructure:"database"`
+	GRPC       GRPCConfig       `mapstructure:"grpc"`
+	Security   SecurityConfig   `mapstructure:"security"`
+	Monitor    MonitorConfig    `mapstructure:"monitor"`
+	APIService APIServiceConfig `mapstructure:"api_service"`
+	Logging    LoggingConfig    `mapstructure:"logging"`
 }
 
 type ServerConfig struct {
@@ -33,15 +35,31 @@ type DatabaseConfig struct {
 }
 
 type GRPCConfig struct {
-	Host    string `mapstructure:"host"`
-	Port    int    `mapstructure:"port"`
-	HostKey string `mapstructure:"host_key"`
-	CertKey string `mapstructure:"cert_key"`
+	Host        string   `mapstructure:"host"`
+	Port        int      `mapstructure:"port"`
+	HostKey     string   `mapstructure:"host_key"`
+	CertKey     string   `mapstructure:"cert_key"`
+	ServerNames []string `mapstructure:"server_names"` // SANs of the CA-issued server cert when no cert files are set
 }
 
 type SecurityConfig struct {
-	JWTSecret     string `mapstructure:"jwt_secret"`
-	NodeAuthToken string `mapstructure:"node_auth_token"`
+	JWTSecret             string `mapstructure:"jwt_secret"`
+	CACertFile            string `mapstructure:"ca_cert_file"`
+	CAKeyFile             string `mapstructure:"ca_key_file"`
+	NodeCertValidityHours int    `mapstructure:"node_cert_validity_hours"`
+	JoinTokenTTLHours     int    `mapstructure:"join_token_ttl_hours"` // default lifetime of a join token
+}
+
+type MonitorConfig struct {
+	OfflineGraceSeconds      int `mapstructure:"offline_grace_seconds"`      // no heartbeat for this long marks a node offline
+	ErrorGraceSeconds        int `mapstructure:"error_grace_seconds"`        // Hysteria2 reported down for this long marks a node error
+	SweepIntervalSeconds     int `mapstructure:"sweep_interval_seconds"`     // how often silent nodes are checked
+	HeartbeatIntervalSeconds int `mapstructure:"heartbeat_interval_seconds"` // sent to agents in every heartbeat response
+}
+
+type APIServiceConfig struct {
+	URL            string `mapstructure:"url"`             // node events are forwarded here for the admin WebSocket
+	InternalSecret string `mapstructure:"internal_secret"` // sent to the api-service and required on the REST API
 }
 
 type LoggingConfig struct {
@@ -104,6 +122,17 @@ func setDefaults() {
 
 	viper.SetDefault("grpc.host", "0.0.0.0")
 	viper.SetDefault("grpc.port", 50052)
+	viper.SetDefault("grpc.server_names", []string{"localhost", "orchestrator-service"})
+
+	viper.SetDefault("security.ca_cert_file", "/var/lib/orchestrator/ca/ca.crt")
+	viper.SetDefault("security.ca_key_file", "/var/lib/orchestrator/pki/ca.key")
+	viper.SetDefault("security.node_cert_validity_hours", 8760)
+	viper.SetDefault("security.join_token_ttl_hours", 24)
+
+	viper.SetDefault("monitor.offline_grace_seconds", 90)
+	viper.SetDefault("monitor.error_grace_seconds", 60)
+	viper.SetDefault("monitor.sweep_interval_seconds", 15)
+	viper.SetDefault("monitor.heartbeat_interval_seconds", 30)
 
 	viper.SetDefault("logging.level", "info")
 	viper.SetDefault("logging.format", "json")
@@ -126,9 +155,21 @@ func bindEnvVars() {
 	viper.BindEnv("grpc.port", "GRPC_PORT")
 	viper.BindEnv("grpc.host_key", "GRPC_HOST_KEY")
 	viper.BindEnv("grpc.cert_key", "GRPC_CERT_KEY")
+	viper.BindEnv("grpc.server_names", "GRPC_SERVER_NAMES")
 
 	viper.BindEnv("security.jwt_secret", "JWT_SECRET")
-	viper.BindEnv("security.node_auth_token", "NODE_AUTH_TOKEN")
+	viper.BindEnv("security.ca_cert_file", "CA_CERT_FILE")
+	viper.BindEnv("security.ca_key_file", "CA_KEY_FILE")
+	viper.BindEnv("security.node_cert_validity_hours", "NODE_CERT_VALIDITY_HOURS")
+	viper.BindEnv("security.join_token_ttl_hours", "JOIN_TOKEN_TTL_HOURS")
+
+	viper.BindEnv("monitor.offline_grace_seconds", "NODE_OFFLINE_GRACE_SECONDS")
+	viper.BindEnv("monitor.error_grace_seconds", "NODE_ERROR_GRACE_SECONDS")
+	viper.BindEnv("monitor.sweep_interval_seconds", "NODE_MONITOR_INTERVAL_SECONDS")
+	viper.BindEnv("monitor.heartbeat_interval_seconds", "NODE_HEARTBEAT_INTERVAL_SECONDS")
+
+	viper.BindEnv("api_service.url", "API_SERVICE_URL")
+	viper.BindEnv("api_service.internal_secret", "INTERNAL_API_SECRET")
 
 	viper.BindEnv("logging.level", "LOG_LEVEL")
 	viper.BindEnv("logging.format", "LOG_FORMAT")
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+	pb "hysteryVPN/orchestrator-service/pkg/proto"
+)
+
+// AdminServiceHandler implements the AdminService gRPC service used by the web UI
+type AdminServiceHandler struct {
+	pb.UnimplementedAdminServiceServer
+	services *services.Services
+	logger   *logrus.Logger
+}
+
+// NewAdminServiceHandler creates a new AdminServiceHandler
+func NewAdminServiceHandler(services *services.Services, logger *logrus.Logger) *AdminServiceHandler {
+	return &AdminServiceHandler{
+		services: services,
+		logger:   logger,
+	}
+}
+
+// PauseRollout stops a running rollout after its current wave
+func (h *AdminServiceHandler) PauseRollout(ctx context.Context, req *pb.RolloutControlRequest) (*pb.RolloutControlResponse, error) {
+	rollout, err := h.services.RolloutService.Pause(req.RolloutId)
+	if err != nil {
+		return rolloutControlError(err)
+	}
+
+	return &pb.RolloutControlResponse{
+		Success: true,
+		Message: "Rollout paused",
+		Status:  rollout.Status,
+	}, nil
+}
+
+// ResumeRollout continues a paused or halted rollout
+func (h *AdminServiceHandler) ResumeRollout(ctx context.Context, req *pb.RolloutControlRequest) (*pb.RolloutControlResponse, error) {
+	rollout, err := h.services.RolloutService.Resume(req.RolloutId)
+	if err != nil {
+		return rolloutControlError(err)
+	}
+
+	return &pb.RolloutControlResponse{
+		Success: true,
+		Message: "Rollout resumed",
+		Status:  rollout.Status,
+	}, nil
+}
+
+// rolloutControlError reports invalid transitions in the response body and
+// maps unexpected failures to gRPC errors
+func rolloutControlError(err error) (*pb.RolloutControlResponse, error) {
+	if errors.Is(err, services.ErrInvalidRolloutState) {
+		return &pb.RolloutControlResponse{Success: false, Message: err.Error()}, nil
+	}
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, status.Error(codes.NotFound, "rollout not found")
+	}
+	return nil, status.Error(codes.Internal, err.Error())
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DeploymentHandler serves config versions and deployments over REST
+type DeploymentHandler struct {
+	deploymentService services.DeploymentService
+	logger            *logrus.Logger
+}
+
+// CreateDeploymentRequest selects the config version to deploy
+type CreateDeploymentRequest struct {
+	Version string `json:"version" binding:"required"`
+}
+
+// RotateObfsRequest optionally sets the new Salamander password
+type RotateObfsRequest struct {
+	Password string `json:"password"`
+}
+
+// NewDeploymentHandler creates a new DeploymentHandler
+func NewDeploymentHandler(deploymentService services.DeploymentService, logger *logrus.Logger) *DeploymentHandler {
+	return &DeploymentHandler{
+		deploymentService: deploymentService,
+		logger:            logger,
+	}
+}
+
+// CreateVersion stores the request body, a rendered Hysteria2 JSON config, as a new version
+func (h *DeploymentHandler) CreateVersion(c *gin.Context) {
+	content, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	version, err := h.deploymentService.CreateVersion(c.Param("id"), content)
+	if err != nil {
+		h.logger.Errorf("Failed to create config version: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, version)
+}
+
+// ListVersions returns the node's config versions, newest first
+func (h *DeploymentHandler) ListVersions(c *gin.Context) {
+	versions, err := h.deploymentService.ListVersions(c.Param("id"), queryLimit(c))
+	if err != nil {
+		h.logger.Errorf("Failed to list config versions: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list config versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"versions": versions})
+}
+
+// GetVersion returns a single config version including its content
+func (h *DeploymentHandler) GetVersion(c *gin.Context) {
+	version, err := h.deploymentService.GetVersion(c.Param("id"), c.Param("version"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Config version not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, version)
+}
+
+// CreateDeployment queues a deployment of a stored version to the node
+func (h *DeploymentHandler) CreateDeployment(c *gin.Context) {
+	var req CreateDeploymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	deployment, err := h.deploymentService.Enqueue(c.Param("id"), req.Version)
+	if err != nil {
+		h.logger.Errorf("Failed to queue deployment: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, deployment)
+}
+
+// RotateObfs deploys the node's current config with a new Salamander password
+func (h *DeploymentHandler) RotateObfs(c *gin.Context) {
+	var req RotateObfsRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	deployment, err := h.deploymentService.RotateObfs(c.Param("id"), req.Password)
+	if err != nil {
+		if errors.Is(err, services.ErrNoDeployedConfig) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		h.logger.Errorf("Failed to rotate obfs password: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, deployment)
+}
+
+// ListDeployments returns the node's deployment history, newest first
+func (h *DeploymentHandler) ListDeployments(c *gin.Context) {
+	deployments, err := h.deploymentService.ListDeployments(c.Param("id"), queryLimit(c))
+	if err != nil {
+		h.logger.Errorf("Failed to list deployments: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list deployments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"deployments": deployments})
+}
+
+// GetDeployment returns a single deployment
+func (h *DeploymentHandler) GetDeployment(c *gin.Context) {
+	deployment, err := h.deploymentService.GetDeployment(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Deployment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deployment)
+}
+
+// queryLimit reads ?limit=, defaulting to 20 and capped at 100
+func queryLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit <= 0 {
+		return 20
+	}
+	if limit > 100 {
+		return 100
+	}
+	return limit
+}
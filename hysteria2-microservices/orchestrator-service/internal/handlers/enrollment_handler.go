@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// EnrollmentHandler serves join tokens and node revocation over REST
+type EnrollmentHandler struct {
+	enrollmentService services.EnrollmentService
+	logger            *logrus.Logger
+}
+
+// CreateJoinTokenRequest describes a join token to mint
+type CreateJoinTokenRequest struct {
+	Description string `json:"description"`
+	TTLSeconds  int    `json:"ttl_seconds"` // 0 uses the configured default
+}
+
+// NewEnrollmentHandler creates a new EnrollmentHandler
+func NewEnrollmentHandler(enrollmentService services.EnrollmentService, logger *logrus.Logger) *EnrollmentHandler {
+	return &EnrollmentHandler{
+		enrollmentService: enrollmentService,
+		logger:            logger,
+	}
+}
+
+// CreateJoinToken mints a single-use join token. The token is only shown in this response.
+func (h *EnrollmentHandler) CreateJoinToken(c *gin.Context) {
+	var req CreateJoinTokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+	if req.TTLSeconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ttl_seconds must not be negative"})
+		return
+	}
+
+	joinToken, token, err := h.enrollmentService.CreateJoinToken(req.Description, time.Duration(req.TTLSeconds)*time.Second)
+	if err != nil {
+		h.logger.Errorf("Failed to create join token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create join token"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"join_token":     joinToken,
+		"token":          token,
+		"ca_fingerprint": h.enrollmentService.CAFingerprint(),
+	})
+}
+
+// ListJoinTokens returns join tokens, newest first
+func (h *EnrollmentHandler) ListJoinTokens(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit := queryLimit(c)
+
+	tokens, total, err := h.enrollmentService.ListJoinTokens(page, limit)
+	if err != nil {
+		h.logger.Errorf("Failed to list join tokens: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list join tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"join_tokens": tokens,
+		"total":       total,
+		"page":        page,
+		"limit":       limit,
+	})
+}
+
+// DeleteJoinToken withdraws a join token before it is used
+func (h *EnrollmentHandler) DeleteJoinToken(c *gin.Context) {
+	if err := h.enrollmentService.DeleteJoinToken(c.Param("id")); err != nil {
+		h.logger.Errorf("Failed to delete join token: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete join token"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// RevokeNode revokes the node's certificates so it can no longer reach the MasterService
+func (h *EnrollmentHandler) RevokeNode(c *gin.Context) {
+	if err := h.enrollmentService.RevokeNode(c.Param("id")); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Node not found"})
+			return
+		}
+		h.logger.Errorf("Failed to revoke node: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke node"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Node revoked"})
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "hysteryVPN/orchestrator-service/pkg/proto"
+)
+
+// MasterServiceHandler implements the MasterService gRPC service called by nodes
+type MasterServiceHandler struct {
+	pb.UnimplementedMasterServiceServer
+	services *services.Services
+	logger   *logrus.Logger
+}
+
+// NewMasterServiceHandler creates a new MasterServiceHandler
+func NewMasterServiceHandler(services *services.Services, logger *logrus.Logger) *MasterServiceHandler {
+	return &MasterServiceHandler{
+		services: services,
+		logger:   logger,
+	}
+}
+
+// RegisterNode enrolls a new node with a join token and CSR, returning the
+// signed client certificate. An enrolled node calling with its certificate
+// re-registers instead and keeps its ID.
+func (h *MasterServiceHandler) RegisterNode(ctx context.Context, req *pb.RegisterNodeRequest) (*pb.RegisterNodeResponse, error) {
+	node := &models.VPSNode{
+		Name:          req.Name,
+		Hostname:      req.Hostname,
+		IPAddress:     req.IpAddress,
+		Location:      req.Location,
+		Country:       req.Country,
+		GRPCPort:      int(req.GrpcPort),
+		Version:       req.Version,
+		ConfigVersion: req.ConfigVersion,
+		Capabilities:  stringMapToJSONB(req.Capabilities),
+		Metadata:      stringMapToJSONB(req.Metadata),
+	}
+
+	if nodeID := nodeIDFromContext(ctx); nodeID != "" {
+		if req.NodeId != "" && req.NodeId != nodeID {
+			return nil, status.Error(codes.PermissionDenied, "node ID does not match certificate")
+		}
+
+		node, err := h.services.EnrollmentService.Reregister(nodeID, node)
+		if err != nil {
+			h.logger.Errorf("Re-registration of node %s failed: %v", nodeID, err)
+			if errors.Is(err, services.ErrNodeRevoked) {
+				return nil, status.Error(codes.PermissionDenied, err.Error())
+			}
+			return nil, status.Errorf(codes.Internal, "registration failed: %v", err)
+		}
+
+		return &pb.RegisterNodeResponse{
+			Success: true,
+			NodeId:  node.ID.String(),
+			Message: "Node registered",
+		}, nil
+	}
+
+	result, err := h.services.EnrollmentService.Enroll(&services.EnrollRequest{
+		Node:      node,
+		NodeID:    req.NodeId,
+		JoinToken: req.AuthToken,
+		CSR:       req.Csr,
+	})
+	if err != nil {
+		h.logger.Warnf("Enrollment of node %s (%s) failed: %v", req.Name, req.IpAddress, err)
+		if errors.Is(err, services.ErrInvalidJoinToken) {
+			return &pb.RegisterNodeResponse{
+				Success: false,
+				Message: err.Error(),
+			}, nil
+		}
+		return nil, status.Errorf(codes.Internal, "enrollment failed: %v", err)
+	}
+
+	return &pb.RegisterNodeResponse{
+		Success:       true,
+		NodeId:        result.Node.ID.String(),
+		Message:       "Node enrolled",
+		Certificate:   result.CertificatePEM,
+		CaCertificate: result.CACertPEM,
+	}, nil
+}
+
+// Heartbeat records that the node is alive. The node monitor derives its
+// status from the heartbeat and the Hysteria2 status the agent reports.
+// The response carries queued commands, which reach nodes behind NAT that
+// cannot be dialed, and their results come back with the next heartbeat.
+func (h *MasterServiceHandler) Heartbeat(ctx context.Context, req *pb.HeartbeatRequest) (*pb.HeartbeatResponse, error) {
+	nodeID := nodeIDFromContext(ctx)
+	if req.NodeId != "" && req.NodeId != nodeID {
+		return nil, status.Error(codes.PermissionDenied, "node ID does not match certificate")
+	}
+
+	if len(req.CommandResults) > 0 {
+		results := make([]services.CommandResult, 0, len(req.CommandResults))
+		for _, r := range req.CommandResults {
+			results = append(results, services.CommandResult{
+				CommandID: r.CommandId,
+				Success:   r.Success,
+				Output:    r.Output,
+			})
+		}
+		h.services.CommandService.Acknowledge(nodeID, results)
+	}
+
+	if len(req.Connections) > 0 {
+		connections := make([]services.ClientConnection, 0, len(req.Connections))
+		for _, c := range req.Connections {
+			connections = append(connections, services.ClientConnection{
+				ClientID:    c.ClientId,
+				Address:     c.Address,
+				ConnectedAt: c.ConnectedAt.AsTime(),
+			})
+		}
+		h.services.UserService.RecordClientConnections(nodeID, connections)
+	}
+
+	if _, err := h.services.NodeMonitor.RecordHeartbeat(nodeID, req.Status, time.Now()); err != nil {
+		h.logger.Errorf("Failed to record heartbeat from node %s: %v", nodeID, err)
+		return nil, status.Error(codes.Internal, "failed to record heartbeat")
+	}
+	if err := h.services.NodeMonitor.RecordMetrics(nodeID, req.Metrics, time.Now()); err != nil {
+		h.logger.Errorf("Failed to record metrics from node %s: %v", nodeID, err)
+	}
+
+	resp := &pb.HeartbeatResponse{
+		Success:                  true,
+		Message:                  "Heartbeat recorded",
+		HeartbeatIntervalSeconds: int32(h.services.NodeMonitor.HeartbeatInterval() / time.Second),
+	}
+
+	commands, err := h.services.CommandService.Dispatch(nodeID)
+	if err != nil {
+		// The heartbeat itself was recorded, commands go out with the next one
+		h.logger.Errorf("Failed to dispatch commands to node %s: %v", nodeID, err)
+		return resp, nil
+	}
+	for _, command := range commands {
+		params := make(map[string]string, len(command.Params))
+		for k, v := range command.Params {
+			params[k] = fmt.Sprint(v)
+		}
+		resp.PendingCommands = append(resp.PendingCommands, &pb.NodeCommand{
+			Id:     command.ID.String(),
+			Type:   command.Type,
+			Params: params,
+		})
+	}
+
+	return resp, nil
+}
+
+// SyncUsers returns the node's complete user table. Agents call it for the
+// resync-users command, since nodes behind NAT cannot be pushed to.
+func (h *MasterServiceHandler) SyncUsers(ctx context.Context, req *pb.SyncUsersRequest) (*pb.SyncUsersResponse, error) {
+	nodeID := nodeIDFromContext(ctx)
+	if req.NodeId != "" && req.NodeId != nodeID {
+		return nil, status.Error(codes.PermissionDenied, "node ID does not match certificate")
+	}
+
+	users, err := h.services.UserService.NodeUserTable()
+	if err != nil {
+		h.logger.Errorf("Failed to build user table for node %s: %v", nodeID, err)
+		return nil, status.Error(codes.Internal, "failed to load users")
+	}
+
+	resp := &pb.SyncUsersResponse{
+		Success: true,
+		Message: fmt.Sprintf("%d users", len(users)),
+	}
+	for _, user := range users {
+		resp.Users = append(resp.Users, &pb.NodeUser{
+			UserId:     user.UserID,
+			UserConfig: user.UserConfig,
+		})
+	}
+
+	return resp, nil
+}
+
+// ReportTraffic receives per-client traffic deltas streamed by a node. The
+// response is only sent once every report on the stream was recorded.
+func (h *MasterServiceHandler) ReportTraffic(stream pb.MasterService_ReportTrafficServer) error {
+	var received int32
+
+	for {
+		report, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&pb.TrafficReportResponse{
+				Success:         true,
+				Message:         "Traffic recorded",
+				ReportsReceived: received,
+			})
+		}
+		if err != nil {
+			h.logger.Errorf("Traffic stream error: %v", err)
+			return err
+		}
+
+		if report.NodeId != nodeIDFromContext(stream.Context()) {
+			return status.Error(codes.PermissionDenied, "traffic reported for another node")
+		}
+
+		recordedAt := time.Now()
+		if report.Timestamp != nil {
+			recordedAt = report.Timestamp.AsTime()
+		}
+
+		clients := make([]services.ClientTraffic, 0, len(report.Clients))
+		for _, c := range report.Clients {
+			clients = append(clients, services.ClientTraffic{
+				ClientID:    c.ClientId,
+				Upload:      c.Upload,
+				Download:    c.Download,
+				Connections: int(c.Connections),
+			})
+		}
+
+		// Agents without report sequences send 0, their reports cannot be
+		// told apart from a resend
+		reportID := ""
+		if report.Sequence != 0 {
+			reportID = fmt.Sprintf("%s:%d", report.NodeId, report.Sequence)
+		}
+
+		recorded, err := h.services.TrafficService.RecordNodeTraffic(reportID, report.NodeId, clients, recordedAt)
+		if err != nil {
+			// Abort the stream so the agent keeps the deltas and resends them
+			h.logger.Errorf("Failed to record traffic from node %s: %v", report.NodeId, err)
+			return status.Errorf(codes.Unavailable, "failed to record traffic: %v", err)
+		}
+
+		received++
+		h.logger.Debugf("Recorded traffic for %d clients from node %s", recorded, report.NodeId)
+	}
+}
+
+func stringMapToJSONB(values map[string]string) models.JSONB {
+	if len(values) == 0 {
+		return nil
+	}
+	result := make(models.JSONB, len(values))
+	for k, v := range values {
+		result[k] = v
+	}
+	return result
+}
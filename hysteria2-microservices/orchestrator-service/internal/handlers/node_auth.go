@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"strings"
+
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	pb "hysteryVPN/orchestrator-service/pkg/proto"
+)
+
+type nodeIDKey struct{}
+
+// NodeAuthInterceptor requires every MasterService call to present a client
+// certificate issued to the node by the internal CA. RegisterNode is also
+// accepted without one so new nodes can enroll with a join token.
+type NodeAuthInterceptor struct {
+	enrollmentService services.EnrollmentService
+	logger            *logrus.Logger
+}
+
+// NewNodeAuthInterceptor creates a new NodeAuthInterceptor
+func NewNodeAuthInterceptor(enrollmentService services.EnrollmentService, logger *logrus.Logger) *NodeAuthInterceptor {
+	return &NodeAuthInterceptor{
+		enrollmentService: enrollmentService,
+		logger:            logger,
+	}
+}
+
+// Unary returns the interceptor for unary calls
+func (i *NodeAuthInterceptor) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := i.authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Stream returns the interceptor for streaming calls
+func (i *NodeAuthInterceptor) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := i.authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func (i *NodeAuthInterceptor) authenticate(ctx context.Context, method string) (context.Context, error) {
+	service := "/" + pb.MasterService_ServiceDesc.ServiceName + "/"
+	if !strings.HasPrefix(method, service) {
+		return ctx, nil
+	}
+
+	var cert *x509.Certificate
+	p, ok := peer.FromContext(ctx)
+	if ok {
+		tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+		if ok && len(tlsInfo.State.VerifiedChains) > 0 && len(tlsInfo.State.VerifiedChains[0]) > 0 {
+			cert = tlsInfo.State.VerifiedChains[0][0]
+		}
+	}
+	if cert == nil {
+		// New nodes enroll with a join token before they hold a certificate
+		if method == service+"RegisterNode" {
+			return ctx, nil
+		}
+		return nil, status.Error(codes.Unauthenticated, "node certificate required")
+	}
+
+	nodeID, err := i.enrollmentService.AuthenticateNode(cert)
+	if err != nil {
+		i.logger.Warnf("Rejected %s from %s: %v", method, p.Addr, err)
+		if errors.Is(err, services.ErrNodeRevoked) || errors.Is(err, services.ErrUnknownCertificate) {
+			return nil, status.Error(codes.PermissionDenied, err.Error())
+		}
+		return nil, status.Error(codes.Internal, "failed to authenticate node")
+	}
+
+	return context.WithValue(ctx, nodeIDKey{}, nodeID), nil
+}
+
+// authenticatedStream carries the authenticated node ID into stream handlers
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// nodeIDFromContext returns the node ID authenticated by NodeAuthInterceptor
+func nodeIDFromContext(ctx context.Context) string {
+	nodeID, _ := ctx.Value(nodeIDKey{}).(string)
+	return nodeID
+}
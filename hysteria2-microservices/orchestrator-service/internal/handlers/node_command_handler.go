@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// NodeCommandHandler serves the node command queue over REST
+type NodeCommandHandler struct {
+	commandService services.CommandService
+	logger         *logrus.Logger
+}
+
+// CreateCommandRequest describes a command to queue for a node
+type CreateCommandRequest struct {
+	Type   string            `json:"type" binding:"required"`
+	Params map[string]string `json:"params"`
+}
+
+// NewNodeCommandHandler creates a new NodeCommandHandler
+func NewNodeCommandHandler(commandService services.CommandService, logger *logrus.Logger) *NodeCommandHandler {
+	return &NodeCommandHandler{
+		commandService: commandService,
+		logger:         logger,
+	}
+}
+
+// CreateCommand queues a command, delivered with the node's next heartbeat
+func (h *NodeCommandHandler) CreateCommand(c *gin.Context) {
+	var req CreateCommandRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	command, err := h.commandService.Enqueue(c.Param("id"), req.Type, req.Params)
+	if err != nil {
+		h.commandError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, command)
+}
+
+// ListCommands returns a node's commands, newest first
+func (h *NodeCommandHandler) ListCommands(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit := queryLimit(c)
+
+	commands, total, err := h.commandService.List(c.Param("id"), page, limit)
+	if err != nil {
+		h.logger.Errorf("Failed to list node commands: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list node commands"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"commands": commands,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// GetCommand returns a command with its result once the node reported it
+func (h *NodeCommandHandler) GetCommand(c *gin.Context) {
+	command, err := h.commandService.Get(c.Param("id"))
+	if err != nil {
+		h.commandError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, command)
+}
+
+// CancelCommand withdraws a command that was not delivered yet
+func (h *NodeCommandHandler) CancelCommand(c *gin.Context) {
+	command, err := h.commandService.Cancel(c.Param("id"))
+	if err != nil {
+		h.commandError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, command)
+}
+
+func (h *NodeCommandHandler) commandError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUnknownCommand):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidCommandState), errors.Is(err, services.ErrNodeRevoked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+	default:
+		h.logger.Errorf("Command request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Command request failed"})
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// NodeEventHandler serves the status history recorded by the node monitor
+type NodeEventHandler struct {
+	nodeMonitor services.NodeMonitor
+	logger      *logrus.Logger
+}
+
+// NewNodeEventHandler creates a new NodeEventHandler
+func NewNodeEventHandler(nodeMonitor services.NodeMonitor, logger *logrus.Logger) *NodeEventHandler {
+	return &NodeEventHandler{
+		nodeMonitor: nodeMonitor,
+		logger:      logger,
+	}
+}
+
+// ListEvents returns a node's status transitions, newest first
+func (h *NodeEventHandler) ListEvents(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit := queryLimit(c)
+
+	events, total, err := h.nodeMonitor.ListEvents(c.Param("id"), page, limit)
+	if err != nil {
+		h.logger.Errorf("Failed to list node events: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list node events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"events": events,
+		"total":  total,
+		"page":   page,
+		"limit":  limit,
+	})
+}
@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// RolloutHandler serves staged config rollouts over REST
+type RolloutHandler struct {
+	rolloutService services.RolloutService
+	logger         *logrus.Logger
+}
+
+// CreateRolloutRequest describes a rollout plan
+type CreateRolloutRequest struct {
+	Name                     string                 `json:"name" binding:"required"`
+	Config                   json.RawMessage        `json:"config" binding:"required"`
+	Selector                 models.RolloutSelector `json:"selector"`
+	BatchSize                int                    `json:"batch_size"`
+	BatchPercent             int                    `json:"batch_percent"`
+	PauseSeconds             *int                   `json:"pause_seconds"`
+	HeartbeatTimeoutSeconds  int                    `json:"heartbeat_timeout_seconds"`
+	MaxConnectionDropPercent *int                   `json:"max_connection_drop_percent"`
+	Start                    bool                   `json:"start"`
+}
+
+// NewRolloutHandler creates a new RolloutHandler
+func NewRolloutHandler(rolloutService services.RolloutService, logger *logrus.Logger) *RolloutHandler {
+	return &RolloutHandler{
+		rolloutService: rolloutService,
+		logger:         logger,
+	}
+}
+
+// CreateRollout plans a rollout and optionally starts it right away
+func (h *RolloutHandler) CreateRollout(c *gin.Context) {
+	var req CreateRolloutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	rollout := &models.Rollout{
+		Name:                     req.Name,
+		Content:                  string(req.Config),
+		Selector:                 req.Selector,
+		BatchSize:                req.BatchSize,
+		BatchPercent:             req.BatchPercent,
+		PauseSeconds:             300,
+		HeartbeatTimeoutSeconds:  req.HeartbeatTimeoutSeconds,
+		MaxConnectionDropPercent: 50,
+	}
+	if req.PauseSeconds != nil {
+		rollout.PauseSeconds = *req.PauseSeconds
+	}
+	if req.MaxConnectionDropPercent != nil {
+		rollout.MaxConnectionDropPercent = *req.MaxConnectionDropPercent
+	}
+
+	rollout, err := h.rolloutService.Create(rollout)
+	if err != nil {
+		h.logger.Errorf("Failed to create rollout: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Start {
+		if rollout, err = h.rolloutService.Start(rollout.ID.String()); err != nil {
+			h.rolloutError(c, err)
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, rollout)
+}
+
+// ListRollouts returns rollouts, newest first
+func (h *RolloutHandler) ListRollouts(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	if page < 1 {
+		page = 1
+	}
+	limit := queryLimit(c)
+
+	rollouts, total, err := h.rolloutService.List(page, limit, c.Query("status"))
+	if err != nil {
+		h.logger.Errorf("Failed to list rollouts: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list rollouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rollouts": rollouts,
+		"total":    total,
+		"page":     page,
+		"limit":    limit,
+	})
+}
+
+// GetRollout returns a rollout with per-node progress
+func (h *RolloutHandler) GetRollout(c *gin.Context) {
+	rollout, err := h.rolloutService.Get(c.Param("id"))
+	if err != nil {
+		h.rolloutError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+// StartRollout begins deploying a pending rollout
+func (h *RolloutHandler) StartRollout(c *gin.Context) {
+	h.control(c, h.rolloutService.Start)
+}
+
+// PauseRollout stops a running rollout after its current wave
+func (h *RolloutHandler) PauseRollout(c *gin.Context) {
+	h.control(c, h.rolloutService.Pause)
+}
+
+// ResumeRollout continues a paused or halted rollout
+func (h *RolloutHandler) ResumeRollout(c *gin.Context) {
+	h.control(c, h.rolloutService.Resume)
+}
+
+// CancelRollout stops a rollout for good
+func (h *RolloutHandler) CancelRollout(c *gin.Context) {
+	h.control(c, h.rolloutService.Cancel)
+}
+
+func (h *RolloutHandler) control(c *gin.Context, action func(id string) (*models.Rollout, error)) {
+	rollout, err := action(c.Param("id"))
+	if err != nil {
+		h.rolloutError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+func (h *RolloutHandler) rolloutError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidRolloutState):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rollout not found"})
+	default:
+		h.logger.Errorf("Rollout request failed: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Rollout request failed"})
+	}
+}
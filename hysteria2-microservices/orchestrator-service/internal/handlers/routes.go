@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+
+	"hysteryVPN/orchestrator-service/internal/middleware"
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// SetupRoutes registers the orchestrator REST API. Everything below /api/v1
+// requires the internal secret shared with the api-service.
+func SetupRoutes(r *gin.Engine, services *services.Services, internalSecret string, logger *logrus.Logger) {
+	r.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+
+	userHandler := NewUserHandler(services.UserService, logger)
+	deploymentHandler := NewDeploymentHandler(services.DeploymentService, logger)
+	rolloutHandler := NewRolloutHandler(services.RolloutService, logger)
+	enrollmentHandler := NewEnrollmentHandler(services.EnrollmentService, logger)
+	nodeEventHandler := NewNodeEventHandler(services.NodeMonitor, logger)
+	nodeCommandHandler := NewNodeCommandHandler(services.CommandService, logger)
+
+	api := r.Group("/api/v1", middleware.RequireSecret(internalSecret, logger))
+
+	users := api.Group("/users")
+	users.POST("/:id/kick", userHandler.KickUser)
+	users.POST("/:id/sync", userHandler.SyncUser)
+	users.POST("/:id/disconnect", userHandler.DisconnectUser)
+
+	api.GET("/connections", userHandler.ListConnections)
+
+	nodes := api.Group("/nodes")
+	nodes.POST("/:id/configs", deploymentHandler.CreateVersion)
+	nodes.GET("/:id/configs", deploymentHandler.ListVersions)
+	nodes.GET("/:id/configs/:version", deploymentHandler.GetVersion)
+	nodes.POST("/:id/deployments", deploymentHandler.CreateDeployment)
+	nodes.GET("/:id/deployments", deploymentHandler.ListDeployments)
+	nodes.POST("/:id/obfs/rotate", deploymentHandler.RotateObfs)
+	nodes.POST("/:id/revoke", enrollmentHandler.RevokeNode)
+	nodes.GET("/:id/events", nodeEventHandler.ListEvents)
+	nodes.POST("/:id/commands", nodeCommandHandler.CreateCommand)
+	nodes.GET("/:id/commands", nodeCommandHandler.ListCommands)
+
+	joinTokens := api.Group("/join-tokens")
+	joinTokens.POST("", enrollmentHandler.CreateJoinToken)
+	joinTokens.GET("", enrollmentHandler.ListJoinTokens)
+	joinTokens.DELETE("/:id", enrollmentHandler.DeleteJoinToken)
+
+	commands := api.Group("/commands")
+	commands.GET("/:id", nodeCommandHandler.GetCommand)
+	commands.POST("/:id/cancel", nodeCommandHandler.CancelCommand)
+
+	deployments := api.Group("/deployments")
+	deployments.GET("/:id", deploymentHandler.GetDeployment)
+
+	rollouts := api.Group("/rollouts")
+	rollouts.POST("", rolloutHandler.CreateRollout)
+	rollouts.GET("", rolloutHandler.ListRollouts)
+	rollouts.GET("/:id", rolloutHandler.GetRollout)
+	rollouts.POST("/:id/start", rolloutHandler.StartRollout)
+	rollouts.POST("/:id/pause", rolloutHandler.PauseRollout)
+	rollouts.POST("/:id/resume", rolloutHandler.ResumeRollout)
+	rollouts.POST("/:id/cancel", rolloutHandler.CancelRollout)
+}
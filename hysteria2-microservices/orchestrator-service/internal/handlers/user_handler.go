@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+
+	"hysteryVPN/orchestrator-service/internal/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// UserHandler serves fleet-wide user operations over REST
+type UserHandler struct {
+	userService services.UserService
+	logger      *logrus.Logger
+}
+
+// KickUserRequest lists the Hysteria2 client IDs to disconnect
+type KickUserRequest struct {
+	ClientIDs []string `json:"client_ids"`
+}
+
+// NewUserHandler creates a new UserHandler
+func NewUserHandler(userService services.UserService, logger *logrus.Logger) *UserHandler {
+	return &UserHandler{
+		userService: userService,
+		logger:      logger,
+	}
+}
+
+// KickUser disconnects a user's clients on every online node
+func (h *UserHandler) KickUser(c *gin.Context) {
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req KickUserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	result, err := h.userService.KickUser(c.Request.Context(), userID, req.ClientIDs)
+	if err != nil {
+		h.logger.Errorf("Failed to kick user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to kick user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// SyncUserRequest lists clients to disconnect in addition to those that lost access
+type SyncUserRequest struct {
+	ClientIDs []string `json:"client_ids"`
+}
+
+// SyncUser pushes the user's current clients to every online node
+func (h *UserHandler) SyncUser(c *gin.Context) {
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req SyncUserRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	result, err := h.userService.SyncUser(c.Request.Context(), userID, req.ClientIDs)
+	if err != nil {
+		h.logger.Errorf("Failed to sync user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sync user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// DisconnectUser drops a user's live connections on every online node
+// without revoking access. An empty client list disconnects all devices.
+func (h *UserHandler) DisconnectUser(c *gin.Context) {
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return
+	}
+
+	var req KickUserRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+			return
+		}
+	}
+
+	result, err := h.userService.DisconnectUser(c.Request.Context(), userID, req.ClientIDs)
+	if err != nil {
+		h.logger.Errorf("Failed to disconnect user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disconnect user"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ListConnections lists the clients connected to every online node
+func (h *UserHandler) ListConnections(c *gin.Context) {
+	online, err := h.userService.OnlineClients(c.Request.Context())
+	if err != nil {
+		h.logger.Errorf("Failed to list connections: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list connections"})
+		return
+	}
+
+	c.JSON(http.StatusOK, online)
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// Logger logs every REST request with its status and latency
+func Logger(logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		logger.WithFields(logrus.Fields{
+			"method":  c.Request.Method,
+			"path":    path,
+			"status":  c.Writer.Status(),
+			"latency": time.Since(start),
+			"ip":      c.ClientIP(),
+		}).Info("HTTP request")
+	}
+}
+
+// Recovery turns panics in handlers into 500 responses
+func Recovery(logger *logrus.Logger) gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		logger.Errorf("Panic recovered: %v", recovered)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+	})
+}
+
+// CORS allows the web UI to call the REST API from another origin
+func CORS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Access-Control-Allow-Origin", "*")
+		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Accept, Authorization, X-Internal-Secret")
+
+		if c.Request.Method == http.MethodOptions {
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSecret rejects requests without the shared X-Internal-Secret header
+// the api-service sends. The API stays closed while no secret is configured.
+func RequireSecret(secret string, logger *logrus.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if secret == "" || subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Internal-Secret")), []byte(secret)) != 1 {
+			logger.Warnf("Request to %s from %s with invalid internal secret", c.Request.URL.Path, c.ClientIP())
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid internal secret"})
+			return
+		}
+		c.Next()
+	}
+}
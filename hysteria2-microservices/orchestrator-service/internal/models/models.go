@@ -1,6 +1,10 @@
 package models
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,6 +22,7 @@ type VPSNode struct {
 	GRPCPort      int       `gorm:"default:50051" json:"grpc_port"`
 	Status        string    `gorm:"size:20;default:'offline';index" json:"status"`
 	Version       string    `gorm:"size:50" json:"version"`
+	ConfigVersion string    `gorm:"size:50" json:"config_version"` // last config version the agent reported applying
 	Capabilities  JSONB     `gorm:"type:jsonb" json:"capabilities"`
 	CreatedAt     time.Time `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	LastHeartbeat time.Time `gorm:"index" json:"last_heartbeat"`
@@ -66,11 +71,121 @@ type Deployment struct {
 	DeployedAt    *time.Time `json:"deployed_at"`
 	RollbackAt    *time.Time `json:"rollback_at"`
 	ErrorMessage  string     `gorm:"type:text" json:"error_message"`
+	CreatedAt     time.Time  `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
 
 	// Relations
 	Node *VPSNode `gorm:"foreignKey:NodeID" json:"node,omitempty"`
 }
 
+// ConfigVersion is an immutable rendered Hysteria2 config for a node.
+// Deployments reference it by Version.
+type ConfigVersion struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	NodeID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_config_versions_node_version" json:"node_id"`
+	Version   string    `gorm:"size:50;not null;uniqueIndex:idx_config_versions_node_version" json:"version"`
+	Checksum  string    `gorm:"size:64;not null" json:"checksum"`
+	Content   string    `gorm:"type:text;not null" json:"content"`
+	CreatedAt time.Time `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
+
+	// Relations
+	Node *VPSNode `gorm:"foreignKey:NodeID" json:"node,omitempty"`
+}
+
+// Rollout deploys one Hysteria2 config to a selection of nodes in waves
+type Rollout struct {
+	ID                       uuid.UUID       `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	Name                     string          `gorm:"size:100;not null" json:"name"`
+	Content                  string          `gorm:"type:text;not null" json:"content"`
+	Selector                 RolloutSelector `gorm:"embedded;embeddedPrefix:selector_" json:"selector"`
+	BatchSize                int             `json:"batch_size"`
+	BatchPercent             int             `json:"batch_percent"`
+	PauseSeconds             int             `json:"pause_seconds"`
+	HeartbeatTimeoutSeconds  int             `json:"heartbeat_timeout_seconds"`
+	MaxConnectionDropPercent int             `json:"max_connection_drop_percent"` // 0 disables the check
+	Status                   string          `gorm:"size:20;default:'pending';index" json:"status"`
+	TotalWaves               int             `json:"total_waves"`
+	CurrentWave              int             `json:"current_wave"`
+	HaltReason               string          `gorm:"type:text" json:"halt_reason"`
+	CreatedAt                time.Time       `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	StartedAt                *time.Time      `json:"started_at"`
+	FinishedAt               *time.Time      `json:"finished_at"`
+
+	// Relations
+	Nodes []RolloutNode `gorm:"foreignKey:RolloutID" json:"nodes,omitempty"`
+}
+
+// RolloutSelector picks the nodes a rollout targets. Empty fields match all.
+type RolloutSelector struct {
+	Country  string    `gorm:"size:2" json:"country,omitempty"`
+	Location string    `gorm:"size:100" json:"location,omitempty"`
+	Status   string    `gorm:"size:20" json:"status,omitempty"`
+	Labels   StringMap `gorm:"type:jsonb" json:"labels,omitempty"` // matched against node metadata
+}
+
+// RolloutNode tracks a single node's progress within a rollout
+type RolloutNode struct {
+	ID                uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	RolloutID         uuid.UUID  `gorm:"type:uuid;not null;index" json:"rollout_id"`
+	NodeID            uuid.UUID  `gorm:"type:uuid;not null;index" json:"node_id"`
+	Wave              int        `gorm:"not null" json:"wave"`
+	Status            string     `gorm:"size:20;default:'pending'" json:"status"`
+	ConfigVersion     string     `gorm:"size:50" json:"config_version"`
+	DeploymentID      *uuid.UUID `gorm:"type:uuid" json:"deployment_id"`
+	ConnectionsBefore int        `json:"connections_before"`
+	ErrorMessage      string     `gorm:"type:text" json:"error_message"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// JoinToken is a single-use secret an admin hands to a new node for
+// enrollment. Only the SHA-256 of the token is stored.
+type JoinToken struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	TokenHash   string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Description string     `gorm:"size:255" json:"description"`
+	ExpiresAt   time.Time  `gorm:"not null" json:"expires_at"`
+	UsedAt      *time.Time `json:"used_at"`
+	NodeID      *uuid.UUID `gorm:"type:uuid" json:"node_id"`
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
+// NodeEvent records a node status transition made by the liveness monitor
+type NodeEvent struct {
+	ID         uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	NodeID     uuid.UUID `gorm:"type:uuid;not null;index" json:"node_id"`
+	Type       string    `gorm:"size:50;not null" json:"type"`
+	FromStatus string    `gorm:"size:20" json:"from_status"`
+	ToStatus   string    `gorm:"size:20" json:"to_status"`
+	Reason     string    `gorm:"type:text" json:"reason"`
+	CreatedAt  time.Time `gorm:"default:CURRENT_TIMESTAMP;index" json:"created_at"`
+}
+
+// NodeCommand is an action queued for a node. Commands are delivered in
+// heartbeat responses, so nodes behind NAT receive them too, and their
+// results come back with a later heartbeat.
+type NodeCommand struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	NodeID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"node_id"`
+	Type        string     `gorm:"size:50;not null" json:"type"`
+	Params      JSONB      `gorm:"type:jsonb" json:"params"`
+	Status      string     `gorm:"size:20;default:'pending';index" json:"status"`
+	Attempts    int        `gorm:"default:0" json:"attempts"`
+	Result      string     `gorm:"type:text" json:"result"`
+	CreatedAt   time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+}
+
+// NodeCertificate records a client certificate issued to a node by the
+// internal CA. A certificate is rejected once RevokedAt is set.
+type NodeCertificate struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	NodeID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"node_id"`
+	SerialNumber string     `gorm:"size:40;not null;uniqueIndex" json:"serial_number"`
+	NotAfter     time.Time  `gorm:"not null" json:"not_after"`
+	RevokedAt    *time.Time `json:"revoked_at"`
+	CreatedAt    time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
 // User model (simplified version for this service)
 type User struct {
 	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
@@ -82,6 +197,8 @@ type User struct {
 	DataLimit  int64      `gorm:"default:0" json:"data_limit"`
 	DataUsed   int64      `gorm:"default:0" json:"data_used"`
 	ExpiryDate *time.Time `json:"expiry_date"`
+	UpMbps     int        `gorm:"default:0" json:"up_mbps"`   // 0 = plan or node default
+	DownMbps   int        `gorm:"default:0" json:"down_mbps"` // 0 = plan or node default
 	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
 	UpdatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"updated_at"`
 	LastLogin  *time.Time `json:"last_login"`
@@ -91,6 +208,40 @@ type User struct {
 	Assignments []NodeAssignment `gorm:"foreignKey:UserID" json:"assignments,omitempty"`
 }
 
+// UserBandwidth is the speed limit a user's clients get on the nodes, in
+// Mbit/s from the client's side: the user's own limit, or else the one of
+// its active plan. 0 leaves the node's bandwidth.
+type UserBandwidth struct {
+	UserID   uuid.UUID `json:"user_id"`
+	UpMbps   int       `json:"up_mbps"`
+	DownMbps int       `json:"down_mbps"`
+}
+
+// Device model (simplified version for this service)
+type Device struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	Name       string     `gorm:"size:100;not null" json:"name"`
+	DeviceID   string     `gorm:"size:255;unique;not null" json:"device_id"`
+	Status     string     `gorm:"size:20;default:'active'" json:"status"`
+	DataUsed   int64      `gorm:"default:0" json:"data_used"`
+	LastSeen   *time.Time `json:"last_seen"`
+	IPAddress  *string    `json:"ip_address"`       // address of the last reported connection
+	AuthSecret string     `gorm:"size:64" json:"-"` // Hysteria2 client secret, pushed to node user tables
+}
+
+// TrafficStats represents per-device traffic reported by a node
+type TrafficStats struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"user_id"`
+	DeviceID   *uuid.UUID `gorm:"type:uuid;index" json:"device_id"`
+	Upload     int64      `gorm:"default:0" json:"upload"`
+	Download   int64      `gorm:"default:0" json:"download"`
+	Total      int64      `gorm:"->" json:"total"` // Generated column in the shared schema
+	RecordedAt time.Time  `gorm:"default:CURRENT_TIMESTAMP;index" json:"recorded_at"`
+	CreatedAt  time.Time  `gorm:"default:CURRENT_TIMESTAMP" json:"created_at"`
+}
+
 // JSONB type for PostgreSQL JSONB fields
 type JSONB map[string]interface{}
 
@@ -123,6 +274,37 @@ func (j *JSONB) Scan(value interface{}) error {
 	return nil
 }
 
+// StringMap is a string map stored as JSONB
+type StringMap map[string]string
+
+// Value implements driver.Valuer interface
+func (m StringMap) Value() (driver.Value, error) {
+	if m == nil {
+		return nil, nil
+	}
+	return json.Marshal(m)
+}
+
+// Scan implements sql.Scanner interface
+func (m *StringMap) Scan(value interface{}) error {
+	if value == nil {
+		*m = nil
+		return nil
+	}
+
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into StringMap", value)
+	}
+
+	return json.Unmarshal(data, m)
+}
+
 // BeforeCreate hook for UUID generation
 func (v *VPSNode) BeforeCreate(tx *gorm.DB) error {
 	if v.ID == uuid.Nil {
@@ -152,6 +334,67 @@ func (d *Deployment) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+func (cv *ConfigVersion) BeforeCreate(tx *gorm.DB) error {
+	if cv.ID == uuid.Nil {
+		cv.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeUpdate keeps config versions immutable once stored
+func (cv *ConfigVersion) BeforeUpdate(tx *gorm.DB) error {
+	return ErrConfigVersionImmutable
+}
+
+func (r *Rollout) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+func (rn *RolloutNode) BeforeCreate(tx *gorm.DB) error {
+	if rn.ID == uuid.Nil {
+		rn.ID = uuid.New()
+	}
+	return nil
+}
+
+func (jt *JoinToken) BeforeCreate(tx *gorm.DB) error {
+	if jt.ID == uuid.Nil {
+		jt.ID = uuid.New()
+	}
+	return nil
+}
+
+func (nc *NodeCertificate) BeforeCreate(tx *gorm.DB) error {
+	if nc.ID == uuid.Nil {
+		nc.ID = uuid.New()
+	}
+	return nil
+}
+
+func (nc *NodeCommand) BeforeCreate(tx *gorm.DB) error {
+	if nc.ID == uuid.Nil {
+		nc.ID = uuid.New()
+	}
+	return nil
+}
+
+func (ne *NodeEvent) BeforeCreate(tx *gorm.DB) error {
+	if ne.ID == uuid.Nil {
+		ne.ID = uuid.New()
+	}
+	return nil
+}
+
+func (t *TrafficStats) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
 // TableName methods for custom table names
 func (VPSNode) TableName() string {
 	return "vps_nodes"
@@ -169,6 +412,42 @@ func (Deployment) TableName() string {
 	return "deployments"
 }
 
+func (ConfigVersion) TableName() string {
+	return "config_versions"
+}
+
+func (Rollout) TableName() string {
+	return "rollouts"
+}
+
+func (RolloutNode) TableName() string {
+	return "rollout_nodes"
+}
+
+func (JoinToken) TableName() string {
+	return "join_tokens"
+}
+
+func (NodeCertificate) TableName() string {
+	return "node_certificates"
+}
+
+func (NodeCommand) TableName() string {
+	return "node_commands"
+}
+
+func (NodeEvent) TableName() string {
+	return "node_events"
+}
+
+func (Device) TableName() string {
+	return "devices"
+}
+
+func (TrafficStats) TableName() string {
+	return "traffic_stats"
+}
+
 // Helper methods
 func (n *VPSNode) IsOnline() bool {
 	return n.Status == "online"
@@ -190,18 +469,46 @@ func (n *VPSNode) GetMetadata(key string) (interface{}, bool) {
 	return value, exists
 }
 
+// ErrConfigVersionImmutable is returned when a stored config version is modified
+var ErrConfigVersionImmutable = errors.New("config versions are immutable")
+
 // Constants
 const (
 	NodeStatusOffline     = "offline"
 	NodeStatusOnline      = "online"
 	NodeStatusMaintenance = "maintenance"
 	NodeStatusError       = "error"
+	NodeStatusRevoked     = "revoked"
+
+	NodeEventStatusChanged = "status_changed"
+
+	CommandRestart            = "restart"
+	CommandReload             = "reload"
+	CommandResyncUsers        = "resync-users"
+	CommandCollectDiagnostics = "collect-diagnostics"
+
+	CommandStatusPending   = "pending"
+	CommandStatusDelivered = "delivered"
+	CommandStatusSucceeded = "succeeded"
+	CommandStatusFailed    = "failed"
+	CommandStatusCancelled = "cancelled"
+
+	// NodeMetadataObfsPassword records the Salamander password of the config
+	// last deployed to the node, so share links follow obfs rotations
+	NodeMetadataObfsPassword = "obfs_password"
 
 	DeploymentStatusPending   = "pending"
 	DeploymentStatusDeploying = "deploying"
 	DeploymentStatusSuccess   = "success"
 	DeploymentStatusFailed    = "failed"
 
+	RolloutStatusPending   = "pending"
+	RolloutStatusRunning   = "running"
+	RolloutStatusPaused    = "paused"
+	RolloutStatusHalted    = "halted"
+	RolloutStatusCompleted = "completed"
+	RolloutStatusCancelled = "cancelled"
+
 	UserStatusActive    = "active"
 	UserStatusSuspended = "suspended"
 	UserStatusDeleted   = "deleted"
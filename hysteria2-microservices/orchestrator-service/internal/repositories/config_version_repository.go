@@ -0,0 +1,42 @@
+package repositories
+
+import (
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+)
+
+type ConfigVersionRepository struct {
+	db interfaces.Database
+}
+
+func NewConfigVersionRepository(db interfaces.Database) interfaces.ConfigVersionRepository {
+	return &ConfigVersionRepository{db: db}
+}
+
+func (r *ConfigVersionRepository) Create(version *models.ConfigVersion) error {
+	return r.db.Create(version).Error
+}
+
+func (r *ConfigVersionRepository) GetByVersion(nodeID, version string) (*models.ConfigVersion, error) {
+	var configVersion models.ConfigVersion
+	err := r.db.First(&configVersion, "node_id = ? AND version = ?", nodeID, version).Error
+	if err != nil {
+		return nil, err
+	}
+	return &configVersion, nil
+}
+
+func (r *ConfigVersionRepository) GetLatest(nodeID string) (*models.ConfigVersion, error) {
+	var configVersion models.ConfigVersion
+	err := r.db.Where("node_id = ?", nodeID).Order("created_at DESC").First(&configVersion).Error
+	if err != nil {
+		return nil, err
+	}
+	return &configVersion, nil
+}
+
+func (r *ConfigVersionRepository) ListByNode(nodeID string, limit int) ([]*models.ConfigVersion, error) {
+	var versions []*models.ConfigVersion
+	err := r.db.Where("node_id = ?", nodeID).Order("created_at DESC").Limit(limit).Find(&versions).Error
+	return versions, err
+}
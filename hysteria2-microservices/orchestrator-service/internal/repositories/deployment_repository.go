@@ -0,0 +1,71 @@
+package repositories
+
+import (
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+)
+
+type DeploymentRepository struct {
+	db interfaces.Database
+}
+
+func NewDeploymentRepository(db interfaces.Database) interfaces.DeploymentRepository {
+	return &DeploymentRepository{db: db}
+}
+
+func (r *DeploymentRepository) Create(deployment *models.Deployment) error {
+	return r.db.Create(deployment).Error
+}
+
+func (r *DeploymentRepository) GetByID(id string) (*models.Deployment, error) {
+	var deployment models.Deployment
+	err := r.db.First(&deployment, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+func (r *DeploymentRepository) GetByNodeID(nodeID string, limit int) ([]*models.Deployment, error) {
+	var deployments []*models.Deployment
+	err := r.db.Where("node_id = ?", nodeID).Order("created_at DESC").Limit(limit).Find(&deployments).Error
+	return deployments, err
+}
+
+func (r *DeploymentRepository) Update(deployment *models.Deployment) error {
+	return r.db.Save(deployment).Error
+}
+
+func (r *DeploymentRepository) GetLatestDeployment(nodeID string) (*models.Deployment, error) {
+	var deployment models.Deployment
+	err := r.db.Where("node_id = ?", nodeID).Order("created_at DESC").First(&deployment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+func (r *DeploymentRepository) GetLastSuccessful(nodeID string) (*models.Deployment, error) {
+	var deployment models.Deployment
+	err := r.db.Where("node_id = ? AND status = ?", nodeID, models.DeploymentStatusSuccess).
+		Order("deployed_at DESC").First(&deployment).Error
+	if err != nil {
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+func (r *DeploymentRepository) GetPendingDeployments() ([]*models.Deployment, error) {
+	var deployments []*models.Deployment
+	err := r.db.Where("status = ?", models.DeploymentStatusPending).Order("created_at ASC").Find(&deployments).Error
+	return deployments, err
+}
+
+// GetUnfinishedDeployments returns deployments that are pending or were left
+// deploying, oldest first
+func (r *DeploymentRepository) GetUnfinishedDeployments() ([]*models.Deployment, error) {
+	var deployments []*models.Deployment
+	err := r.db.Where("status IN ?", []string{models.DeploymentStatusPending, models.DeploymentStatusDeploying}).
+		Order("created_at ASC").Find(&deployments).Error
+	return deployments, err
+}
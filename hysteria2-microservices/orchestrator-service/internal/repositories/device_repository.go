@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+)
+
+type DeviceRepository struct {
+	db interfaces.Database
+}
+
+func NewDeviceRepository(db interfaces.Database) interfaces.DeviceRepository {
+	return &DeviceRepository{db: db}
+}
+
+func (r *DeviceRepository) GetByID(id string) (*models.Device, error) {
+	var device models.Device
+	err := r.db.First(&device, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+func (r *DeviceRepository) GetByIDs(ids []string) ([]*models.Device, error) {
+	var devices []*models.Device
+	if len(ids) == 0 {
+		return devices, nil
+	}
+	err := r.db.Where("id IN ?", ids).Find(&devices).Error
+	return devices, err
+}
+
+func (r *DeviceRepository) GetByUserID(userID string) ([]*models.Device, error) {
+	var devices []*models.Device
+	err := r.db.Where("user_id = ?", userID).Find(&devices).Error
+	return devices, err
+}
+
+// ListActiveClients returns the active devices with a Hysteria2 secret
+// whose owner is active and not expired, i.e. every client a node should accept
+func (r *DeviceRepository) ListActiveClients(now time.Time) ([]*models.Device, error) {
+	var devices []*models.Device
+	err := r.db.Joins("JOIN users ON users.id = devices.user_id").
+		Where("devices.status = ? AND devices.auth_secret <> ''", "active").
+		Where("users.status = ? AND (users.expiry_date IS NULL OR users.expiry_date > ?)", models.UserStatusActive, now).
+		Find(&devices).Error
+	return devices, err
+}
+
+// ListActiveClientsByUser is ListActiveClients for a single user
+func (r *DeviceRepository) ListActiveClientsByUser(userID string, now time.Time) ([]*models.Device, error) {
+	var devices []*models.Device
+	err := r.db.Joins("JOIN users ON users.id = devices.user_id").
+		Where("devices.user_id = ? AND devices.status = ? AND devices.auth_secret <> ''", userID, "active").
+		Where("users.status = ? AND (users.expiry_date IS NULL OR users.expiry_date > ?)", models.UserStatusActive, now).
+		Find(&devices).Error
+	return devices, err
+}
+
+// RecordConnection stores where and when a device last connected. Nodes
+// report independently, so an event older than the stored one is ignored.
+func (r *DeviceRepository) RecordConnection(id string, ipAddress string, at time.Time) error {
+	return r.db.Model(&models.Device{}).
+		Where("id = ? AND (last_seen IS NULL OR last_seen < ?)", id, at).
+		Updates(map[string]interface{}{
+			"last_seen":  at,
+			"ip_address": ipAddress,
+		}).Error
+}
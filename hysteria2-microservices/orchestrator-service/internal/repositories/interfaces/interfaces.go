@@ -16,7 +16,10 @@ type NodeRepository interface {
 	List(offset, limit int, statusFilter, locationFilter string) ([]*models.VPSNode, int64, error)
 	UpdateStatus(id, status string) error
 	UpdateLastHeartbeat(id string, heartbeat time.Time) error
-	GetOnlineNodes() ([]*models.VPSNode, error)
+	TransitionStatus(id, from, to string) (bool, error)
+	GetByStatuses(statuses []string) ([]*models.VPSNode, error)
+	GetOnlineNodes(heartbeatAfter time.Time) ([]*models.VPSNode, error)
+	MergeMetadata(id string, values models.JSONB) error
 }
 
 // NodeAssignmentRepository defines operations for user-node assignments
@@ -48,7 +51,59 @@ type DeploymentRepository interface {
 	GetByNodeID(nodeID string, limit int) ([]*models.Deployment, error)
 	Update(deployment *models.Deployment) error
 	GetLatestDeployment(nodeID string) (*models.Deployment, error)
+	GetLastSuccessful(nodeID string) (*models.Deployment, error)
 	GetPendingDeployments() ([]*models.Deployment, error)
+	GetUnfinishedDeployments() ([]*models.Deployment, error)
+}
+
+// ConfigVersionRepository stores immutable rendered node configs
+type ConfigVersionRepository interface {
+	Create(version *models.ConfigVersion) error
+	GetByVersion(nodeID, version string) (*models.ConfigVersion, error)
+	GetLatest(nodeID string) (*models.ConfigVersion, error)
+	ListByNode(nodeID string, limit int) ([]*models.ConfigVersion, error)
+}
+
+// RolloutRepository defines operations for staged config rollouts
+type RolloutRepository interface {
+	Create(rollout *models.Rollout) error
+	GetByID(id string) (*models.Rollout, error)
+	List(offset, limit int, statusFilter string) ([]*models.Rollout, int64, error)
+	GetByStatus(status string) ([]*models.Rollout, error)
+	TransitionStatus(id string, from []string, updates map[string]interface{}) (bool, error)
+	UpdateCurrentWave(id string, wave int) error
+	UpdateNode(node *models.RolloutNode) error
+}
+
+// JoinTokenRepository defines operations for node enrollment tokens
+type JoinTokenRepository interface {
+	Create(token *models.JoinToken) error
+	List(offset, limit int) ([]*models.JoinToken, int64, error)
+	Delete(id string) error
+	Consume(tokenHash, nodeID string, now time.Time) (bool, error)
+}
+
+// NodeCertificateRepository defines operations for issued node certificates
+type NodeCertificateRepository interface {
+	Create(cert *models.NodeCertificate) error
+	GetBySerial(serial string) (*models.NodeCertificate, error)
+	RevokeByNodeID(nodeID string, revokedAt time.Time) error
+}
+
+// NodeEventRepository defines operations for node status events
+type NodeEventRepository interface {
+	Create(event *models.NodeEvent) error
+	ListByNode(nodeID string, offset, limit int) ([]*models.NodeEvent, int64, error)
+}
+
+// NodeCommandRepository defines operations for the node command queue
+type NodeCommandRepository interface {
+	Create(command *models.NodeCommand) error
+	GetByID(id string) (*models.NodeCommand, error)
+	ListByNode(nodeID string, offset, limit int) ([]*models.NodeCommand, int64, error)
+	GetDeliverable(nodeID string, redeliverBefore time.Time, limit int) ([]*models.NodeCommand, error)
+	MarkDelivered(ids []string, deliveredAt time.Time) error
+	TransitionStatus(id string, from []string, updates map[string]interface{}) (bool, error)
 }
 
 // UserRepository defines operations for user management
@@ -61,4 +116,15 @@ type UserRepository interface {
 	Delete(id string) error
 	List(offset, limit int) ([]*models.User, int64, error)
 	Search(query string, offset, limit int) ([]*models.User, int64, error)
+	ListBandwidth(userIDs []string) ([]*models.UserBandwidth, error)
+}
+
+// DeviceRepository defines read access to user devices
+type DeviceRepository interface {
+	GetByID(id string) (*models.Device, error)
+	GetByIDs(ids []string) ([]*models.Device, error)
+	GetByUserID(userID string) ([]*models.Device, error)
+	ListActiveClients(now time.Time) ([]*models.Device, error)
+	ListActiveClientsByUser(userID string, now time.Time) ([]*models.Device, error)
+	RecordConnection(id string, ipAddress string, at time.Time) error
 }
@@ -0,0 +1,52 @@
+package repositories
+
+import (
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+)
+
+type JoinTokenRepository struct {
+	db interfaces.Database
+}
+
+func NewJoinTokenRepository(db interfaces.Database) interfaces.JoinTokenRepository {
+	return &JoinTokenRepository{db: db}
+}
+
+func (r *JoinTokenRepository) Create(token *models.JoinToken) error {
+	return r.db.Create(token).Error
+}
+
+func (r *JoinTokenRepository) List(offset, limit int) ([]*models.JoinToken, int64, error) {
+	var tokens []*models.JoinToken
+	var total int64
+
+	query := r.db.Model(&models.JoinToken{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&tokens).Error
+	return tokens, total, err
+}
+
+func (r *JoinTokenRepository) Delete(id string) error {
+	return r.db.Delete(&models.JoinToken{}, "id = ?", id).Error
+}
+
+// Consume marks an unused, unexpired token as used by nodeID in a single
+// statement, so a token can enroll at most one node
+func (r *JoinTokenRepository) Consume(tokenHash, nodeID string, now time.Time) (bool, error) {
+	result := r.db.Model(&models.JoinToken{}).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, now).
+		Updates(map[string]interface{}{
+			"used_at": now,
+			"node_id": nodeID,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
@@ -0,0 +1,35 @@
+package repositories
+
+import (
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+)
+
+type NodeCertificateRepository struct {
+	db interfaces.Database
+}
+
+func NewNodeCertificateRepository(db interfaces.Database) interfaces.NodeCertificateRepository {
+	return &NodeCertificateRepository{db: db}
+}
+
+func (r *NodeCertificateRepository) Create(cert *models.NodeCertificate) error {
+	return r.db.Create(cert).Error
+}
+
+func (r *NodeCertificateRepository) GetBySerial(serial string) (*models.NodeCertificate, error) {
+	var cert models.NodeCertificate
+	err := r.db.First(&cert, "serial_number = ?", serial).Error
+	if err != nil {
+		return nil, err
+	}
+	return &cert, nil
+}
+
+func (r *NodeCertificateRepository) RevokeByNodeID(nodeID string, revokedAt time.Time) error {
+	return r.db.Model(&models.NodeCertificate{}).
+		Where("node_id = ? AND revoked_at IS NULL", nodeID).
+		Update("revoked_at", revokedAt).Error
+}
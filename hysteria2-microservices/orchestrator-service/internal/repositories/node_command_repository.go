@@ -0,0 +1,79 @@
+package repositories
+
+import (
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"gorm.io/gorm"
+)
+
+type NodeCommandRepository struct {
+	db interfaces.Database
+}
+
+func NewNodeCommandRepository(db interfaces.Database) interfaces.NodeCommandRepository {
+	return &NodeCommandRepository{db: db}
+}
+
+func (r *NodeCommandRepository) Create(command *models.NodeCommand) error {
+	return r.db.Create(command).Error
+}
+
+func (r *NodeCommandRepository) GetByID(id string) (*models.NodeCommand, error) {
+	var command models.NodeCommand
+	err := r.db.First(&command, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &command, nil
+}
+
+func (r *NodeCommandRepository) ListByNode(nodeID string, offset, limit int) ([]*models.NodeCommand, int64, error) {
+	var commands []*models.NodeCommand
+	var total int64
+
+	query := r.db.Model(&models.NodeCommand{}).Where("node_id = ?", nodeID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&commands).Error
+	return commands, total, err
+}
+
+// GetDeliverable returns the node's pending commands, plus delivered ones
+// that were not acknowledged before redeliverBefore, oldest first
+func (r *NodeCommandRepository) GetDeliverable(nodeID string, redeliverBefore time.Time, limit int) ([]*models.NodeCommand, error) {
+	var commands []*models.NodeCommand
+	err := r.db.Where("node_id = ?", nodeID).
+		Where("status = ? OR (status = ? AND delivered_at < ?)", models.CommandStatusPending, models.CommandStatusDelivered, redeliverBefore).
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&commands).Error
+	return commands, err
+}
+
+func (r *NodeCommandRepository) MarkDelivered(ids []string, deliveredAt time.Time) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return r.db.Model(&models.NodeCommand{}).
+		Where("id IN ?", ids).
+		Updates(map[string]interface{}{
+			"status":       models.CommandStatusDelivered,
+			"delivered_at": deliveredAt,
+			"attempts":     gorm.Expr("attempts + 1"),
+		}).Error
+}
+
+// TransitionStatus applies updates only while the command is in one of the
+// from statuses, so a late result cannot overwrite a cancellation
+func (r *NodeCommandRepository) TransitionStatus(id string, from []string, updates map[string]interface{}) (bool, error) {
+	result := r.db.Model(&models.NodeCommand{}).Where("id = ? AND status IN ?", id, from).Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
@@ -0,0 +1,31 @@
+package repositories
+
+import (
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+)
+
+type NodeEventRepository struct {
+	db interfaces.Database
+}
+
+func NewNodeEventRepository(db interfaces.Database) interfaces.NodeEventRepository {
+	return &NodeEventRepository{db: db}
+}
+
+func (r *NodeEventRepository) Create(event *models.NodeEvent) error {
+	return r.db.Create(event).Error
+}
+
+func (r *NodeEventRepository) ListByNode(nodeID string, offset, limit int) ([]*models.NodeEvent, int64, error) {
+	var events []*models.NodeEvent
+	var total int64
+
+	query := r.db.Model(&models.NodeEvent{}).Where("node_id = ?", nodeID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&events).Error
+	return events, total, err
+}
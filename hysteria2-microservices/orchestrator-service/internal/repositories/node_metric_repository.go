@@ -0,0 +1,60 @@
+package repositories
+
+import (
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+)
+
+type NodeMetricRepository struct {
+	db interfaces.Database
+}
+
+func NewNodeMetricRepository(db interfaces.Database) interfaces.NodeMetricRepository {
+	return &NodeMetricRepository{db: db}
+}
+
+func (r *NodeMetricRepository) Create(metric *models.NodeMetric) error {
+	return r.db.Create(metric).Error
+}
+
+func (r *NodeMetricRepository) GetByNodeID(nodeID string, limit int) ([]*models.NodeMetric, error) {
+	var metrics []*models.NodeMetric
+	err := r.db.Where("node_id = ?", nodeID).Order("recorded_at DESC").Limit(limit).Find(&metrics).Error
+	return metrics, err
+}
+
+func (r *NodeMetricRepository) GetLatest(nodeID string) (*models.NodeMetric, error) {
+	var metric models.NodeMetric
+	err := r.db.Where("node_id = ?", nodeID).Order("recorded_at DESC").First(&metric).Error
+	if err != nil {
+		return nil, err
+	}
+	return &metric, nil
+}
+
+func (r *NodeMetricRepository) GetByTimeRange(nodeID string, startTime, endTime time.Time) ([]*models.NodeMetric, error) {
+	var metrics []*models.NodeMetric
+	err := r.db.Where("node_id = ? AND recorded_at BETWEEN ? AND ?", nodeID, startTime, endTime).
+		Order("recorded_at ASC").Find(&metrics).Error
+	return metrics, err
+}
+
+func (r *NodeMetricRepository) DeleteOldMetrics(before time.Time) error {
+	return r.db.Where("recorded_at < ?", before).Delete(&models.NodeMetric{}).Error
+}
+
+func (r *NodeMetricRepository) GetAverageMetrics(nodeID string, duration time.Duration) (*models.NodeMetric, error) {
+	var metric models.NodeMetric
+	err := r.db.Model(&models.NodeMetric{}).
+		Select("AVG(cpu_usage) AS cpu_usage, AVG(memory_usage) AS memory_usage, "+
+			"AVG(bandwidth_up)::bigint AS bandwidth_up, AVG(bandwidth_down)::bigint AS bandwidth_down, "+
+			"AVG(active_connections)::int AS active_connections").
+		Where("node_id = ? AND recorded_at >= ?", nodeID, time.Now().Add(-duration)).
+		Scan(&metric).Error
+	if err != nil {
+		return nil, err
+	}
+	return &metric, nil
+}
@@ -1,10 +1,13 @@
 package repositories
 
 import (
+	"encoding/json"
 	"time"
 
 	"hysteryVPN/orchestrator-service/internal/models"
 	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"gorm.io/gorm"
 )
 
 type NodeRepository struct {
@@ -81,8 +84,37 @@ func (r *NodeRepository) UpdateLastHeartbeat(id string, heartbeat time.Time) err
 	return r.db.Model(&models.VPSNode{}).Where("id = ?", id).Update("last_heartbeat", heartbeat).Error
 }
 
-func (r *NodeRepository) GetOnlineNodes() ([]*models.VPSNode, error) {
+// TransitionStatus changes the status only while the node is still in the
+// from status, so the monitor and heartbeats cannot record the same change twice
+func (r *NodeRepository) TransitionStatus(id, from, to string) (bool, error) {
+	result := r.db.Model(&models.VPSNode{}).Where("id = ? AND status = ?", id, from).Update("status", to)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *NodeRepository) GetByStatuses(statuses []string) ([]*models.VPSNode, error) {
+	var nodes []*models.VPSNode
+	err := r.db.Where("status IN ?", statuses).Find(&nodes).Error
+	return nodes, err
+}
+
+// GetOnlineNodes returns online nodes that have sent a heartbeat after
+// heartbeatAfter, leaving out nodes the monitor has not yet marked offline
+func (r *NodeRepository) GetOnlineNodes(heartbeatAfter time.Time) ([]*models.VPSNode, error) {
 	var nodes []*models.VPSNode
-	err := r.db.Where("status = ?", models.NodeStatusOnline).Find(&nodes).Error
+	err := r.db.Where("status = ? AND last_heartbeat > ?", models.NodeStatusOnline, heartbeatAfter).Find(&nodes).Error
 	return nodes, err
 }
+
+// MergeMetadata sets the given metadata keys and keeps the others, without
+// overwriting columns that heartbeats update concurrently
+func (r *NodeRepository) MergeMetadata(id string, values models.JSONB) error {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return r.db.Model(&models.VPSNode{}).Where("id = ?", id).
+		Update("metadata", gorm.Expr("COALESCE(metadata, '{}'::jsonb) || ?::jsonb", string(data))).Error
+}
@@ -0,0 +1,77 @@
+package repositories
+
+import (
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"gorm.io/gorm"
+)
+
+type RolloutRepository struct {
+	db interfaces.Database
+}
+
+func NewRolloutRepository(db interfaces.Database) interfaces.RolloutRepository {
+	return &RolloutRepository{db: db}
+}
+
+// Create stores the rollout together with its nodes
+func (r *RolloutRepository) Create(rollout *models.Rollout) error {
+	return r.db.Create(rollout).Error
+}
+
+func (r *RolloutRepository) GetByID(id string) (*models.Rollout, error) {
+	var rollout models.Rollout
+	err := r.db.Preload("Nodes", func(db *gorm.DB) *gorm.DB {
+		return db.Order("wave ASC")
+	}).First(&rollout, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rollout, nil
+}
+
+func (r *RolloutRepository) List(offset, limit int, statusFilter string) ([]*models.Rollout, int64, error) {
+	var rollouts []*models.Rollout
+	var total int64
+
+	query := r.db.Model(&models.Rollout{})
+	if statusFilter != "" {
+		query = query.Where("status = ?", statusFilter)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order("created_at DESC").Offset(offset).Limit(limit).Find(&rollouts).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return rollouts, total, nil
+}
+
+func (r *RolloutRepository) GetByStatus(status string) ([]*models.Rollout, error) {
+	var rollouts []*models.Rollout
+	err := r.db.Where("status = ?", status).Order("created_at ASC").Find(&rollouts).Error
+	return rollouts, err
+}
+
+// TransitionStatus applies updates only while the rollout is in one of the
+// from statuses, so concurrent pause/resume/halt calls cannot overwrite each other
+func (r *RolloutRepository) TransitionStatus(id string, from []string, updates map[string]interface{}) (bool, error) {
+	result := r.db.Model(&models.Rollout{}).Where("id = ? AND status IN ?", id, from).Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (r *RolloutRepository) UpdateCurrentWave(id string, wave int) error {
+	return r.db.Model(&models.Rollout{}).Where("id = ?", id).Update("current_wave", wave).Error
+}
+
+func (r *RolloutRepository) UpdateNode(node *models.RolloutNode) error {
+	return r.db.Save(node).Error
+}
@@ -0,0 +1,109 @@
+package repositories
+
+import (
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+)
+
+type UserRepository struct {
+	db interfaces.Database
+}
+
+func NewUserRepository(db interfaces.Database) interfaces.UserRepository {
+	return &UserRepository{db: db}
+}
+
+func (r *UserRepository) GetByID(id string) (*models.User, error) {
+	var user models.User
+	err := r.db.First(&user, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
+	var user models.User
+	err := r.db.First(&user, "email = ?", email).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) GetByUsername(username string) (*models.User, error) {
+	var user models.User
+	err := r.db.First(&user, "username = ?", username).Error
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (r *UserRepository) Create(user *models.User) error {
+	return r.db.Create(user).Error
+}
+
+func (r *UserRepository) Update(user *models.User) error {
+	return r.db.Save(user).Error
+}
+
+func (r *UserRepository) Delete(id string) error {
+	return r.db.Delete(&models.User{}, "id = ?", id).Error
+}
+
+func (r *UserRepository) List(offset, limit int) ([]*models.User, int64, error) {
+	var users []*models.User
+	var total int64
+
+	query := r.db.Model(&models.User{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Offset(offset).Limit(limit).Order("created_at DESC").Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+func (r *UserRepository) Search(query string, offset, limit int) ([]*models.User, int64, error) {
+	var users []*models.User
+	var total int64
+
+	pattern := "%" + query + "%"
+	search := r.db.Model(&models.User{}).
+		Where("username ILIKE ? OR email ILIKE ? OR full_name ILIKE ?", pattern, pattern, pattern)
+	if err := search.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := search.Offset(offset).Limit(limit).Order("created_at DESC").Find(&users).Error
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// ListBandwidth returns the speed limits of the given users. A user's own
+// limit wins over the one of its active plan, plans and subscriptions are
+// the api-service's tables.
+func (r *UserRepository) ListBandwidth(userIDs []string) ([]*models.UserBandwidth, error) {
+	var limits []*models.UserBandwidth
+	if len(userIDs) == 0 {
+		return limits, nil
+	}
+
+	err := r.db.Table("users").
+		Select("users.id AS user_id, "+
+			"COALESCE(NULLIF(users.up_mbps, 0), plans.up_mbps, 0) AS up_mbps, "+
+			"COALESCE(NULLIF(users.down_mbps, 0), plans.down_mbps, 0) AS down_mbps").
+		Joins("LEFT JOIN subscriptions ON subscriptions.user_id = users.id AND subscriptions.status = ?", "active").
+		Joins("LEFT JOIN plans ON plans.id = subscriptions.plan_id").
+		Where("users.id IN ?", userIDs).
+		Scan(&limits).Error
+	return limits, err
+}
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// commandAckTimeout is how long a delivered command may stay without a
+	// result before it is delivered again
+	commandAckTimeout = 5 * time.Minute
+
+	// maxCommandAttempts bounds redeliveries of a command the node never acknowledges
+	maxCommandAttempts = 3
+
+	maxCommandsPerHeartbeat = 10
+	maxCommandResultSize    = 64 * 1024
+)
+
+var (
+	// ErrUnknownCommand is returned when enqueuing an unsupported command type
+	ErrUnknownCommand = errors.New("unknown command type")
+	// ErrInvalidCommandState is returned when cancelling a command that was already delivered
+	ErrInvalidCommandState = errors.New("invalid command state")
+)
+
+var commandTypes = map[string]bool{
+	models.CommandRestart:            true,
+	models.CommandReload:             true,
+	models.CommandResyncUsers:        true,
+	models.CommandCollectDiagnostics: true,
+}
+
+// CommandResult is a node's report on a command it executed
+type CommandResult struct {
+	CommandID string
+	Success   bool
+	Output    string
+}
+
+// CommandService queues commands for nodes. Commands are stored until a
+// heartbeat picks them up and redelivered when the node does not
+// acknowledge them, so they survive orchestrator and agent restarts.
+type CommandService interface {
+	Enqueue(nodeID, commandType string, params map[string]string) (*models.NodeCommand, error)
+	Get(id string) (*models.NodeCommand, error)
+	List(nodeID string, page, limit int) ([]*models.NodeCommand, int64, error)
+	Cancel(id string) (*models.NodeCommand, error)
+	Dispatch(nodeID string) ([]*models.NodeCommand, error)
+	Acknowledge(nodeID string, results []CommandResult)
+}
+
+type commandService struct {
+	commandRepo interfaces.NodeCommandRepository
+	nodeRepo    interfaces.NodeRepository
+	logger      *logrus.Logger
+}
+
+// NewCommandService creates a new CommandService
+func NewCommandService(commandRepo interfaces.NodeCommandRepository, nodeRepo interfaces.NodeRepository, logger *logrus.Logger) CommandService {
+	return &commandService{
+		commandRepo: commandRepo,
+		nodeRepo:    nodeRepo,
+		logger:      logger,
+	}
+}
+
+// Enqueue queues a command for the node's next heartbeat. Config changes such
+// as obfs rotation go through DeploymentService instead.
+func (s *commandService) Enqueue(nodeID, commandType string, params map[string]string) (*models.NodeCommand, error) {
+	if !commandTypes[commandType] {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownCommand, commandType)
+	}
+
+	node, err := s.nodeRepo.GetByID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if node.Status == models.NodeStatusRevoked {
+		return nil, ErrNodeRevoked
+	}
+
+	command := &models.NodeCommand{
+		NodeID: node.ID,
+		Type:   commandType,
+		Params: make(models.JSONB, len(params)),
+		Status: models.CommandStatusPending,
+	}
+	for k, v := range params {
+		command.Params[k] = v
+	}
+	if err := s.commandRepo.Create(command); err != nil {
+		return nil, fmt.Errorf("failed to queue command: %w", err)
+	}
+
+	s.logger.Infof("Queued %s command %s for node %s", command.Type, command.ID, nodeID)
+	return command, nil
+}
+
+func (s *commandService) Get(id string) (*models.NodeCommand, error) {
+	return s.commandRepo.GetByID(id)
+}
+
+func (s *commandService) List(nodeID string, page, limit int) ([]*models.NodeCommand, int64, error) {
+	return s.commandRepo.ListByNode(nodeID, (page-1)*limit, limit)
+}
+
+// Cancel withdraws a command that has not been delivered yet
+func (s *commandService) Cancel(id string) (*models.NodeCommand, error) {
+	if _, err := s.commandRepo.GetByID(id); err != nil {
+		return nil, err
+	}
+
+	changed, err := s.commandRepo.TransitionStatus(id, []string{models.CommandStatusPending}, map[string]interface{}{
+		"status":       models.CommandStatusCancelled,
+		"completed_at": time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel command: %w", err)
+	}
+	if !changed {
+		return nil, ErrInvalidCommandState
+	}
+
+	return s.commandRepo.GetByID(id)
+}
+
+// Dispatch returns the commands to hand to the node in its heartbeat
+// response and marks them delivered. Commands that were delivered too often
+// without a result are failed instead.
+func (s *commandService) Dispatch(nodeID string) ([]*models.NodeCommand, error) {
+	now := time.Now()
+	commands, err := s.commandRepo.GetDeliverable(nodeID, now.Add(-commandAckTimeout), maxCommandsPerHeartbeat)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load commands: %w", err)
+	}
+
+	deliver := make([]*models.NodeCommand, 0, len(commands))
+	ids := make([]string, 0, len(commands))
+	for _, command := range commands {
+		if command.Attempts >= maxCommandAttempts {
+			s.complete(command.ID.String(), models.CommandStatusFailed,
+				fmt.Sprintf("not acknowledged after %d deliveries", command.Attempts), now)
+			continue
+		}
+		deliver = append(deliver, command)
+		ids = append(ids, command.ID.String())
+	}
+
+	if err := s.commandRepo.MarkDelivered(ids, now); err != nil {
+		return nil, fmt.Errorf("failed to mark commands delivered: %w", err)
+	}
+
+	return deliver, nil
+}
+
+// Acknowledge records the results a node reported. Results for commands of
+// other nodes, or for commands already completed or cancelled, are ignored.
+func (s *commandService) Acknowledge(nodeID string, results []CommandResult) {
+	now := time.Now()
+	for _, result := range results {
+		command, err := s.commandRepo.GetByID(result.CommandID)
+		if err != nil {
+			s.logger.Warnf("Node %s acknowledged unknown command %s: %v", nodeID, result.CommandID, err)
+			continue
+		}
+		if command.NodeID.String() != nodeID {
+			s.logger.Warnf("Node %s acknowledged command %s of node %s", nodeID, command.ID, command.NodeID)
+			continue
+		}
+
+		status := models.CommandStatusSucceeded
+		if !result.Success {
+			status = models.CommandStatusFailed
+		}
+		output := result.Output
+		if len(output) > maxCommandResultSize {
+			output = output[:maxCommandResultSize]
+		}
+
+		if !s.complete(result.CommandID, status, output, now) {
+			continue
+		}
+		s.logger.Infof("Command %s (%s) on node %s %s", command.ID, command.Type, nodeID, status)
+	}
+}
+
+func (s *commandService) complete(id, status, result string, at time.Time) bool {
+	changed, err := s.commandRepo.TransitionStatus(id, []string{models.CommandStatusDelivered}, map[string]interface{}{
+		"status":       status,
+		"result":       result,
+		"completed_at": at,
+	})
+	if err != nil {
+		s.logger.Errorf("Failed to complete command %s: %v", id, err)
+		return false
+	}
+	return changed
+}
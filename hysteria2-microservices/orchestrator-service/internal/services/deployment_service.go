@@ -0,0 +1,440 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	pb "hysteryVPN/orchestrator-service/pkg/proto"
+)
+
+const (
+	// hysteria2ConfigType is the config_type agents accept for Hysteria2 configs
+	hysteria2ConfigType = "hysteria2"
+
+	healthCheckAttempts = 5
+	healthCheckInterval = 3 * time.Second
+
+	// reloadTimeout covers the agent probing the new config and, when it
+	// fails, restoring and probing its last known good one
+	reloadTimeout = 30 * time.Second
+)
+
+// DeploymentService stores rendered configs as immutable versions and
+// pushes them to nodes, rolling back to the last good version on failure
+type DeploymentService interface {
+	CreateVersion(nodeID string, content []byte) (*models.ConfigVersion, error)
+	GetVersion(nodeID, version string) (*models.ConfigVersion, error)
+	ListVersions(nodeID string, limit int) ([]*models.ConfigVersion, error)
+	Deploy(ctx context.Context, nodeID, version string) (*models.Deployment, error)
+	Enqueue(nodeID, version string) (*models.Deployment, error)
+	GetDeployment(id string) (*models.Deployment, error)
+	ListDeployments(nodeID string, limit int) ([]*models.Deployment, error)
+	ResumePending(ctx context.Context)
+	RotateObfs(nodeID, password string) (*models.Deployment, error)
+}
+
+// ErrNoDeployedConfig is returned when a change needs the node's deployed
+// config but no deployment to it has succeeded yet
+var ErrNoDeployedConfig = errors.New("node has no deployed config")
+
+type deploymentService struct {
+	deploymentRepo interfaces.DeploymentRepository
+	versionRepo    interfaces.ConfigVersionRepository
+	nodeRepo       interfaces.NodeRepository
+	nodeClients    *NodeClientPool
+	publisher      NodeEventPublisher
+	logger         *logrus.Logger
+
+	// Deployments to the same node are serialised so a rollback never races
+	// with the next push
+	nodeLocks sync.Map
+}
+
+// NewDeploymentService creates a new DeploymentService
+func NewDeploymentService(deploymentRepo interfaces.DeploymentRepository, versionRepo interfaces.ConfigVersionRepository, nodeRepo interfaces.NodeRepository, nodeClients *NodeClientPool, publisher NodeEventPublisher, logger *logrus.Logger) DeploymentService {
+	return &deploymentService{
+		deploymentRepo: deploymentRepo,
+		versionRepo:    versionRepo,
+		nodeRepo:       nodeRepo,
+		nodeClients:    nodeClients,
+		publisher:      publisher,
+		logger:         logger,
+	}
+}
+
+// CreateVersion stores content as a new config version for the node. If it is
+// identical to the latest version, that version is returned instead.
+func (s *deploymentService) CreateVersion(nodeID string, content []byte) (*models.ConfigVersion, error) {
+	node, err := s.nodeRepo.GetByID(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("node not found: %w", err)
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal(content, &parsed); err != nil {
+		return nil, fmt.Errorf("config must be a JSON object: %w", err)
+	}
+
+	sum := sha256.Sum256(content)
+	checksum := hex.EncodeToString(sum[:])
+
+	latest, err := s.versionRepo.GetLatest(nodeID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if latest != nil && latest.Checksum == checksum {
+		return latest, nil
+	}
+
+	version := &models.ConfigVersion{
+		NodeID:   node.ID,
+		Version:  fmt.Sprintf("%s-%s", time.Now().UTC().Format("20060102-150405"), checksum[:8]),
+		Checksum: checksum,
+		Content:  string(content),
+	}
+	if err := s.versionRepo.Create(version); err != nil {
+		return nil, fmt.Errorf("failed to store config version: %w", err)
+	}
+
+	s.logger.Infof("Stored config version %s for node %s", version.Version, nodeID)
+	return version, nil
+}
+
+func (s *deploymentService) GetVersion(nodeID, version string) (*models.ConfigVersion, error) {
+	return s.versionRepo.GetByVersion(nodeID, version)
+}
+
+func (s *deploymentService) ListVersions(nodeID string, limit int) ([]*models.ConfigVersion, error) {
+	return s.versionRepo.ListByNode(nodeID, limit)
+}
+
+func (s *deploymentService) GetDeployment(id string) (*models.Deployment, error) {
+	return s.deploymentRepo.GetByID(id)
+}
+
+func (s *deploymentService) ListDeployments(nodeID string, limit int) ([]*models.Deployment, error) {
+	return s.deploymentRepo.GetByNodeID(nodeID, limit)
+}
+
+// Deploy pushes a version to the node and waits for the outcome. The returned
+// deployment is always recorded; err is set when the push failed.
+func (s *deploymentService) Deploy(ctx context.Context, nodeID, version string) (*models.Deployment, error) {
+	deployment, err := s.createPending(nodeID, version)
+	if err != nil {
+		return nil, err
+	}
+	return deployment, s.execute(ctx, deployment)
+}
+
+// Enqueue records a pending deployment and runs it in the background
+func (s *deploymentService) Enqueue(nodeID, version string) (*models.Deployment, error) {
+	deployment, err := s.createPending(nodeID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	go s.execute(context.Background(), deployment)
+
+	return deployment, nil
+}
+
+// RotateObfs stores the node's deployed config with a new Salamander password
+// as a new version and deploys it in the background, so the rotation is
+// health checked and rolled back like any other config change. A random
+// password is generated unless one is given.
+func (s *deploymentService) RotateObfs(nodeID, password string) (*models.Deployment, error) {
+	deployed, err := s.deploymentRepo.GetLastSuccessful(nodeID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrNoDeployedConfig
+		}
+		return nil, fmt.Errorf("failed to look up deployed config: %w", err)
+	}
+
+	base, err := s.versionRepo.GetByVersion(nodeID, deployed.ConfigVersion)
+	if err != nil {
+		return nil, fmt.Errorf("config version %s not found: %w", deployed.ConfigVersion, err)
+	}
+
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(base.Content), &parsed); err != nil {
+		return nil, fmt.Errorf("deployed config is not a JSON object: %w", err)
+	}
+
+	if password == "" {
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, fmt.Errorf("failed to generate obfs password: %w", err)
+		}
+		password = hex.EncodeToString(buf)
+	}
+	parsed["obfs"] = map[string]interface{}{
+		"type": "salamander",
+		"salamander": map[string]interface{}{
+			"password": password,
+		},
+	}
+
+	content, err := json.MarshalIndent(parsed, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	version, err := s.CreateVersion(nodeID, content)
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("Rotating obfs password of node %s with config %s", nodeID, version.Version)
+	return s.Enqueue(nodeID, version.Version)
+}
+
+// ResumePending runs deployments left pending or deploying by a restart.
+// An interrupted deployment is pushed again from the start: the node may hold
+// the new config or the old one, and pushing the same version is idempotent.
+func (s *deploymentService) ResumePending(ctx context.Context) {
+	deployments, err := s.deploymentRepo.GetUnfinishedDeployments()
+	if err != nil {
+		s.logger.Errorf("Failed to load unfinished deployments: %v", err)
+		return
+	}
+
+	for _, deployment := range deployments {
+		if deployment.Status == models.DeploymentStatusDeploying {
+			s.logger.Warnf("Resuming deployment %s of config %s to node %s interrupted by a restart", deployment.ID, deployment.ConfigVersion, deployment.NodeID)
+		}
+		s.execute(ctx, deployment)
+	}
+}
+
+func (s *deploymentService) createPending(nodeID, version string) (*models.Deployment, error) {
+	configVersion, err := s.versionRepo.GetByVersion(nodeID, version)
+	if err != nil {
+		return nil, fmt.Errorf("config version %s not found for node %s: %w", version, nodeID, err)
+	}
+
+	deployment := &models.Deployment{
+		NodeID:        configVersion.NodeID,
+		ConfigVersion: configVersion.Version,
+		Status:        models.DeploymentStatusPending,
+	}
+	if err := s.deploymentRepo.Create(deployment); err != nil {
+		return nil, fmt.Errorf("failed to create deployment: %w", err)
+	}
+	s.publisher.PublishDeployment(deployment)
+
+	return deployment, nil
+}
+
+func (s *deploymentService) execute(ctx context.Context, deployment *models.Deployment) error {
+	nodeID := deployment.NodeID.String()
+
+	lock, _ := s.nodeLocks.LoadOrStore(nodeID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// Capture the rollback target before this deployment can become it
+	previous, err := s.deploymentRepo.GetLastSuccessful(nodeID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		s.logger.Warnf("Failed to look up last successful deployment for node %s: %v", nodeID, err)
+	}
+
+	deployment.Status = models.DeploymentStatusDeploying
+	if err := s.deploymentRepo.Update(deployment); err != nil {
+		return fmt.Errorf("failed to update deployment: %w", err)
+	}
+	s.publisher.PublishDeployment(deployment)
+
+	s.logger.Infof("Deploying config %s to node %s", deployment.ConfigVersion, nodeID)
+
+	pushErr := s.push(ctx, nodeID, deployment.ConfigVersion)
+	if pushErr == nil {
+		now := time.Now()
+		deployment.Status = models.DeploymentStatusSuccess
+		deployment.DeployedAt = &now
+		if err := s.deploymentRepo.Update(deployment); err != nil {
+			return fmt.Errorf("failed to update deployment: %w", err)
+		}
+		s.publisher.PublishDeployment(deployment)
+
+		s.logger.Infof("Deployed config %s to node %s", deployment.ConfigVersion, nodeID)
+		s.recordObfsPassword(nodeID, deployment.ConfigVersion)
+		return nil
+	}
+
+	s.logger.Errorf("Deployment of config %s to node %s failed: %v", deployment.ConfigVersion, nodeID, pushErr)
+
+	deployment.Status = models.DeploymentStatusFailed
+	deployment.ErrorMessage = pushErr.Error()
+
+	if previous != nil && previous.ConfigVersion != deployment.ConfigVersion {
+		if err := s.push(ctx, nodeID, previous.ConfigVersion); err != nil {
+			s.logger.Errorf("Rollback of node %s to config %s failed: %v", nodeID, previous.ConfigVersion, err)
+			deployment.ErrorMessage += fmt.Sprintf("; rollback to %s failed: %v", previous.ConfigVersion, err)
+			s.nodeRepo.UpdateStatus(nodeID, models.NodeStatusError)
+		} else {
+			now := time.Now()
+			deployment.RollbackAt = &now
+			deployment.ErrorMessage += fmt.Sprintf("; rolled back to %s", previous.ConfigVersion)
+			s.logger.Infof("Rolled node %s back to config %s", nodeID, previous.ConfigVersion)
+		}
+	} else if s.isRunning(ctx, nodeID) {
+		// The agent restores the config it last ran with when a reload fails
+		deployment.ErrorMessage += "; no previous successful version to roll back to, node kept its last known good config"
+	} else {
+		deployment.ErrorMessage += "; no previous successful version to roll back to"
+		s.nodeRepo.UpdateStatus(nodeID, models.NodeStatusError)
+	}
+
+	if err := s.deploymentRepo.Update(deployment); err != nil {
+		s.logger.Errorf("Failed to record failed deployment %s: %v", deployment.ID, err)
+	} else {
+		s.publisher.PublishDeployment(deployment)
+	}
+
+	return fmt.Errorf("deployment of config %s to node %s failed: %w", deployment.ConfigVersion, nodeID, pushErr)
+}
+
+// push uploads a stored version, reloads Hysteria2 and waits until the node
+// reports the server running with that version. Agents only report a version
+// once Hysteria2 came up with it, so a config the server rejected never passes.
+func (s *deploymentService) push(ctx context.Context, nodeID, version string) error {
+	node, err := s.nodeRepo.GetByID(nodeID)
+	if err != nil {
+		return fmt.Errorf("node not found: %w", err)
+	}
+
+	configVersion, err := s.versionRepo.GetByVersion(nodeID, version)
+	if err != nil {
+		return fmt.Errorf("config version %s not found: %w", version, err)
+	}
+
+	client, err := s.nodeClients.Get(node)
+	if err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, nodeCallTimeout)
+	updateResp, err := client.UpdateConfig(callCtx, &pb.ConfigUpdateRequest{
+		NodeId:     nodeID,
+		ConfigType: hysteria2ConfigType,
+		ConfigData: []byte(configVersion.Content),
+		Version:    configVersion.Version,
+	})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("update config: %w", err)
+	}
+	if !updateResp.Success {
+		return fmt.Errorf("update config: %s", updateResp.Message)
+	}
+
+	callCtx, cancel = context.WithTimeout(ctx, reloadTimeout)
+	reloadResp, err := client.ReloadConfig(callCtx, &pb.ReloadRequest{
+		NodeId:      nodeID,
+		ServiceName: hysteria2ConfigType,
+	})
+	cancel()
+	if err != nil {
+		return fmt.Errorf("reload config: %w", err)
+	}
+	if !reloadResp.Success {
+		return fmt.Errorf("reload config: %s", reloadResp.Message)
+	}
+
+	return s.waitHealthy(ctx, client, nodeID, version)
+}
+
+// recordObfsPassword copies the Salamander password of a deployed version
+// into the node metadata, so share links use the password the node runs with
+func (s *deploymentService) recordObfsPassword(nodeID, version string) {
+	configVersion, err := s.versionRepo.GetByVersion(nodeID, version)
+	if err != nil {
+		s.logger.Warnf("Failed to load config %s of node %s: %v", version, nodeID, err)
+		return
+	}
+
+	var parsed struct {
+		Obfs struct {
+			Type       string `json:"type"`
+			Salamander struct {
+				Password string `json:"password"`
+			} `json:"salamander"`
+		} `json:"obfs"`
+	}
+	if err := json.Unmarshal([]byte(configVersion.Content), &parsed); err != nil {
+		return
+	}
+	if parsed.Obfs.Type != "salamander" || parsed.Obfs.Salamander.Password == "" {
+		return
+	}
+
+	if err := s.nodeRepo.MergeMetadata(nodeID, models.JSONB{
+		models.NodeMetadataObfsPassword: parsed.Obfs.Salamander.Password,
+	}); err != nil {
+		s.logger.Errorf("Failed to record obfs password of node %s: %v", nodeID, err)
+	}
+}
+
+// isRunning reports whether the node's Hysteria2 server is up, whatever
+// config version it runs
+func (s *deploymentService) isRunning(ctx context.Context, nodeID string) bool {
+	node, err := s.nodeRepo.GetByID(nodeID)
+	if err != nil {
+		return false
+	}
+	client, err := s.nodeClients.Get(node)
+	if err != nil {
+		return false
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, nodeCallTimeout)
+	defer cancel()
+	resp, err := client.GetHysteria2Status(callCtx, &pb.GetHysteria2StatusRequest{NodeId: nodeID})
+	return err == nil && resp.Status["running"] == "true"
+}
+
+func (s *deploymentService) waitHealthy(ctx context.Context, client pb.NodeManagerClient, nodeID, version string) error {
+	var lastErr error
+
+	for attempt := 0; attempt < healthCheckAttempts; attempt++ {
+		select {
+		case <-time.After(healthCheckInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, nodeCallTimeout)
+		resp, err := client.GetHysteria2Status(callCtx, &pb.GetHysteria2StatusRequest{NodeId: nodeID})
+		cancel()
+		if err != nil {
+			lastErr = fmt.Errorf("health check: %w", err)
+			continue
+		}
+
+		if resp.Status["running"] != "true" {
+			lastErr = fmt.Errorf("health check: hysteria2 is not running")
+			continue
+		}
+		if running := resp.Status["config_version"]; running != version {
+			lastErr = fmt.Errorf("health check: node runs config %q, expected %q", running, version)
+			continue
+		}
+
+		return nil
+	}
+
+	return lastErr
+}
@@ -0,0 +1,317 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/pki"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrInvalidJoinToken is returned when a join token is unknown, expired or already used
+	ErrInvalidJoinToken = errors.New("invalid or expired join token")
+	// ErrUnknownCertificate is returned for a CA-signed certificate that was never recorded
+	ErrUnknownCertificate = errors.New("unknown node certificate")
+	// ErrNodeRevoked is returned when a node certificate has been revoked
+	ErrNodeRevoked = errors.New("node certificate revoked")
+)
+
+// EnrollRequest carries a node's registration along with its join token and
+// CSR. NodeID is set when the agent remembers an earlier enrollment.
+type EnrollRequest struct {
+	Node      *models.VPSNode
+	NodeID    string
+	JoinToken string
+	CSR       []byte
+}
+
+// EnrollResult is returned to a newly enrolled node
+type EnrollResult struct {
+	Node           *models.VPSNode
+	CertificatePEM []byte
+	CACertPEM      []byte
+}
+
+// EnrollmentService issues join tokens and node client certificates, and
+// authenticates nodes by the certificate they present
+type EnrollmentService interface {
+	CreateJoinToken(description string, ttl time.Duration) (*models.JoinToken, string, error)
+	ListJoinTokens(page, limit int) ([]*models.JoinToken, int64, error)
+	DeleteJoinToken(id string) error
+	Enroll(req *EnrollRequest) (*EnrollResult, error)
+	Reregister(nodeID string, node *models.VPSNode) (*models.VPSNode, error)
+	AuthenticateNode(cert *x509.Certificate) (string, error)
+	RevokeNode(nodeID string) error
+	CAFingerprint() string
+}
+
+type enrollmentService struct {
+	joinTokenRepo interfaces.JoinTokenRepository
+	nodeCertRepo  interfaces.NodeCertificateRepository
+	nodeRepo      interfaces.NodeRepository
+	ca            *pki.CA
+	certValidity  time.Duration
+	tokenTTL      time.Duration
+	logger        *logrus.Logger
+}
+
+// NewEnrollmentService creates a new EnrollmentService
+func NewEnrollmentService(joinTokenRepo interfaces.JoinTokenRepository, nodeCertRepo interfaces.NodeCertificateRepository, nodeRepo interfaces.NodeRepository, ca *pki.CA, certValidity, tokenTTL time.Duration, logger *logrus.Logger) EnrollmentService {
+	return &enrollmentService{
+		joinTokenRepo: joinTokenRepo,
+		nodeCertRepo:  nodeCertRepo,
+		nodeRepo:      nodeRepo,
+		ca:            ca,
+		certValidity:  certValidity,
+		tokenTTL:      tokenTTL,
+		logger:        logger,
+	}
+}
+
+// CreateJoinToken mints a single-use token, valid for the default TTL when
+// ttl is zero. The plaintext token is only returned here; the database
+// keeps its hash.
+func (s *enrollmentService) CreateJoinToken(description string, ttl time.Duration) (*models.JoinToken, string, error) {
+	if ttl <= 0 {
+		ttl = s.tokenTTL
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", fmt.Errorf("failed to generate join token: %w", err)
+	}
+	token := hex.EncodeToString(buf)
+
+	joinToken := &models.JoinToken{
+		TokenHash:   hashJoinToken(token),
+		Description: description,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	if err := s.joinTokenRepo.Create(joinToken); err != nil {
+		return nil, "", fmt.Errorf("failed to store join token: %w", err)
+	}
+
+	s.logger.Infof("Created join token %s, expires at %s", joinToken.ID, joinToken.ExpiresAt.Format(time.RFC3339))
+	return joinToken, token, nil
+}
+
+func (s *enrollmentService) ListJoinTokens(page, limit int) ([]*models.JoinToken, int64, error) {
+	return s.joinTokenRepo.List((page-1)*limit, limit)
+}
+
+func (s *enrollmentService) DeleteJoinToken(id string) error {
+	return s.joinTokenRepo.Delete(id)
+}
+
+// Enroll consumes the join token, signs the node's CSR and registers the
+// node. A node already known by the ID it persisted keeps its record and its
+// earlier certificates are revoked.
+func (s *enrollmentService) Enroll(req *EnrollRequest) (*EnrollResult, error) {
+	if req.JoinToken == "" {
+		return nil, ErrInvalidJoinToken
+	}
+	if len(req.CSR) == 0 {
+		return nil, errors.New("certificate signing request is required")
+	}
+
+	existing, err := s.findExisting(req.NodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	node := existing
+	if node == nil {
+		node = req.Node
+		node.ID = uuid.New()
+	}
+
+	// Sign before consuming so a malformed CSR does not burn the token. The
+	// certificate is useless until it is recorded below.
+	cert, certPEM, err := s.ca.SignNodeCSR(req.CSR, node.ID.String(), s.certValidity)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	consumed, err := s.joinTokenRepo.Consume(hashJoinToken(req.JoinToken), node.ID.String(), now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to consume join token: %w", err)
+	}
+	if !consumed {
+		return nil, ErrInvalidJoinToken
+	}
+
+	if existing != nil {
+		if err := s.nodeCertRepo.RevokeByNodeID(node.ID.String(), now); err != nil {
+			return nil, fmt.Errorf("failed to revoke previous node certificates: %w", err)
+		}
+		if err := s.updateRegistration(node, req.Node, now); err != nil {
+			return nil, err
+		}
+		s.logger.Infof("Node %s re-enrolled, reusing existing record", node.ID)
+	} else {
+		node.Status = models.NodeStatusOnline
+		node.LastHeartbeat = now
+		if err := s.nodeRepo.Create(node); err != nil {
+			return nil, fmt.Errorf("failed to create node: %w", err)
+		}
+	}
+
+	record := &models.NodeCertificate{
+		NodeID:       node.ID,
+		SerialNumber: pki.SerialString(cert),
+		NotAfter:     cert.NotAfter,
+	}
+	if err := s.nodeCertRepo.Create(record); err != nil {
+		return nil, fmt.Errorf("failed to record node certificate: %w", err)
+	}
+
+	s.logger.Infof("Enrolled node %s (%s) with certificate %s", node.ID, node.Name, record.SerialNumber)
+
+	return &EnrollResult{
+		Node:           node,
+		CertificatePEM: certPEM,
+		CACertPEM:      s.ca.CertPEM(),
+	}, nil
+}
+
+// Reregister refreshes the record of an enrolled node that reconnected with
+// its client certificate. The node keeps its ID and no certificate is issued.
+func (s *enrollmentService) Reregister(nodeID string, node *models.VPSNode) (*models.VPSNode, error) {
+	existing, err := s.nodeRepo.GetByID(nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load node %s: %w", nodeID, err)
+	}
+	if existing.Status == models.NodeStatusRevoked {
+		return nil, ErrNodeRevoked
+	}
+
+	if err := s.updateRegistration(existing, node, time.Now()); err != nil {
+		return nil, err
+	}
+
+	s.logger.Infof("Node %s re-registered from %s", existing.ID, existing.IPAddress)
+	return existing, nil
+}
+
+// AuthenticateNode maps a CA-verified client certificate to its node ID,
+// rejecting certificates that were revoked or never issued by Enroll
+func (s *enrollmentService) AuthenticateNode(cert *x509.Certificate) (string, error) {
+	record, err := s.nodeCertRepo.GetBySerial(pki.SerialString(cert))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrUnknownCertificate
+		}
+		return "", fmt.Errorf("failed to look up node certificate: %w", err)
+	}
+	if record.RevokedAt != nil {
+		return "", ErrNodeRevoked
+	}
+
+	nodeID := record.NodeID.String()
+	if cert.Subject.CommonName != nodeID {
+		return "", ErrUnknownCertificate
+	}
+
+	return nodeID, nil
+}
+
+// RevokeNode revokes every certificate issued to the node. The node has to
+// enroll again with a new join token to reconnect.
+func (s *enrollmentService) RevokeNode(nodeID string) error {
+	if _, err := s.nodeRepo.GetByID(nodeID); err != nil {
+		return err
+	}
+
+	if err := s.nodeCertRepo.RevokeByNodeID(nodeID, time.Now()); err != nil {
+		return fmt.Errorf("failed to revoke node certificates: %w", err)
+	}
+	if err := s.nodeRepo.UpdateStatus(nodeID, models.NodeStatusRevoked); err != nil {
+		return fmt.Errorf("failed to update node status: %w", err)
+	}
+
+	s.logger.Infof("Revoked node %s", nodeID)
+	return nil
+}
+
+func (s *enrollmentService) CAFingerprint() string {
+	return s.ca.Fingerprint()
+}
+
+// findExisting looks a node up by the ID it persisted from an earlier
+// enrollment, so re-enrolling does not create duplicate records. Nodes are
+// never matched by IP address: distinct nodes can share one behind NAT or
+// after an address is reassigned.
+func (s *enrollmentService) findExisting(nodeID string) (*models.VPSNode, error) {
+	if nodeID == "" {
+		return nil, nil
+	}
+	if _, err := uuid.Parse(nodeID); err != nil {
+		return nil, nil
+	}
+
+	node, err := s.nodeRepo.GetByID(nodeID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to look up node %s: %w", nodeID, err)
+	}
+	return node, nil
+}
+
+// updateRegistration stores the agent-reported fields of a known node. The
+// reported metadata is merged into the stored one, so keys set by the
+// orchestrator such as the obfs password, rollout labels and group survive.
+func (s *enrollmentService) updateRegistration(dst, src *models.VPSNode, now time.Time) error {
+	applyRegistration(dst, src, now)
+	if err := s.nodeRepo.Update(dst); err != nil {
+		return fmt.Errorf("failed to update node: %w", err)
+	}
+
+	if len(src.Metadata) == 0 {
+		return nil
+	}
+	if err := s.nodeRepo.MergeMetadata(dst.ID.String(), src.Metadata); err != nil {
+		return fmt.Errorf("failed to update node metadata: %w", err)
+	}
+	if dst.Metadata == nil {
+		dst.Metadata = models.JSONB{}
+	}
+	for key, value := range src.Metadata {
+		dst.Metadata[key] = value
+	}
+	return nil
+}
+
+// applyRegistration copies the agent-reported fields onto a stored node and
+// marks it online. Metadata is left to updateRegistration.
+func applyRegistration(dst, src *models.VPSNode, now time.Time) {
+	dst.Name = src.Name
+	dst.Hostname = src.Hostname
+	dst.IPAddress = src.IPAddress
+	dst.Location = src.Location
+	dst.Country = src.Country
+	dst.GRPCPort = src.GRPCPort
+	dst.Version = src.Version
+	dst.ConfigVersion = src.ConfigVersion
+	dst.Capabilities = src.Capabilities
+	dst.Status = models.NodeStatusOnline
+	dst.LastHeartbeat = now
+}
+
+func hashJoinToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
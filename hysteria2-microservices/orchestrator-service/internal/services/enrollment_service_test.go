@@ -0,0 +1,363 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/pki"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+// fakeJoinTokenRepo keeps join tokens in memory and consumes them with the
+// conditions of the real repository. Methods the tests do not need panic
+// through the embedded nil interface.
+type fakeJoinTokenRepo struct {
+	interfaces.JoinTokenRepository
+
+	tokens []*models.JoinToken
+}
+
+func (r *fakeJoinTokenRepo) Create(token *models.JoinToken) error {
+	token.ID = uuid.New()
+	r.tokens = append(r.tokens, token)
+	return nil
+}
+
+func (r *fakeJoinTokenRepo) Consume(tokenHash, nodeID string, now time.Time) (bool, error) {
+	for _, token := range r.tokens {
+		if token.TokenHash != tokenHash || token.UsedAt != nil || !token.ExpiresAt.After(now) {
+			continue
+		}
+		id := uuid.MustParse(nodeID)
+		token.UsedAt = &now
+		token.NodeID = &id
+		return true, nil
+	}
+	return false, nil
+}
+
+// fakeNodeCertRepo keeps issued certificates by serial number
+type fakeNodeCertRepo struct {
+	interfaces.NodeCertificateRepository
+
+	certs map[string]*models.NodeCertificate
+}
+
+func (r *fakeNodeCertRepo) Create(cert *models.NodeCertificate) error {
+	r.certs[cert.SerialNumber] = cert
+	return nil
+}
+
+func (r *fakeNodeCertRepo) GetBySerial(serial string) (*models.NodeCertificate, error) {
+	cert, ok := r.certs[serial]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return cert, nil
+}
+
+func (r *fakeNodeCertRepo) RevokeByNodeID(nodeID string, revokedAt time.Time) error {
+	for _, cert := range r.certs {
+		if cert.NodeID.String() == nodeID && cert.RevokedAt == nil {
+			cert.RevokedAt = &revokedAt
+		}
+	}
+	return nil
+}
+
+// fakeNodeRepo keeps nodes by ID
+type fakeNodeRepo struct {
+	interfaces.NodeRepository
+
+	nodes map[string]*models.VPSNode
+}
+
+func (r *fakeNodeRepo) Create(node *models.VPSNode) error {
+	r.nodes[node.ID.String()] = node
+	return nil
+}
+
+func (r *fakeNodeRepo) GetByID(id string) (*models.VPSNode, error) {
+	node, ok := r.nodes[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return node, nil
+}
+
+func (r *fakeNodeRepo) Update(node *models.VPSNode) error {
+	r.nodes[node.ID.String()] = node
+	return nil
+}
+
+func (r *fakeNodeRepo) UpdateStatus(id, status string) error {
+	r.nodes[id].Status = status
+	return nil
+}
+
+type enrollmentFixture struct {
+	service *enrollmentService
+	tokens  *fakeJoinTokenRepo
+	certs   *fakeNodeCertRepo
+	nodes   *fakeNodeRepo
+	ca      *pki.CA
+}
+
+func newEnrollmentFixture(t *testing.T) *enrollmentFixture {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := pki.LoadOrCreateCA(filepath.Join(dir, "ca.crt"), filepath.Join(dir, "ca.key"))
+	if err != nil {
+		t.Fatalf("LoadOrCreateCA: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	f := &enrollmentFixture{
+		tokens: &fakeJoinTokenRepo{},
+		certs:  &fakeNodeCertRepo{certs: make(map[string]*models.NodeCertificate)},
+		nodes:  &fakeNodeRepo{nodes: make(map[string]*models.VPSNode)},
+		ca:     ca,
+	}
+	f.service = &enrollmentService{
+		joinTokenRepo: f.tokens,
+		nodeCertRepo:  f.certs,
+		nodeRepo:      f.nodes,
+		ca:            ca,
+		certValidity:  time.Hour,
+		tokenTTL:      time.Hour,
+		logger:        logger,
+	}
+	return f
+}
+
+// newCSR returns a PEM certificate signing request for a fresh node key
+func newCSR(t *testing.T) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "node"},
+	}, key)
+	if err != nil {
+		t.Fatalf("CreateCertificateRequest: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// enroll enrolls a new node with the token and returns its certificate
+func (f *enrollmentFixture) enroll(t *testing.T, token string) (*EnrollResult, *x509.Certificate) {
+	t.Helper()
+	result, err := f.service.Enroll(&EnrollRequest{
+		Node:      &models.VPSNode{Name: "node-1"},
+		JoinToken: token,
+		CSR:       newCSR(t),
+	})
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	block, _ := pem.Decode(result.CertificatePEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return result, cert
+}
+
+func TestEnrollJoinToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// token returns the join token to enroll with
+		token   func(t *testing.T, f *enrollmentFixture) string
+		wantErr error
+	}{
+		{
+			name: "fresh token",
+			token: func(t *testing.T, f *enrollmentFixture) string {
+				_, token, err := f.service.CreateJoinToken("", 0)
+				if err != nil {
+					t.Fatalf("CreateJoinToken: %v", err)
+				}
+				return token
+			},
+		},
+		{
+			name: "reused token",
+			token: func(t *testing.T, f *enrollmentFixture) string {
+				_, token, err := f.service.CreateJoinToken("", 0)
+				if err != nil {
+					t.Fatalf("CreateJoinToken: %v", err)
+				}
+				f.enroll(t, token)
+				return token
+			},
+			wantErr: ErrInvalidJoinToken,
+		},
+		{
+			name: "expired token",
+			token: func(t *testing.T, f *enrollmentFixture) string {
+				joinToken, token, err := f.service.CreateJoinToken("", time.Minute)
+				if err != nil {
+					t.Fatalf("CreateJoinToken: %v", err)
+				}
+				joinToken.ExpiresAt = time.Now().Add(-time.Second)
+				return token
+			},
+			wantErr: ErrInvalidJoinToken,
+		},
+		{
+			name: "unknown token",
+			token: func(t *testing.T, f *enrollmentFixture) string {
+				return "0123456789abcdef"
+			},
+			wantErr: ErrInvalidJoinToken,
+		},
+		{
+			name: "missing token",
+			token: func(t *testing.T, f *enrollmentFixture) string {
+				return ""
+			},
+			wantErr: ErrInvalidJoinToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newEnrollmentFixture(t)
+			token := tt.token(t, f)
+			nodes, certs := len(f.nodes.nodes), len(f.certs.certs)
+
+			result, err := f.service.Enroll(&EnrollRequest{
+				Node:      &models.VPSNode{Name: "node-2"},
+				JoinToken: token,
+				CSR:       newCSR(t),
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Enroll error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.wantErr != nil {
+				if len(f.nodes.nodes) != nodes || len(f.certs.certs) != certs {
+					t.Error("rejected enrollment stored a node or certificate")
+				}
+				return
+			}
+			if result.Node == nil || len(result.CertificatePEM) == 0 {
+				t.Fatal("Enroll returned no node or certificate")
+			}
+			if _, err := f.service.Enroll(&EnrollRequest{
+				Node:      &models.VPSNode{Name: "node-3"},
+				JoinToken: token,
+				CSR:       newCSR(t),
+			}); !errors.Is(err, ErrInvalidJoinToken) {
+				t.Errorf("second Enroll error = %v, want %v", err, ErrInvalidJoinToken)
+			}
+		})
+	}
+}
+
+func TestAuthenticateNode(t *testing.T) {
+	tests := []struct {
+		name string
+		// cert returns the certificate to present and the node it was
+		// issued to
+		cert    func(t *testing.T, f *enrollmentFixture) (*x509.Certificate, string)
+		wantErr error
+	}{
+		{
+			name: "enrolled node",
+			cert: func(t *testing.T, f *enrollmentFixture) (*x509.Certificate, string) {
+				_, token, _ := f.service.CreateJoinToken("", 0)
+				result, cert := f.enroll(t, token)
+				return cert, result.Node.ID.String()
+			},
+		},
+		{
+			name: "revoked node",
+			cert: func(t *testing.T, f *enrollmentFixture) (*x509.Certificate, string) {
+				_, token, _ := f.service.CreateJoinToken("", 0)
+				result, cert := f.enroll(t, token)
+				if err := f.service.RevokeNode(result.Node.ID.String()); err != nil {
+					t.Fatalf("RevokeNode: %v", err)
+				}
+				return cert, ""
+			},
+			wantErr: ErrNodeRevoked,
+		},
+		{
+			name: "certificate replaced by re-enrollment",
+			cert: func(t *testing.T, f *enrollmentFixture) (*x509.Certificate, string) {
+				_, token, _ := f.service.CreateJoinToken("", 0)
+				result, cert := f.enroll(t, token)
+				_, token, _ = f.service.CreateJoinToken("", 0)
+				if _, err := f.service.Enroll(&EnrollRequest{
+					Node:      &models.VPSNode{Name: "node-1"},
+					NodeID:    result.Node.ID.String(),
+					JoinToken: token,
+					CSR:       newCSR(t),
+				}); err != nil {
+					t.Fatalf("re-Enroll: %v", err)
+				}
+				return cert, ""
+			},
+			wantErr: ErrNodeRevoked,
+		},
+		{
+			name: "certificate never recorded",
+			cert: func(t *testing.T, f *enrollmentFixture) (*x509.Certificate, string) {
+				cert, _, err := f.ca.SignNodeCSR(newCSR(t), uuid.NewString(), time.Hour)
+				if err != nil {
+					t.Fatalf("SignNodeCSR: %v", err)
+				}
+				return cert, ""
+			},
+			wantErr: ErrUnknownCertificate,
+		},
+		{
+			name: "certificate names another node",
+			cert: func(t *testing.T, f *enrollmentFixture) (*x509.Certificate, string) {
+				cert, _, err := f.ca.SignNodeCSR(newCSR(t), uuid.NewString(), time.Hour)
+				if err != nil {
+					t.Fatalf("SignNodeCSR: %v", err)
+				}
+				f.certs.Create(&models.NodeCertificate{
+					NodeID:       uuid.New(),
+					SerialNumber: pki.SerialString(cert),
+					NotAfter:     cert.NotAfter,
+				})
+				return cert, ""
+			},
+			wantErr: ErrUnknownCertificate,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newEnrollmentFixture(t)
+			cert, wantNodeID := tt.cert(t, f)
+
+			nodeID, err := f.service.AuthenticateNode(cert)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("AuthenticateNode error = %v, want %v", err, tt.wantErr)
+			}
+			if nodeID != wantNodeID {
+				t.Errorf("AuthenticateNode = %q, want %q", nodeID, wantNodeID)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/pki"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	pb "hysteryVPN/orchestrator-service/pkg/proto"
+)
+
+// NodeClientPool keeps one gRPC connection per node for NodeManager calls.
+// Connections use mTLS: the pool presents the orchestrator's client
+// certificate and each node must present the certificate issued to it.
+type NodeClientPool struct {
+	ca         *pki.CA
+	clientCert tls.Certificate
+
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+	addrs map[string]string
+}
+
+// NewNodeClientPool creates an empty NodeClientPool
+func NewNodeClientPool(ca *pki.CA, clientCert tls.Certificate) *NodeClientPool {
+	return &NodeClientPool{
+		ca:         ca,
+		clientCert: clientCert,
+		conns:      make(map[string]*grpc.ClientConn),
+		addrs:      make(map[string]string),
+	}
+}
+
+// Get returns a NodeManager client for the node, dialing it on first use.
+// A cached connection is replaced when the node's address changes.
+func (p *NodeClientPool) Get(node *models.VPSNode) (pb.NodeManagerClient, error) {
+	id := node.ID.String()
+	addr := fmt.Sprintf("%s:%d", node.IPAddress, node.GRPCPort)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[id]; ok {
+		if p.addrs[id] == addr {
+			return pb.NewNodeManagerClient(conn), nil
+		}
+		conn.Close()
+		delete(p.conns, id)
+	}
+
+	creds := credentials.NewTLS(p.ca.NodeTLSConfig(p.clientCert, id))
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial node %s at %s: %w", id, addr, err)
+	}
+
+	p.conns[id] = conn
+	p.addrs[id] = addr
+
+	return pb.NewNodeManagerClient(conn), nil
+}
+
+// Remove closes and forgets the connection to a node
+func (p *NodeClientPool) Remove(nodeID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if conn, ok := p.conns[nodeID]; ok {
+		conn.Close()
+		delete(p.conns, nodeID)
+		delete(p.addrs, nodeID)
+	}
+}
+
+// Close closes all node connections
+func (p *NodeClientPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for id, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, id)
+		delete(p.addrs, id)
+	}
+}
@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NodeEventPublisher forwards node events, node metrics and deployment
+// updates to the API service, which streams them to admins over its
+// WebSocket. Node traffic is sent to the API service to be recorded.
+type NodeEventPublisher interface {
+	Publish(event *models.NodeEvent)
+	PublishMetric(metric *models.NodeMetric)
+	PublishDeployment(deployment *models.Deployment)
+	SendTraffic(reportID, nodeID string, stats []*models.TrafficStats) error
+}
+
+type httpNodeEventPublisher struct {
+	baseURL    string
+	secret     string
+	httpClient *http.Client
+	logger     *logrus.Logger
+}
+
+// NewNodeEventPublisher creates a publisher that POSTs to the API service's
+// internal endpoints. Nothing is forwarded when apiServiceURL is empty.
+func NewNodeEventPublisher(apiServiceURL, secret string, logger *logrus.Logger) NodeEventPublisher {
+	baseURL := ""
+	if apiServiceURL != "" {
+		baseURL = strings.TrimRight(apiServiceURL, "/") + "/api/v1/internal"
+	}
+
+	return &httpNodeEventPublisher{
+		baseURL:    baseURL,
+		secret:     secret,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+		logger:     logger,
+	}
+}
+
+// Publish delivers the event in the background. Delivery is best effort:
+// the event is already stored and admins can list it over REST.
+func (p *httpNodeEventPublisher) Publish(event *models.NodeEvent) {
+	p.publish("/node-events", event, fmt.Sprintf("event for node %s", event.NodeID))
+}
+
+// PublishMetric forwards a stored metric sample in the background
+func (p *httpNodeEventPublisher) PublishMetric(metric *models.NodeMetric) {
+	p.publish("/node-metrics", metric, fmt.Sprintf("metrics for node %s", metric.NodeID))
+}
+
+// PublishDeployment forwards a deployment status change in the background
+func (p *httpNodeEventPublisher) PublishDeployment(deployment *models.Deployment) {
+	p.publish("/deployments", deployment, fmt.Sprintf("deployment %s", deployment.ID))
+}
+
+// trafficReport is the body of the API service's /traffic endpoint
+type trafficReport struct {
+	ID     string                 `json:"id,omitempty"`
+	NodeID string                 `json:"node_id"`
+	Stats  []*models.TrafficStats `json:"stats"`
+}
+
+// SendTraffic delivers the traffic of one node report to the API service,
+// which records it and enforces quotas. Unlike the other events it is sent
+// synchronously, the node only drops the traffic once the API service has
+// recorded it. A report with an ID is recorded at most once, so the node can
+// safely resend it.
+func (p *httpNodeEventPublisher) SendTraffic(reportID, nodeID string, stats []*models.TrafficStats) error {
+	if len(stats) == 0 {
+		return nil
+	}
+	if p.baseURL == "" {
+		return fmt.Errorf("API service URL is not configured")
+	}
+
+	data, err := json.Marshal(&trafficReport{ID: reportID, NodeID: nodeID, Stats: stats})
+	if err != nil {
+		return fmt.Errorf("failed to encode traffic: %w", err)
+	}
+	return p.send("/traffic", data)
+}
+
+func (p *httpNodeEventPublisher) publish(path string, payload interface{}, what string) {
+	if p.baseURL == "" {
+		return
+	}
+
+	// Encoded now, the caller may keep changing the value
+	data, err := json.Marshal(payload)
+	if err != nil {
+		p.logger.Warnf("Failed to encode %s: %v", what, err)
+		return
+	}
+
+	go func() {
+		if err := p.send(path, data); err != nil {
+			p.logger.Warnf("Failed to publish %s: %v", what, err)
+		}
+	}()
+}
+
+func (p *httpNodeEventPublisher) send(path string, data []byte) error {
+	req, err := http.NewRequest(http.MethodPost, p.baseURL+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Internal-Secret", p.secret)
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("API service returned status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package services
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// NodeMonitor derives node status from heartbeats. A node goes offline when
+// no heartbeat arrived within the offline grace period, and to error when
+// it keeps reporting Hysteria2 down for longer than the error grace period.
+type NodeMonitor interface {
+	RecordHeartbeat(nodeID, reportedStatus string, at time.Time) (*models.VPSNode, error)
+	RecordMetrics(nodeID string, values map[string]float64, at time.Time) error
+	OnlineNodes() ([]*models.VPSNode, error)
+	HeartbeatInterval() time.Duration
+	ListEvents(nodeID string, page, limit int) ([]*models.NodeEvent, int64, error)
+	Start()
+	Stop()
+}
+
+type nodeMonitor struct {
+	nodeRepo     interfaces.NodeRepository
+	eventRepo    interfaces.NodeEventRepository
+	metricRepo   interfaces.NodeMetricRepository
+	publisher    NodeEventPublisher
+	offlineGrace time.Duration
+	errorGrace   time.Duration
+	interval     time.Duration
+	heartbeat    time.Duration
+	logger       *logrus.Logger
+
+	// unhealthySince tracks when each node started reporting Hysteria2
+	// down. It is rebuilt from heartbeats after a restart.
+	mu             sync.Mutex
+	unhealthySince map[string]time.Time
+
+	stopChan chan struct{}
+	stopOnce sync.Once
+}
+
+// NewNodeMonitor creates a new NodeMonitor
+func NewNodeMonitor(nodeRepo interfaces.NodeRepository, eventRepo interfaces.NodeEventRepository, metricRepo interfaces.NodeMetricRepository, publisher NodeEventPublisher, offlineGrace, errorGrace, interval, heartbeat time.Duration, logger *logrus.Logger) NodeMonitor {
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	if heartbeat <= 0 {
+		heartbeat = 30 * time.Second
+	}
+
+	return &nodeMonitor{
+		nodeRepo:       nodeRepo,
+		eventRepo:      eventRepo,
+		metricRepo:     metricRepo,
+		publisher:      publisher,
+		offlineGrace:   offlineGrace,
+		errorGrace:     errorGrace,
+		interval:       interval,
+		heartbeat:      heartbeat,
+		logger:         logger,
+		unhealthySince: make(map[string]time.Time),
+		stopChan:       make(chan struct{}),
+	}
+}
+
+// Start runs the periodic sweep that catches nodes which stopped sending
+// heartbeats altogether
+func (m *nodeMonitor) Start() {
+	go func() {
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		m.sweep()
+		for {
+			select {
+			case <-ticker.C:
+				m.sweep()
+			case <-m.stopChan:
+				return
+			}
+		}
+	}()
+
+	m.logger.Infof("Node monitor started (offline grace %s, error grace %s)", m.offlineGrace, m.errorGrace)
+}
+
+func (m *nodeMonitor) Stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopChan)
+	})
+}
+
+// RecordHeartbeat stores the heartbeat and applies any status change it
+// causes right away. reportedStatus is "error" while the agent sees
+// Hysteria2 down.
+func (m *nodeMonitor) RecordHeartbeat(nodeID, reportedStatus string, at time.Time) (*models.VPSNode, error) {
+	node, err := m.nodeRepo.GetByID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := m.nodeRepo.UpdateLastHeartbeat(nodeID, at); err != nil {
+		return nil, fmt.Errorf("failed to update heartbeat: %w", err)
+	}
+	node.LastHeartbeat = at
+
+	m.mu.Lock()
+	if reportedStatus == models.NodeStatusError {
+		if _, ok := m.unhealthySince[nodeID]; !ok {
+			m.unhealthySince[nodeID] = at
+		}
+	} else {
+		delete(m.unhealthySince, nodeID)
+	}
+	m.mu.Unlock()
+
+	m.evaluate(node, time.Now(), true)
+	return node, nil
+}
+
+// RecordMetrics stores the metrics an agent reported with its heartbeat and
+// forwards them for the admins watching the node live. Heartbeats without
+// host metrics are not stored.
+func (m *nodeMonitor) RecordMetrics(nodeID string, values map[string]float64, at time.Time) error {
+	cpu, hasCPU := values["cpu_usage"]
+	memory, hasMemory := values["memory_usage"]
+	if !hasCPU && !hasMemory {
+		return nil
+	}
+
+	id, err := uuid.Parse(nodeID)
+	if err != nil {
+		return fmt.Errorf("invalid node ID: %w", err)
+	}
+
+	metric := &models.NodeMetric{
+		NodeID:            id,
+		CPUUsage:          cpu,
+		MemoryUsage:       memory,
+		BandwidthUp:       int64(values["bandwidth_up"]),
+		BandwidthDown:     int64(values["bandwidth_down"]),
+		ActiveConnections: int(values["active_connections"]),
+		RecordedAt:        at,
+	}
+	if err := m.metricRepo.Create(metric); err != nil {
+		return fmt.Errorf("failed to store metrics: %w", err)
+	}
+
+	m.publisher.PublishMetric(metric)
+	return nil
+}
+
+// OnlineNodes returns the nodes that are online and still within the
+// offline grace period
+func (m *nodeMonitor) OnlineNodes() ([]*models.VPSNode, error) {
+	return m.nodeRepo.GetOnlineNodes(time.Now().Add(-m.offlineGrace))
+}
+
+// HeartbeatInterval is how often agents are told to send heartbeats. It
+// should stay well below the offline grace period.
+func (m *nodeMonitor) HeartbeatInterval() time.Duration {
+	return m.heartbeat
+}
+
+func (m *nodeMonitor) ListEvents(nodeID string, page, limit int) ([]*models.NodeEvent, int64, error) {
+	return m.eventRepo.ListByNode(nodeID, (page-1)*limit, limit)
+}
+
+func (m *nodeMonitor) sweep() {
+	nodes, err := m.nodeRepo.GetByStatuses([]string{models.NodeStatusOnline, models.NodeStatusError})
+	if err != nil {
+		m.logger.Errorf("Failed to list nodes for liveness check: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, node := range nodes {
+		m.evaluate(node, now, false)
+	}
+}
+
+// evaluate moves the node to the status its heartbeats call for. Only a
+// heartbeat brings a node back online, so the sweep never clears an error
+// it did not set. Nodes in maintenance or revoked are managed by admins and
+// left alone.
+func (m *nodeMonitor) evaluate(node *models.VPSNode, now time.Time, heartbeat bool) {
+	if node.Status == models.NodeStatusMaintenance || node.Status == models.NodeStatusRevoked {
+		return
+	}
+
+	status, reason := m.desiredStatus(node, now)
+	if status == node.Status || (status == models.NodeStatusOnline && !heartbeat) {
+		return
+	}
+
+	m.transition(node, status, reason)
+}
+
+func (m *nodeMonitor) desiredStatus(node *models.VPSNode, now time.Time) (string, string) {
+	silent := now.Sub(node.LastHeartbeat)
+	if silent > m.offlineGrace {
+		return models.NodeStatusOffline, fmt.Sprintf("no heartbeat for %s", silent.Round(time.Second))
+	}
+
+	m.mu.Lock()
+	since, unhealthy := m.unhealthySince[node.ID.String()]
+	m.mu.Unlock()
+
+	if unhealthy {
+		down := now.Sub(since)
+		if down >= m.errorGrace {
+			return models.NodeStatusError, fmt.Sprintf("Hysteria2 reported down for %s", down.Round(time.Second))
+		}
+		if node.Status == models.NodeStatusError {
+			return models.NodeStatusError, ""
+		}
+	}
+
+	switch node.Status {
+	case models.NodeStatusOffline:
+		return models.NodeStatusOnline, "heartbeat resumed"
+	case models.NodeStatusError:
+		return models.NodeStatusOnline, "Hysteria2 recovered"
+	default:
+		return models.NodeStatusOnline, ""
+	}
+}
+
+func (m *nodeMonitor) transition(node *models.VPSNode, status, reason string) {
+	changed, err := m.nodeRepo.TransitionStatus(node.ID.String(), node.Status, status)
+	if err != nil {
+		m.logger.Errorf("Failed to update status of node %s: %v", node.ID, err)
+		return
+	}
+	if !changed {
+		// Another heartbeat or the sweep got there first
+		return
+	}
+
+	event := &models.NodeEvent{
+		NodeID:     node.ID,
+		Type:       models.NodeEventStatusChanged,
+		FromStatus: node.Status,
+		ToStatus:   status,
+		Reason:     reason,
+		CreatedAt:  time.Now(),
+	}
+	node.Status = status
+
+	m.logger.Infof("Node %s (%s) is now %s: %s", node.ID, node.Name, event.ToStatus, reason)
+
+	if err := m.eventRepo.Create(event); err != nil {
+		m.logger.Errorf("Failed to store event for node %s: %v", node.ID, err)
+	}
+	m.publisher.Publish(event)
+}
@@ -0,0 +1,496 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// maxRolloutNodes bounds how many nodes a single selector can resolve to
+	maxRolloutNodes = 10000
+
+	defaultHeartbeatTimeout = 90 * time.Second
+
+	// Connection drops are only judged on nodes that had meaningful load
+	minConnectionsForDropCheck = 10
+)
+
+// ErrInvalidRolloutState is returned when a control action does not apply to
+// the rollout's current status
+var ErrInvalidRolloutState = errors.New("invalid rollout state")
+
+// RolloutService deploys a config to selected nodes in waves and halts
+// automatically when a wave looks unhealthy
+type RolloutService interface {
+	Create(rollout *models.Rollout) (*models.Rollout, error)
+	Get(id string) (*models.Rollout, error)
+	List(page, limit int, statusFilter string) ([]*models.Rollout, int64, error)
+	Start(id string) (*models.Rollout, error)
+	Pause(id string) (*models.Rollout, error)
+	Resume(id string) (*models.Rollout, error)
+	Cancel(id string) (*models.Rollout, error)
+	ResumeRunning()
+}
+
+type rolloutService struct {
+	rolloutRepo       interfaces.RolloutRepository
+	nodeRepo          interfaces.NodeRepository
+	metricRepo        interfaces.NodeMetricRepository
+	deploymentService DeploymentService
+	logger            *logrus.Logger
+
+	mu   sync.Mutex
+	runs map[string]*rolloutRun
+}
+
+// rolloutRun is an active runner. A stopped runner stays registered until it
+// exits so a quick resume cannot start a second runner for the same rollout.
+type rolloutRun struct {
+	stop    chan struct{}
+	stopped bool
+}
+
+// NewRolloutService creates a new RolloutService
+func NewRolloutService(rolloutRepo interfaces.RolloutRepository, nodeRepo interfaces.NodeRepository, metricRepo interfaces.NodeMetricRepository, deploymentService DeploymentService, logger *logrus.Logger) RolloutService {
+	return &rolloutService{
+		rolloutRepo:       rolloutRepo,
+		nodeRepo:          nodeRepo,
+		metricRepo:        metricRepo,
+		deploymentService: deploymentService,
+		logger:            logger,
+		runs:              make(map[string]*rolloutRun),
+	}
+}
+
+// Create resolves the selector and splits the matching nodes into waves.
+// The rollout starts in pending and must be started explicitly.
+func (s *rolloutService) Create(rollout *models.Rollout) (*models.Rollout, error) {
+	if rollout.Name == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	var parsed map[string]interface{}
+	if err := json.Unmarshal([]byte(rollout.Content), &parsed); err != nil {
+		return nil, fmt.Errorf("content must be a JSON object: %w", err)
+	}
+	if rollout.BatchSize < 0 || rollout.BatchPercent < 0 || rollout.BatchPercent > 100 {
+		return nil, fmt.Errorf("batch_size must be positive and batch_percent between 1 and 100")
+	}
+	if rollout.PauseSeconds < 0 || rollout.MaxConnectionDropPercent < 0 {
+		return nil, fmt.Errorf("pause_seconds and max_connection_drop_percent must not be negative")
+	}
+	if rollout.HeartbeatTimeoutSeconds <= 0 {
+		rollout.HeartbeatTimeoutSeconds = int(defaultHeartbeatTimeout / time.Second)
+	}
+
+	nodes, err := s.selectNodes(rollout.Selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, fmt.Errorf("no nodes match the selector")
+	}
+
+	batch := waveSize(rollout, len(nodes))
+	rollout.Nodes = make([]models.RolloutNode, 0, len(nodes))
+	for i, node := range nodes {
+		rollout.Nodes = append(rollout.Nodes, models.RolloutNode{
+			NodeID: node.ID,
+			Wave:   i / batch,
+			Status: models.DeploymentStatusPending,
+		})
+	}
+	rollout.TotalWaves = (len(nodes) + batch - 1) / batch
+	rollout.CurrentWave = 0
+	rollout.Status = models.RolloutStatusPending
+
+	if err := s.rolloutRepo.Create(rollout); err != nil {
+		return nil, fmt.Errorf("failed to create rollout: %w", err)
+	}
+
+	s.logger.Infof("Created rollout %s (%s): %d nodes in %d waves", rollout.ID, rollout.Name, len(nodes), rollout.TotalWaves)
+	return rollout, nil
+}
+
+func (s *rolloutService) Get(id string) (*models.Rollout, error) {
+	return s.rolloutRepo.GetByID(id)
+}
+
+func (s *rolloutService) List(page, limit int, statusFilter string) ([]*models.Rollout, int64, error) {
+	return s.rolloutRepo.List((page-1)*limit, limit, statusFilter)
+}
+
+func (s *rolloutService) Start(id string) (*models.Rollout, error) {
+	if err := s.transition(id, []string{models.RolloutStatusPending}, map[string]interface{}{
+		"status":     models.RolloutStatusRunning,
+		"started_at": time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+
+	s.launch(id)
+	return s.rolloutRepo.GetByID(id)
+}
+
+// Pause stops the rollout at the next checkpoint. A wave that is already
+// deploying finishes first.
+func (s *rolloutService) Pause(id string) (*models.Rollout, error) {
+	if err := s.transition(id, []string{models.RolloutStatusRunning}, map[string]interface{}{
+		"status": models.RolloutStatusPaused,
+	}); err != nil {
+		return nil, err
+	}
+
+	s.stop(id)
+	s.logger.Infof("Paused rollout %s", id)
+	return s.rolloutRepo.GetByID(id)
+}
+
+// Resume continues a paused or halted rollout from its current wave.
+// Nodes of that wave that already succeeded are not deployed again.
+func (s *rolloutService) Resume(id string) (*models.Rollout, error) {
+	if err := s.transition(id, []string{models.RolloutStatusPaused, models.RolloutStatusHalted}, map[string]interface{}{
+		"status":      models.RolloutStatusRunning,
+		"halt_reason": "",
+	}); err != nil {
+		return nil, err
+	}
+
+	s.launch(id)
+	s.logger.Infof("Resumed rollout %s", id)
+	return s.rolloutRepo.GetByID(id)
+}
+
+func (s *rolloutService) Cancel(id string) (*models.Rollout, error) {
+	if err := s.transition(id, []string{
+		models.RolloutStatusPending, models.RolloutStatusRunning, models.RolloutStatusPaused, models.RolloutStatusHalted,
+	}, map[string]interface{}{
+		"status":      models.RolloutStatusCancelled,
+		"finished_at": time.Now(),
+	}); err != nil {
+		return nil, err
+	}
+
+	s.stop(id)
+	s.logger.Infof("Cancelled rollout %s", id)
+	return s.rolloutRepo.GetByID(id)
+}
+
+// ResumeRunning restarts runners for rollouts that were running when the
+// orchestrator stopped
+func (s *rolloutService) ResumeRunning() {
+	rollouts, err := s.rolloutRepo.GetByStatus(models.RolloutStatusRunning)
+	if err != nil {
+		s.logger.Errorf("Failed to load running rollouts: %v", err)
+		return
+	}
+
+	for _, rollout := range rollouts {
+		s.launch(rollout.ID.String())
+	}
+}
+
+func (s *rolloutService) transition(id string, from []string, updates map[string]interface{}) error {
+	ok, err := s.rolloutRepo.TransitionStatus(id, from, updates)
+	if err != nil {
+		return fmt.Errorf("failed to update rollout: %w", err)
+	}
+	if !ok {
+		return fmt.Errorf("%w: rollout %s is not %s", ErrInvalidRolloutState, id, strings.Join(from, " or "))
+	}
+	return nil
+}
+
+func (s *rolloutService) launch(id string) {
+	s.mu.Lock()
+	if _, running := s.runs[id]; running {
+		s.mu.Unlock()
+		return
+	}
+	run := &rolloutRun{stop: make(chan struct{})}
+	s.runs[id] = run
+	s.mu.Unlock()
+
+	go func() {
+		s.run(id, run.stop)
+
+		s.mu.Lock()
+		delete(s.runs, id)
+		stopped := run.stopped
+		s.mu.Unlock()
+
+		// The rollout may have been resumed while this runner was winding down
+		if stopped {
+			if rollout, err := s.rolloutRepo.GetByID(id); err == nil && rollout.Status == models.RolloutStatusRunning {
+				s.launch(id)
+			}
+		}
+	}()
+}
+
+func (s *rolloutService) stop(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if run, running := s.runs[id]; running && !run.stopped {
+		close(run.stop)
+		run.stopped = true
+	}
+}
+
+func (s *rolloutService) run(id string, stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		rollout, err := s.rolloutRepo.GetByID(id)
+		if err != nil {
+			s.logger.Errorf("Rollout %s: failed to load: %v", id, err)
+			return
+		}
+		if rollout.Status != models.RolloutStatusRunning {
+			return
+		}
+
+		if rollout.CurrentWave >= rollout.TotalWaves {
+			s.transition(id, []string{models.RolloutStatusRunning}, map[string]interface{}{
+				"status":      models.RolloutStatusCompleted,
+				"finished_at": time.Now(),
+			})
+			s.logger.Infof("Rollout %s completed", id)
+			return
+		}
+
+		wave := rollout.CurrentWave
+		var nodes []*models.RolloutNode
+		for i := range rollout.Nodes {
+			if rollout.Nodes[i].Wave == wave {
+				nodes = append(nodes, &rollout.Nodes[i])
+			}
+		}
+
+		s.logger.Infof("Rollout %s: starting wave %d/%d with %d nodes", id, wave+1, rollout.TotalWaves, len(nodes))
+
+		if reason := s.runWave(rollout, nodes); reason != "" {
+			s.halt(id, reason)
+			return
+		}
+
+		// Let the wave soak before judging it
+		if rollout.PauseSeconds > 0 {
+			select {
+			case <-time.After(time.Duration(rollout.PauseSeconds) * time.Second):
+			case <-stop:
+				return
+			}
+		}
+
+		if reason := s.verifyWave(rollout, nodes); reason != "" {
+			s.halt(id, reason)
+			return
+		}
+
+		if err := s.rolloutRepo.UpdateCurrentWave(id, wave+1); err != nil {
+			s.logger.Errorf("Rollout %s: failed to record progress: %v", id, err)
+			return
+		}
+	}
+}
+
+// runWave deploys to every node of the wave that has not succeeded yet and
+// returns a halt reason, or "" if the whole wave deployed
+func (s *rolloutService) runWave(rollout *models.Rollout, nodes []*models.RolloutNode) string {
+	timeout := heartbeatTimeout(rollout)
+
+	var pending []*models.RolloutNode
+	for _, rn := range nodes {
+		if rn.Status == models.DeploymentStatusSuccess {
+			continue
+		}
+
+		node, err := s.nodeRepo.GetByID(rn.NodeID.String())
+		if err != nil {
+			return fmt.Sprintf("node %s not found: %v", rn.NodeID, err)
+		}
+		if !nodeAlive(node, timeout) {
+			return fmt.Sprintf("heartbeat lost on node %s before deployment", node.Name)
+		}
+		pending = append(pending, rn)
+	}
+
+	var wg sync.WaitGroup
+	for _, rn := range pending {
+		wg.Add(1)
+		go func(rn *models.RolloutNode) {
+			defer wg.Done()
+			s.deployNode(rollout, rn)
+		}(rn)
+	}
+	wg.Wait()
+
+	for _, rn := range pending {
+		if rn.Status == models.DeploymentStatusFailed {
+			return fmt.Sprintf("deployment to node %s failed: %s", rn.NodeID, rn.ErrorMessage)
+		}
+	}
+
+	return ""
+}
+
+func (s *rolloutService) deployNode(rollout *models.Rollout, rn *models.RolloutNode) {
+	nodeID := rn.NodeID.String()
+
+	if metric, err := s.metricRepo.GetLatest(nodeID); err == nil {
+		rn.ConnectionsBefore = metric.ActiveConnections
+	}
+	rn.Status = models.DeploymentStatusDeploying
+	rn.ErrorMessage = ""
+	s.saveNode(rn)
+
+	version, err := s.deploymentService.CreateVersion(nodeID, []byte(rollout.Content))
+	if err != nil {
+		rn.Status = models.DeploymentStatusFailed
+		rn.ErrorMessage = err.Error()
+		s.saveNode(rn)
+		return
+	}
+	rn.ConfigVersion = version.Version
+
+	deployment, err := s.deploymentService.Deploy(context.Background(), nodeID, version.Version)
+	if deployment != nil {
+		deploymentID := deployment.ID
+		rn.DeploymentID = &deploymentID
+	}
+	if err != nil {
+		rn.Status = models.DeploymentStatusFailed
+		rn.ErrorMessage = err.Error()
+	} else {
+		rn.Status = models.DeploymentStatusSuccess
+	}
+	s.saveNode(rn)
+}
+
+// verifyWave re-checks the wave after the soak period and returns a halt
+// reason if a node went silent or lost too many connections
+func (s *rolloutService) verifyWave(rollout *models.Rollout, nodes []*models.RolloutNode) string {
+	timeout := heartbeatTimeout(rollout)
+
+	for _, rn := range nodes {
+		node, err := s.nodeRepo.GetByID(rn.NodeID.String())
+		if err != nil {
+			return fmt.Sprintf("node %s not found: %v", rn.NodeID, err)
+		}
+		if !nodeAlive(node, timeout) {
+			return fmt.Sprintf("heartbeat lost on node %s after deployment", node.Name)
+		}
+
+		if rollout.MaxConnectionDropPercent <= 0 || rn.ConnectionsBefore < minConnectionsForDropCheck {
+			continue
+		}
+
+		metric, err := s.metricRepo.GetLatest(node.ID.String())
+		if err != nil || metric.RecordedAt.Before(rn.UpdatedAt) {
+			// No metrics since the deployment yet, liveness was checked above
+			continue
+		}
+
+		drop := (rn.ConnectionsBefore - metric.ActiveConnections) * 100 / rn.ConnectionsBefore
+		if drop > rollout.MaxConnectionDropPercent {
+			return fmt.Sprintf("active connections on node %s dropped %d%% (%d -> %d)",
+				node.Name, drop, rn.ConnectionsBefore, metric.ActiveConnections)
+		}
+	}
+
+	return ""
+}
+
+func (s *rolloutService) halt(id, reason string) {
+	s.logger.Warnf("Rollout %s halted: %s", id, reason)
+
+	if err := s.transition(id, []string{models.RolloutStatusRunning}, map[string]interface{}{
+		"status":      models.RolloutStatusHalted,
+		"halt_reason": reason,
+	}); err != nil {
+		s.logger.Errorf("Rollout %s: failed to record halt: %v", id, err)
+	}
+}
+
+func (s *rolloutService) saveNode(rn *models.RolloutNode) {
+	if err := s.rolloutRepo.UpdateNode(rn); err != nil {
+		s.logger.Errorf("Failed to update rollout node %s: %v", rn.ID, err)
+	}
+}
+
+// selectNodes returns the nodes matching the selector in a stable order
+func (s *rolloutService) selectNodes(selector models.RolloutSelector) ([]*models.VPSNode, error) {
+	nodes, _, err := s.nodeRepo.List(0, maxRolloutNodes, selector.Status, selector.Location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	var matched []*models.VPSNode
+	for _, node := range nodes {
+		if selector.Country != "" && !strings.EqualFold(node.Country, selector.Country) {
+			continue
+		}
+		if !matchLabels(node, selector.Labels) {
+			continue
+		}
+		matched = append(matched, node)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Name < matched[j].Name
+	})
+
+	return matched, nil
+}
+
+func matchLabels(node *models.VPSNode, labels map[string]string) bool {
+	for key, want := range labels {
+		value, ok := node.GetMetadata(key)
+		if !ok || fmt.Sprint(value) != want {
+			return false
+		}
+	}
+	return true
+}
+
+// waveSize returns the number of nodes per wave. A fixed batch size wins over
+// a percentage; with neither set, nodes are rolled out one at a time.
+func waveSize(rollout *models.Rollout, total int) int {
+	size := 1
+	if rollout.BatchSize > 0 {
+		size = rollout.BatchSize
+	} else if rollout.BatchPercent > 0 {
+		size = (total*rollout.BatchPercent + 99) / 100
+	}
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+func heartbeatTimeout(rollout *models.Rollout) time.Duration {
+	if rollout.HeartbeatTimeoutSeconds > 0 {
+		return time.Duration(rollout.HeartbeatTimeoutSeconds) * time.Second
+	}
+	return defaultHeartbeatTimeout
+}
+
+func nodeAlive(node *models.VPSNode, timeout time.Duration) bool {
+	return node.Status == models.NodeStatusOnline && time.Since(node.LastHeartbeat) <= timeout
+}
@@ -0,0 +1,15 @@
+package services
+
+// Services aggregates the orchestrator business services
+type Services struct {
+	NodeService       NodeService
+	AssignmentService AssignmentService
+	MetricsService    MetricsService
+	DeploymentService DeploymentService
+	RolloutService    RolloutService
+	EnrollmentService EnrollmentService
+	NodeMonitor       NodeMonitor
+	CommandService    CommandService
+	UserService       UserService
+	TrafficService    TrafficService
+}
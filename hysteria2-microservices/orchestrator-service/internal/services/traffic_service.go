@@ -0,0 +1,98 @@
+package services
+
+import (
+	"fmt"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// ClientTraffic is a per-client traffic delta reported by a node
+type ClientTraffic struct {
+	ClientID    string
+	Upload      int64
+	Download    int64
+	Connections int
+}
+
+// TrafficService records traffic reported by nodes
+type TrafficService interface {
+	RecordNodeTraffic(reportID, nodeID string, clients []ClientTraffic, recordedAt time.Time) (int, error)
+}
+
+type trafficService struct {
+	deviceRepo interfaces.DeviceRepository
+	publisher  NodeEventPublisher
+	logger     *logrus.Logger
+}
+
+// NewTrafficService creates a new TrafficService
+func NewTrafficService(deviceRepo interfaces.DeviceRepository, publisher NodeEventPublisher, logger *logrus.Logger) TrafficService {
+	return &trafficService{
+		deviceRepo: deviceRepo,
+		publisher:  publisher,
+		logger:     logger,
+	}
+}
+
+// RecordNodeTraffic builds one TrafficStats row per client and sends them to
+// the api-service, which stores them, adds them to the users' usage and
+// enforces quotas. Hysteria client IDs are device IDs handed out by the
+// api-service auth backend, so the owning user is resolved through the
+// devices table. reportID identifies the node's report so a resent report is
+// not counted twice; it is empty for nodes that do not number their reports.
+// Returns the number of rows recorded.
+func (s *trafficService) RecordNodeTraffic(reportID, nodeID string, clients []ClientTraffic, recordedAt time.Time) (int, error) {
+	var ids []string
+	for _, c := range clients {
+		if c.Upload == 0 && c.Download == 0 {
+			continue
+		}
+		if _, err := uuid.Parse(c.ClientID); err != nil {
+			s.logger.Warnf("Ignoring traffic for unknown client %q from node %s", c.ClientID, nodeID)
+			continue
+		}
+		ids = append(ids, c.ClientID)
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	devices, err := s.deviceRepo.GetByIDs(ids)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve devices: %w", err)
+	}
+
+	byID := make(map[string]*models.Device, len(devices))
+	for _, d := range devices {
+		byID[d.ID.String()] = d
+	}
+
+	var stats []*models.TrafficStats
+	for _, c := range clients {
+		device, ok := byID[c.ClientID]
+		if !ok || (c.Upload == 0 && c.Download == 0) {
+			continue
+		}
+
+		deviceID := device.ID
+		stats = append(stats, &models.TrafficStats{
+			UserID:     device.UserID,
+			DeviceID:   &deviceID,
+			Upload:     c.Upload,
+			Download:   c.Download,
+			RecordedAt: recordedAt,
+		})
+	}
+
+	if err := s.publisher.SendTraffic(reportID, nodeID, stats); err != nil {
+		return 0, fmt.Errorf("failed to record traffic: %w", err)
+	}
+
+	return len(stats), nil
+}
@@ -0,0 +1,460 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"hysteryVPN/orchestrator-service/internal/models"
+	"hysteryVPN/orchestrator-service/internal/repositories/interfaces"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	pb "hysteryVPN/orchestrator-service/pkg/proto"
+)
+
+// nodeCallTimeout bounds a single NodeManager call made on behalf of a user
+const nodeCallTimeout = 10 * time.Second
+
+// userConfigClientPrefix + client ID maps a Hysteria2 client (device ID) to
+// its auth secret in a node's user_config
+const userConfigClientPrefix = "client."
+
+// userConfigBandwidthUp and userConfigBandwidthDown carry the user's speed
+// limits in a node's user_config, from the client's side
+const (
+	userConfigBandwidthUp   = "bandwidth_up"
+	userConfigBandwidthDown = "bandwidth_down"
+)
+
+// KickResult summarises a fleet-wide user kick or sync
+type KickResult struct {
+	NodesTotal  int      `json:"nodes_total"`
+	NodesKicked int      `json:"nodes_kicked"`
+	Errors      []string `json:"errors,omitempty"`
+}
+
+// NodeUser is one entry of the user table a node authenticates against
+type NodeUser struct {
+	UserID     string
+	UserConfig map[string]string
+}
+
+// ClientConnection is a client authentication reported by a node
+type ClientConnection struct {
+	ClientID    string
+	Address     string
+	ConnectedAt time.Time
+}
+
+// OnlineClient is a Hysteria2 client connected to a node. User and address
+// come from the device record, unknown clients are listed without them.
+type OnlineClient struct {
+	NodeID      string     `json:"node_id"`
+	NodeName    string     `json:"node_name"`
+	ClientID    string     `json:"client_id"`
+	UserID      string     `json:"user_id,omitempty"`
+	DeviceName  string     `json:"device_name,omitempty"`
+	Connections int        `json:"connections"`
+	Address     string     `json:"address,omitempty"`
+	ConnectedAt *time.Time `json:"connected_at,omitempty"`
+}
+
+// OnlineClients is the fleet-wide list of connected clients. Nodes that
+// could not be queried are listed in Errors.
+type OnlineClients struct {
+	Clients      []*OnlineClient `json:"clients"`
+	NodesTotal   int             `json:"nodes_total"`
+	NodesQueried int             `json:"nodes_queried"`
+	Errors       []string        `json:"errors,omitempty"`
+}
+
+// UserService handles user operations that span the node fleet
+type UserService interface {
+	GetUser(userID string) (*models.User, error)
+	KickUser(ctx context.Context, userID string, clientIDs []string) (*KickResult, error)
+	SyncUser(ctx context.Context, userID string, kickClientIDs []string) (*KickResult, error)
+	DisconnectUser(ctx context.Context, userID string, clientIDs []string) (*KickResult, error)
+	OnlineClients(ctx context.Context) (*OnlineClients, error)
+	NodeUserTable() ([]*NodeUser, error)
+	RecordClientConnections(nodeID string, connections []ClientConnection)
+}
+
+type userService struct {
+	userRepo    interfaces.UserRepository
+	deviceRepo  interfaces.DeviceRepository
+	nodeMonitor NodeMonitor
+	nodeClients *NodeClientPool
+	logger      *logrus.Logger
+}
+
+// NewUserService creates a new UserService
+func NewUserService(userRepo interfaces.UserRepository, deviceRepo interfaces.DeviceRepository, nodeMonitor NodeMonitor, nodeClients *NodeClientPool, logger *logrus.Logger) UserService {
+	return &userService{
+		userRepo:    userRepo,
+		deviceRepo:  deviceRepo,
+		nodeMonitor: nodeMonitor,
+		nodeClients: nodeClients,
+		logger:      logger,
+	}
+}
+
+func (s *userService) GetUser(userID string) (*models.User, error) {
+	return s.userRepo.GetByID(userID)
+}
+
+// KickUser asks every online node to drop the user's Hysteria2 clients.
+// Nodes are contacted in parallel and per-node failures are collected
+// rather than aborting the whole operation.
+func (s *userService) KickUser(ctx context.Context, userID string, clientIDs []string) (*KickResult, error) {
+	result, err := s.forEachOnlineNode(func(node *models.VPSNode) error {
+		return s.removeUserFromNode(ctx, node, userID, clientIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Errors) > 0 {
+		s.logger.Warnf("Kick of user %s failed on %d/%d nodes", userID, len(result.Errors), result.NodesTotal)
+	} else {
+		s.logger.Infof("Kicked user %s from %d nodes", userID, result.NodesKicked)
+	}
+
+	return result, nil
+}
+
+// SyncUser pushes the user's current clients and speed limits to every
+// online node, after credentials were rotated, devices blocked or limits
+// changed. Nodes replace their entry for the user and disconnect clients
+// that lost access, plus the listed clients. A user without active clients
+// is removed from the nodes.
+func (s *userService) SyncUser(ctx context.Context, userID string, kickClientIDs []string) (*KickResult, error) {
+	devices, err := s.deviceRepo.ListActiveClientsByUser(userID, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active devices: %w", err)
+	}
+
+	userConfig := make(map[string]string, len(devices))
+	for _, device := range devices {
+		userConfig[userConfigClientPrefix+device.ID.String()] = device.AuthSecret
+	}
+	if len(userConfig) > 0 {
+		limits, err := s.userRepo.ListBandwidth([]string{userID})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get bandwidth limits: %w", err)
+		}
+		for _, limit := range limits {
+			setBandwidth(userConfig, limit)
+		}
+	}
+
+	result, err := s.forEachOnlineNode(func(node *models.VPSNode) error {
+		if len(userConfig) == 0 {
+			return s.removeUserFromNode(ctx, node, userID, kickClientIDs)
+		}
+		return s.addUserToNode(ctx, node, userID, userConfig, kickClientIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Errors) > 0 {
+		s.logger.Warnf("Sync of user %s failed on %d/%d nodes", userID, len(result.Errors), result.NodesTotal)
+	} else {
+		s.logger.Infof("Synced user %s to %d nodes", userID, result.NodesKicked)
+	}
+
+	return result, nil
+}
+
+// DisconnectUser drops the user's live connections on every online node
+// without revoking access, so the clients may reconnect. Without client IDs
+// all of the user's devices are disconnected.
+func (s *userService) DisconnectUser(ctx context.Context, userID string, clientIDs []string) (*KickResult, error) {
+	if len(clientIDs) == 0 {
+		devices, err := s.deviceRepo.GetByUserID(userID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list devices: %w", err)
+		}
+		for _, device := range devices {
+			clientIDs = append(clientIDs, device.ID.String())
+		}
+	}
+	if len(clientIDs) == 0 {
+		return &KickResult{}, nil
+	}
+
+	result, err := s.forEachOnlineNode(func(node *models.VPSNode) error {
+		return s.kickClientsOnNode(ctx, node, clientIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(result.Errors) > 0 {
+		s.logger.Warnf("Disconnect of user %s failed on %d/%d nodes", userID, len(result.Errors), result.NodesTotal)
+	} else {
+		s.logger.Infof("Disconnected user %s on %d nodes", userID, result.NodesKicked)
+	}
+
+	return result, nil
+}
+
+// OnlineClients collects the connected clients of every online node and
+// resolves them to their devices
+func (s *userService) OnlineClients(ctx context.Context) (*OnlineClients, error) {
+	var mu sync.Mutex
+	var clients []*OnlineClient
+
+	result, err := s.forEachOnlineNode(func(node *models.VPSNode) error {
+		nodeClients, err := s.onlineClientsOnNode(ctx, node)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		clients = append(clients, nodeClients...)
+		mu.Unlock()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Client IDs are device UUIDs, anything else cannot match a device
+	ids := make([]string, 0, len(clients))
+	for _, client := range clients {
+		if _, err := uuid.Parse(client.ClientID); err == nil {
+			ids = append(ids, client.ClientID)
+		}
+	}
+	devices, err := s.deviceRepo.GetByIDs(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve devices: %w", err)
+	}
+	byID := make(map[string]*models.Device, len(devices))
+	for _, device := range devices {
+		byID[device.ID.String()] = device
+	}
+	for _, client := range clients {
+		device, ok := byID[client.ClientID]
+		if !ok {
+			continue
+		}
+		client.UserID = device.UserID.String()
+		client.DeviceName = device.Name
+		client.ConnectedAt = device.LastSeen
+		if device.IPAddress != nil {
+			client.Address = *device.IPAddress
+		}
+	}
+
+	if clients == nil {
+		clients = make([]*OnlineClient, 0)
+	}
+	return &OnlineClients{
+		Clients:      clients,
+		NodesTotal:   result.NodesTotal,
+		NodesQueried: result.NodesKicked,
+		Errors:       result.Errors,
+	}, nil
+}
+
+// forEachOnlineNode calls fn for every online node in parallel. NodesKicked
+// counts the nodes where fn succeeded.
+func (s *userService) forEachOnlineNode(fn func(node *models.VPSNode) error) (*KickResult, error) {
+	nodes, err := s.nodeMonitor.OnlineNodes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list online nodes: %w", err)
+	}
+
+	result := &KickResult{NodesTotal: len(nodes)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, node := range nodes {
+		wg.Add(1)
+		go func(node *models.VPSNode) {
+			defer wg.Done()
+
+			err := fn(node)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", node.ID, err))
+				return
+			}
+			result.NodesKicked++
+		}(node)
+	}
+	wg.Wait()
+
+	return result, nil
+}
+
+// NodeUserTable builds the complete user table for a node: every active
+// user with the secrets of its active devices and its speed limits
+func (s *userService) NodeUserTable() ([]*NodeUser, error) {
+	devices, err := s.deviceRepo.ListActiveClients(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list active devices: %w", err)
+	}
+
+	byUser := make(map[string]*NodeUser)
+	users := make([]*NodeUser, 0)
+	for _, device := range devices {
+		userID := device.UserID.String()
+		user, ok := byUser[userID]
+		if !ok {
+			user = &NodeUser{UserID: userID, UserConfig: make(map[string]string)}
+			byUser[userID] = user
+			users = append(users, user)
+		}
+		user.UserConfig[userConfigClientPrefix+device.ID.String()] = device.AuthSecret
+	}
+
+	userIDs := make([]string, 0, len(users))
+	for _, user := range users {
+		userIDs = append(userIDs, user.UserID)
+	}
+	limits, err := s.userRepo.ListBandwidth(userIDs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list bandwidth limits: %w", err)
+	}
+	for _, limit := range limits {
+		if user, ok := byUser[limit.UserID.String()]; ok {
+			setBandwidth(user.UserConfig, limit)
+		}
+	}
+
+	return users, nil
+}
+
+// setBandwidth adds the user's speed limits to its user_config. Nodes
+// replace the whole entry, so a limit left out falls back to the node's.
+func setBandwidth(userConfig map[string]string, limit *models.UserBandwidth) {
+	if limit.UpMbps > 0 {
+		userConfig[userConfigBandwidthUp] = fmt.Sprintf("%d mbps", limit.UpMbps)
+	}
+	if limit.DownMbps > 0 {
+		userConfig[userConfigBandwidthDown] = fmt.Sprintf("%d mbps", limit.DownMbps)
+	}
+}
+
+// RecordClientConnections updates the devices' last seen time and address
+// from the connections a node reported. Failures are only logged, the data
+// is informational.
+func (s *userService) RecordClientConnections(nodeID string, connections []ClientConnection) {
+	for _, conn := range connections {
+		host, _, err := net.SplitHostPort(conn.Address)
+		if err != nil {
+			host = conn.Address
+		}
+		if err := s.deviceRepo.RecordConnection(conn.ClientID, host, conn.ConnectedAt); err != nil {
+			s.logger.Warnf("Failed to record connection of client %s on node %s: %v", conn.ClientID, nodeID, err)
+		}
+	}
+}
+
+func (s *userService) removeUserFromNode(ctx context.Context, node *models.VPSNode, userID string, clientIDs []string) error {
+	client, err := s.nodeClients.Get(node)
+	if err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, nodeCallTimeout)
+	defer cancel()
+
+	resp, err := client.RemoveUser(callCtx, &pb.RemoveUserRequest{
+		NodeId:    node.ID.String(),
+		UserId:    userID,
+		ClientIds: clientIDs,
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Message)
+	}
+
+	return nil
+}
+
+func (s *userService) kickClientsOnNode(ctx context.Context, node *models.VPSNode, clientIDs []string) error {
+	client, err := s.nodeClients.Get(node)
+	if err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, nodeCallTimeout)
+	defer cancel()
+
+	resp, err := client.KickClients(callCtx, &pb.KickClientsRequest{
+		NodeId:    node.ID.String(),
+		ClientIds: clientIDs,
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Message)
+	}
+
+	return nil
+}
+
+func (s *userService) onlineClientsOnNode(ctx context.Context, node *models.VPSNode) ([]*OnlineClient, error) {
+	client, err := s.nodeClients.Get(node)
+	if err != nil {
+		return nil, err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, nodeCallTimeout)
+	defer cancel()
+
+	resp, err := client.GetOnlineClients(callCtx, &pb.GetOnlineClientsRequest{NodeId: node.ID.String()})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success {
+		return nil, fmt.Errorf("%s", resp.Message)
+	}
+
+	clients := make([]*OnlineClient, 0, len(resp.Clients))
+	for _, c := range resp.Clients {
+		clients = append(clients, &OnlineClient{
+			NodeID:      node.ID.String(),
+			NodeName:    node.Name,
+			ClientID:    c.ClientId,
+			Connections: int(c.Connections),
+		})
+	}
+	return clients, nil
+}
+
+func (s *userService) addUserToNode(ctx context.Context, node *models.VPSNode, userID string, userConfig map[string]string, kickClientIDs []string) error {
+	client, err := s.nodeClients.Get(node)
+	if err != nil {
+		return err
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, nodeCallTimeout)
+	defer cancel()
+
+	resp, err := client.AddUser(callCtx, &pb.AddUserRequest{
+		NodeId:     node.ID.String(),
+		UserId:     userID,
+		UserConfig: userConfig,
+		ClientIds:  kickClientIDs,
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("%s", resp.Message)
+	}
+
+	return nil
+}
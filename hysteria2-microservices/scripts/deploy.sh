@@ -46,7 +46,7 @@ if ! command -v docker-compose &> /dev/null; then
 fi
 
 # Check required environment variables
-required_vars=("DB_PASSWORD" "JWT_SECRET" "NODE_AUTH_TOKEN")
+required_vars=("DB_PASSWORD" "JWT_SECRET")
 missing_vars=()
 
 for var in "${required_vars[@]}"; do